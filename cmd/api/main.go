@@ -14,6 +14,7 @@ import (
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
+	"github.com/shopspring/decimal"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
 	"time"
@@ -45,6 +46,18 @@ func main() {
 
 	userRepo := repository.NewUserRepository(config.DB)
 	tradeRepo := repository.NewTradeRepository(config.DB)
+	markRepo := repository.NewMarkPriceRepository(config.DB)
+	priceRepo := repository.NewPriceRepository(config.DB)
+	cashRepo := repository.NewCashRepository(config.DB)
+	fxRepo := repository.NewFXRepository(config.DB)
+	instrumentRepo := repository.NewInstrumentRepository(config.DB)
+	positionRepo := repository.NewPositionRepository(config.DB)
+	revisionRepo := repository.NewRevisionRepository(config.DB)
+	actionRepo := repository.NewCorporateActionRepository(config.DB)
+	incomeRepo := repository.NewIncomeRepository(config.DB)
+	borrowRepo := repository.NewBorrowFeeRepository(config.DB)
+	derivativeRepo := repository.NewDerivativeEventRepository(config.DB)
+	accountRepo := repository.NewAccountRepository(config.DB)
 
 	// PASS SECRETS HERE
 	authService := service.NewAuthService(
@@ -53,10 +66,43 @@ func main() {
 		config.AppConfig.JWTRefreshSecret,
 		config.AppConfig.AdminSecret,
 	)
-	tradeService := service.NewTradeService(tradeRepo)
+	history := service.NewHistoricalPriceProvider(priceRepo)
+	tradeService := service.NewTradeService(
+		tradeRepo,
+		service.WithUserRepository(userRepo),
+		service.WithMarkPriceRepository(markRepo),
+		service.WithCashRepository(cashRepo),
+		service.WithFXRepository(fxRepo),
+		service.WithInstrumentRepository(instrumentRepo),
+		service.WithPositionRepository(positionRepo),
+		service.WithRevisionRepository(revisionRepo),
+		service.WithCorporateActionRepository(actionRepo),
+		service.WithIncomeRepository(incomeRepo),
+		service.WithBorrowFeeRepository(borrowRepo),
+		service.WithDerivativeEventRepository(derivativeRepo),
+		service.WithAccountRepository(accountRepo),
+		service.WithPriceProvider(buildPriceProvider(history), parseDuration(config.AppConfig.PriceMaxAge, 15*time.Minute)),
+		service.WithHistoricalPrices(history),
+		service.WithTaxHoldingPeriods(parseHoldingPeriods(config.AppConfig.TaxHoldingPeriods)),
+		service.WithMarginWarningRatio(parseRatio(config.AppConfig.MarginWarningRatio)),
+	)
+	priceService := service.NewPriceService(priceRepo, instrumentRepo)
+	cashService := service.NewCashService(cashRepo, tradeRepo, userRepo)
+	fxService := service.NewFXService(fxRepo)
+	instrumentService := service.NewInstrumentService(instrumentRepo, tradeRepo)
+	positionService := service.NewPositionService(positionRepo, tradeRepo, actionRepo)
+	corporateActionService := service.NewCorporateActionService(actionRepo, positionService)
+	accountService := service.NewAccountService(accountRepo, userRepo)
 
 	authHandler := transport.NewAuthHandler(authService)
 	tradeHandler := transport.NewTradeHandler(tradeService)
+	priceHandler := transport.NewPriceHandler(priceService)
+	cashHandler := transport.NewCashHandler(cashService)
+	fxHandler := transport.NewFXHandler(fxService)
+	instrumentHandler := transport.NewInstrumentHandler(instrumentService)
+	positionHandler := transport.NewPositionHandler(positionService)
+	corporateActionHandler := transport.NewCorporateActionHandler(corporateActionService)
+	accountHandler := transport.NewAccountHandler(accountService)
 
 	r := gin.Default()
 
@@ -89,8 +135,49 @@ func main() {
 		{
 			protected.POST("/trades", tradeHandler.CreateTrade)
 			protected.GET("/trades", tradeHandler.ListTrades)
+			protected.GET("/trades/export", tradeHandler.ExportTrades)
+			protected.POST("/trades/import", tradeHandler.ImportTrades)
+			protected.GET("/trades/import/formats", tradeHandler.ListImportFormats)
+			protected.PATCH("/trades/:id", tradeHandler.UpdateTrade)
+			protected.DELETE("/trades/:id", tradeHandler.DeleteTrade)
+			protected.POST("/trades/:id/restore", tradeHandler.RestoreTrade)
+			protected.GET("/trades/:id/history", tradeHandler.GetTradeHistory)
 			protected.GET("/portfolio", tradeHandler.GetPortfolio)
+			protected.GET("/portfolio/export", tradeHandler.ExportPortfolio)
+			protected.GET("/journal", tradeHandler.ExportJournal)
+			protected.GET("/tax/report", tradeHandler.GetTaxReport)
+			protected.GET("/tax/wash-sales", tradeHandler.GetWashSales)
+			protected.GET("/prices", priceHandler.GetSeries)
+			protected.POST("/income", tradeHandler.RecordIncome)
+			protected.GET("/income", tradeHandler.ListIncome)
+			protected.GET("/income/summary", tradeHandler.GetIncomeSummary)
+			protected.POST("/borrow-fees", tradeHandler.RecordBorrowFee)
+			protected.GET("/borrow-fees", tradeHandler.ListBorrowFees)
+			protected.POST("/derivatives/events", tradeHandler.SettleDerivative)
+			protected.GET("/derivatives/events", tradeHandler.ListDerivativeEvents)
+			protected.GET("/pnl", tradeHandler.GetPnL)
+			protected.PUT("/pnl/marks", tradeHandler.SetMarkPrice)
+			protected.GET("/settings", tradeHandler.GetSettings)
+			protected.PUT("/settings", tradeHandler.UpdateSettings)
+			protected.POST("/cash", cashHandler.RecordMovement)
+			protected.GET("/cash", cashHandler.GetLedger)
+			protected.GET("/cash/balances", cashHandler.GetBalances)
+			protected.POST("/accounts", accountHandler.Create)
+			protected.GET("/accounts", accountHandler.List)
+			protected.PATCH("/accounts/:id", accountHandler.Update)
+			protected.GET("/fx/rates", fxHandler.ListRates)
+			protected.POST("/fx/rates", fxHandler.SetRate)
+			protected.POST("/fx/rates/import", fxHandler.ImportRates)
+			protected.GET("/instruments", instrumentHandler.List)
+			protected.GET("/corporate-actions", corporateActionHandler.List)
 			protected.GET("/admin/trades", tradeHandler.GetAllTrades)
+			protected.POST("/admin/instruments", instrumentHandler.Create)
+			protected.PUT("/admin/instruments/:id", instrumentHandler.Update)
+			protected.DELETE("/admin/instruments/:id", instrumentHandler.Delete)
+			protected.POST("/admin/corporate-actions", corporateActionHandler.Create)
+			protected.DELETE("/admin/corporate-actions/:id", corporateActionHandler.Delete)
+			protected.POST("/admin/positions/rebuild", positionHandler.Rebuild)
+			protected.GET("/admin/positions/check", positionHandler.Check)
 			protected.POST("/auth/promote", authHandler.Promote)
 		}
 	}
@@ -116,3 +203,66 @@ func main() {
 		panic(err)
 	}
 }
+
+// @desc: chain the configured price sources, then the price history; the trade service falls back to the user's own last fill
+func buildPriceProvider(history service.HistoricalPriceProvider) service.PriceProvider {
+	var providers []service.PriceProvider
+
+	if config.AppConfig.PriceTable != "" {
+		prices, err := service.ParsePriceTable(config.AppConfig.PriceTable)
+		if err != nil {
+			color.Yellow("Ignoring PRICE_TABLE: %v", err)
+		} else {
+			providers = append(providers, service.NewStaticPriceProvider(prices))
+		}
+	}
+
+	if config.AppConfig.PriceFile != "" {
+		provider, err := service.NewFilePriceProvider(config.AppConfig.PriceFile)
+		if err != nil {
+			color.Yellow("Ignoring PRICE_FILE: %v", err)
+		} else {
+			providers = append(providers, provider)
+		}
+	}
+
+	if config.AppConfig.PriceAPIURL != "" {
+		providers = append(providers, service.NewHTTPPriceProvider(config.AppConfig.PriceAPIURL, nil))
+	}
+
+	providers = append(providers, history)
+
+	ttl := parseDuration(config.AppConfig.PriceCacheTTL, 30*time.Second)
+	return service.NewCachedPriceProvider(service.NewChainPriceProvider(providers...), ttl)
+}
+
+func parseHoldingPeriods(value string) map[string]service.TaxJurisdiction {
+	periods, err := service.ParseHoldingPeriods(value)
+	if err != nil {
+		color.Yellow("Ignoring TAX_HOLDING_PERIODS: %v", err)
+		return nil
+	}
+	return periods
+}
+
+// parseRatio reads a ratio between 0 and 1, zero (the service default) when unset or invalid
+func parseRatio(value string) decimal.Decimal {
+	if value == "" {
+		return decimal.Zero
+	}
+	ratio, err := decimal.NewFromString(value)
+	if err != nil || !ratio.IsPositive() || ratio.GreaterThan(decimal.NewFromInt(1)) {
+		color.Yellow("Ignoring MARGIN_WARNING_RATIO %q, it must be a number above 0 and at most 1", value)
+		return decimal.Zero
+	}
+	return ratio
+}
+
+func parseDuration(value string, fallback time.Duration) time.Duration {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		color.Yellow("Invalid duration %q, using %s", value, fallback)
+		return fallback
+	}
+	return d
+}
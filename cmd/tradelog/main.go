@@ -0,0 +1,230 @@
+package main
+
+/*
+	why :
+	Maintenance jobs (bulk ingest, rebuilds, exports) don't belong behind the HTTP API.
+	This binary reuses the same config, repositories and services as cmd/api.
+
+	usage :
+	go run ./cmd/tradelog <command> [flags]
+*/
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/MonalBarse/tradelog/internal/config"
+	"github.com/MonalBarse/tradelog/internal/repository"
+	"github.com/MonalBarse/tradelog/internal/service"
+	"github.com/fatih/color"
+	"github.com/joho/godotenv"
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+var commands = []command{
+	{"ingest-prices", "load OHLCV candles from CSV files into the price history", ingestPrices},
+	{"ingest-fx", "load FX rates (base,quote,rate,as_of) from CSV files", ingestFX},
+	{"rebuild-positions", "recompute the positions table from trade history", rebuildPositions},
+	{"check-positions", "report positions that drifted from trade history", checkPositions},
+	{"export-journal", "write a user's trades and cash as a Beancount or Ledger journal", exportJournal},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		printUsage()
+		os.Exit(2)
+	}
+
+	for _, cmd := range commands {
+		if cmd.name == os.Args[1] {
+			if err := cmd.run(os.Args[2:]); err != nil {
+				color.Red("%s: %v", cmd.name, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	printUsage()
+	os.Exit(2)
+}
+
+func printUsage() {
+	fmt.Fprintln(os.Stderr, "usage: tradelog <command> [flags]")
+	fmt.Fprintln(os.Stderr, "commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-20s %s\n", cmd.name, cmd.usage)
+	}
+}
+
+// connect loads config and opens the database the same way the API does
+func connect() {
+	if err := godotenv.Load(); err != nil {
+		color.Yellow("No .env file found")
+	}
+	config.LoadConfig()
+	config.ConnectDB()
+}
+
+// @desc: ingest one or more CSV files of candles
+// @flow: parse flags -> connect -> import each file
+func ingestPrices(args []string) error {
+	fs := flag.NewFlagSet("ingest-prices", flag.ExitOnError)
+	symbol := fs.String("symbol", "", "symbol of every row, for files without a symbol column")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: tradelog ingest-prices [-symbol BTC/USD] file.csv [file.csv...]")
+		fmt.Fprintln(os.Stderr, "columns: [symbol,]timestamp,open,high,low,close[,volume]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("no files given")
+	}
+
+	connect()
+	prices := service.NewPriceService(repository.NewPriceRepository(config.DB), repository.NewInstrumentRepository(config.DB))
+
+	for _, path := range fs.Args() {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		n, err := prices.ImportCSV(context.Background(), f, *symbol)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w (%d rows stored before the error)", path, err, n)
+		}
+		color.Green("%s: %d candles ingested", path, n)
+	}
+	return nil
+}
+
+// @desc: ingest one or more CSV files of FX rates, each file is all or nothing
+func ingestFX(args []string) error {
+	fs := flag.NewFlagSet("ingest-fx", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: tradelog ingest-fx file.csv [file.csv...]")
+		fmt.Fprintln(os.Stderr, "columns: base,quote,rate,as_of")
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("no files given")
+	}
+
+	connect()
+	fx := service.NewFXService(repository.NewFXRepository(config.DB))
+
+	for _, path := range fs.Args() {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		n, err := fx.ImportCSV(context.Background(), f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		color.Green("%s: %d rates ingested", path, n)
+	}
+	return nil
+}
+
+func positionService() service.PositionService {
+	return service.NewPositionService(repository.NewPositionRepository(config.DB), repository.NewTradeRepository(config.DB), repository.NewCorporateActionRepository(config.DB))
+}
+
+// @desc: rebuild positions of one user, or of every user with trades
+func rebuildPositions(args []string) error {
+	fs := flag.NewFlagSet("rebuild-positions", flag.ExitOnError)
+	userID := fs.Uint("user", 0, "only rebuild this user")
+	fs.Parse(args)
+
+	connect()
+	positions := positionService()
+
+	if *userID != 0 {
+		rebuilt, err := positions.Rebuild(context.Background(), *userID)
+		if err != nil {
+			return err
+		}
+		color.Green("user %d: %d positions rebuilt", *userID, len(rebuilt))
+		return nil
+	}
+
+	users, err := positions.RebuildAll(context.Background())
+	if err != nil {
+		return err
+	}
+	color.Green("positions rebuilt for %d users", users)
+	return nil
+}
+
+// @desc: print every drifted position, fails when there is any so it can run from cron or CI
+func checkPositions(args []string) error {
+	connect()
+	drifts, err := positionService().Check(context.Background())
+	if err != nil {
+		return err
+	}
+	for _, d := range drifts {
+		color.Yellow("user %d account %d %s: quantity %s (expected %s), cost basis %s (expected %s)",
+			d.UserID, d.AccountID, d.Symbol, d.StoredQuantity, d.ExpectedQuantity, d.StoredCostBasis, d.ExpectedCostBasis)
+	}
+	if len(drifts) > 0 {
+		return fmt.Errorf("%d positions drifted, run rebuild-positions", len(drifts))
+	}
+	color.Green("positions are consistent")
+	return nil
+}
+
+// @desc: write the journal of one user to stdout or a file
+func exportJournal(args []string) error {
+	fs := flag.NewFlagSet("export-journal", flag.ExitOnError)
+	userID := fs.Uint("user", 0, "user to export (required)")
+	format := fs.String("format", service.JournalBeancount, "beancount or ledger")
+	output := fs.String("o", "", "write to this file instead of stdout")
+	fs.Parse(args)
+
+	if *userID == 0 {
+		fs.Usage()
+		return fmt.Errorf("-user is required")
+	}
+	if !service.IsValidJournalFormat(*format) {
+		return fmt.Errorf("unknown format %q, use beancount or ledger", *format)
+	}
+
+	connect()
+	trades := service.NewTradeService(
+		repository.NewTradeRepository(config.DB),
+		service.WithUserRepository(repository.NewUserRepository(config.DB)),
+		service.WithCashRepository(repository.NewCashRepository(config.DB)),
+		service.WithCorporateActionRepository(repository.NewCorporateActionRepository(config.DB)),
+	)
+
+	out := os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	if err := trades.ExportJournal(context.Background(), *userID, *format, out); err != nil {
+		return err
+	}
+	if *output != "" {
+		color.Green("user %d: journal written to %s", *userID, *output)
+	}
+	return nil
+}
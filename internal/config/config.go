@@ -15,6 +15,19 @@ type Config struct {
 	JWTSecret        string `mapstructure:"JWT_SECRET"`
 	JWTRefreshSecret string `mapstructure:"JWT_REFRESH_SECRET"`
 	AdminSecret      string `mapstructure:"ADMIN_SECRET"`
+
+	// Market prices
+	PriceTable    string `mapstructure:"PRICE_TABLE"`     // static prices, e.g. "BTC/USD=65000,ETH/USD=3000"
+	PriceFile     string `mapstructure:"PRICE_FILE"`      // .csv or .json price file
+	PriceAPIURL   string `mapstructure:"PRICE_API_URL"`   // HTTP JSON quote source
+	PriceCacheTTL string `mapstructure:"PRICE_CACHE_TTL"` // e.g. "30s"
+	PriceMaxAge   string `mapstructure:"PRICE_MAX_AGE"`   // quotes older than this are flagged stale, e.g. "15m"
+
+	// Tax reports
+	TaxHoldingPeriods string `mapstructure:"TAX_HOLDING_PERIODS"` // long-term holding periods in months, e.g. "US=12,IN=24,IN/EQUITY=12"
+
+	// Leveraged positions
+	MarginWarningRatio string `mapstructure:"MARGIN_WARNING_RATIO"` // margin ratio (maintenance / equity) from which positions are flagged, e.g. "0.8"
 }
 
 var AppConfig *Config // Global accessible config
@@ -56,6 +69,33 @@ func LoadConfig() {
 			config.Port = "8080"
 		}
 	}
+	if config.PriceTable == "" {
+		config.PriceTable = os.Getenv("PRICE_TABLE")
+	}
+	if config.PriceFile == "" {
+		config.PriceFile = os.Getenv("PRICE_FILE")
+	}
+	if config.PriceAPIURL == "" {
+		config.PriceAPIURL = os.Getenv("PRICE_API_URL")
+	}
+	if config.PriceCacheTTL == "" {
+		config.PriceCacheTTL = os.Getenv("PRICE_CACHE_TTL")
+		if config.PriceCacheTTL == "" {
+			config.PriceCacheTTL = "30s"
+		}
+	}
+	if config.PriceMaxAge == "" {
+		config.PriceMaxAge = os.Getenv("PRICE_MAX_AGE")
+		if config.PriceMaxAge == "" {
+			config.PriceMaxAge = "15m"
+		}
+	}
+	if config.TaxHoldingPeriods == "" {
+		config.TaxHoldingPeriods = os.Getenv("TAX_HOLDING_PERIODS")
+	}
+	if config.MarginWarningRatio == "" {
+		config.MarginWarningRatio = os.Getenv("MARGIN_WARNING_RATIO")
+	}
 	if config.Env == "" {
 		config.Env = os.Getenv("ENV")
 		if config.Env == "" {
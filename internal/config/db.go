@@ -37,10 +37,24 @@ func ConnectDB() {
 	//AutoMigrate (create tables automatically based on structs)
 	// In production, I would use proper migration files, but for this assignment, AutoMigrate should be acceptable
 	log.Println("Running migrations...")
-	err = DB.AutoMigrate(&domain.User{}, &domain.Trade{})
+	err = DB.AutoMigrate(&domain.User{}, &domain.Trade{}, &domain.LotSelection{}, &domain.TradeFee{}, &domain.MarkPrice{}, &domain.PricePoint{}, &domain.CashMovement{}, &domain.FXRate{}, &domain.Instrument{}, &domain.Position{}, &domain.TradeRevision{}, &domain.CorporateAction{}, &domain.IncomeEvent{}, &domain.BorrowFee{}, &domain.DerivativeEvent{}, &domain.Account{})
 	if err != nil {
 		color.Red("Migration failed :( : %v", err)
 		log.Fatal("Migration failed :(  :", err)
 	}
+	// short positions are stored with a negative quantity, AutoMigrate does not drop the old check by itself
+	if DB.Migrator().HasConstraint(&domain.Position{}, "chk_positions_quantity") {
+		if err := DB.Migrator().DropConstraint(&domain.Position{}, "chk_positions_quantity"); err != nil {
+			color.Red("Migration failed :( : %v", err)
+			log.Fatal("Migration failed :(  :", err)
+		}
+	}
+	// positions are kept per account now, the old unique index on user and symbol would reject a second account
+	if DB.Migrator().HasIndex(&domain.Position{}, "idx_position_user_symbol") {
+		if err := DB.Migrator().DropIndex(&domain.Position{}, "idx_position_user_symbol"); err != nil {
+			color.Red("Migration failed :( : %v", err)
+			log.Fatal("Migration failed :(  :", err)
+		}
+	}
 	color.Green("----------------DB migrations done XOXO-----------------")
 }
@@ -0,0 +1,25 @@
+package domain
+
+import "time"
+
+// Account types
+const (
+	AccountTypeBrokerage  = "BROKERAGE"  // taxable cash or margin account at a broker
+	AccountTypeRetirement = "RETIREMENT" // tax-advantaged account such as an IRA
+	AccountTypeExchange   = "EXCHANGE"   // crypto exchange account
+	AccountTypeWallet     = "WALLET"     // self-custody wallet
+)
+
+// Account is a portfolio of its own within a user's holdings, e.g. a long-term account and a scalping account,
+// or one account per broker. Trades without an account belong to the user's default account.
+type Account struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	UserID       uint      `gorm:"not null;uniqueIndex:idx_account_user_name" json:"user_id"`
+	Name         string    `gorm:"not null;uniqueIndex:idx_account_user_name" json:"name"`
+	Broker       string    `json:"broker"`
+	BaseCurrency string    `gorm:"not null" json:"base_currency"` // reporting currency of the account's portfolio
+	Type         string    `gorm:"not null" json:"type"`
+	AllowShort   bool      `gorm:"default:false" json:"allow_short"` // a SELL beyond the account's holding opens a short position
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
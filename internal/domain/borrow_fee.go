@@ -0,0 +1,23 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// BorrowFee is a fee charged for the units borrowed by a short position, it is paid out of the cash balance of Currency
+// through a BORROW_FEE cash movement.
+type BorrowFee struct {
+	ID             uint            `gorm:"primaryKey" json:"id"`
+	UserID         uint            `gorm:"not null;index" json:"user_id"`
+	AccountID      *uint           `json:"account_id,omitempty"` // account of the short, nil for the default one
+	Symbol         string          `gorm:"not null;index" json:"symbol"`
+	Currency       string          `gorm:"not null" json:"currency"`
+	Amount         decimal.Decimal `gorm:"type:numeric;not null" json:"amount"`         // positive, in Currency
+	Rate           decimal.Decimal `gorm:"type:numeric;not null;default:0" json:"rate"` // annual borrow rate the broker charged, for reference only
+	CashMovementID *uint           `json:"cash_movement_id,omitempty"`
+	Notes          string          `json:"notes"`
+	ChargedAt      time.Time       `gorm:"not null;index" json:"charged_at"`
+	CreatedAt      time.Time       `json:"created_at"`
+}
@@ -0,0 +1,33 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Cash movement types. The first group is recorded by users, the second is derived from trades.
+const (
+	CashDeposit    = "DEPOSIT"
+	CashWithdrawal = "WITHDRAWAL"
+	CashDividend   = "DIVIDEND"
+	CashInterest   = "INTEREST"
+	CashFee        = "FEE" // account level fees, e.g. platform or custody fees
+
+	CashTradeSettlement = "TRADE_SETTLEMENT" // BUY pays, SELL receives
+	CashTradeFee        = "TRADE_FEE"        // fee lines charged on a trade
+	CashBorrowFee       = "BORROW_FEE"       // recorded with a BorrowFee, not through the cash endpoints
+	CashMarginLoan      = "MARGIN_LOAN"      // borrowed part of a leveraged position, settled when it is closed
+)
+
+// CashMovement is a user recorded change to a cash balance, Amount is signed (+ in, - out)
+type CashMovement struct {
+	ID         uint            `gorm:"primaryKey" json:"id"`
+	UserID     uint            `gorm:"not null;index" json:"user_id"`
+	Type       string          `gorm:"not null" json:"type"`
+	Currency   string          `gorm:"not null" json:"currency"`
+	Amount     decimal.Decimal `gorm:"type:numeric;not null" json:"amount"`
+	Notes      string          `json:"notes"`
+	OccurredAt time.Time       `gorm:"not null" json:"occurred_at"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
@@ -0,0 +1,27 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Corporate action types
+const (
+	CorporateActionSplit        = "SPLIT"         // forward or reverse split, RatioTo new shares for every RatioFrom old ones
+	CorporateActionSymbolChange = "SYMBOL_CHANGE" // Symbol trades as NewSymbol from EffectiveAt on
+)
+
+// CorporateAction changes how trades of a symbol executed before EffectiveAt are read.
+// Trades are never rewritten, the action is applied every time positions and lots are replayed.
+type CorporateAction struct {
+	ID          uint            `gorm:"primaryKey" json:"id"`
+	Type        string          `gorm:"not null" json:"type"`
+	Symbol      string          `gorm:"not null;index" json:"symbol"`   // symbol the action applies to, as it was called at EffectiveAt
+	NewSymbol   string          `json:"new_symbol,omitempty"`           // only for SYMBOL_CHANGE
+	RatioFrom   decimal.Decimal `gorm:"type:numeric" json:"ratio_from"` // a 4:1 split is RatioTo 4, RatioFrom 1; a 1:10 reverse split is RatioTo 1, RatioFrom 10
+	RatioTo     decimal.Decimal `gorm:"type:numeric" json:"ratio_to"`
+	EffectiveAt time.Time       `gorm:"not null;index" json:"effective_at"`
+	Notes       string          `json:"notes"`
+	CreatedAt   time.Time       `json:"created_at"`
+}
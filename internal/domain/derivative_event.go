@@ -0,0 +1,37 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Derivative event types, they end some or all of a futures or options position
+const (
+	DerivativeExpiration = "EXPIRATION" // the contract expired, options worthless, futures cash settled
+	DerivativeExercise   = "EXERCISE"   // the holder of a long option exercised it
+	DerivativeAssignment = "ASSIGNMENT" // the writer of a short option was assigned
+)
+
+// TradeSourceDerivative marks the trades a derivative event created, they change only with the event
+const TradeSourceDerivative = "derivative"
+
+// DerivativeEvent closes Quantity contracts of Symbol at Price. Exercise and assignment also deliver
+// UnderlyingQuantity units of the underlying at the strike, each leg is stored as a trade.
+type DerivativeEvent struct {
+	ID                 uint            `gorm:"primaryKey" json:"id"`
+	UserID             uint            `gorm:"not null;index" json:"user_id"`
+	AccountID          *uint           `json:"account_id,omitempty"` // account of the contracts and of the trades, nil for the default one
+	Type               string          `gorm:"not null" json:"type"`
+	Symbol             string          `gorm:"not null;index" json:"symbol"`
+	Quantity           decimal.Decimal `gorm:"type:numeric;not null" json:"quantity"` // contracts, positive for long and short positions alike
+	Price              decimal.Decimal `gorm:"type:numeric;not null" json:"price"`    // per unit the contracts were closed at, zero for options
+	Underlying         string          `json:"underlying,omitempty"`
+	UnderlyingQuantity decimal.Decimal `gorm:"type:numeric;not null;default:0" json:"underlying_quantity"` // delivered on exercise or assignment
+	Strike             decimal.Decimal `gorm:"type:numeric;not null;default:0" json:"strike"`
+	TradeID            *uint           `json:"trade_id,omitempty"`            // the trade closing the contracts
+	UnderlyingTradeID  *uint           `json:"underlying_trade_id,omitempty"` // the trade delivering the underlying
+	Notes              string          `json:"notes"`
+	OccurredAt         time.Time       `gorm:"not null;index" json:"occurred_at"`
+	CreatedAt          time.Time       `json:"created_at"`
+}
@@ -0,0 +1,19 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// FXRate says 1 unit of Base is worth Rate units of Quote at AsOf
+type FXRate struct {
+	ID        uint            `gorm:"primaryKey" json:"id"`
+	Base      string          `gorm:"not null;uniqueIndex:idx_fx_pair_time" json:"base"`
+	Quote     string          `gorm:"not null;uniqueIndex:idx_fx_pair_time" json:"quote"`
+	AsOf      time.Time       `gorm:"not null;uniqueIndex:idx_fx_pair_time" json:"as_of"`
+	Rate      decimal.Decimal `gorm:"type:numeric;not null" json:"rate"`
+	Source    string          `json:"source"` // "manual" or "file"
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
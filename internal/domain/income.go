@@ -0,0 +1,48 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Income event types. Cash income is paid into a cash balance, in-kind income adds units to a holding.
+const (
+	IncomeCashDividend  = "CASH_DIVIDEND"
+	IncomeInterest      = "INTEREST"
+	IncomeStockDividend = "STOCK_DIVIDEND"
+	IncomeStaking       = "STAKING"
+	IncomeAirdrop       = "AIRDROP"
+)
+
+// TradeSourceIncome marks the BUY trade that adds in-kind income to a holding, it settles no cash
+const TradeSourceIncome = "income"
+
+// IncomeEvent is income received on a holding (or on cash for interest).
+// Cash income is recorded as a cash movement of the net amount, in-kind income as a BUY of the net units at Price.
+type IncomeEvent struct {
+	ID             uint            `gorm:"primaryKey" json:"id"`
+	UserID         uint            `gorm:"not null;index" json:"user_id"`
+	AccountID      *uint           `json:"account_id,omitempty"` // account the holding is in, nil for the default one
+	Type           string          `gorm:"not null" json:"type"`
+	Symbol         string          `gorm:"index" json:"symbol"`                                    // holding the income is paid on, empty for interest on cash
+	Currency       string          `gorm:"not null" json:"currency"`                               // currency paid, or the base asset for in-kind income
+	Amount         decimal.Decimal `gorm:"type:numeric;not null" json:"amount"`                    // gross, in Currency
+	WithholdingTax decimal.Decimal `gorm:"type:numeric;not null;default:0" json:"withholding_tax"` // withheld at source, in Currency
+	Price          decimal.Decimal `gorm:"type:numeric;not null;default:0" json:"price"`           // fair value of one unit of in-kind income in the quote currency, its cost basis
+	TradeID        *uint           `json:"trade_id,omitempty"`                                     // BUY created for in-kind income
+	CashMovementID *uint           `json:"cash_movement_id,omitempty"`                             // movement created for cash income
+	Notes          string          `json:"notes"`
+	OccurredAt     time.Time       `gorm:"not null" json:"occurred_at"`
+	CreatedAt      time.Time       `json:"created_at"`
+}
+
+// IsInKindIncome reports whether income of this type is received as units of the holding
+func IsInKindIncome(incomeType string) bool {
+	return incomeType == IncomeStockDividend || incomeType == IncomeStaking || incomeType == IncomeAirdrop
+}
+
+// Net is the amount received after withholding tax
+func (e IncomeEvent) Net() decimal.Decimal {
+	return e.Amount.Sub(e.WithholdingTax)
+}
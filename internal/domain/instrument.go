@@ -0,0 +1,81 @@
+package domain
+
+import (
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Asset classes an instrument can belong to
+const (
+	AssetClassCrypto    = "CRYPTO"
+	AssetClassEquity    = "EQUITY"
+	AssetClassFX        = "FX"
+	AssetClassCommodity = "COMMODITY"
+	AssetClassETF       = "ETF"
+	AssetClassFuture    = "FUTURE"
+	AssetClassOption    = "OPTION"
+)
+
+// Option types
+const (
+	OptionCall = "CALL"
+	OptionPut  = "PUT"
+)
+
+// Instrument is the canonical definition of a tradable symbol.
+// A zero TickSize, LotSize, MinQuantity or MaxLeverage means no constraint.
+// Futures and options are contracts on an underlying symbol, one contract is Multiplier units of it.
+type Instrument struct {
+	ID          uint            `gorm:"primaryKey" json:"id"`
+	Symbol      string          `gorm:"uniqueIndex;not null" json:"symbol"` // canonical "BASE/QUOTE"
+	BaseAsset   string          `gorm:"not null" json:"base_asset"`
+	QuoteAsset  string          `gorm:"not null" json:"quote_asset"`
+	AssetClass  string          `gorm:"not null" json:"asset_class"`
+	TickSize    decimal.Decimal `gorm:"type:numeric;not null;default:0" json:"tick_size"`    // prices must be a multiple of it
+	LotSize     decimal.Decimal `gorm:"type:numeric;not null;default:0" json:"lot_size"`     // quantities must be a multiple of it
+	MinQuantity decimal.Decimal `gorm:"type:numeric;not null;default:0" json:"min_quantity"` // smallest quantity a trade may have
+	Active      bool            `gorm:"not null" json:"active"`                              // inactive instruments can't be traded
+
+	// leveraged trading
+	MaxLeverage       decimal.Decimal `gorm:"type:numeric;not null;default:0" json:"max_leverage"`       // highest leverage a trade may use
+	MaintenanceMargin decimal.Decimal `gorm:"type:numeric;not null;default:0" json:"maintenance_margin"` // share of a leveraged position's value its equity must cover, e.g. 0.005
+
+	// derivatives
+	Underlying string          `json:"underlying,omitempty"`                              // canonical symbol of the underlying, e.g. "AAPL/USD"
+	Multiplier decimal.Decimal `gorm:"type:numeric;not null;default:0" json:"multiplier"` // units of the underlying per contract, zero for spot
+	Expiry     *time.Time      `json:"expiry,omitempty"`                                  // last moment the contract exists
+	OptionType string          `json:"option_type,omitempty"`                             // CALL or PUT, options only
+	Strike     decimal.Decimal `gorm:"type:numeric;not null;default:0" json:"strike"`     // options only, in the quote currency
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// IsDerivative reports whether the instrument is a future or an option
+func (i Instrument) IsDerivative() bool {
+	return i.AssetClass == AssetClassFuture || i.AssetClass == AssetClassOption
+}
+
+// ContractMultiplier is the size of one unit of the instrument, 1 for spot
+func (i Instrument) ContractMultiplier() decimal.Decimal {
+	if !i.Multiplier.IsPositive() {
+		return decimal.NewFromInt(1)
+	}
+	return i.Multiplier
+}
+
+// NormalizeSymbol upper-cases a symbol and rewrites a single separator "-", "_" or ":" to "/",
+// so "btc-usd" and "BTC/USD" name the same instrument. A symbol that already has a "/" keeps its
+// other characters, so tickers like "BAJAJ-AUTO/INR" stay intact.
+func NormalizeSymbol(symbol string) string {
+	symbol = strings.ToUpper(strings.TrimSpace(symbol))
+	if strings.Contains(symbol, "/") {
+		return symbol
+	}
+	if replaced := strings.NewReplacer("-", "/", "_", "/", ":", "/").Replace(symbol); strings.Count(replaced, "/") == 1 {
+		return replaced
+	}
+	return symbol
+}
@@ -0,0 +1,17 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// MarkPrice is the price a user wants their open positions in a symbol valued at
+type MarkPrice struct {
+	ID        uint            `gorm:"primaryKey" json:"id"`
+	UserID    uint            `gorm:"not null;uniqueIndex:idx_mark_user_symbol" json:"user_id"`
+	Symbol    string          `gorm:"not null;uniqueIndex:idx_mark_user_symbol" json:"symbol"`
+	Price     decimal.Decimal `gorm:"type:numeric;not null" json:"price"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
@@ -0,0 +1,22 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Position is the maintained net holding of a user in a symbol within one account, it is derived data and can always be rebuilt from trades.
+// CostBasis is tracked at average cost, lot level cost comes from replaying trades.
+// A short position has a negative Quantity and CostBasis, the cost being the proceeds of the SELLs that opened it.
+type Position struct {
+	ID          uint            `gorm:"primaryKey" json:"id"`
+	UserID      uint            `gorm:"not null;uniqueIndex:idx_position_user_account_symbol" json:"user_id"`
+	AccountID   uint            `gorm:"not null;default:0;uniqueIndex:idx_position_user_account_symbol" json:"account_id"` // 0 for the default account
+	Symbol      string          `gorm:"not null;uniqueIndex:idx_position_user_account_symbol" json:"symbol"`
+	Quantity    decimal.Decimal `gorm:"type:numeric;not null" json:"quantity"`
+	CostBasis   decimal.Decimal `gorm:"type:numeric;not null" json:"cost_basis"`
+	TradeCount  int             `gorm:"not null" json:"trade_count"`
+	LastTradeID uint            `json:"last_trade_id"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+}
@@ -0,0 +1,21 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// PricePoint is one OHLCV candle of a symbol, Timestamp is the start of the candle
+type PricePoint struct {
+	ID        uint            `gorm:"primaryKey" json:"-"`
+	Symbol    string          `gorm:"not null;uniqueIndex:idx_price_symbol_ts" json:"symbol"`
+	Timestamp time.Time       `gorm:"not null;uniqueIndex:idx_price_symbol_ts" json:"timestamp"`
+	Open      decimal.Decimal `gorm:"type:numeric;not null" json:"open"`
+	High      decimal.Decimal `gorm:"type:numeric;not null" json:"high"`
+	Low       decimal.Decimal `gorm:"type:numeric;not null" json:"low"`
+	Close     decimal.Decimal `gorm:"type:numeric;not null" json:"close"`
+	Volume    decimal.Decimal `gorm:"type:numeric" json:"volume"`
+	CreatedAt time.Time       `json:"-"`
+	UpdatedAt time.Time       `json:"-"`
+}
@@ -1,22 +1,97 @@
 package domain
 
 import (
+	"strings"
 	"time"
 
 	"github.com/shopspring/decimal"
 	"gorm.io/gorm"
 )
 
+// Cost basis methods used to match SELL trades against open BUY lots
+const (
+	CostMethodFIFO     = "FIFO"     // oldest lot is sold first
+	CostMethodLIFO     = "LIFO"     // newest lot is sold first
+	CostMethodAverage  = "AVERAGE"  // every lot carries the running average cost
+	CostMethodSpecific = "SPECIFIC" // the SELL names the lots it closes
+)
+
+// Fee types a trade can be charged
+const (
+	FeeTypeCommission = "COMMISSION" // broker commission
+	FeeTypeExchange   = "EXCHANGE"   // exchange / clearing fee
+	FeeTypeFunding    = "FUNDING"    // funding or financing charge
+	FeeTypeTax        = "TAX"        // transaction taxes such as STT or stamp duty
+	FeeTypeSlippage   = "SLIPPAGE"   // execution slippage the broker reports separately
+)
+
 type Trade struct {
+	ID            uint            `gorm:"primaryKey" json:"id"`
+	UserID        uint            `gorm:"not null;index;index:idx_trade_user_executed,priority:1;uniqueIndex:idx_trade_external,priority:1" json:"user_id"` // Foreign Key with Index
+	AccountID     *uint           `gorm:"index" json:"account_id,omitempty"`                                                                                // nil for the user's default account
+	Symbol        string          `gorm:"not null" json:"symbol"`                                                                                           // e.g., "BTC/USD"
+	Type          string          `gorm:"not null" json:"type"`                                                                                             // "BUY" or "SELL"
+	Price         decimal.Decimal `gorm:"type:numeric;not null" json:"price"`
+	Quantity      decimal.Decimal `gorm:"type:numeric;not null" json:"quantity"`
+	Notes         string          `json:"notes"`
+	Source        string          `gorm:"uniqueIndex:idx_trade_external,priority:2" json:"source,omitempty"`                              // importer that created the trade, empty when entered by hand
+	ExternalID    string          `gorm:"uniqueIndex:idx_trade_external,priority:3,where:external_id <> ''" json:"external_id,omitempty"` // trade id at the broker, re-imports skip ids already stored
+	Fees          []TradeFee      `gorm:"foreignKey:TradeID" json:"fees,omitempty"`
+	LotSelections []LotSelection  `gorm:"foreignKey:TradeID" json:"lot_selections,omitempty"`                   // Only for SELLs with specific-lot identification
+	Leverage      decimal.Decimal `gorm:"type:numeric;not null;default:1" json:"leverage"`                      // 1 for a cash trade
+	Margin        decimal.Decimal `gorm:"type:numeric;not null;default:0" json:"margin"`                        // collateral posted for the position it opens, in the quote currency; zero for a cash trade
+	Multiplier    decimal.Decimal `gorm:"type:numeric;not null;default:1" json:"multiplier"`                    // contract size of futures and options at the time of the trade, 1 for spot
+	ExecutedAt    time.Time       `gorm:"not null;index:idx_trade_user_executed,priority:2" json:"executed_at"` // When the trade happened
+	CreatedAt     time.Time       `json:"created_at"`
+	UpdatedAt     time.Time       `json:"updated_at"`
+	DeletedAt     gorm.DeletedAt  `gorm:"index" json:"-"`
+}
+
+// IsLeveraged reports whether the trade was made on margin
+func (t Trade) IsLeveraged() bool {
+	return t.Leverage.GreaterThan(decimal.NewFromInt(1))
+}
+
+// AccountKey is the account of the trade, 0 for the user's default account
+func (t Trade) AccountKey() uint {
+	if t.AccountID == nil {
+		return 0
+	}
+	return *t.AccountID
+}
+
+// ContractMultiplier is the multiplier of the trade, a trade without one is spot
+func (t Trade) ContractMultiplier() decimal.Decimal {
+	if !t.Multiplier.IsPositive() {
+		return decimal.NewFromInt(1)
+	}
+	return t.Multiplier
+}
+
+// Notional is what the trade is worth in the quote currency, Quantity * Price * Multiplier
+func (t Trade) Notional() decimal.Decimal {
+	return t.Quantity.Mul(t.Price).Mul(t.ContractMultiplier())
+}
+
+// LotSelection ties part of a SELL trade to the BUY trade (lot) it closes
+type LotSelection struct {
 	ID         uint            `gorm:"primaryKey" json:"id"`
-	UserID     uint            `gorm:"not null;index" json:"user_id"` // Foreign Key with Index
-	Symbol     string          `gorm:"not null" json:"symbol"`        // e.g., "BTC/USD"
-	Type       string          `gorm:"not null" json:"type"`          // "BUY" or "SELL"
-	Price      decimal.Decimal `gorm:"type:numeric;not null" json:"price"`
+	TradeID    uint            `gorm:"not null;index" json:"trade_id"`     // the SELL
+	LotTradeID uint            `gorm:"not null;index" json:"lot_trade_id"` // the BUY being closed
 	Quantity   decimal.Decimal `gorm:"type:numeric;not null" json:"quantity"`
-	Notes      string          `json:"notes"`
-	ExecutedAt time.Time       `gorm:"not null" json:"executed_at"` // When the trade happened
-	CreatedAt  time.Time       `json:"created_at"`
-	UpdatedAt  time.Time       `json:"updated_at"`
-	DeletedAt  gorm.DeletedAt  `gorm:"index" json:"-"`
+}
+
+// TradeFee is one fee line charged on a trade
+type TradeFee struct {
+	ID       uint            `gorm:"primaryKey" json:"id"`
+	TradeID  uint            `gorm:"not null;index" json:"trade_id"`
+	Type     string          `gorm:"not null" json:"type"` // see FeeType* constants
+	Amount   decimal.Decimal `gorm:"type:numeric;not null" json:"amount"`
+	Currency string          `gorm:"not null" json:"currency"` // quote currency, base asset or any other asset (e.g. BNB)
+}
+
+// SplitSymbol splits "BTC/USD" into base "BTC" and quote "USD", quote is empty when the symbol has no "/"
+func SplitSymbol(symbol string) (base, quote string) {
+	base, quote, _ = strings.Cut(symbol, "/")
+	return base, quote
 }
@@ -0,0 +1,25 @@
+package domain
+
+import "time"
+
+// Actions recorded in the trade history
+const (
+	RevisionCreate  = "CREATE"
+	RevisionUpdate  = "UPDATE"
+	RevisionDelete  = "DELETE"
+	RevisionRestore = "RESTORE"
+)
+
+// TradeRevision is an immutable record of one change to a trade: who made it, when, why, and the trade before and after.
+// Rows are only ever inserted.
+type TradeRevision struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	TradeID   uint      `gorm:"not null;uniqueIndex:idx_revision_trade_number" json:"trade_id"`
+	Number    int       `gorm:"not null;uniqueIndex:idx_revision_trade_number" json:"number"` // 1 for the CREATE, counting up
+	Action    string    `gorm:"not null" json:"action"`                                       // see Revision* constants
+	ChangedBy uint      `gorm:"not null" json:"changed_by"`                                   // user id of whoever made the change
+	Reason    string    `json:"reason"`
+	Before    string    `gorm:"type:text" json:"before,omitempty"` // JSON snapshot, empty for CREATE
+	After     string    `gorm:"type:text" json:"after,omitempty"`  // JSON snapshot, empty for DELETE
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -7,12 +7,16 @@ import (
 )
 
 type User struct {
-	ID        uint           `gorm:"primaryKey" json:"id"`
-	Email     string         `gorm:"uniqueIndex;not null" json:"email"`
-	Password  string         `gorm:"not null" json:"-"` // "-" prevents sending password in JSON response
-	Role      string         `gorm:"default:'user'" json:"role"` // 'user' or 'admin'
-	Trades    []Trade        `gorm:"foreignKey:UserID" json:"trades,omitempty"`
-	CreatedAt time.Time      `json:"created_at"`
-	UpdatedAt time.Time      `json:"updated_at"`
-	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"` // Soft delete support
-}
\ No newline at end of file
+	ID           uint           `gorm:"primaryKey" json:"id"`
+	Email        string         `gorm:"uniqueIndex;not null" json:"email"`
+	Password     string         `gorm:"not null" json:"-"`                  // "-" prevents sending password in JSON response
+	Role         string         `gorm:"default:'user'" json:"role"`         // 'user' or 'admin'
+	CostMethod   string         `gorm:"default:'FIFO'" json:"cost_method"`  // lot matching method, see CostMethod* constants
+	StrictCash   bool           `gorm:"default:false" json:"strict_cash"`   // reject BUYs the cash balance can't pay for
+	BaseCurrency string         `gorm:"default:'USD'" json:"base_currency"` // portfolio and P&L totals are reported in this currency
+	AllowShort   bool           `gorm:"default:false" json:"allow_short"`   // a SELL beyond the holding opens a short position
+	Trades       []Trade        `gorm:"foreignKey:UserID" json:"trades,omitempty"`
+	CreatedAt    time.Time      `json:"created_at"`
+	UpdatedAt    time.Time      `json:"updated_at"`
+	DeletedAt    gorm.DeletedAt `gorm:"index" json:"-"` // Soft delete support
+}
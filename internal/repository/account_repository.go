@@ -0,0 +1,52 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/MonalBarse/tradelog/internal/domain"
+	"gorm.io/gorm"
+)
+
+type AccountRepository interface {
+	Create(ctx context.Context, account *domain.Account) error
+	Update(ctx context.Context, account *domain.Account) error
+	GetByID(ctx context.Context, id uint) (*domain.Account, error)
+	GetByUserID(ctx context.Context, userID uint) ([]domain.Account, error)
+}
+
+type accountRepository struct {
+	db *gorm.DB
+}
+
+func NewAccountRepository(db *gorm.DB) AccountRepository {
+	return &accountRepository{db}
+}
+
+func (r *accountRepository) Create(ctx context.Context, account *domain.Account) error {
+	return conn(ctx, r.db).Create(account).Error
+}
+
+func (r *accountRepository) Update(ctx context.Context, account *domain.Account) error {
+	return conn(ctx, r.db).Save(account).Error
+}
+
+// @desc: account by id, nil when there is none
+func (r *accountRepository) GetByID(ctx context.Context, id uint) (*domain.Account, error) {
+	var account domain.Account
+	err := conn(ctx, r.db).First(&account, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
+
+// @desc: accounts of a user, by name
+func (r *accountRepository) GetByUserID(ctx context.Context, userID uint) ([]domain.Account, error) {
+	var accounts []domain.Account
+	err := conn(ctx, r.db).Where("user_id = ?", userID).Order("name").Find(&accounts).Error
+	return accounts, err
+}
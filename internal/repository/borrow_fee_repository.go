@@ -0,0 +1,37 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/MonalBarse/tradelog/internal/domain"
+	"gorm.io/gorm"
+)
+
+type BorrowFeeRepository interface {
+	Create(ctx context.Context, fee *domain.BorrowFee) error
+	Update(ctx context.Context, fee *domain.BorrowFee) error
+	GetByUserID(ctx context.Context, userID uint) ([]domain.BorrowFee, error)
+}
+
+type borrowFeeRepository struct {
+	db *gorm.DB
+}
+
+func NewBorrowFeeRepository(db *gorm.DB) BorrowFeeRepository {
+	return &borrowFeeRepository{db}
+}
+
+func (r *borrowFeeRepository) Create(ctx context.Context, fee *domain.BorrowFee) error {
+	return conn(ctx, r.db).Create(fee).Error
+}
+
+func (r *borrowFeeRepository) Update(ctx context.Context, fee *domain.BorrowFee) error {
+	return conn(ctx, r.db).Save(fee).Error
+}
+
+// @desc: borrow fees of a user, oldest first
+func (r *borrowFeeRepository) GetByUserID(ctx context.Context, userID uint) ([]domain.BorrowFee, error) {
+	var fees []domain.BorrowFee
+	err := conn(ctx, r.db).Where("user_id = ?", userID).Order("charged_at, id").Find(&fees).Error
+	return fees, err
+}
@@ -0,0 +1,32 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/MonalBarse/tradelog/internal/domain"
+	"gorm.io/gorm"
+)
+
+type CashRepository interface {
+	Create(ctx context.Context, movement *domain.CashMovement) error
+	GetByUserID(ctx context.Context, userID uint) ([]domain.CashMovement, error)
+}
+
+type cashRepository struct {
+	db *gorm.DB
+}
+
+func NewCashRepository(db *gorm.DB) CashRepository {
+	return &cashRepository{db}
+}
+
+func (r *cashRepository) Create(ctx context.Context, movement *domain.CashMovement) error {
+	return conn(ctx, r.db).Create(movement).Error
+}
+
+// @desc: cash movements of a user, oldest first
+func (r *cashRepository) GetByUserID(ctx context.Context, userID uint) ([]domain.CashMovement, error) {
+	var movements []domain.CashMovement
+	err := conn(ctx, r.db).Where("user_id = ?", userID).Order("occurred_at, id").Find(&movements).Error
+	return movements, err
+}
@@ -0,0 +1,52 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/MonalBarse/tradelog/internal/domain"
+	"gorm.io/gorm"
+)
+
+type CorporateActionRepository interface {
+	Create(ctx context.Context, action *domain.CorporateAction) error
+	Delete(ctx context.Context, id uint) error
+	GetByID(ctx context.Context, id uint) (*domain.CorporateAction, error)
+	List(ctx context.Context) ([]domain.CorporateAction, error)
+}
+
+type corporateActionRepository struct {
+	db *gorm.DB
+}
+
+func NewCorporateActionRepository(db *gorm.DB) CorporateActionRepository {
+	return &corporateActionRepository{db}
+}
+
+func (r *corporateActionRepository) Create(ctx context.Context, action *domain.CorporateAction) error {
+	return conn(ctx, r.db).Create(action).Error
+}
+
+func (r *corporateActionRepository) Delete(ctx context.Context, id uint) error {
+	return conn(ctx, r.db).Delete(&domain.CorporateAction{}, id).Error
+}
+
+// @desc: action by id, nil when there is none
+func (r *corporateActionRepository) GetByID(ctx context.Context, id uint) (*domain.CorporateAction, error) {
+	var action domain.CorporateAction
+	err := conn(ctx, r.db).First(&action, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &action, nil
+}
+
+// @desc: every action in the order it took effect
+func (r *corporateActionRepository) List(ctx context.Context) ([]domain.CorporateAction, error) {
+	var actions []domain.CorporateAction
+	err := conn(ctx, r.db).Order("effective_at, id").Find(&actions).Error
+	return actions, err
+}
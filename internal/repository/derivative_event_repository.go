@@ -0,0 +1,37 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/MonalBarse/tradelog/internal/domain"
+	"gorm.io/gorm"
+)
+
+type DerivativeEventRepository interface {
+	Create(ctx context.Context, event *domain.DerivativeEvent) error
+	Update(ctx context.Context, event *domain.DerivativeEvent) error
+	GetByUserID(ctx context.Context, userID uint) ([]domain.DerivativeEvent, error)
+}
+
+type derivativeEventRepository struct {
+	db *gorm.DB
+}
+
+func NewDerivativeEventRepository(db *gorm.DB) DerivativeEventRepository {
+	return &derivativeEventRepository{db}
+}
+
+func (r *derivativeEventRepository) Create(ctx context.Context, event *domain.DerivativeEvent) error {
+	return conn(ctx, r.db).Create(event).Error
+}
+
+func (r *derivativeEventRepository) Update(ctx context.Context, event *domain.DerivativeEvent) error {
+	return conn(ctx, r.db).Save(event).Error
+}
+
+// @desc: derivative events of a user, oldest first
+func (r *derivativeEventRepository) GetByUserID(ctx context.Context, userID uint) ([]domain.DerivativeEvent, error) {
+	var events []domain.DerivativeEvent
+	err := conn(ctx, r.db).Where("user_id = ?", userID).Order("occurred_at, id").Find(&events).Error
+	return events, err
+}
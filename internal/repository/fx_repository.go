@@ -0,0 +1,58 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/MonalBarse/tradelog/internal/domain"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type FXRepository interface {
+	BulkUpsert(ctx context.Context, rates []domain.FXRate) error
+	GetLatestAt(ctx context.Context, base, quote string, at time.Time) (*domain.FXRate, error)
+	ListLatest(ctx context.Context) ([]domain.FXRate, error)
+}
+
+type fxRepository struct {
+	db *gorm.DB
+}
+
+func NewFXRepository(db *gorm.DB) FXRepository {
+	return &fxRepository{db}
+}
+
+// @desc: insert rates, a rate for the same pair and time is overwritten
+func (r *fxRepository) BulkUpsert(ctx context.Context, rates []domain.FXRate) error {
+	if len(rates) == 0 {
+		return nil
+	}
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "base"}, {Name: "quote"}, {Name: "as_of"}},
+		DoUpdates: clause.AssignmentColumns([]string{"rate", "source", "updated_at"}),
+	}).CreateInBatches(rates, 1000).Error
+}
+
+// @desc: most recent rate of the pair at or before at, nil when there is none
+func (r *fxRepository) GetLatestAt(ctx context.Context, base, quote string, at time.Time) (*domain.FXRate, error) {
+	var rates []domain.FXRate
+	err := r.db.WithContext(ctx).
+		Where("base = ? AND quote = ? AND as_of <= ?", base, quote, at).
+		Order("as_of DESC").
+		Limit(1).
+		Find(&rates).Error
+	if err != nil || len(rates) == 0 {
+		return nil, err
+	}
+	return &rates[0], nil
+}
+
+// @desc: the latest rate of every pair
+func (r *fxRepository) ListLatest(ctx context.Context) ([]domain.FXRate, error) {
+	var rates []domain.FXRate
+	err := r.db.WithContext(ctx).
+		Raw(`SELECT DISTINCT ON (base, quote) * FROM fx_rates ORDER BY base, quote, as_of DESC`).
+		Scan(&rates).Error
+	return rates, err
+}
@@ -0,0 +1,37 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/MonalBarse/tradelog/internal/domain"
+	"gorm.io/gorm"
+)
+
+type IncomeRepository interface {
+	Create(ctx context.Context, event *domain.IncomeEvent) error
+	Update(ctx context.Context, event *domain.IncomeEvent) error
+	GetByUserID(ctx context.Context, userID uint) ([]domain.IncomeEvent, error)
+}
+
+type incomeRepository struct {
+	db *gorm.DB
+}
+
+func NewIncomeRepository(db *gorm.DB) IncomeRepository {
+	return &incomeRepository{db}
+}
+
+func (r *incomeRepository) Create(ctx context.Context, event *domain.IncomeEvent) error {
+	return conn(ctx, r.db).Create(event).Error
+}
+
+func (r *incomeRepository) Update(ctx context.Context, event *domain.IncomeEvent) error {
+	return conn(ctx, r.db).Save(event).Error
+}
+
+// @desc: income events of a user, oldest first
+func (r *incomeRepository) GetByUserID(ctx context.Context, userID uint) ([]domain.IncomeEvent, error) {
+	var events []domain.IncomeEvent
+	err := conn(ctx, r.db).Where("user_id = ?", userID).Order("occurred_at, id").Find(&events).Error
+	return events, err
+}
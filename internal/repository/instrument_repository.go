@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/MonalBarse/tradelog/internal/domain"
+	"gorm.io/gorm"
+)
+
+type InstrumentRepository interface {
+	Create(ctx context.Context, instrument *domain.Instrument) error
+	Update(ctx context.Context, instrument *domain.Instrument) error
+	Delete(ctx context.Context, id uint) error
+	GetByID(ctx context.Context, id uint) (*domain.Instrument, error)
+	GetBySymbol(ctx context.Context, symbol string) (*domain.Instrument, error)
+	List(ctx context.Context) ([]domain.Instrument, error)
+}
+
+type instrumentRepository struct {
+	db *gorm.DB
+}
+
+func NewInstrumentRepository(db *gorm.DB) InstrumentRepository {
+	return &instrumentRepository{db}
+}
+
+func (r *instrumentRepository) Create(ctx context.Context, instrument *domain.Instrument) error {
+	return r.db.WithContext(ctx).Create(instrument).Error
+}
+
+// @desc: save every column, false and zero values included
+func (r *instrumentRepository) Update(ctx context.Context, instrument *domain.Instrument) error {
+	return r.db.WithContext(ctx).Save(instrument).Error
+}
+
+func (r *instrumentRepository) Delete(ctx context.Context, id uint) error {
+	return r.db.WithContext(ctx).Delete(&domain.Instrument{}, id).Error
+}
+
+// @desc: instrument by id, nil when there is none
+func (r *instrumentRepository) GetByID(ctx context.Context, id uint) (*domain.Instrument, error) {
+	var instrument domain.Instrument
+	err := r.db.WithContext(ctx).First(&instrument, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &instrument, nil
+}
+
+// @desc: instrument by canonical symbol, nil when there is none
+func (r *instrumentRepository) GetBySymbol(ctx context.Context, symbol string) (*domain.Instrument, error) {
+	var instrument domain.Instrument
+	err := r.db.WithContext(ctx).Where("symbol = ?", symbol).First(&instrument).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &instrument, nil
+}
+
+func (r *instrumentRepository) List(ctx context.Context) ([]domain.Instrument, error) {
+	var instruments []domain.Instrument
+	err := r.db.WithContext(ctx).Order("symbol").Find(&instruments).Error
+	return instruments, err
+}
@@ -0,0 +1,36 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/MonalBarse/tradelog/internal/domain"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type MarkPriceRepository interface {
+	Upsert(ctx context.Context, mark *domain.MarkPrice) error
+	GetByUserID(ctx context.Context, userID uint) ([]domain.MarkPrice, error)
+}
+
+type markPriceRepository struct {
+	db *gorm.DB
+}
+
+func NewMarkPriceRepository(db *gorm.DB) MarkPriceRepository {
+	return &markPriceRepository{db}
+}
+
+// @desc: insert the mark or overwrite the price if the user already has one for the symbol
+func (r *markPriceRepository) Upsert(ctx context.Context, mark *domain.MarkPrice) error {
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "symbol"}},
+		DoUpdates: clause.AssignmentColumns([]string{"price", "updated_at"}),
+	}).Create(mark).Error
+}
+
+func (r *markPriceRepository) GetByUserID(ctx context.Context, userID uint) ([]domain.MarkPrice, error) {
+	var marks []domain.MarkPrice
+	err := r.db.WithContext(ctx).Where("user_id = ?", userID).Find(&marks).Error
+	return marks, err
+}
@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/MonalBarse/tradelog/internal/domain"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type PositionRepository interface {
+	Get(ctx context.Context, userID, accountID uint, symbol string) (*domain.Position, error)
+	GetByUserID(ctx context.Context, userID uint) ([]domain.Position, error)
+	GetAll(ctx context.Context) ([]domain.Position, error)
+	Upsert(ctx context.Context, position *domain.Position) error
+	ReplaceForUser(ctx context.Context, userID uint, positions []domain.Position) error
+}
+
+type positionRepository struct {
+	db *gorm.DB
+}
+
+func NewPositionRepository(db *gorm.DB) PositionRepository {
+	return &positionRepository{db}
+}
+
+// @desc: position of a user in a symbol within an account (0 for the default one), nil when there is none
+func (r *positionRepository) Get(ctx context.Context, userID, accountID uint, symbol string) (*domain.Position, error) {
+	var positions []domain.Position
+	err := conn(ctx, r.db).Where("user_id = ? AND account_id = ? AND symbol = ?", userID, accountID, symbol).Limit(1).Find(&positions).Error
+	if err != nil || len(positions) == 0 {
+		return nil, err
+	}
+	return &positions[0], nil
+}
+
+func (r *positionRepository) GetByUserID(ctx context.Context, userID uint) ([]domain.Position, error) {
+	var positions []domain.Position
+	err := conn(ctx, r.db).Where("user_id = ?", userID).Order("symbol, account_id").Find(&positions).Error
+	return positions, err
+}
+
+func (r *positionRepository) GetAll(ctx context.Context) ([]domain.Position, error) {
+	var positions []domain.Position
+	err := conn(ctx, r.db).Order("user_id, symbol, account_id").Find(&positions).Error
+	return positions, err
+}
+
+// @desc: insert the position or overwrite the stored figures of the user, account and symbol
+func (r *positionRepository) Upsert(ctx context.Context, position *domain.Position) error {
+	return conn(ctx, r.db).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "account_id"}, {Name: "symbol"}},
+		DoUpdates: clause.AssignmentColumns([]string{"quantity", "cost_basis", "trade_count", "last_trade_id", "updated_at"}),
+	}).Create(position).Error
+}
+
+// @desc: swap all positions of a user for the given ones in one transaction
+func (r *positionRepository) ReplaceForUser(ctx context.Context, userID uint, positions []domain.Position) error {
+	return conn(ctx, r.db).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&domain.Position{}).Error; err != nil {
+			return err
+		}
+		if len(positions) == 0 {
+			return nil
+		}
+		return tx.Create(&positions).Error
+	})
+}
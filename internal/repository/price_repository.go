@@ -0,0 +1,59 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/MonalBarse/tradelog/internal/domain"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type PriceRepository interface {
+	BulkUpsert(ctx context.Context, points []domain.PricePoint) error
+	GetRange(ctx context.Context, symbol string, from, to time.Time) ([]domain.PricePoint, error)
+	GetLatestAt(ctx context.Context, symbol string, at time.Time) (*domain.PricePoint, error)
+}
+
+type priceRepository struct {
+	db *gorm.DB
+}
+
+func NewPriceRepository(db *gorm.DB) PriceRepository {
+	return &priceRepository{db}
+}
+
+// @desc: insert candles in batches, re-ingesting a candle overwrites it
+func (r *priceRepository) BulkUpsert(ctx context.Context, points []domain.PricePoint) error {
+	if len(points) == 0 {
+		return nil
+	}
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "symbol"}, {Name: "timestamp"}},
+		DoUpdates: clause.AssignmentColumns([]string{"open", "high", "low", "close", "volume", "updated_at"}),
+	}).CreateInBatches(points, 1000).Error
+}
+
+// @desc: candles of a symbol in [from, to], oldest first
+func (r *priceRepository) GetRange(ctx context.Context, symbol string, from, to time.Time) ([]domain.PricePoint, error) {
+	var points []domain.PricePoint
+	err := r.db.WithContext(ctx).
+		Where("symbol = ? AND timestamp >= ? AND timestamp <= ?", symbol, from, to).
+		Order("timestamp").
+		Find(&points).Error
+	return points, err
+}
+
+// @desc: last candle of a symbol at or before at, nil when there is none
+func (r *priceRepository) GetLatestAt(ctx context.Context, symbol string, at time.Time) (*domain.PricePoint, error) {
+	var points []domain.PricePoint
+	err := r.db.WithContext(ctx).
+		Where("symbol = ? AND timestamp <= ?", symbol, at).
+		Order("timestamp DESC").
+		Limit(1).
+		Find(&points).Error
+	if err != nil || len(points) == 0 {
+		return nil, err
+	}
+	return &points[0], nil
+}
@@ -0,0 +1,40 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/MonalBarse/tradelog/internal/domain"
+	"gorm.io/gorm"
+)
+
+// RevisionRepository is append-only, revisions are never updated or deleted
+type RevisionRepository interface {
+	Create(ctx context.Context, revision *domain.TradeRevision) error
+	GetByTradeID(ctx context.Context, tradeID uint) ([]domain.TradeRevision, error)
+}
+
+type revisionRepository struct {
+	db *gorm.DB
+}
+
+func NewRevisionRepository(db *gorm.DB) RevisionRepository {
+	return &revisionRepository{db}
+}
+
+// @desc: append a revision, its number follows the last one of the trade
+func (r *revisionRepository) Create(ctx context.Context, revision *domain.TradeRevision) error {
+	db := conn(ctx, r.db)
+	var last int
+	if err := db.Model(&domain.TradeRevision{}).Where("trade_id = ?", revision.TradeID).Select("COALESCE(MAX(number), 0)").Scan(&last).Error; err != nil {
+		return err
+	}
+	revision.Number = last + 1
+	return db.Create(revision).Error
+}
+
+// @desc: history of a trade, oldest first
+func (r *revisionRepository) GetByTradeID(ctx context.Context, tradeID uint) ([]domain.TradeRevision, error) {
+	var revisions []domain.TradeRevision
+	err := conn(ctx, r.db).Where("trade_id = ?", tradeID).Order("number").Find(&revisions).Error
+	return revisions, err
+}
@@ -0,0 +1,184 @@
+package repository
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/MonalBarse/tradelog/internal/domain"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// Columns trades can be sorted by, every sort is tie-broken by id so the order is total
+var tradeSortColumns = map[string]bool{
+	"executed_at": true,
+	"price":       true,
+	"quantity":    true,
+	"symbol":      true,
+}
+
+// TradeFilter narrows, orders and pages a trade listing. Zero values mean "no constraint".
+type TradeFilter struct {
+	UserID      *uint // nil lists every user (admin)
+	AccountID   *uint // 0 lists the trades of the default account
+	Symbol      string
+	Type        string
+	From        *time.Time // executed at or after
+	To          *time.Time // executed at or before
+	MinPrice    *decimal.Decimal
+	MaxPrice    *decimal.Decimal
+	MinQuantity *decimal.Decimal
+	MaxQuantity *decimal.Decimal
+	Notes       string // case-insensitive substring
+
+	SortBy string // one of executed_at, price, quantity, symbol; defaults to executed_at
+	Desc   bool
+	Limit  int    // 0 means no limit
+	Cursor string // opaque token from NextTradeCursor, the page starts after that row
+}
+
+// tradeCursor is the keyset position behind a cursor token: sort order, sort value and id of the last row
+type tradeCursor struct {
+	SortBy string `json:"s"`
+	Desc   bool   `json:"d"`
+	Value  string `json:"v"`
+	ID     uint   `json:"id"`
+}
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// likeEscaper makes %, _ and \ in a search match themselves instead of acting as LIKE wildcards
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// NextTradeCursor is the token of the page that follows trade under filter's sort order
+func NextTradeCursor(filter TradeFilter, trade domain.Trade) string {
+	c := tradeCursor{SortBy: filter.SortColumn(), Desc: filter.Desc, ID: trade.ID}
+	switch c.SortBy {
+	case "executed_at":
+		c.Value = trade.ExecutedAt.UTC().Format(time.RFC3339Nano)
+	case "price":
+		c.Value = trade.Price.String()
+	case "quantity":
+		c.Value = trade.Quantity.String()
+	case "symbol":
+		c.Value = trade.Symbol
+	}
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeTradeCursor(token string) (*tradeCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c tradeCursor
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+// IsValidTradeSort reports whether column can be used to sort trades
+func IsValidTradeSort(column string) bool {
+	return tradeSortColumns[column]
+}
+
+// SortColumn is the column the listing is ordered by
+func (f TradeFilter) SortColumn() string {
+	if f.SortBy == "" {
+		return "executed_at"
+	}
+	return f.SortBy
+}
+
+// scope applies the filter conditions, without ordering or paging, so it can back a count too
+func (f TradeFilter) scope(db *gorm.DB) *gorm.DB {
+	if f.UserID != nil {
+		db = db.Where("user_id = ?", *f.UserID)
+	}
+	if f.AccountID != nil {
+		if *f.AccountID == 0 {
+			db = db.Where("account_id IS NULL")
+		} else {
+			db = db.Where("account_id = ?", *f.AccountID)
+		}
+	}
+	if f.Symbol != "" {
+		db = db.Where("symbol = ?", f.Symbol)
+	}
+	if f.Type != "" {
+		db = db.Where("type = ?", f.Type)
+	}
+	if f.From != nil {
+		db = db.Where("executed_at >= ?", *f.From)
+	}
+	if f.To != nil {
+		db = db.Where("executed_at <= ?", *f.To)
+	}
+	if f.MinPrice != nil {
+		db = db.Where("price >= ?", *f.MinPrice)
+	}
+	if f.MaxPrice != nil {
+		db = db.Where("price <= ?", *f.MaxPrice)
+	}
+	if f.MinQuantity != nil {
+		db = db.Where("quantity >= ?", *f.MinQuantity)
+	}
+	if f.MaxQuantity != nil {
+		db = db.Where("quantity <= ?", *f.MaxQuantity)
+	}
+	if f.Notes != "" {
+		db = db.Where(`notes ILIKE ? ESCAPE '\'`, "%"+likeEscaper.Replace(f.Notes)+"%")
+	}
+	return db
+}
+
+// @desc: order by the sort column and id, and start after the cursor row
+func (f TradeFilter) page(db *gorm.DB) (*gorm.DB, error) {
+	column := f.SortColumn()
+	if !IsValidTradeSort(column) {
+		return nil, fmt.Errorf("unsupported sort %q", column)
+	}
+
+	direction, cmp := "ASC", ">"
+	if f.Desc {
+		direction, cmp = "DESC", "<"
+	}
+
+	if f.Cursor != "" {
+		after, err := decodeTradeCursor(f.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		if after.SortBy != column || after.Desc != f.Desc {
+			return nil, fmt.Errorf("%w: it belongs to a different sort order", ErrInvalidCursor)
+		}
+		value, err := cursorValue(column, after.Value)
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		db = db.Where(fmt.Sprintf("(%s, id) %s (?, ?)", column, cmp), value, after.ID)
+	}
+
+	db = db.Order(fmt.Sprintf("%s %s, id %s", column, direction, direction))
+	if f.Limit > 0 {
+		db = db.Limit(f.Limit)
+	}
+	return db, nil
+}
+
+// cursorValue turns the text form of a cursor back into the type of its column
+func cursorValue(column, raw string) (interface{}, error) {
+	switch column {
+	case "executed_at":
+		return time.Parse(time.RFC3339Nano, raw)
+	case "price", "quantity":
+		return decimal.NewFromString(raw)
+	}
+	return raw, nil
+}
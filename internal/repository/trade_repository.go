@@ -9,8 +9,20 @@ import (
 
 type TradeRepository interface {
 	Create(ctx context.Context, trade *domain.Trade) error
+	CreateBatch(ctx context.Context, trades []*domain.Trade) error
+	GetByID(ctx context.Context, id uint, withDeleted bool) (*domain.Trade, error)
+	Update(ctx context.Context, trade *domain.Trade) error
+	Delete(ctx context.Context, id uint) error
+	Restore(ctx context.Context, id uint) error
 	GetByUserID(ctx context.Context, userID uint) ([]domain.Trade, error)
+	GetByUserIDAndSymbols(ctx context.Context, userID uint, symbols []string) ([]domain.Trade, error)
 	GetAll(ctx context.Context) ([]domain.Trade, error) // For Admins
+	List(ctx context.Context, filter TradeFilter) ([]domain.Trade, error)
+	Count(ctx context.Context, filter TradeFilter) (int64, error)
+	GetExternalIDs(ctx context.Context, userID uint, source string, ids []string) (map[string]bool, error)
+	CountBySymbol(ctx context.Context, symbol string) (int64, error)
+	// WithUserLock runs fn atomically, no other WithUserLock of the same user runs until it returns
+	WithUserLock(ctx context.Context, userID uint, fn func(ctx context.Context) error) error
 }
 
 type tradeRepository struct {
@@ -22,19 +34,125 @@ func NewTradeRepository(db *gorm.DB) TradeRepository {
 }
 
 func (r *tradeRepository) Create(ctx context.Context, trade *domain.Trade) error {
-	return r.db.WithContext(ctx).Create(trade).Error
+	return conn(ctx, r.db).Create(trade).Error
+}
+
+// @desc: insert many trades with their fee lines in batches, ids are set on the trades
+func (r *tradeRepository) CreateBatch(ctx context.Context, trades []*domain.Trade) error {
+	if len(trades) == 0 {
+		return nil
+	}
+	return conn(ctx, r.db).CreateInBatches(trades, 500).Error
+}
+
+// @desc: trade by id with fees and lot selections, nil when there is none; withDeleted also finds soft-deleted trades
+func (r *tradeRepository) GetByID(ctx context.Context, id uint, withDeleted bool) (*domain.Trade, error) {
+	db := conn(ctx, r.db)
+	if withDeleted {
+		db = db.Unscoped()
+	}
+	var trades []domain.Trade
+	err := db.Preload("Fees").Preload("LotSelections").Where("id = ?", id).Limit(1).Find(&trades).Error
+	if err != nil || len(trades) == 0 {
+		return nil, err
+	}
+	return &trades[0], nil
+}
+
+// @desc: save the trade columns and replace its fee lines and lot selections
+func (r *tradeRepository) Update(ctx context.Context, trade *domain.Trade) error {
+	return conn(ctx, r.db).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("trade_id = ?", trade.ID).Delete(&domain.TradeFee{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("trade_id = ?", trade.ID).Delete(&domain.LotSelection{}).Error; err != nil {
+			return err
+		}
+		for i := range trade.Fees {
+			trade.Fees[i].ID, trade.Fees[i].TradeID = 0, trade.ID
+		}
+		for i := range trade.LotSelections {
+			trade.LotSelections[i].ID, trade.LotSelections[i].TradeID = 0, trade.ID
+		}
+		return tx.Session(&gorm.Session{FullSaveAssociations: true}).Save(trade).Error
+	})
+}
+
+// @desc: soft delete, the row stays and can be restored
+func (r *tradeRepository) Delete(ctx context.Context, id uint) error {
+	return conn(ctx, r.db).Delete(&domain.Trade{}, id).Error
+}
+
+func (r *tradeRepository) Restore(ctx context.Context, id uint) error {
+	return conn(ctx, r.db).Unscoped().Model(&domain.Trade{}).Where("id = ?", id).Update("deleted_at", nil).Error
 }
 
 // @desc: get trades for a specific user
 func (r *tradeRepository) GetByUserID(ctx context.Context, userID uint) ([]domain.Trade, error) {
 	var trades []domain.Trade
-	err := r.db.WithContext(ctx).Where("user_id = ?", userID).Find(&trades).Error
+	err := conn(ctx, r.db).Preload("Fees").Preload("LotSelections").Where("user_id = ?", userID).Find(&trades).Error
+	return trades, err
+}
+
+// @desc: trades of a user in the given symbols only
+func (r *tradeRepository) GetByUserIDAndSymbols(ctx context.Context, userID uint, symbols []string) ([]domain.Trade, error) {
+	var trades []domain.Trade
+	if len(symbols) == 0 {
+		return trades, nil
+	}
+	err := conn(ctx, r.db).Preload("Fees").Preload("LotSelections").Where("user_id = ? AND symbol IN ?", userID, symbols).Find(&trades).Error
 	return trades, err
 }
 
 // @desc: get all trades (admin)
 func (r *tradeRepository) GetAll(ctx context.Context) ([]domain.Trade, error) {
 	var trades []domain.Trade
-	err := r.db.WithContext(ctx).Find(&trades).Error
+	err := conn(ctx, r.db).Preload("Fees").Preload("LotSelections").Find(&trades).Error
 	return trades, err
 }
+
+// @desc: one page of trades matching the filter
+func (r *tradeRepository) List(ctx context.Context, filter TradeFilter) ([]domain.Trade, error) {
+	db, err := filter.page(filter.scope(conn(ctx, r.db)))
+	if err != nil {
+		return nil, err
+	}
+	var trades []domain.Trade
+	err = db.Preload("Fees").Preload("LotSelections").Find(&trades).Error
+	return trades, err
+}
+
+// @desc: number of trades matching the filter, paging is ignored
+func (r *tradeRepository) Count(ctx context.Context, filter TradeFilter) (int64, error) {
+	var total int64
+	err := filter.scope(conn(ctx, r.db).Model(&domain.Trade{})).Count(&total).Error
+	return total, err
+}
+
+// @desc: which of ids a user already has from source, deleted trades count so a re-import doesn't bring them back
+func (r *tradeRepository) GetExternalIDs(ctx context.Context, userID uint, source string, ids []string) (map[string]bool, error) {
+	found := make(map[string]bool)
+	if len(ids) == 0 {
+		return found, nil
+	}
+	var existing []string
+	err := conn(ctx, r.db).Unscoped().Model(&domain.Trade{}).
+		Where("user_id = ? AND source = ? AND external_id IN ?", userID, source, ids).
+		Pluck("external_id", &existing).Error
+	for _, id := range existing {
+		found[id] = true
+	}
+	return found, err
+}
+
+// @desc: number of trades of a symbol across all users, deleted trades count since they can be restored
+func (r *tradeRepository) CountBySymbol(ctx context.Context, symbol string) (int64, error) {
+	var total int64
+	err := conn(ctx, r.db).Unscoped().Model(&domain.Trade{}).Where("symbol = ?", symbol).Count(&total).Error
+	return total, err
+}
+
+// @desc: serialize check-then-write sequences of one user (SELL balance checks, buying power checks)
+func (r *tradeRepository) WithUserLock(ctx context.Context, userID uint, fn func(ctx context.Context) error) error {
+	return withUserLock(ctx, r.db, userID, fn)
+}
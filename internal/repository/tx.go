@@ -0,0 +1,70 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+/*
+NOTE:
+	A transaction travels in the context so the service layer can group calls to
+	several repositories without knowing about GORM. Repositories get their
+	handle from conn(), which picks the transaction up when there is one.
+*/
+
+type txKey struct{}
+
+// userLockKey marks in the context that the transaction holds the advisory lock of a user
+type userLockKey struct{ userID uint }
+
+// userLockNamespace fills the top 16 bits of the 64-bit advisory lock key of a user, it keeps those
+// locks apart from other advisory locks; the user id takes the remaining 48 bits
+const (
+	userLockNamespace = 7201
+	userLockIDBits    = 48
+)
+
+// conn returns the transaction carried by ctx, or db when there is none
+func conn(ctx context.Context, db *gorm.DB) *gorm.DB {
+	if tx, ok := ctx.Value(txKey{}).(*gorm.DB); ok {
+		return tx.WithContext(ctx)
+	}
+	return db.WithContext(ctx)
+}
+
+// @desc: run fn in a transaction that holds the advisory lock of the user
+// @flow: begin -> pg_advisory_xact_lock(namespace << 48 | user) -> fn -> commit (the lock is released with the transaction)
+// Calls nested inside fn reuse the outer transaction, a nested call for another user takes that user's lock in it too.
+func withUserLock(ctx context.Context, db *gorm.DB, userID uint, fn func(ctx context.Context) error) error {
+	if ctx.Value(userLockKey{userID}) != nil {
+		return fn(ctx)
+	}
+	if tx, ok := ctx.Value(txKey{}).(*gorm.DB); ok {
+		return lockUser(ctx, tx, userID, fn)
+	}
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return lockUser(context.WithValue(ctx, txKey{}, tx), tx, userID, fn)
+	})
+}
+
+// lockUser takes the advisory lock of the user in tx and runs fn with the lock recorded in the context
+func lockUser(ctx context.Context, tx *gorm.DB, userID uint, fn func(ctx context.Context) error) error {
+	key, err := userLockID(userID)
+	if err != nil {
+		return err
+	}
+	if err := tx.WithContext(ctx).Exec("SELECT pg_advisory_xact_lock(?::bigint)", key).Error; err != nil {
+		return err
+	}
+	return fn(context.WithValue(ctx, userLockKey{userID}, true))
+}
+
+// userLockID is the 64-bit advisory lock key of a user, ids that don't fit are refused rather than truncated
+func userLockID(userID uint) (int64, error) {
+	if uint64(userID) >= 1<<userLockIDBits {
+		return 0, fmt.Errorf("user id %d is too large for an advisory lock key", userID)
+	}
+	return userLockNamespace<<userLockIDBits | int64(userID), nil
+}
@@ -0,0 +1,97 @@
+package repository
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// testDB connects to the Postgres named by TRADELOG_TEST_DATABASE_URL, advisory locks need a real server
+func testDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := os.Getenv("TRADELOG_TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TRADELOG_TEST_DATABASE_URL is not set")
+	}
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	require.NoError(t, err)
+	return db
+}
+
+// heldElsewhere reports whether another session holds the advisory lock of the user
+func heldElsewhere(t *testing.T, db *gorm.DB, userID uint) bool {
+	t.Helper()
+	key, err := userLockID(userID)
+	require.NoError(t, err)
+	var got bool
+	require.NoError(t, db.Raw("SELECT pg_try_advisory_xact_lock(?::bigint)", key).Scan(&got).Error)
+	return !got
+}
+
+func TestWithUserLock_SerializesTheSameUser(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+
+	locked, release := make(chan struct{}), make(chan struct{})
+	go withUserLock(ctx, db, 1, func(ctx context.Context) error {
+		close(locked)
+		<-release
+		return nil
+	})
+	<-locked
+
+	second := make(chan struct{})
+	go func() {
+		withUserLock(ctx, db, 1, func(ctx context.Context) error { return nil })
+		close(second)
+	}()
+
+	select {
+	case <-second:
+		t.Fatal("a second transaction of the same user ran while the first held the lock")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// another user is not held up, also when its id only differs above 32 bits
+	require.NoError(t, withUserLock(ctx, db, 1+1<<32, func(ctx context.Context) error { return nil }))
+
+	close(release)
+	select {
+	case <-second:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the second transaction never got the lock")
+	}
+}
+
+func TestWithUserLock_NestedCallLocksTheOtherUser(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+
+	err := withUserLock(ctx, db, 1, func(ctx context.Context) error {
+		assert.False(t, heldElsewhere(t, db, 2))
+		return withUserLock(ctx, db, 2, func(ctx context.Context) error {
+			assert.True(t, heldElsewhere(t, db, 1))
+			assert.True(t, heldElsewhere(t, db, 2))
+			return nil
+		})
+	})
+	require.NoError(t, err)
+	assert.False(t, heldElsewhere(t, db, 2))
+}
+
+func TestUserLockID_RefusesIDsThatDoNotFit(t *testing.T) {
+	_, err := userLockID(1 << userLockIDBits)
+	assert.ErrorContains(t, err, "too large")
+
+	low, err := userLockID(1)
+	require.NoError(t, err)
+	high, err := userLockID(1 + 1<<32)
+	require.NoError(t, err)
+	assert.NotEqual(t, low, high)
+}
@@ -0,0 +1,325 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/MonalBarse/tradelog/internal/domain"
+	"github.com/MonalBarse/tradelog/internal/repository"
+	"github.com/shopspring/decimal"
+)
+
+/*
+NOTE:
+	An account is a book of its own inside a user's holdings: SELLs are
+	checked against what the account holds and close only its lots (see
+	lots.go), positions are kept per account. Trades logged without an
+	account, including everything from before accounts existed, make up the
+	user's default account (id 0 in filters and breakdowns). Imports, in-kind
+	income, borrow fees and derivative events name the account they belong to
+	the same way, so a statement, the dividend shares it pays or the fee of a
+	short stay with the holding they concern.
+	The portfolio of one account is valued in the account's base currency.
+	The consolidated portfolio puts the lots of every account together, in
+	the user's base currency, with what each account holds of every item.
+	Short selling is allowed per account: in the default account by the
+	user's allow_short setting, in every other account by its own AllowShort,
+	so shorting futures in a margin account leaves a cash account long only.
+	The other settings (cost method, strict cash) and the cash ledger stay
+	per user.
+*/
+
+var ErrAccountNotFound = errors.New("account not found")
+
+// AccountInput carries the user supplied fields of an account
+type AccountInput struct {
+	Name         string
+	Broker       string
+	BaseCurrency string // defaults to the user's base currency
+	Type         string
+	AllowShort   bool
+}
+
+// AccountHolding is what one account holds of a consolidated portfolio item, amounts in the item's currency
+type AccountHolding struct {
+	AccountID uint            `json:"account_id"` // 0 for the default account
+	Quantity  decimal.Decimal `json:"quantity"`
+	CostBasis decimal.Decimal `json:"cost_basis"`
+	Value     decimal.Decimal `json:"value"`
+}
+
+// AccountTotal is the consolidated value of one account in the user's base currency
+type AccountTotal struct {
+	AccountID      uint            `json:"account_id"`
+	TotalCostBasis decimal.Decimal `json:"total_cost_basis"`
+	TotalValue     decimal.Decimal `json:"total_value"`
+}
+
+type AccountService interface {
+	Create(ctx context.Context, userID uint, input AccountInput) (*domain.Account, error)
+	Update(ctx context.Context, userID, accountID uint, input AccountInput) (*domain.Account, error)
+	List(ctx context.Context, userID uint) ([]domain.Account, error)
+}
+
+type accountService struct {
+	repo     repository.AccountRepository
+	userRepo repository.UserRepository
+}
+
+func NewAccountService(repo repository.AccountRepository, userRepo repository.UserRepository) AccountService {
+	return &accountService{repo: repo, userRepo: userRepo}
+}
+
+// IsValidAccountType reports whether accountType is one of the supported account types
+func IsValidAccountType(accountType string) bool {
+	switch accountType {
+	case domain.AccountTypeBrokerage, domain.AccountTypeRetirement, domain.AccountTypeExchange, domain.AccountTypeWallet:
+		return true
+	}
+	return false
+}
+
+// @desc: open a new account for the user
+// @flow: validate -> default the base currency to the user's -> make sure the name is free -> save
+func (s *accountService) Create(ctx context.Context, userID uint, input AccountInput) (*domain.Account, error) {
+	account := &domain.Account{UserID: userID}
+	if err := s.applyAccountInput(ctx, account, input); err != nil {
+		return nil, err
+	}
+	if err := s.checkNameFree(ctx, account); err != nil {
+		return nil, err
+	}
+	if err := s.repo.Create(ctx, account); err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+// @desc: rename or retype an account of the user, its trades stay where they are
+func (s *accountService) Update(ctx context.Context, userID, accountID uint, input AccountInput) (*domain.Account, error) {
+	account, err := s.repo.GetByID(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	if account == nil || account.UserID != userID {
+		return nil, ErrAccountNotFound
+	}
+	if err := s.applyAccountInput(ctx, account, input); err != nil {
+		return nil, err
+	}
+	if err := s.checkNameFree(ctx, account); err != nil {
+		return nil, err
+	}
+	if err := s.repo.Update(ctx, account); err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+func (s *accountService) List(ctx context.Context, userID uint) ([]domain.Account, error) {
+	accounts, err := s.repo.GetByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if accounts == nil {
+		accounts = []domain.Account{}
+	}
+	return accounts, nil
+}
+
+func (s *accountService) applyAccountInput(ctx context.Context, account *domain.Account, input AccountInput) error {
+	name := strings.TrimSpace(input.Name)
+	if name == "" {
+		return errors.New("name is required")
+	}
+	accountType := strings.ToUpper(strings.TrimSpace(input.Type))
+	if !IsValidAccountType(accountType) {
+		return fmt.Errorf("unsupported account type %q", input.Type)
+	}
+
+	baseCurrency := strings.ToUpper(strings.TrimSpace(input.BaseCurrency))
+	if baseCurrency == "" {
+		baseCurrency = defaultBaseCurrency
+		if s.userRepo != nil {
+			user, err := s.userRepo.FindByID(ctx, account.UserID)
+			if err != nil {
+				return err
+			}
+			if user.BaseCurrency != "" {
+				baseCurrency = user.BaseCurrency
+			}
+		}
+	}
+
+	account.Name = name
+	account.Broker = strings.TrimSpace(input.Broker)
+	account.BaseCurrency = baseCurrency
+	account.Type = accountType
+	account.AllowShort = input.AllowShort
+	return nil
+}
+
+func (s *accountService) checkNameFree(ctx context.Context, account *domain.Account) error {
+	accounts, err := s.repo.GetByUserID(ctx, account.UserID)
+	if err != nil {
+		return err
+	}
+	for _, other := range accounts {
+		if other.ID != account.ID && strings.EqualFold(other.Name, account.Name) {
+			return fmt.Errorf("an account named %q already exists", other.Name)
+		}
+	}
+	return nil
+}
+
+// WithAccountRepository enables booking trades into accounts and account portfolios
+func WithAccountRepository(acctRepo repository.AccountRepository) TradeServiceOption {
+	return func(s *tradeService) {
+		s.acctRepo = acctRepo
+	}
+}
+
+// @desc: get the portfolio of one account (0 for the default one) and the base currency of the account it is valued in
+func (s *tradeService) GetAccountPortfolio(ctx context.Context, userID, accountID uint, at *time.Time) ([]PortfolioItem, string, error) {
+	if err := s.checkAccount(ctx, userID, accountRef(accountID)); err != nil {
+		return nil, "", err
+	}
+	settings, err := s.GetSettings(ctx, userID)
+	if err != nil {
+		return nil, "", err
+	}
+	baseCurrency, err := s.accountBaseCurrency(ctx, userID, &accountID, settings.BaseCurrency)
+	if err != nil {
+		return nil, "", err
+	}
+	items, err := s.portfolio(ctx, userID, &accountID, at)
+	if err != nil {
+		return nil, "", err
+	}
+	return items, baseCurrency, nil
+}
+
+// checkAccount makes sure the account exists and belongs to the user, nil is the default account
+func (s *tradeService) checkAccount(ctx context.Context, userID uint, accountID *uint) error {
+	if accountID == nil {
+		return nil
+	}
+	_, err := s.ownAccount(ctx, userID, *accountID)
+	return err
+}
+
+func (s *tradeService) ownAccount(ctx context.Context, userID, accountID uint) (*domain.Account, error) {
+	if s.acctRepo == nil {
+		return nil, errors.New("accounts are not available")
+	}
+	account, err := s.acctRepo.GetByID(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	if account == nil || account.UserID != userID {
+		return nil, fmt.Errorf("%w: %d", ErrAccountNotFound, accountID)
+	}
+	return account, nil
+}
+
+// shortPolicy is where the user may short: the default account by the settings, every other account by its own flag
+func (s *tradeService) shortPolicy(ctx context.Context, userID uint, settings *UserSettings) (shortPolicy, error) {
+	allowed := map[uint]bool{0: settings.AllowShort}
+	if s.acctRepo != nil {
+		accounts, err := s.acctRepo.GetByUserID(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		for _, account := range accounts {
+			allowed[account.ID] = account.AllowShort
+		}
+	}
+	return func(accountID uint) bool { return allowed[accountID] }, nil
+}
+
+// accountBaseCurrency is the reporting currency of a portfolio, the account's own for a single account
+func (s *tradeService) accountBaseCurrency(ctx context.Context, userID uint, accountID *uint, userBase string) (string, error) {
+	if accountID == nil || *accountID == 0 {
+		return userBase, nil
+	}
+	account, err := s.ownAccount(ctx, userID, *accountID)
+	if err != nil {
+		return "", err
+	}
+	if account.BaseCurrency == "" {
+		return userBase, nil
+	}
+	return account.BaseCurrency, nil
+}
+
+// accountRef turns an account id of a filter or path into the reference stored on trades, 0 is the default account
+func accountRef(accountID uint) *uint {
+	if accountID == 0 {
+		return nil
+	}
+	return &accountID
+}
+
+// accountKey is the id filters and breakdowns use for an account reference
+func accountKey(accountID *uint) uint {
+	if accountID == nil {
+		return 0
+	}
+	return *accountID
+}
+
+// accountTrades keeps the trades booked in one account
+func accountTrades(trades []domain.Trade, accountID uint) []domain.Trade {
+	var kept []domain.Trade
+	for _, t := range trades {
+		if t.AccountKey() == accountID {
+			kept = append(kept, t)
+		}
+	}
+	return kept
+}
+
+// addAccountHoldings splits a valued item by the accounts its lots are in, items of the default account alone are left as they are
+func addAccountHoldings(item *PortfolioItem) {
+	byAccount := make(map[uint]*AccountHolding)
+	for _, lot := range item.Lots {
+		holding, ok := byAccount[lot.AccountID]
+		if !ok {
+			holding = &AccountHolding{AccountID: lot.AccountID}
+			byAccount[lot.AccountID] = holding
+		}
+		holding.Quantity = holding.Quantity.Add(lot.Quantity)
+		holding.CostBasis = holding.CostBasis.Add(lot.CostBasis)
+	}
+	if _, onlyDefault := byAccount[0]; onlyDefault && len(byAccount) == 1 {
+		return
+	}
+
+	item.Accounts = make([]AccountHolding, 0, len(byAccount))
+	for _, holding := range byAccount {
+		if item.PriceAsOf != nil {
+			holding.Value = holding.Quantity.Mul(item.Price).Mul(item.Multiplier)
+		}
+		item.Accounts = append(item.Accounts, *holding)
+	}
+	sort.Slice(item.Accounts, func(i, j int) bool { return item.Accounts[i].AccountID < item.Accounts[j].AccountID })
+}
+
+// addAccountTotals adds the converted account holdings of an item to the per account totals
+func addAccountTotals(totals []AccountTotal, item PortfolioItem) []AccountTotal {
+	for _, holding := range item.Accounts {
+		i := sort.Search(len(totals), func(i int) bool { return totals[i].AccountID >= holding.AccountID })
+		if i == len(totals) || totals[i].AccountID != holding.AccountID {
+			totals = append(totals, AccountTotal{})
+			copy(totals[i+1:], totals[i:])
+			totals[i] = AccountTotal{AccountID: holding.AccountID}
+		}
+		totals[i].TotalCostBasis = totals[i].TotalCostBasis.Add(holding.CostBasis.Mul(item.FXRate))
+		totals[i].TotalValue = totals[i].TotalValue.Add(holding.Value.Mul(item.FXRate))
+	}
+	return totals
+}
@@ -0,0 +1,174 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/MonalBarse/tradelog/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type MockAccountRepo struct {
+	mock.Mock
+}
+
+func (m *MockAccountRepo) Create(ctx context.Context, account *domain.Account) error {
+	return m.Called(ctx, account).Error(0)
+}
+
+func (m *MockAccountRepo) Update(ctx context.Context, account *domain.Account) error {
+	return m.Called(ctx, account).Error(0)
+}
+
+func (m *MockAccountRepo) GetByID(ctx context.Context, id uint) (*domain.Account, error) {
+	args := m.Called(ctx, id)
+	account, _ := args.Get(0).(*domain.Account)
+	return account, args.Error(1)
+}
+
+func (m *MockAccountRepo) GetByUserID(ctx context.Context, userID uint) ([]domain.Account, error) {
+	args := m.Called(ctx, userID)
+	accounts, _ := args.Get(0).([]domain.Account)
+	return accounts, args.Error(1)
+}
+
+func TestCreateAccount_DefaultsCurrencyAndRejectsDuplicateNames(t *testing.T) {
+	repo, userRepo := new(MockAccountRepo), new(MockUserRepo)
+	service := NewAccountService(repo, userRepo)
+	ctx := context.Background()
+
+	userRepo.On("FindByID", ctx, uint(1)).Return(&domain.User{ID: 1, BaseCurrency: "EUR"}, nil)
+	repo.On("GetByUserID", ctx, uint(1)).Return([]domain.Account{{ID: 1, UserID: 1, Name: "Kraken"}}, nil)
+	repo.On("Create", ctx, mock.Anything).Return(nil)
+
+	_, err := service.Create(ctx, 1, AccountInput{Name: "kraken", Type: "EXCHANGE"})
+	assert.ErrorContains(t, err, `an account named "Kraken" already exists`)
+
+	_, err = service.Create(ctx, 1, AccountInput{Name: "Pension", Type: "SAVINGS"})
+	assert.ErrorContains(t, err, "unsupported account type")
+
+	account, err := service.Create(ctx, 1, AccountInput{Name: " Roth IRA ", Broker: "Fidelity", Type: "retirement"})
+	require.NoError(t, err)
+	assert.Equal(t, "Roth IRA", account.Name)
+	assert.Equal(t, domain.AccountTypeRetirement, account.Type)
+	assert.Equal(t, "EUR", account.BaseCurrency)
+	repo.AssertNumberOfCalls(t, "Create", 1)
+}
+
+func TestCreateTrade_SellsOnlyFromItsOwnAccount(t *testing.T) {
+	repo, instRepo, acctRepo := &memTradeRepo{}, new(MockInstrumentRepo), new(MockAccountRepo)
+	service := NewTradeService(repo, WithInstrumentRepository(instRepo), WithAccountRepository(acctRepo))
+	ctx := context.Background()
+	broker, exchange := uint(2), uint(3)
+
+	instRepo.On("GetBySymbol", ctx, "BTC/USD").Return(btcInstrument(), nil)
+	acctRepo.On("GetByID", ctx, broker).Return(&domain.Account{ID: broker, UserID: 1, Name: "Broker"}, nil)
+	acctRepo.On("GetByID", ctx, exchange).Return(&domain.Account{ID: exchange, UserID: 1, Name: "Exchange", BaseCurrency: "USD"}, nil)
+	acctRepo.On("GetByID", ctx, uint(9)).Return(&domain.Account{ID: 9, UserID: 2, Name: "Someone else's"}, nil)
+
+	buy := TradeInput{AccountID: &broker, Symbol: "BTC/USD", Type: "BUY", Price: d("50000"), Quantity: d("1")}
+	require.NoError(t, service.CreateTrade(ctx, 1, buy))
+
+	other := uint(9)
+	buy.AccountID = &other
+	assert.True(t, errors.Is(service.CreateTrade(ctx, 1, buy), ErrAccountNotFound))
+
+	sell := TradeInput{AccountID: &exchange, Symbol: "BTC/USD", Type: "SELL", Price: d("55000"), Quantity: d("0.5")}
+	assert.ErrorContains(t, service.CreateTrade(ctx, 1, sell), "insufficient funds")
+	sell.AccountID = nil
+	assert.ErrorContains(t, service.CreateTrade(ctx, 1, sell), "insufficient funds")
+
+	sell.AccountID = &broker
+	require.NoError(t, service.CreateTrade(ctx, 1, sell))
+	require.NoError(t, service.CreateTrade(ctx, 1, TradeInput{AccountID: &exchange, Symbol: "BTC/USD", Type: "BUY", Price: d("60000"), Quantity: d("0.2")}))
+
+	portfolio, err := service.GetPortfolio(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, portfolio, 1)
+	assert.True(t, d("0.7").Equal(portfolio[0].Quantity))
+	require.Len(t, portfolio[0].Accounts, 2)
+	assert.Equal(t, broker, portfolio[0].Accounts[0].AccountID)
+	assert.True(t, d("25000").Equal(portfolio[0].Accounts[0].CostBasis))
+	assert.Equal(t, exchange, portfolio[0].Accounts[1].AccountID)
+	assert.True(t, d("12000").Equal(portfolio[0].Accounts[1].CostBasis))
+
+	summary := SummarizePortfolio(portfolio, "USD")
+	require.Len(t, summary.Accounts, 2)
+	assert.True(t, d("12000").Equal(summary.Accounts[1].TotalCostBasis))
+
+	held, baseCurrency, err := service.GetAccountPortfolio(ctx, 1, exchange, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "USD", baseCurrency)
+	require.Len(t, held, 1)
+	assert.True(t, d("0.2").Equal(held[0].Quantity))
+	assert.Empty(t, held[0].Accounts)
+}
+
+func TestAccounts_ImportIncomeAndBorrowFeesStayInTheirAccount(t *testing.T) {
+	opened := time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)
+	broker, margin := uint(2), uint(3)
+	repo := &memTradeRepo{}
+	acctRepo, incomeRepo, borrowRepo, cashRepo := new(MockAccountRepo), new(MockIncomeRepo), new(MockBorrowFeeRepo), new(MockCashRepo)
+	service := NewTradeService(repo,
+		WithAccountRepository(acctRepo),
+		WithIncomeRepository(incomeRepo),
+		WithBorrowFeeRepository(borrowRepo),
+		WithCashRepository(cashRepo),
+	)
+	ctx := context.Background()
+
+	accounts := []domain.Account{{ID: broker, UserID: 1, Name: "Broker"}, {ID: margin, UserID: 1, Name: "Margin", AllowShort: true}}
+	acctRepo.On("GetByID", ctx, broker).Return(&accounts[0], nil)
+	acctRepo.On("GetByID", ctx, margin).Return(&accounts[1], nil)
+	acctRepo.On("GetByUserID", ctx, uint(1)).Return(accounts, nil)
+	incomeRepo.On("Create", ctx, mock.Anything).Return(nil)
+	incomeRepo.On("Update", ctx, mock.Anything).Return(nil)
+	borrowRepo.On("Create", ctx, mock.Anything).Return(nil)
+	borrowRepo.On("Update", ctx, mock.Anything).Return(nil)
+	cashRepo.On("Create", ctx, mock.Anything).Return(nil)
+	cashRepo.On("GetByUserID", ctx, uint(1)).Return([]domain.CashMovement{}, nil)
+
+	// a statement imported into the broker account, its account is looked up once
+	day := opened.AddDate(0, 0, 1)
+	rows := []ImportRow{
+		{Line: 2, Input: TradeInput{AccountID: &broker, Symbol: "AAPL/USD", Type: "BUY", Price: d("150"), Quantity: d("10"), ExecutedAt: &day}},
+		{Line: 3, Input: TradeInput{AccountID: &broker, Symbol: "AAPL/USD", Type: "SELL", Price: d("160"), Quantity: d("4"), ExecutedAt: &day}},
+	}
+	report, err := service.ImportTrades(ctx, 1, rows, false)
+	require.NoError(t, err)
+	assert.Empty(t, report.Errors)
+	acctRepo.AssertNumberOfCalls(t, "GetByID", 1)
+
+	_, err = service.RecordIncome(ctx, 1, IncomeInput{AccountID: &broker, Type: domain.IncomeStockDividend, Symbol: "AAPL/USD", Amount: d("2"), Price: d("155")})
+	require.NoError(t, err)
+	sell := TradeInput{Symbol: "AAPL/USD", Type: "SELL", Price: d("170"), Quantity: d("8")}
+	assert.ErrorContains(t, service.CreateTrade(ctx, 1, sell), "insufficient funds")
+	sell.AccountID = &broker
+	require.NoError(t, service.CreateTrade(ctx, 1, sell)) // 10 - 4 + 2 dividend units
+
+	// only the margin account may short
+	short := TradeInput{AccountID: &broker, Symbol: "TSLA/USD", Type: "SELL", Price: d("150"), Quantity: d("10"), ExecutedAt: &opened}
+	assert.ErrorContains(t, service.CreateTrade(ctx, 1, short), "would no longer add up")
+	short.AccountID = &margin
+	require.NoError(t, service.CreateTrade(ctx, 1, short))
+	charged := opened.AddDate(0, 0, 30)
+	_, err = service.RecordBorrowFee(ctx, 1, BorrowFeeInput{Symbol: "TSLA/USD", Amount: d("1"), ChargedAt: &charged})
+	assert.ErrorIs(t, err, ErrNoShortPosition) // the short is in the margin account
+	_, err = service.RecordBorrowFee(ctx, 1, BorrowFeeInput{AccountID: &margin, Symbol: "TSLA/USD", Amount: d("4.5"), ChargedAt: &charged})
+	require.NoError(t, err)
+
+	borrowRepo.On("GetByUserID", ctx, uint(1)).Return([]domain.BorrowFee{
+		{AccountID: &margin, Symbol: "TSLA/USD", Currency: "USD", Amount: d("4.5"), ChargedAt: charged},
+		{AccountID: &broker, Symbol: "TSLA/USD", Currency: "USD", Amount: d("3"), ChargedAt: charged}, // a short of another account
+	}, nil)
+	portfolio, err := service.GetPortfolio(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, portfolio, 1) // the AAPL lots are all sold
+	assert.Equal(t, "TSLA/USD", portfolio[0].Symbol)
+	require.NotNil(t, portfolio[0].BorrowFees)
+	assert.True(t, d("4.5").Equal(*portfolio[0].BorrowFees))
+}
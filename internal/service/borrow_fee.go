@@ -0,0 +1,206 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/MonalBarse/tradelog/internal/domain"
+	"github.com/MonalBarse/tradelog/internal/repository"
+	"github.com/shopspring/decimal"
+)
+
+/*
+NOTE:
+	Short selling is off by default, a SELL beyond the holding is rejected.
+	In an account that allows it (AllowShort of the account, the user's
+	setting for the default account) it opens a short position instead (see
+	lots.go), which costs a borrow fee for as long as it is open.
+	Brokers charge the fee daily or monthly, every charge is recorded as it
+	appears on the statement and paid out of the cash ledger by a BORROW_FEE
+	movement created in the same transaction. Fees are in the quote currency of
+	the symbol; the portfolio shows what an open short has cost so far and the
+	P&L takes them off the total return.
+*/
+
+var ErrNoShortPosition = errors.New("no short position")
+
+// BorrowFeeInput carries a borrow fee charged on a short position
+type BorrowFeeInput struct {
+	AccountID *uint // account of the short, nil for the default account
+	Symbol    string
+	Currency  string // defaults to the quote currency of the symbol
+	Amount    decimal.Decimal
+	Rate      decimal.Decimal // annual rate, optional
+	Notes     string
+	ChargedAt *time.Time // defaults to now
+}
+
+// WithBorrowFeeRepository enables borrow fees of short positions
+func WithBorrowFeeRepository(borrowRepo repository.BorrowFeeRepository) TradeServiceOption {
+	return func(s *tradeService) {
+		s.borrowRepo = borrowRepo
+	}
+}
+
+// @desc: record a borrow fee charged on a short position
+// @flow: validate -> lock user -> check the short was open when charged -> save fee -> cash movement -> link it to the fee
+func (s *tradeService) RecordBorrowFee(ctx context.Context, userID uint, input BorrowFeeInput) (*domain.BorrowFee, error) {
+	if s.borrowRepo == nil {
+		return nil, errors.New("borrow fee tracking is not available")
+	}
+	if s.cashRepo == nil {
+		return nil, errors.New("borrow fees need the cash ledger")
+	}
+	fee, err := s.newBorrowFee(ctx, userID, input)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.repo.WithUserLock(ctx, userID, func(ctx context.Context) error {
+		short, err := s.shortAt(ctx, userID, accountKey(fee.AccountID), fee.Symbol, fee.ChargedAt)
+		if err != nil {
+			return err
+		}
+		if !short {
+			return fmt.Errorf("%w in %s at %s", ErrNoShortPosition, fee.Symbol, fee.ChargedAt.Format(time.RFC3339))
+		}
+
+		if err := s.borrowRepo.Create(ctx, fee); err != nil {
+			return err
+		}
+		notes := "borrow fee " + fee.Symbol
+		if fee.Notes != "" {
+			notes += ": " + fee.Notes
+		}
+		movement := &domain.CashMovement{
+			UserID:     userID,
+			Type:       domain.CashBorrowFee,
+			Currency:   fee.Currency,
+			Amount:     fee.Amount.Neg(),
+			Notes:      notes,
+			OccurredAt: fee.ChargedAt,
+		}
+		if err := s.cashRepo.Create(ctx, movement); err != nil {
+			return err
+		}
+		fee.CashMovementID = &movement.ID
+		return s.borrowRepo.Update(ctx, fee)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fee, nil
+}
+
+func (s *tradeService) newBorrowFee(ctx context.Context, userID uint, input BorrowFeeInput) (*domain.BorrowFee, error) {
+	if !input.Amount.IsPositive() {
+		return nil, errors.New("amount must be positive")
+	}
+	if input.Rate.IsNegative() {
+		return nil, errors.New("rate cannot be negative")
+	}
+
+	if err := s.checkAccount(ctx, userID, input.AccountID); err != nil {
+		return nil, err
+	}
+
+	fee := &domain.BorrowFee{
+		UserID:    userID,
+		AccountID: input.AccountID,
+		Symbol:    domain.NormalizeSymbol(input.Symbol),
+		Amount:    input.Amount,
+		Rate:      input.Rate,
+		Notes:     input.Notes,
+		ChargedAt: time.Now(),
+	}
+	if input.ChargedAt != nil {
+		if input.ChargedAt.After(time.Now()) {
+			return nil, errors.New("charged_at cannot be in the future")
+		}
+		fee.ChargedAt = *input.ChargedAt
+	}
+	if s.instRepo != nil {
+		instrument, err := resolveInstrument(ctx, s.instRepo, input.Symbol)
+		if err != nil {
+			return nil, err
+		}
+		fee.Symbol = instrument.Symbol
+	}
+
+	base, quote := domain.SplitSymbol(fee.Symbol)
+	if base == "" || quote == "" {
+		return nil, fmt.Errorf("symbol %q must look like BASE/QUOTE", input.Symbol)
+	}
+	fee.Currency = strings.ToUpper(strings.TrimSpace(input.Currency))
+	if fee.Currency == "" {
+		fee.Currency = quote
+	}
+	if fee.Currency != quote {
+		return nil, fmt.Errorf("borrow fees of %s are charged in %s", fee.Symbol, quote)
+	}
+	return fee, nil
+}
+
+// shortAt reports whether the account had a short position in symbol open at the moment at
+func (s *tradeService) shortAt(ctx context.Context, userID, accountID uint, symbol string, at time.Time) (bool, error) {
+	trades, err := s.userTrades(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	book, err := matchLotsWithShorts(tradesUntil(accountTrades(trades, accountID), at), domain.CostMethodFIFO)
+	if err != nil {
+		return false, err
+	}
+	return len(book.short[symbol]) > 0, nil
+}
+
+// @desc: borrow fees of the user, oldest first
+func (s *tradeService) ListBorrowFees(ctx context.Context, userID uint) ([]domain.BorrowFee, error) {
+	if s.borrowRepo == nil {
+		return []domain.BorrowFee{}, nil
+	}
+	fees, err := s.borrowRepo.GetByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if fees == nil {
+		fees = []domain.BorrowFee{}
+	}
+	return fees, nil
+}
+
+// addBorrowFees fills the fees charged on every short item from its oldest open lot up to at,
+// each account's fees counted from that account's oldest open lot
+func (s *tradeService) addBorrowFees(ctx context.Context, userID uint, items []PortfolioItem, at time.Time) error {
+	if s.borrowRepo == nil {
+		return nil
+	}
+	fees, err := s.borrowRepo.GetByUserID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for i := range items {
+		item := &items[i]
+		if item.Side != PositionShort {
+			continue
+		}
+		opened := make(map[uint]time.Time) // account -> oldest open lot
+		for _, lot := range item.Lots {
+			if since, ok := opened[lot.AccountID]; !ok || lot.AcquiredAt.Before(since) {
+				opened[lot.AccountID] = lot.AcquiredAt
+			}
+		}
+		total := decimal.Zero
+		for _, fee := range fees {
+			since, ok := opened[accountKey(fee.AccountID)]
+			if ok && fee.Symbol == item.Symbol && !fee.ChargedAt.Before(since) && !fee.ChargedAt.After(at) {
+				total = total.Add(fee.Amount)
+			}
+		}
+		item.BorrowFees = &total
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/MonalBarse/tradelog/internal/domain"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type MockBorrowFeeRepo struct {
+	mock.Mock
+}
+
+func (m *MockBorrowFeeRepo) Create(ctx context.Context, fee *domain.BorrowFee) error {
+	return m.Called(ctx, fee).Error(0)
+}
+
+func (m *MockBorrowFeeRepo) Update(ctx context.Context, fee *domain.BorrowFee) error {
+	return m.Called(ctx, fee).Error(0)
+}
+
+func (m *MockBorrowFeeRepo) GetByUserID(ctx context.Context, userID uint) ([]domain.BorrowFee, error) {
+	args := m.Called(ctx, userID)
+	fees, _ := args.Get(0).([]domain.BorrowFee)
+	return fees, args.Error(1)
+}
+
+func TestCreateTrade_ShortSellingFollowsSetting(t *testing.T) {
+	repo := &memTradeRepo{}
+	userRepo := new(MockUserRepo)
+	service := NewTradeService(repo, WithUserRepository(userRepo))
+	ctx := context.Background()
+
+	user := &domain.User{ID: 1, CostMethod: domain.CostMethodFIFO, BaseCurrency: "USD"}
+	userRepo.On("FindByID", ctx, uint(1)).Return(user, nil)
+
+	sell := TradeInput{Symbol: "TSLA/USD", Type: "SELL", Price: d("150"), Quantity: d("3")}
+	err := service.CreateTrade(ctx, 1, sell)
+	assert.EqualError(t, err, "insufficient funds: you cannot sell more than you own")
+
+	user.AllowShort = true
+	require.NoError(t, service.CreateTrade(ctx, 1, sell))
+
+	portfolio, err := service.GetPortfolio(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, portfolio, 1)
+	assert.Equal(t, PositionShort, portfolio[0].Side)
+	assert.True(t, d("-3").Equal(portfolio[0].Quantity))
+
+	// a backdated SELL before the short is checked against the setting too
+	user.AllowShort = false
+	past := time.Now().Add(-time.Hour)
+	err = service.CreateTrade(ctx, 1, TradeInput{Symbol: "TSLA/USD", Type: "SELL", Price: d("140"), Quantity: d("1"), ExecutedAt: &past})
+	assert.ErrorContains(t, err, "would no longer add up")
+}
+
+func TestRecordBorrowFee_PaidFromCashAndShownOnShort(t *testing.T) {
+	opened := time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)
+	repo := &memTradeRepo{trades: []domain.Trade{
+		{ID: 1, UserID: 1, Symbol: "TSLA/USD", Type: "SELL", Price: d("150"), Quantity: d("10"), ExecutedAt: opened},
+	}}
+	borrowRepo, cashRepo := new(MockBorrowFeeRepo), new(MockCashRepo)
+	service := NewTradeService(repo, WithBorrowFeeRepository(borrowRepo), WithCashRepository(cashRepo))
+	ctx := context.Background()
+
+	borrowRepo.On("Create", ctx, mock.Anything).Return(nil)
+	borrowRepo.On("Update", ctx, mock.Anything).Return(nil)
+	cashRepo.On("Create", ctx, mock.MatchedBy(func(m *domain.CashMovement) bool {
+		return m.Type == domain.CashBorrowFee && m.Currency == "USD" && m.Amount.Equal(d("-4.5"))
+	})).Return(nil)
+
+	charged := opened.AddDate(0, 0, 30)
+	fee, err := service.RecordBorrowFee(ctx, 1, BorrowFeeInput{Symbol: "tsla-usd", Amount: d("4.5"), Rate: d("0.036"), ChargedAt: &charged})
+	require.NoError(t, err)
+	assert.Equal(t, "TSLA/USD", fee.Symbol)
+	cashRepo.AssertExpectations(t)
+
+	before := opened.Add(-time.Hour)
+	_, err = service.RecordBorrowFee(ctx, 1, BorrowFeeInput{Symbol: "TSLA/USD", Amount: d("1"), ChargedAt: &before})
+	assert.ErrorIs(t, err, ErrNoShortPosition)
+	_, err = service.RecordBorrowFee(ctx, 1, BorrowFeeInput{Symbol: "TSLA/USD", Currency: "EUR", Amount: d("1")})
+	assert.ErrorContains(t, err, "charged in USD")
+
+	// once the short is covered it costs nothing more
+	covered := opened.AddDate(0, 0, 40)
+	repo.trades = append(repo.trades, domain.Trade{ID: 2, UserID: 1, Symbol: "TSLA/USD", Type: "BUY", Price: d("145"), Quantity: d("10"), ExecutedAt: covered})
+	late := covered.AddDate(0, 0, 5)
+	_, err = service.RecordBorrowFee(ctx, 1, BorrowFeeInput{Symbol: "TSLA/USD", Amount: d("1"), ChargedAt: &late})
+	assert.ErrorIs(t, err, ErrNoShortPosition)
+	repo.trades = repo.trades[:1]
+
+	borrowRepo.On("GetByUserID", ctx, uint(1)).Return([]domain.BorrowFee{
+		{Symbol: "TSLA/USD", Currency: "USD", Amount: d("4.5"), ChargedAt: charged},
+		{Symbol: "TSLA/USD", Currency: "USD", Amount: d("2"), ChargedAt: opened.Add(-24 * time.Hour)}, // an earlier short
+	}, nil)
+	portfolio, err := service.GetPortfolio(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, portfolio, 1)
+	require.NotNil(t, portfolio[0].BorrowFees)
+	assert.True(t, d("4.5").Equal(*portfolio[0].BorrowFees))
+
+	report, err := service.GetPnL(ctx, 1, map[string]decimal.Decimal{"TSLA/USD": d("140")}, "")
+	require.NoError(t, err)
+	assert.True(t, d("100").Equal(report.TotalUnrealized)) // 10 * (150 - 140)
+	assert.True(t, d("6.5").Equal(report.TotalBorrowFees))
+	assert.True(t, d("93.5").Equal(report.TotalReturn))
+}
@@ -0,0 +1,546 @@
+package service
+
+import (
+	"crypto/sha1"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MonalBarse/tradelog/internal/domain"
+	"github.com/shopspring/decimal"
+)
+
+/*
+NOTE:
+	An Importer turns one broker or exchange export into ImportRows, the rows
+	then go through ImportTrades like a mapped CSV. Every row carries the
+	importer name as Source and the broker's trade id as ExternalID, so
+	importing an overlapping statement again only adds the new trades.
+	Exports without a trade id (Binance) get a hash of the row instead, two
+	fills identical in every column are then taken for one.
+	New formats are added with RegisterImporter.
+*/
+
+// Importer parses a broker statement, rows that don't parse are reported by line (or element) number
+type Importer interface {
+	Name() string
+	Parse(r io.Reader, loc *time.Location) ([]ImportRow, []ImportRowError, error)
+}
+
+var (
+	importersMu sync.RWMutex
+	importers   = make(map[string]Importer)
+)
+
+func init() {
+	RegisterImporter(binanceImporter{})
+	RegisterImporter(coinbaseImporter{})
+	RegisterImporter(ibkrImporter{})
+	RegisterImporter(zerodhaImporter{})
+}
+
+// RegisterImporter makes an importer available by its name, a later registration replaces an earlier one
+func RegisterImporter(imp Importer) {
+	importersMu.Lock()
+	defer importersMu.Unlock()
+	importers[strings.ToLower(imp.Name())] = imp
+}
+
+// GetImporter finds a registered importer by name
+func GetImporter(name string) (Importer, bool) {
+	importersMu.RLock()
+	defer importersMu.RUnlock()
+	imp, ok := importers[strings.ToLower(strings.TrimSpace(name))]
+	return imp, ok
+}
+
+// ImporterNames lists the registered importers, sorted
+func ImporterNames() []string {
+	importersMu.RLock()
+	defer importersMu.RUnlock()
+	names := make([]string, 0, len(importers))
+	for name := range importers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ---------- shared helpers ----------
+
+// csvTable reads a CSV export into a header index, skipping any preamble before the line holding marker
+type csvTable struct {
+	reader *csv.Reader
+	cols   map[string]int
+	line   int
+}
+
+func newCSVTable(r io.Reader, marker string, required ...string) (*csvTable, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	reader.LazyQuotes = true
+
+	t := &csvTable{reader: reader}
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("no header row with %q found", marker)
+		}
+		if err != nil {
+			return nil, err
+		}
+		t.line++
+
+		cols := make(map[string]int, len(record))
+		for i, name := range record {
+			cols[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+		}
+		if _, ok := cols[marker]; !ok {
+			continue
+		}
+		for _, name := range required {
+			if _, ok := cols[name]; !ok {
+				return nil, fmt.Errorf("missing column %q", name)
+			}
+		}
+		t.cols = cols
+		return t, nil
+	}
+}
+
+// next returns the following non-empty record and its line number, io.EOF at the end
+func (t *csvTable) next() (func(string) string, []string, int, error) {
+	for {
+		record, err := t.reader.Read()
+		if err != nil {
+			return nil, nil, t.line, err
+		}
+		t.line++
+		if len(record) == 0 || (len(record) == 1 && strings.TrimSpace(record[0]) == "") {
+			continue
+		}
+		field := func(name string) string {
+			if i, ok := t.cols[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		return field, record, t.line, nil
+	}
+}
+
+// readCSVRows drives parse over every record of the table, collecting rows and per-line errors
+func readCSVRows(t *csvTable, parse func(field func(string) string, record []string) (*TradeInput, error)) ([]ImportRow, []ImportRowError, error) {
+	var rows []ImportRow
+	var rowErrors []ImportRowError
+	for {
+		field, record, line, err := t.next()
+		if errors.Is(err, io.EOF) {
+			return rows, rowErrors, nil
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if len(rows)+len(rowErrors) == MaxImportRows {
+			return nil, nil, fmt.Errorf("more than %d rows, split the file", MaxImportRows)
+		}
+
+		input, err := parse(field, record)
+		switch {
+		case err != nil:
+			rowErrors = append(rowErrors, ImportRowError{Line: line, Error: err.Error()})
+		case input != nil: // nil input: a row of a kind that isn't a trade (transfers, rewards, ...)
+			rows = append(rows, ImportRow{Line: line, Input: *input})
+		}
+	}
+}
+
+// parseAmount reads numbers as brokers print them: "$1,234.50", "-2.5", "1 000"
+func parseAmount(name, value string) (decimal.Decimal, error) {
+	cleaned := strings.Map(func(r rune) rune {
+		switch {
+		case r >= '0' && r <= '9', r == '.', r == '-':
+			return r
+		}
+		return -1
+	}, value)
+	amount, err := decimal.NewFromString(cleaned)
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("invalid %s %q", name, value)
+	}
+	return amount, nil
+}
+
+func parseSide(value string) (string, error) {
+	switch strings.ToUpper(strings.TrimSpace(value)) {
+	case "BUY", "B", "BOT":
+		return "BUY", nil
+	case "SELL", "S", "SLD":
+		return "SELL", nil
+	}
+	return "", fmt.Errorf("side %q must be BUY or SELL", value)
+}
+
+func parseTimeLayouts(name, value string, loc *time.Location, layouts ...string) (time.Time, error) {
+	for _, layout := range layouts {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid %s %q", name, value)
+}
+
+// rowHasher stands in for a trade id when the export has none. Identical rows are separate fills
+// (partial fills often share time, price and amount), so each copy after the first hashes its
+// occurrence number too; the first copy keeps the plain content hash and the same file always
+// gets the same ids.
+type rowHasher map[string]int
+
+func (h rowHasher) hash(record []string) string {
+	content := strings.Join(record, "\x1f")
+	h[content]++
+	if n := h[content]; n > 1 {
+		content += fmt.Sprintf("\x1e%d", n)
+	}
+	sum := sha1.Sum([]byte(content))
+	return "sha1:" + hex.EncodeToString(sum[:10])
+}
+
+func commission(amount decimal.Decimal, currency string) []domain.TradeFee {
+	if amount.IsZero() {
+		return nil
+	}
+	return []domain.TradeFee{{Type: domain.FeeTypeCommission, Amount: amount.Abs(), Currency: strings.ToUpper(currency)}}
+}
+
+// ---------- Binance spot trade history (CSV) ----------
+
+// binanceQuotes are tried longest first to split a market like "ETHBTC" without a separator
+var binanceQuotes = []string{"FDUSD", "USDT", "USDC", "BUSD", "TUSD", "BIDR", "BTC", "ETH", "BNB", "EUR", "GBP", "TRY", "BRL", "USD", "DAI", "INR"}
+
+var amountWithAsset = regexp.MustCompile(`^(-?[0-9][0-9,]*(?:\.[0-9]+)?)\s*([A-Za-z][A-Za-z0-9]*)$`)
+
+type binanceImporter struct{}
+
+func (binanceImporter) Name() string { return "binance" }
+
+// Parse reads both export layouts, times are UTC:
+//
+//	Date(UTC),Pair,Side,Price,Executed,Amount,Fee        (amounts suffixed with their asset, "0.5BTC")
+//	Date(UTC),Market,Type,Price,Amount,Total,Fee,Fee Coin
+func (binanceImporter) Parse(r io.Reader, _ *time.Location) ([]ImportRow, []ImportRowError, error) {
+	t, err := newCSVTable(r, "date(utc)", "price")
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, ok := t.cols["pair"]; !ok {
+		if _, ok := t.cols["market"]; !ok {
+			return nil, nil, errors.New(`missing column "pair" or "market"`)
+		}
+	}
+
+	hasher := rowHasher{}
+	return readCSVRows(t, func(field func(string) string, record []string) (*TradeInput, error) {
+		input := &TradeInput{Source: "binance", ExternalID: hasher.hash(record)}
+
+		side := field("side")
+		if side == "" {
+			side = field("type")
+		}
+		var err error
+		if input.Type, err = parseSide(side); err != nil {
+			return nil, err
+		}
+		if input.Price, err = parseAmount("price", field("price")); err != nil {
+			return nil, err
+		}
+		executedAt, err := parseTimeLayouts("date", field("date(utc)"), time.UTC, "2006-01-02 15:04:05", "06-01-02 15:04:05")
+		if err != nil {
+			return nil, err
+		}
+		input.ExecutedAt = &executedAt
+
+		var base, feeCurrency string
+		var fee decimal.Decimal
+		if pair := field("pair"); pair != "" {
+			// new layout: the asset is glued to the amount
+			var qty, feeAmount string
+			if qty, base, err = splitAmountAsset("executed", field("executed")); err != nil {
+				return nil, err
+			}
+			if input.Quantity, err = parseAmount("executed", qty); err != nil {
+				return nil, err
+			}
+			if raw := field("fee"); raw != "" {
+				if feeAmount, feeCurrency, err = splitAmountAsset("fee", raw); err != nil {
+					return nil, err
+				}
+				if fee, err = parseAmount("fee", feeAmount); err != nil {
+					return nil, err
+				}
+			}
+			quote := strings.TrimPrefix(strings.ToUpper(pair), base)
+			if quote == strings.ToUpper(pair) || quote == "" {
+				return nil, fmt.Errorf("pair %q does not start with the executed asset %s", pair, base)
+			}
+			input.Symbol = base + "/" + quote
+		} else {
+			market := strings.ToUpper(field("market"))
+			quote := ""
+			for _, q := range binanceQuotes {
+				if strings.HasSuffix(market, q) && len(market) > len(q) {
+					quote = q
+					break
+				}
+			}
+			if quote == "" {
+				return nil, fmt.Errorf("cannot split market %q into base and quote", market)
+			}
+			input.Symbol = strings.TrimSuffix(market, quote) + "/" + quote
+			if input.Quantity, err = parseAmount("amount", field("amount")); err != nil {
+				return nil, err
+			}
+			if raw := field("fee"); raw != "" {
+				if fee, err = parseAmount("fee", raw); err != nil {
+					return nil, err
+				}
+			}
+			feeCurrency = field("fee coin")
+		}
+		input.Fees = commission(fee, feeCurrency)
+		return input, nil
+	})
+}
+
+func splitAmountAsset(name, value string) (amount, asset string, err error) {
+	m := amountWithAsset.FindStringSubmatch(strings.TrimSpace(value))
+	if m == nil {
+		return "", "", fmt.Errorf("%s %q must look like 0.5BTC", name, value)
+	}
+	return m[1], strings.ToUpper(m[2]), nil
+}
+
+// ---------- Coinbase transaction history (CSV) ----------
+
+type coinbaseImporter struct{}
+
+func (coinbaseImporter) Name() string { return "coinbase" }
+
+// Parse reads the "Transactions" report, lines above the header are skipped and only buys and sells are kept
+func (coinbaseImporter) Parse(r io.Reader, _ *time.Location) ([]ImportRow, []ImportRowError, error) {
+	t, err := newCSVTable(r, "transaction type", "timestamp", "asset", "quantity transacted", "price currency", "price at transaction")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hasher := rowHasher{}
+	return readCSVRows(t, func(field func(string) string, record []string) (*TradeInput, error) {
+		kind := strings.ToUpper(field("transaction type"))
+		kind = strings.TrimPrefix(kind, "ADVANCED TRADE ")
+		if kind != "BUY" && kind != "SELL" {
+			return nil, nil // sends, receives, staking income, conversions ...
+		}
+
+		input := &TradeInput{
+			Type:       kind,
+			Symbol:     strings.ToUpper(field("asset")) + "/" + strings.ToUpper(field("price currency")),
+			Source:     "coinbase",
+			ExternalID: field("id"),
+			Notes:      field("notes"),
+		}
+		if input.ExternalID == "" {
+			input.ExternalID = hasher.hash(record)
+		}
+
+		var err error
+		if input.Quantity, err = parseAmount("quantity", field("quantity transacted")); err != nil {
+			return nil, err
+		}
+		input.Quantity = input.Quantity.Abs() // newer exports sign sells negative
+		if input.Price, err = parseAmount("price", field("price at transaction")); err != nil {
+			return nil, err
+		}
+		executedAt, err := parseTimeLayouts("timestamp", field("timestamp"), time.UTC, time.RFC3339, "2006-01-02 15:04:05 MST", "2006-01-02 15:04:05")
+		if err != nil {
+			return nil, err
+		}
+		input.ExecutedAt = &executedAt
+
+		if raw := field("fees and/or spread"); raw != "" {
+			fee, err := parseAmount("fees", raw)
+			if err != nil {
+				return nil, err
+			}
+			input.Fees = commission(fee, field("price currency"))
+		}
+		return input, nil
+	})
+}
+
+// ---------- Interactive Brokers Flex Query (XML) ----------
+
+type ibkrImporter struct{}
+
+func (ibkrImporter) Name() string { return "ibkr" }
+
+// Parse reads the <Trade> elements of a Flex Query, wherever they sit in the document.
+// Error "lines" are the position of the Trade element. Times without an offset are read in loc (UTC by default).
+func (ibkrImporter) Parse(r io.Reader, loc *time.Location) ([]ImportRow, []ImportRowError, error) {
+	if loc == nil {
+		loc = time.UTC
+	}
+	decoder := xml.NewDecoder(r)
+
+	var rows []ImportRow
+	var rowErrors []ImportRowError
+	for n := 0; ; {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid flex query: %w", err)
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "Trade" {
+			continue
+		}
+		n++
+		if len(rows)+len(rowErrors) == MaxImportRows {
+			return nil, nil, fmt.Errorf("more than %d trades, split the file", MaxImportRows)
+		}
+
+		attrs := make(map[string]string, len(start.Attr))
+		for _, a := range start.Attr {
+			attrs[a.Name.Local] = strings.TrimSpace(a.Value)
+		}
+		input, err := parseFlexTrade(attrs, loc)
+		switch {
+		case err != nil:
+			rowErrors = append(rowErrors, ImportRowError{Line: n, Error: err.Error()})
+		case input != nil:
+			rows = append(rows, ImportRow{Line: n, Input: *input})
+		}
+	}
+	if len(rows)+len(rowErrors) == 0 {
+		return nil, nil, errors.New("no <Trade> elements found")
+	}
+	return rows, rowErrors, nil
+}
+
+func parseFlexTrade(attrs map[string]string, loc *time.Location) (*TradeInput, error) {
+	// summaries and orders repeat the executions they are made of
+	if level := attrs["levelOfDetail"]; level != "" && !strings.EqualFold(level, "EXECUTION") {
+		return nil, nil
+	}
+	if strings.EqualFold(attrs["assetCategory"], "CASH") {
+		return nil, nil // FX conversions, not a position
+	}
+
+	input := &TradeInput{
+		Symbol:     strings.ToUpper(attrs["symbol"] + "/" + attrs["currency"]),
+		Source:     "ibkr",
+		ExternalID: attrs["tradeID"],
+	}
+	if input.ExternalID == "" {
+		input.ExternalID = attrs["ibExecID"]
+	}
+	if attrs["symbol"] == "" || attrs["currency"] == "" {
+		return nil, errors.New("symbol and currency are required")
+	}
+
+	var err error
+	if input.Type, err = parseSide(attrs["buySell"]); err != nil {
+		return nil, err
+	}
+	if input.Quantity, err = parseAmount("quantity", attrs["quantity"]); err != nil {
+		return nil, err
+	}
+	input.Quantity = input.Quantity.Abs() // sells are negative
+	if input.Price, err = parseAmount("tradePrice", attrs["tradePrice"]); err != nil {
+		return nil, err
+	}
+
+	stamp := attrs["dateTime"]
+	if stamp == "" {
+		stamp = strings.TrimSpace(attrs["tradeDate"] + ";" + attrs["tradeTime"])
+	}
+	executedAt, err := parseTimeLayouts("dateTime", strings.TrimSuffix(stamp, ";"), loc,
+		"20060102;150405", "20060102 150405", "2006-01-02;15:04:05", "2006-01-02 15:04:05", "2006-01-02, 15:04:05", "20060102")
+	if err != nil {
+		return nil, err
+	}
+	input.ExecutedAt = &executedAt
+
+	if raw := attrs["ibCommission"]; raw != "" {
+		fee, err := parseAmount("ibCommission", raw)
+		if err != nil {
+			return nil, err
+		}
+		currency := attrs["ibCommissionCurrency"]
+		if currency == "" {
+			currency = attrs["currency"]
+		}
+		input.Fees = commission(fee, currency) // reported negative
+	}
+	return input, nil
+}
+
+// ---------- Zerodha tradebook (CSV) ----------
+
+// Zerodha reports exchange time, India has no daylight saving
+var istZone = time.FixedZone("IST", 5*60*60+30*60)
+
+type zerodhaImporter struct{}
+
+func (zerodhaImporter) Name() string { return "zerodha" }
+
+// Parse reads the Console tradebook export, symbols are quoted in INR and the tradebook carries no charges
+func (zerodhaImporter) Parse(r io.Reader, _ *time.Location) ([]ImportRow, []ImportRowError, error) {
+	t, err := newCSVTable(r, "trade_id", "symbol", "trade_type", "quantity", "price")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return readCSVRows(t, func(field func(string) string, record []string) (*TradeInput, error) {
+		input := &TradeInput{
+			Symbol:     strings.ToUpper(field("symbol")) + "/INR",
+			Source:     "zerodha",
+			ExternalID: field("trade_id"),
+		}
+		if exchange := field("exchange"); exchange != "" {
+			input.ExternalID = exchange + ":" + input.ExternalID // trade ids are only unique per exchange
+		}
+
+		var err error
+		if input.Type, err = parseSide(field("trade_type")); err != nil {
+			return nil, err
+		}
+		if input.Quantity, err = parseAmount("quantity", field("quantity")); err != nil {
+			return nil, err
+		}
+		if input.Price, err = parseAmount("price", field("price")); err != nil {
+			return nil, err
+		}
+
+		stamp := field("order_execution_time")
+		if stamp == "" {
+			stamp = field("trade_date")
+		}
+		executedAt, err := parseTimeLayouts("order_execution_time", stamp, istZone, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02")
+		if err != nil {
+			return nil, err
+		}
+		input.ExecutedAt = &executedAt
+		return input, nil
+	})
+}
@@ -0,0 +1,157 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func parseWith(t *testing.T, format, content string) ([]ImportRow, []ImportRowError) {
+	t.Helper()
+	importer, ok := GetImporter(format)
+	require.True(t, ok, format)
+	rows, rowErrors, err := importer.Parse(strings.NewReader(content), nil)
+	require.NoError(t, err)
+	return rows, rowErrors
+}
+
+func TestBinanceImporter_BothLayouts(t *testing.T) {
+	rows, rowErrors := parseWith(t, "binance", "Date(UTC),Pair,Side,Price,Executed,Amount,Fee\n"+
+		"2024-01-02 03:04:05,ETHBTC,BUY,0.05,2ETH,0.1BTC,0.0015BNB\n"+
+		"2024-01-02 03:05:00,BTCUSDT,SELL,42000,0.5BTC,21000USDT,21USDT\n"+
+		"2024-01-02 03:06:00,BTCUSDT,SELL,42000,abc,21000USDT,21USDT\n")
+	require.Len(t, rows, 2)
+	assert.Equal(t, "ETH/BTC", rows[0].Input.Symbol)
+	assert.True(t, d("2").Equal(rows[0].Input.Quantity))
+	assert.Equal(t, "BNB", rows[0].Input.Fees[0].Currency)
+	assert.Equal(t, "BTC/USDT", rows[1].Input.Symbol)
+	assert.Equal(t, "SELL", rows[1].Input.Type)
+	assert.Equal(t, "2024-01-02T03:05:00Z", rows[1].Input.ExecutedAt.Format(time.RFC3339))
+	assert.NotEqual(t, rows[0].Input.ExternalID, rows[1].Input.ExternalID)
+	require.Len(t, rowErrors, 1)
+	assert.Equal(t, 4, rowErrors[0].Line)
+
+	rows, rowErrors = parseWith(t, "binance", "Date(UTC),Market,Type,Price,Amount,Total,Fee,Fee Coin\n"+
+		"2021-05-01 10:00:00,SOLUSDT,BUY,40,10,400,0.01,SOL\n")
+	assert.Empty(t, rowErrors)
+	require.Len(t, rows, 1)
+	assert.Equal(t, "SOL/USDT", rows[0].Input.Symbol)
+	assert.Equal(t, "SOL", rows[0].Input.Fees[0].Currency)
+}
+
+func TestImportTrades_KeepsIdenticalBinanceFills(t *testing.T) {
+	mem := &memTradeRepo{}
+	service := NewTradeService(mem)
+	ctx := context.Background()
+
+	// two partial fills of one order, same second, price and amount
+	statement := "Date(UTC),Pair,Side,Price,Executed,Amount,Fee\n" +
+		"2024-01-02 03:04:05,BTCUSDT,BUY,42000,0.1BTC,4200USDT,0.0001BTC\n" +
+		"2024-01-02 03:04:05,BTCUSDT,BUY,42000,0.1BTC,4200USDT,0.0001BTC\n"
+	rows, rowErrors := parseWith(t, "binance", statement)
+	assert.Empty(t, rowErrors)
+	require.Len(t, rows, 2)
+	assert.NotEqual(t, rows[0].Input.ExternalID, rows[1].Input.ExternalID)
+
+	report, err := service.ImportTrades(ctx, 1, rows, false)
+	require.NoError(t, err)
+	assert.Equal(t, 0, report.Duplicates)
+	assert.Equal(t, 2, report.Imported)
+
+	// importing the same file again still skips both
+	rows, _ = parseWith(t, "binance", statement)
+	report, err = service.ImportTrades(ctx, 1, rows, false)
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Duplicates)
+	assert.Len(t, mem.trades, 2)
+}
+
+func TestCoinbaseImporter_SkipsPreambleAndTransfers(t *testing.T) {
+	rows, rowErrors := parseWith(t, "coinbase", "Transactions\nUser,jane@example.com\n\n"+
+		"ID,Timestamp,Transaction Type,Asset,Quantity Transacted,Price Currency,Price at Transaction,Subtotal,Total (inclusive of fees and/or spread),Fees and/or Spread,Notes\n"+
+		`cb-1,2024-02-01 12:00:00 UTC,Buy,BTC,0.1,USD,"$40,000.00",$4000.00,$4010.00,$10.00,Bought BTC`+"\n"+
+		"cb-2,2024-02-02 12:00:00 UTC,Send,BTC,0.05,USD,$41000,,,,\n"+
+		"cb-3,2024-02-03 12:00:00 UTC,Advanced Trade Sell,BTC,-0.05,USD,$42000,$2100,$2095,$5,\n")
+	assert.Empty(t, rowErrors)
+	require.Len(t, rows, 2)
+	assert.Equal(t, "cb-1", rows[0].Input.ExternalID)
+	assert.True(t, d("40000").Equal(rows[0].Input.Price))
+	assert.True(t, d("10").Equal(rows[0].Input.Fees[0].Amount))
+	assert.Equal(t, "SELL", rows[1].Input.Type)
+	assert.True(t, d("0.05").Equal(rows[1].Input.Quantity))
+}
+
+func TestIBKRImporter_ExecutionsOnly(t *testing.T) {
+	rows, rowErrors := parseWith(t, "ibkr", `<FlexQueryResponse><FlexStatements count="1"><FlexStatement><Trades>
+<Trade tradeID="111" symbol="AAPL" currency="USD" assetCategory="STK" buySell="BUY" quantity="10" tradePrice="150.25" ibCommission="-1.00" ibCommissionCurrency="USD" dateTime="20240102;093000" levelOfDetail="EXECUTION"/>
+<Trade symbol="AAPL" currency="USD" assetCategory="STK" buySell="BUY" quantity="10" tradePrice="150.25" levelOfDetail="SYMBOL_SUMMARY"/>
+<Trade tradeID="112" symbol="EUR.USD" currency="USD" assetCategory="CASH" buySell="BUY" quantity="1000" tradePrice="1.09" dateTime="20240102;093000"/>
+<Trade tradeID="113" symbol="AAPL" currency="USD" assetCategory="STK" buySell="SELL" quantity="-4" tradePrice="155" dateTime="yesterday"/>
+</Trades></FlexStatement></FlexStatements></FlexQueryResponse>`)
+	require.Len(t, rows, 1)
+	assert.Equal(t, "AAPL/USD", rows[0].Input.Symbol)
+	assert.Equal(t, "111", rows[0].Input.ExternalID)
+	assert.Equal(t, "2024-01-02T09:30:00Z", rows[0].Input.ExecutedAt.Format(time.RFC3339))
+	assert.True(t, d("1").Equal(rows[0].Input.Fees[0].Amount))
+	require.Len(t, rowErrors, 1)
+	assert.Equal(t, 4, rowErrors[0].Line)
+}
+
+func TestZerodhaImporter_ISTTimes(t *testing.T) {
+	rows, rowErrors := parseWith(t, "zerodha", "symbol,isin,trade_date,exchange,segment,series,trade_type,auction,quantity,price,trade_id,order_id,order_execution_time\n"+
+		"INFY,INE009A01021,2024-03-01,NSE,EQ,EQ,buy,false,5,1650.5,7001,1100,2024-03-01T09:15:32\n")
+	assert.Empty(t, rowErrors)
+	require.Len(t, rows, 1)
+	assert.Equal(t, "INFY/INR", rows[0].Input.Symbol)
+	assert.Equal(t, "NSE:7001", rows[0].Input.ExternalID)
+	assert.Equal(t, "2024-03-01T03:45:32Z", rows[0].Input.ExecutedAt.UTC().Format(time.RFC3339))
+}
+
+func TestImportTrades_KeepsHyphenatedTickers(t *testing.T) {
+	mem := &memTradeRepo{}
+	service := NewTradeService(mem)
+	ctx := context.Background()
+
+	rows, rowErrors := parseWith(t, "zerodha", "symbol,isin,trade_date,exchange,segment,series,trade_type,auction,quantity,price,trade_id,order_id,order_execution_time\n"+
+		"BAJAJ-AUTO,INE917I01010,2024-03-01,NSE,EQ,EQ,buy,false,2,8900,7101,1200,2024-03-01T10:02:11\n")
+	assert.Empty(t, rowErrors)
+	report, err := service.ImportTrades(ctx, 1, rows, false)
+	require.NoError(t, err)
+	assert.Empty(t, report.Errors)
+	require.Len(t, mem.trades, 1)
+	assert.Equal(t, "BAJAJ-AUTO/INR", mem.trades[0].Symbol)
+}
+
+func TestImportTrades_SkipsKnownExternalIDs(t *testing.T) {
+	mem := &memTradeRepo{}
+	service := NewTradeService(mem)
+	ctx := context.Background()
+
+	statement := "symbol,isin,trade_date,exchange,segment,series,trade_type,auction,quantity,price,trade_id,order_id,order_execution_time\n" +
+		"INFY,,,NSE,,,buy,,5,1650,7001,,2024-03-01T09:15:32\n" +
+		"INFY,,,NSE,,,sell,,2,1700,7002,,2024-03-04T10:00:00\n"
+	rows, _ := parseWith(t, "zerodha", statement)
+	report, err := service.ImportTrades(ctx, 1, rows, false)
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Imported)
+
+	// the next statement overlaps the first one and repeats a line
+	rows, _ = parseWith(t, "zerodha", statement+
+		"INFY,,,NSE,,,sell,,1,1710,7003,,2024-03-05T10:00:00\n"+
+		"INFY,,,NSE,,,sell,,1,1710,7003,,2024-03-05T10:00:00\n")
+	report, err = service.ImportTrades(ctx, 1, rows, false)
+	require.NoError(t, err)
+	assert.Empty(t, report.Errors)
+	assert.Equal(t, 3, report.Duplicates)
+	assert.Equal(t, 1, report.Imported)
+	assert.Len(t, mem.trades, 3)
+
+	// another user importing the same statement is not a duplicate
+	report, err = service.ImportTrades(ctx, 2, rows[:2], false)
+	require.NoError(t, err)
+	assert.Equal(t, 0, report.Duplicates)
+}
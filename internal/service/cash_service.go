@@ -0,0 +1,295 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/MonalBarse/tradelog/internal/domain"
+	"github.com/MonalBarse/tradelog/internal/repository"
+	"github.com/shopspring/decimal"
+)
+
+/*
+NOTE:
+	Only deposits, withdrawals, dividends, interest and account fees are stored.
+	Trade settlements and trade fees are derived from the trades every time the
+	ledger is built, so a corrected or imported trade can never leave the cash
+	ledger out of sync.
+	Trades are settled in the quote currency of their symbol ("USD" for "BTC/USD"),
+	symbols without a quote currency don't touch cash.
+	A leveraged trade only settles the margin it posts, the rest of its notional
+	is borrowed. That loan is kept per account and symbol and settled on the
+	trades that close the position, pro rata to the quantity they close: a long
+	repays what it borrowed, a short gets its margin and sale proceeds back.
+*/
+
+// CashEntry is one line of the cash ledger with the running balance of its currency
+type CashEntry struct {
+	Type       string          `json:"type"`
+	Currency   string          `json:"currency"`
+	Amount     decimal.Decimal `json:"amount"`
+	Balance    decimal.Decimal `json:"balance"`
+	MovementID *uint           `json:"movement_id,omitempty"`
+	TradeID    *uint           `json:"trade_id,omitempty"`
+	Notes      string          `json:"notes,omitempty"`
+	OccurredAt time.Time       `json:"occurred_at"`
+}
+
+// CashMovementInput carries a user recorded cash movement, Amount is always positive
+type CashMovementInput struct {
+	Type       string
+	Currency   string
+	Amount     decimal.Decimal
+	Notes      string
+	OccurredAt *time.Time // defaults to now
+}
+
+type CashService interface {
+	RecordMovement(ctx context.Context, userID uint, input CashMovementInput) (*domain.CashMovement, error)
+	GetLedger(ctx context.Context, userID uint, currency string) ([]CashEntry, error)
+	GetBalances(ctx context.Context, userID uint) (map[string]decimal.Decimal, error)
+}
+
+type cashService struct {
+	repo      repository.CashRepository
+	tradeRepo repository.TradeRepository
+	userRepo  repository.UserRepository
+}
+
+func NewCashService(repo repository.CashRepository, tradeRepo repository.TradeRepository, userRepo repository.UserRepository) CashService {
+	return &cashService{repo: repo, tradeRepo: tradeRepo, userRepo: userRepo}
+}
+
+// @desc: record a deposit, withdrawal, dividend, interest payment or account fee
+// @flow: validate -> sign amount by type -> lock user -> check balance for withdrawals in strict mode -> save
+func (s *cashService) RecordMovement(ctx context.Context, userID uint, input CashMovementInput) (*domain.CashMovement, error) {
+	if !input.Amount.IsPositive() {
+		return nil, errors.New("amount must be positive")
+	}
+	currency := strings.ToUpper(strings.TrimSpace(input.Currency))
+	if currency == "" {
+		return nil, errors.New("currency is required")
+	}
+
+	amount := input.Amount
+	switch input.Type {
+	case domain.CashDeposit, domain.CashDividend, domain.CashInterest:
+	case domain.CashWithdrawal, domain.CashFee:
+		amount = amount.Neg()
+	default:
+		return nil, fmt.Errorf("unsupported cash movement type %q", input.Type)
+	}
+
+	occurredAt := time.Now()
+	if input.OccurredAt != nil {
+		occurredAt = *input.OccurredAt
+	}
+
+	movement := &domain.CashMovement{
+		UserID:     userID,
+		Type:       input.Type,
+		Currency:   currency,
+		Amount:     amount,
+		Notes:      input.Notes,
+		OccurredAt: occurredAt,
+	}
+
+	// the same lock as CreateTrade, a withdrawal and a BUY can't both spend the same cash
+	err := s.tradeRepo.WithUserLock(ctx, userID, func(ctx context.Context) error {
+		if input.Type == domain.CashWithdrawal {
+			user, err := s.userRepo.FindByID(ctx, userID)
+			if err != nil {
+				return err
+			}
+			if user.StrictCash {
+				balances, err := s.GetBalances(ctx, userID)
+				if err != nil {
+					return err
+				}
+				if balances[currency].LessThan(input.Amount) {
+					return fmt.Errorf("insufficient cash: %s balance is %s", currency, balances[currency])
+				}
+			}
+		}
+		return s.repo.Create(ctx, movement)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return movement, nil
+}
+
+// @desc: full cash ledger with running balances, optionally for one currency only
+func (s *cashService) GetLedger(ctx context.Context, userID uint, currency string) ([]CashEntry, error) {
+	entries, err := s.entries(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if currency == "" {
+		return entries, nil
+	}
+	filtered := make([]CashEntry, 0, len(entries))
+	for _, e := range entries {
+		if strings.EqualFold(e.Currency, currency) {
+			filtered = append(filtered, e)
+		}
+	}
+	return filtered, nil
+}
+
+func (s *cashService) GetBalances(ctx context.Context, userID uint) (map[string]decimal.Decimal, error) {
+	entries, err := s.entries(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return cashBalances(entries), nil
+}
+
+func (s *cashService) entries(ctx context.Context, userID uint) ([]CashEntry, error) {
+	movements, err := s.repo.GetByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	trades, err := s.tradeRepo.GetByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return buildCashLedger(movements, trades), nil
+}
+
+// @desc: merge stored movements with entries derived from trades and compute running balances
+func buildCashLedger(movements []domain.CashMovement, trades []domain.Trade) []CashEntry {
+	entries := make([]CashEntry, 0, len(movements)+len(trades))
+	for _, m := range movements {
+		id := m.ID
+		entries = append(entries, CashEntry{
+			Type:       m.Type,
+			Currency:   m.Currency,
+			Amount:     m.Amount,
+			MovementID: &id,
+			Notes:      m.Notes,
+			OccurredAt: m.OccurredAt,
+		})
+	}
+	loans := make(marginLoans)
+	for _, t := range sortTrades(trades) {
+		entries = append(entries, loans.cashEntries(t)...)
+	}
+
+	// stable sort keeps movements ahead of trades at the same instant, a deposit funds a BUY made in the same second
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].OccurredAt.Before(entries[j].OccurredAt) })
+
+	balances := make(map[string]decimal.Decimal)
+	for i := range entries {
+		balances[entries[i].Currency] = balances[entries[i].Currency].Add(entries[i].Amount)
+		entries[i].Balance = balances[entries[i].Currency]
+	}
+	return entries
+}
+
+// tradeCashEntries is what a trade takes out of cash when it opens a position, with no other trades to close
+func tradeCashEntries(t domain.Trade) []CashEntry {
+	return make(marginLoans).cashEntries(t)
+}
+
+// marginLoan is the open leveraged part of a position: what it still settles when closed, negative when owed
+type marginLoan struct {
+	quantity decimal.Decimal // signed position, negative for a short
+	deferred decimal.Decimal
+}
+
+type marginLoanKey struct {
+	accountID uint
+	symbol    string
+}
+
+// marginLoans tracks the positions trades are replayed against, by account and symbol
+type marginLoans map[marginLoanKey]*marginLoan
+
+// settle splits the plain settlement amount of a trade into what is paid now, which holds only the margin
+// for the part of a leveraged trade that opens a position, and the loan its closing part settles
+func (l marginLoans) settle(t domain.Trade, amount decimal.Decimal) (decimal.Decimal, decimal.Decimal) {
+	key := marginLoanKey{t.AccountKey(), t.Symbol}
+	loan := l[key]
+	if loan == nil {
+		loan = &marginLoan{}
+		l[key] = loan
+	}
+	delta := positionDelta(t)
+	if delta.IsZero() {
+		return amount, decimal.Zero
+	}
+
+	repaid, closed := decimal.Zero, decimal.Zero
+	if loan.quantity.Sign()*delta.Sign() < 0 {
+		closed = decimal.Min(loan.quantity.Abs(), delta.Abs())
+		repaid = loan.deferred.Mul(closed).Div(loan.quantity.Abs())
+		loan.deferred = loan.deferred.Sub(repaid)
+	}
+	loan.quantity = loan.quantity.Add(delta)
+
+	opened := delta.Abs().Sub(closed)
+	if !opened.IsPositive() || !t.Margin.IsPositive() {
+		return amount, repaid
+	}
+	// as for lots, the margin of a trade is shared by the units it opens
+	openAmount := amount.Mul(opened).Div(delta.Abs())
+	margin := t.Margin.Mul(opened).Div(delta.Abs())
+	loan.deferred = loan.deferred.Add(openAmount).Add(margin)
+	return amount.Sub(openAmount).Sub(margin), repaid
+}
+
+// cashEntries is the settlement of a trade, the margin loan it settles and a line per fee not paid in the base asset
+func (l marginLoans) cashEntries(t domain.Trade) []CashEntry {
+	base, quote := domain.SplitSymbol(t.Symbol)
+	id := t.ID
+
+	amount := decimal.Zero
+	if t.Source != domain.TradeSourceIncome { // in-kind income is received, not paid for
+		amount = t.Notional()
+		if t.Type == "BUY" {
+			amount = amount.Neg()
+		}
+	}
+	settled, repaid := l.settle(t, amount)
+
+	var entries []CashEntry
+	if quote != "" && t.Source != domain.TradeSourceIncome {
+		entries = append(entries, CashEntry{Type: domain.CashTradeSettlement, Currency: quote, Amount: settled, TradeID: &id, OccurredAt: t.ExecutedAt})
+	}
+	if quote != "" && !repaid.IsZero() {
+		entries = append(entries, CashEntry{Type: domain.CashMarginLoan, Currency: quote, Amount: repaid, TradeID: &id, OccurredAt: t.ExecutedAt})
+	}
+
+	for _, fee := range t.Fees {
+		currency := fee.Currency
+		if currency == "" {
+			currency = quote
+		}
+		if currency == "" || strings.EqualFold(currency, base) {
+			continue // base asset fees reduce the position, not cash
+		}
+		entries = append(entries, CashEntry{
+			Type:       domain.CashTradeFee,
+			Currency:   strings.ToUpper(currency),
+			Amount:     fee.Amount.Neg(),
+			TradeID:    &id,
+			Notes:      fee.Type,
+			OccurredAt: t.ExecutedAt,
+		})
+	}
+	return entries
+}
+
+func cashBalances(entries []CashEntry) map[string]decimal.Decimal {
+	balances := make(map[string]decimal.Decimal)
+	for _, e := range entries {
+		balances[e.Currency] = balances[e.Currency].Add(e.Amount)
+	}
+	return balances
+}
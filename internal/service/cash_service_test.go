@@ -0,0 +1,132 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/MonalBarse/tradelog/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type MockCashRepo struct {
+	mock.Mock
+}
+
+func (m *MockCashRepo) Create(ctx context.Context, movement *domain.CashMovement) error {
+	args := m.Called(ctx, movement)
+	return args.Error(0)
+}
+
+func (m *MockCashRepo) GetByUserID(ctx context.Context, userID uint) ([]domain.CashMovement, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).([]domain.CashMovement), args.Error(1)
+}
+
+type MockUserRepo struct {
+	mock.Mock
+}
+
+func (m *MockUserRepo) Create(ctx context.Context, user *domain.User) error {
+	return m.Called(ctx, user).Error(0)
+}
+
+func (m *MockUserRepo) FindByEmail(ctx context.Context, email string) (*domain.User, error) {
+	args := m.Called(ctx, email)
+	user, _ := args.Get(0).(*domain.User)
+	return user, args.Error(1)
+}
+
+func (m *MockUserRepo) FindByID(ctx context.Context, id uint) (*domain.User, error) {
+	args := m.Called(ctx, id)
+	user, _ := args.Get(0).(*domain.User)
+	return user, args.Error(1)
+}
+
+func (m *MockUserRepo) Update(ctx context.Context, user *domain.User) error {
+	return m.Called(ctx, user).Error(0)
+}
+
+func cashHistory() []domain.CashMovement {
+	return []domain.CashMovement{
+		{ID: 1, Type: domain.CashDeposit, Currency: "USD", Amount: d("1500"), OccurredAt: time.Date(2023, 12, 31, 0, 0, 0, 0, time.UTC)},
+	}
+}
+
+func TestGetLedger_DerivesTradeSettlements(t *testing.T) {
+	cashRepo, tradeRepo := new(MockCashRepo), new(MockTradeRepo)
+	cash := NewCashService(cashRepo, tradeRepo, new(MockUserRepo))
+	ctx := context.Background()
+
+	trades := lotHistory()
+	trades[0].Fees = []domain.TradeFee{{Type: domain.FeeTypeCommission, Amount: d("5"), Currency: "USD"}}
+	cashRepo.On("GetByUserID", ctx, uint(1)).Return(cashHistory(), nil)
+	tradeRepo.On("GetByUserID", ctx, uint(1)).Return(trades, nil)
+
+	ledger, err := cash.GetLedger(ctx, 1, "USD")
+	require.NoError(t, err)
+	require.Len(t, ledger, 5) // deposit, buy, buy fee, buy, sell
+
+	assert.Equal(t, domain.CashTradeFee, ledger[2].Type)
+	assert.True(t, d("495").Equal(ledger[2].Balance))  // 1500 - 1000 - 5
+	assert.True(t, d("2995").Equal(ledger[4].Balance)) // 495 - 2000 + 4500
+}
+
+func TestCreateTrade_StrictCashRejectsUnfundedBuy(t *testing.T) {
+	tradeRepo, cashRepo, userRepo := new(MockTradeRepo), new(MockCashRepo), new(MockUserRepo)
+	service := NewTradeService(tradeRepo, WithUserRepository(userRepo), WithCashRepository(cashRepo))
+	ctx := context.Background()
+
+	userRepo.On("FindByID", ctx, uint(1)).Return(&domain.User{ID: 1, StrictCash: true}, nil)
+	cashRepo.On("GetByUserID", ctx, uint(1)).Return(cashHistory(), nil)
+	tradeRepo.On("GetByUserID", ctx, uint(1)).Return([]domain.Trade{}, nil)
+
+	err := service.CreateTrade(ctx, 1, TradeInput{Symbol: "BTC/USD", Type: "BUY", Price: d("100"), Quantity: d("16")})
+
+	assert.EqualError(t, err, "insufficient buying power: USD balance is 1500, trade needs 1600")
+	tradeRepo.AssertNotCalled(t, "Create")
+}
+
+func TestRecordMovement_SignsWithdrawals(t *testing.T) {
+	cashRepo, userRepo := new(MockCashRepo), new(MockUserRepo)
+	cash := NewCashService(cashRepo, new(MockTradeRepo), userRepo)
+	ctx := context.Background()
+
+	userRepo.On("FindByID", ctx, uint(1)).Return(&domain.User{ID: 1}, nil)
+	cashRepo.On("Create", ctx, mock.MatchedBy(func(m *domain.CashMovement) bool {
+		return m.Currency == "USD" && d("-200").Equal(m.Amount)
+	})).Return(nil)
+
+	_, err := cash.RecordMovement(ctx, 1, CashMovementInput{Type: domain.CashWithdrawal, Currency: "usd", Amount: d("200")})
+
+	require.NoError(t, err)
+	cashRepo.AssertExpectations(t)
+}
+
+func TestCreateTrade_StrictCashOnlyTakesTheMarginOfALeveragedBuy(t *testing.T) {
+	repo, instRepo, cashRepo, userRepo := &memTradeRepo{}, new(MockInstrumentRepo), new(MockCashRepo), new(MockUserRepo)
+	service := NewTradeService(repo, WithInstrumentRepository(instRepo), WithCashRepository(cashRepo), WithUserRepository(userRepo))
+	ctx := context.Background()
+
+	instrument := btcInstrument()
+	instrument.MaxLeverage = d("10")
+	instRepo.On("GetBySymbol", ctx, "BTC/USD").Return(instrument, nil)
+	userRepo.On("FindByID", ctx, uint(1)).Return(&domain.User{ID: 1, StrictCash: true}, nil)
+	deposit := []domain.CashMovement{{ID: 1, Type: domain.CashDeposit, Currency: "USD", Amount: d("15000"), OccurredAt: time.Now().Add(-time.Hour)}}
+	cashRepo.On("GetByUserID", ctx, uint(1)).Return(deposit, nil)
+
+	require.NoError(t, service.CreateTrade(ctx, 1, TradeInput{Symbol: "BTC/USD", Type: "BUY", Price: d("60000"), Quantity: d("1"), Leverage: d("5")}))
+	// 3000 of the deposit is left after the 12000 margin
+	require.NoError(t, service.CreateTrade(ctx, 1, TradeInput{Symbol: "BTC/USD", Type: "BUY", Price: d("10000"), Quantity: d("0.001")}))
+	assert.ErrorContains(t, service.CreateTrade(ctx, 1, TradeInput{Symbol: "BTC/USD", Type: "BUY", Price: d("10000"), Quantity: d("0.3")}), "USD balance is 2990")
+
+	// closing the position repays the 48000 borrowed
+	require.NoError(t, service.CreateTrade(ctx, 1, TradeInput{Symbol: "BTC/USD", Type: "SELL", Price: d("66000"), Quantity: d("1.001")}))
+	ledger := buildCashLedger(deposit, repo.trades)
+	require.Len(t, ledger, 5)
+	assert.Equal(t, domain.CashMarginLoan, ledger[4].Type)
+	assert.True(t, d("-48000").Equal(ledger[4].Amount), ledger[4].Amount.String())
+	assert.True(t, d("21056").Equal(ledger[4].Balance), ledger[4].Balance.String()) // 15000 - 12000 - 10 + 66066 - 48000
+}
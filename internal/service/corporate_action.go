@@ -0,0 +1,258 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/MonalBarse/tradelog/internal/domain"
+	"github.com/MonalBarse/tradelog/internal/repository"
+	"github.com/shopspring/decimal"
+)
+
+/*
+NOTE:
+	Corporate actions are never written into the trades. Whenever positions or
+	lots are replayed, trades executed before an action take effect are read as
+	if they had happened after it:
+	- a split multiplies quantities (and base asset fees and lot selections) by
+	  RatioTo/RatioFrom and divides prices by it, so the cost of every lot stays
+	- a symbol change moves the trade, and fees paid in the old base asset, to the new symbol
+	Actions are applied in the order they took effect, so a trade from before a
+	rename is also split by a later split of the new symbol.
+	The positions table caches the adjusted replay, it is rebuilt when an action
+	is added or removed.
+*/
+
+var ErrCorporateActionNotFound = errors.New("corporate action not found")
+
+// CorporateActionInput carries the admin supplied fields of a corporate action
+type CorporateActionInput struct {
+	Type        string
+	Symbol      string
+	NewSymbol   string
+	RatioFrom   decimal.Decimal
+	RatioTo     decimal.Decimal
+	EffectiveAt time.Time
+	Notes       string
+}
+
+type CorporateActionService interface {
+	Create(ctx context.Context, input CorporateActionInput) (*domain.CorporateAction, error)
+	Delete(ctx context.Context, id uint) error
+	List(ctx context.Context) ([]domain.CorporateAction, error)
+}
+
+type corporateActionService struct {
+	repo      repository.CorporateActionRepository
+	positions PositionService
+}
+
+// NewCorporateActionService takes the position service to rebuild stored positions with, nil when there is no positions table
+func NewCorporateActionService(repo repository.CorporateActionRepository, positions PositionService) CorporateActionService {
+	return &corporateActionService{repo: repo, positions: positions}
+}
+
+// @desc: record a corporate action that has taken effect
+// @flow: validate -> save -> rebuild stored positions
+func (s *corporateActionService) Create(ctx context.Context, input CorporateActionInput) (*domain.CorporateAction, error) {
+	action, err := newCorporateAction(input)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.Create(ctx, action); err != nil {
+		return nil, err
+	}
+	if err := s.rebuildPositions(ctx); err != nil {
+		return nil, err
+	}
+	return action, nil
+}
+
+func (s *corporateActionService) Delete(ctx context.Context, id uint) error {
+	action, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if action == nil {
+		return ErrCorporateActionNotFound
+	}
+	if err := s.repo.Delete(ctx, id); err != nil {
+		return err
+	}
+	return s.rebuildPositions(ctx)
+}
+
+func (s *corporateActionService) List(ctx context.Context) ([]domain.CorporateAction, error) {
+	return s.repo.List(ctx)
+}
+
+func (s *corporateActionService) rebuildPositions(ctx context.Context) error {
+	if s.positions == nil {
+		return nil
+	}
+	if _, err := s.positions.RebuildAll(ctx); err != nil {
+		return fmt.Errorf("corporate action saved but positions could not be rebuilt, run the position rebuild: %w", err)
+	}
+	return nil
+}
+
+func newCorporateAction(input CorporateActionInput) (*domain.CorporateAction, error) {
+	action := &domain.CorporateAction{
+		Type:        strings.ToUpper(strings.TrimSpace(input.Type)),
+		Symbol:      domain.NormalizeSymbol(input.Symbol),
+		EffectiveAt: input.EffectiveAt,
+		Notes:       input.Notes,
+	}
+	base, quote := domain.SplitSymbol(action.Symbol)
+	if base == "" || quote == "" {
+		return nil, fmt.Errorf("symbol %q must look like BASE/QUOTE", input.Symbol)
+	}
+	if action.EffectiveAt.IsZero() {
+		return nil, errors.New("effective_at is required")
+	}
+	if action.EffectiveAt.After(time.Now()) {
+		return nil, errors.New("effective_at cannot be in the future, record the action once it has taken effect")
+	}
+
+	switch action.Type {
+	case domain.CorporateActionSplit:
+		if !input.RatioFrom.IsPositive() || !input.RatioTo.IsPositive() {
+			return nil, errors.New("split ratio must be positive on both sides")
+		}
+		if input.RatioFrom.Equal(input.RatioTo) {
+			return nil, errors.New("split ratio must change the number of shares")
+		}
+		action.RatioFrom = input.RatioFrom
+		action.RatioTo = input.RatioTo
+	case domain.CorporateActionSymbolChange:
+		action.NewSymbol = domain.NormalizeSymbol(input.NewSymbol)
+		newBase, newQuote := domain.SplitSymbol(action.NewSymbol)
+		if newBase == "" || newQuote == "" {
+			return nil, fmt.Errorf("new symbol %q must look like BASE/QUOTE", input.NewSymbol)
+		}
+		if newQuote != quote {
+			return nil, fmt.Errorf("a symbol change cannot change the quote currency %s", quote)
+		}
+		if action.NewSymbol == action.Symbol {
+			return nil, errors.New("new symbol must differ from the symbol")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported corporate action type %q", input.Type)
+	}
+	return action, nil
+}
+
+// applyCorporateActions returns copies of trades with the actions effective at or before at applied in order
+func applyCorporateActions(trades []domain.Trade, actions []domain.CorporateAction, at time.Time) []domain.Trade {
+	if len(actions) == 0 {
+		return trades
+	}
+	ordered := make([]domain.CorporateAction, 0, len(actions))
+	for _, a := range actions {
+		if !a.EffectiveAt.After(at) {
+			ordered = append(ordered, a)
+		}
+	}
+	sort.SliceStable(ordered, func(i, j int) bool { return ordered[i].EffectiveAt.Before(ordered[j].EffectiveAt) })
+
+	out := make([]domain.Trade, len(trades))
+	for i, t := range trades {
+		copied := false
+		for _, a := range ordered {
+			if t.Symbol != a.Symbol || !t.ExecutedAt.Before(a.EffectiveAt) {
+				continue
+			}
+			if !copied {
+				// fees and lot selections are shared with the caller's slice
+				t.Fees = append([]domain.TradeFee(nil), t.Fees...)
+				t.LotSelections = append([]domain.LotSelection(nil), t.LotSelections...)
+				copied = true
+			}
+			applyCorporateAction(&t, a)
+		}
+		out[i] = t
+	}
+	return out
+}
+
+func applyCorporateAction(t *domain.Trade, a domain.CorporateAction) {
+	base, _ := domain.SplitSymbol(t.Symbol)
+	switch a.Type {
+	case domain.CorporateActionSplit:
+		// multiply before dividing so quantities stay exact
+		t.Quantity = t.Quantity.Mul(a.RatioTo).Div(a.RatioFrom)
+		t.Price = t.Price.Mul(a.RatioFrom).Div(a.RatioTo)
+		for i := range t.Fees {
+			if strings.EqualFold(t.Fees[i].Currency, base) {
+				t.Fees[i].Amount = t.Fees[i].Amount.Mul(a.RatioTo).Div(a.RatioFrom)
+			}
+		}
+		for i := range t.LotSelections {
+			t.LotSelections[i].Quantity = t.LotSelections[i].Quantity.Mul(a.RatioTo).Div(a.RatioFrom)
+		}
+	case domain.CorporateActionSymbolChange:
+		newBase, _ := domain.SplitSymbol(a.NewSymbol)
+		for i := range t.Fees {
+			if strings.EqualFold(t.Fees[i].Currency, base) {
+				t.Fees[i].Currency = newBase
+			}
+		}
+		t.Symbol = a.NewSymbol
+	}
+}
+
+// formerSymbols adds the symbols that were renamed into any of symbols, following chains of renames
+func formerSymbols(actions []domain.CorporateAction, symbols []string) []string {
+	seen := make(map[string]bool, len(symbols))
+	for _, symbol := range symbols {
+		seen[symbol] = true
+	}
+	out := append([]string(nil), symbols...)
+	for changed := true; changed; {
+		changed = false
+		for _, a := range actions {
+			if a.Type == domain.CorporateActionSymbolChange && seen[a.NewSymbol] && !seen[a.Symbol] {
+				seen[a.Symbol] = true
+				out = append(out, a.Symbol)
+				changed = true
+			}
+		}
+	}
+	return out
+}
+
+// WithCorporateActionRepository applies splits and symbol changes whenever trades are replayed
+func WithCorporateActionRepository(repo repository.CorporateActionRepository) TradeServiceOption {
+	return func(s *tradeService) {
+		s.actionRepo = repo
+	}
+}
+
+func (s *tradeService) corporateActions(ctx context.Context) ([]domain.CorporateAction, error) {
+	if s.actionRepo == nil {
+		return nil, nil
+	}
+	return s.actionRepo.List(ctx)
+}
+
+// @desc: trades as position math has to read them at the moment at
+func (s *tradeService) adjustTrades(ctx context.Context, trades []domain.Trade, at time.Time) ([]domain.Trade, error) {
+	actions, err := s.corporateActions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return applyCorporateActions(trades, actions, at), nil
+}
+
+// @desc: all trades of the user adjusted for corporate actions
+func (s *tradeService) userTrades(ctx context.Context, userID uint) ([]domain.Trade, error) {
+	trades, err := s.repo.GetByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return s.adjustTrades(ctx, trades, time.Now())
+}
@@ -0,0 +1,133 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/MonalBarse/tradelog/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type MockCorporateActionRepo struct {
+	mock.Mock
+}
+
+func (m *MockCorporateActionRepo) Create(ctx context.Context, action *domain.CorporateAction) error {
+	args := m.Called(ctx, action)
+	return args.Error(0)
+}
+
+func (m *MockCorporateActionRepo) Delete(ctx context.Context, id uint) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (m *MockCorporateActionRepo) GetByID(ctx context.Context, id uint) (*domain.CorporateAction, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.CorporateAction), args.Error(1)
+}
+
+func (m *MockCorporateActionRepo) List(ctx context.Context) ([]domain.CorporateAction, error) {
+	args := m.Called(ctx)
+	return args.Get(0).([]domain.CorporateAction), args.Error(1)
+}
+
+var actionStart = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// FB/USD is renamed to META/USD in March, META/USD splits 4:1 in June
+func actionHistory() ([]domain.Trade, []domain.CorporateAction) {
+	trades := []domain.Trade{
+		{ID: 1, Symbol: "FB/USD", Type: "BUY", Price: d("400"), Quantity: d("10"), ExecutedAt: actionStart,
+			Fees: []domain.TradeFee{{Type: domain.FeeTypeCommission, Amount: d("1"), Currency: "FB"}}},
+		{ID: 2, Symbol: "META/USD", Type: "BUY", Price: d("500"), Quantity: d("2"), ExecutedAt: actionStart.AddDate(0, 3, 0)},
+		{ID: 3, Symbol: "META/USD", Type: "SELL", Price: d("150"), Quantity: d("8"), ExecutedAt: actionStart.AddDate(0, 7, 0),
+			LotSelections: []domain.LotSelection{{LotTradeID: 1, Quantity: d("8")}}},
+	}
+	actions := []domain.CorporateAction{
+		{ID: 2, Type: domain.CorporateActionSplit, Symbol: "META/USD", RatioFrom: d("1"), RatioTo: d("4"), EffectiveAt: actionStart.AddDate(0, 5, 0)},
+		{ID: 1, Type: domain.CorporateActionSymbolChange, Symbol: "FB/USD", NewSymbol: "META/USD", EffectiveAt: actionStart.AddDate(0, 2, 0)},
+	}
+	return trades, actions
+}
+
+func TestApplyCorporateActions_InOrderWithoutMutating(t *testing.T) {
+	trades, actions := actionHistory()
+
+	adjusted := applyCorporateActions(trades, actions, time.Now())
+
+	// renamed first, then split: the cost of 4000 stays
+	assert.Equal(t, "META/USD", adjusted[0].Symbol)
+	assert.True(t, d("40").Equal(adjusted[0].Quantity))
+	assert.True(t, d("100").Equal(adjusted[0].Price))
+	assert.Equal(t, "META", adjusted[0].Fees[0].Currency)
+	assert.True(t, d("4").Equal(adjusted[0].Fees[0].Amount))
+	assert.True(t, d("8").Equal(adjusted[1].Quantity))
+	// after the split, untouched
+	assert.True(t, d("8").Equal(adjusted[2].Quantity))
+	assert.True(t, d("8").Equal(adjusted[2].LotSelections[0].Quantity))
+
+	// the stored rows are not changed
+	assert.Equal(t, "FB/USD", trades[0].Symbol)
+	assert.True(t, d("10").Equal(trades[0].Quantity))
+	assert.Equal(t, "FB", trades[0].Fees[0].Currency)
+	assert.True(t, d("1").Equal(trades[0].Fees[0].Amount))
+
+	// as of April only the rename had happened
+	april := applyCorporateActions(trades, actions, actionStart.AddDate(0, 3, 0))
+	assert.Equal(t, "META/USD", april[0].Symbol)
+	assert.True(t, d("10").Equal(april[0].Quantity))
+}
+
+func TestGetPortfolio_AppliesCorporateActions(t *testing.T) {
+	mockRepo := new(MockTradeRepo)
+	mockActions := new(MockCorporateActionRepo)
+	service := NewTradeService(mockRepo, WithCorporateActionRepository(mockActions))
+	ctx := context.Background()
+
+	trades, actions := actionHistory()
+	trades = trades[:2]
+	mockRepo.On("GetByUserID", ctx, uint(1)).Return(trades, nil)
+	mockActions.On("List", ctx).Return(actions, nil)
+
+	portfolio, err := service.GetPortfolio(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, portfolio, 1)
+	assert.Equal(t, "META/USD", portfolio[0].Symbol)
+	assert.True(t, d("44").Equal(portfolio[0].Quantity), portfolio[0].Quantity.String()) // 4 * (10 - 1 fee) + 4 * 2
+	assert.True(t, d("5000").Equal(portfolio[0].CostBasis))
+
+	// SELLs are checked against the adjusted position
+	balance, err := service.(*tradeService).calculatePosition(ctx, 1, 0, "META/USD")
+	require.NoError(t, err)
+	assert.True(t, d("44").Equal(balance))
+}
+
+func TestNewCorporateAction_Validation(t *testing.T) {
+	_, err := newCorporateAction(CorporateActionInput{Type: "SPLIT", Symbol: "AAPL/USD", RatioFrom: d("1"), RatioTo: d("4"), EffectiveAt: time.Now().Add(time.Hour)})
+	assert.ErrorContains(t, err, "future")
+
+	_, err = newCorporateAction(CorporateActionInput{Type: "SPLIT", Symbol: "AAPL/USD", RatioFrom: d("2"), RatioTo: d("2"), EffectiveAt: actionStart})
+	assert.Error(t, err)
+
+	_, err = newCorporateAction(CorporateActionInput{Type: "SYMBOL_CHANGE", Symbol: "FB/USD", NewSymbol: "META/EUR", EffectiveAt: actionStart})
+	assert.ErrorContains(t, err, "quote currency")
+
+	action, err := newCorporateAction(CorporateActionInput{Type: "split", Symbol: "aapl-usd", RatioFrom: d("10"), RatioTo: d("1"), EffectiveAt: actionStart})
+	require.NoError(t, err)
+	assert.Equal(t, domain.CorporateActionSplit, action.Type)
+	assert.Equal(t, "AAPL/USD", action.Symbol)
+}
+
+func TestFormerSymbols(t *testing.T) {
+	actions := []domain.CorporateAction{
+		{Type: domain.CorporateActionSymbolChange, Symbol: "B/USD", NewSymbol: "C/USD"},
+		{Type: domain.CorporateActionSymbolChange, Symbol: "A/USD", NewSymbol: "B/USD"},
+	}
+	assert.ElementsMatch(t, []string{"C/USD", "B/USD", "A/USD"}, formerSymbols(actions, []string{"C/USD"}))
+}
@@ -0,0 +1,408 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/MonalBarse/tradelog/internal/domain"
+	"github.com/MonalBarse/tradelog/internal/repository"
+	"github.com/shopspring/decimal"
+)
+
+/*
+NOTE:
+	Futures and options are instruments with a multiplier, every trade keeps
+	the multiplier of its time so cost, proceeds and value are always
+	quantity * price * multiplier (see domain.Trade.Notional).
+	A derivative event ends contracts through trades marked with source
+	"derivative", so lots, cash, P&L and taxes need nothing special:
+	- expiration: the contracts close at the expiry, options at zero,
+	  futures at the final settlement price (cash settled)
+	- exercise of a long option / assignment of a short one: the contracts
+	  close at zero and the underlying is bought or sold at the strike,
+	  contracts * multiplier units of it
+	  (long call, short put: BUY; long put, short call: SELL)
+	The premium stays realized on the option rather than folded into the cost
+	of the delivered units, the way a trading journal reads it.
+	Exposure in the portfolio is greeks-free: contracts * multiplier units of
+	the underlying, signed by the direction the position gains in.
+*/
+
+var ErrDerivativeTrade = errors.New("trade was created by a derivative event and cannot be changed")
+
+// DerivativeEventInput carries an expiration, exercise or assignment
+type DerivativeEventInput struct {
+	AccountID       *uint // account holding the contracts, nil for the default account
+	Type            string
+	Symbol          string
+	Quantity        decimal.Decimal // contracts, defaults to the whole open position
+	SettlementPrice decimal.Decimal // final settlement price, futures expiration only
+	Notes           string
+	OccurredAt      *time.Time // defaults to now, expirations always happen at the expiry
+}
+
+// DerivativeExposure is what a futures or options position stands for in its underlying, without greeks
+type DerivativeExposure struct {
+	AssetClass         string           `json:"asset_class"` // FUTURE or OPTION
+	Underlying         string           `json:"underlying,omitempty"`
+	OptionType         string           `json:"option_type,omitempty"`
+	Strike             *decimal.Decimal `json:"strike,omitempty"`
+	Expiry             *time.Time       `json:"expiry,omitempty"`
+	DaysToExpiry       int              `json:"days_to_expiry"`
+	Expired            bool             `json:"expired"`             // past the expiry without an event settling it
+	UnderlyingQuantity decimal.Decimal  `json:"underlying_quantity"` // contracts * multiplier, negative when it gains as the underlying falls
+	UnderlyingPrice    *decimal.Decimal `json:"underlying_price,omitempty"`
+	Notional           *decimal.Decimal `json:"notional,omitempty"`        // underlying quantity * underlying price
+	IntrinsicValue     *decimal.Decimal `json:"intrinsic_value,omitempty"` // options only, what exercising now would be worth, signed like value
+}
+
+// WithDerivativeEventRepository enables expirations, exercises and assignments of futures and options
+func WithDerivativeEventRepository(derivRepo repository.DerivativeEventRepository) TradeServiceOption {
+	return func(s *tradeService) {
+		s.derivRepo = derivRepo
+	}
+}
+
+// IsValidDerivativeEvent reports whether eventType is one of the supported derivative events
+func IsValidDerivativeEvent(eventType string) bool {
+	switch eventType {
+	case domain.DerivativeExpiration, domain.DerivativeExercise, domain.DerivativeAssignment:
+		return true
+	}
+	return false
+}
+
+// @desc: record an expiration, exercise or assignment and the trades it settles into
+// @flow: validate against the contract -> lock user -> size from the open position -> check the new history -> save event -> save trades -> link them to the event
+func (s *tradeService) SettleDerivative(ctx context.Context, userID uint, input DerivativeEventInput) (*domain.DerivativeEvent, error) {
+	if s.derivRepo == nil || s.instRepo == nil {
+		return nil, errors.New("derivative events are not available")
+	}
+	event, instrument, err := s.newDerivativeEvent(ctx, userID, input)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.repo.WithUserLock(ctx, userID, func(ctx context.Context) error {
+		history, err := s.repo.GetByUserID(ctx, userID)
+		if err != nil {
+			return err
+		}
+		held, err := s.contractsHeld(ctx, accountTrades(history, accountKey(event.AccountID)), event.Symbol, event.OccurredAt)
+		if err != nil {
+			return err
+		}
+		if err := sizeDerivativeEvent(event, held); err != nil {
+			return err
+		}
+
+		closing := domain.Trade{
+			UserID:     userID,
+			AccountID:  event.AccountID,
+			Symbol:     event.Symbol,
+			Type:       "SELL",
+			Price:      event.Price,
+			Quantity:   event.Quantity,
+			Leverage:   decimal.NewFromInt(1),
+			Multiplier: instrument.ContractMultiplier(),
+			Notes:      derivativeNotes(event),
+			Source:     domain.TradeSourceDerivative,
+			ExecutedAt: event.OccurredAt,
+		}
+		if held.IsNegative() {
+			closing.Type = "BUY"
+		}
+		trades := []*domain.Trade{&closing}
+
+		if event.Type != domain.DerivativeExpiration {
+			delivery, err := s.deliveryTrade(ctx, userID, event, instrument, held.IsPositive())
+			if err != nil {
+				return err
+			}
+			trades = append(trades, delivery)
+		}
+
+		pending := history
+		for _, t := range trades {
+			pending = append(pending, *t)
+		}
+		if err := s.checkTimeline(ctx, userID, pending); err != nil {
+			return err
+		}
+
+		if err := s.derivRepo.Create(ctx, event); err != nil {
+			return err
+		}
+		for i, t := range trades {
+			t.ExternalID = fmt.Sprintf("%s:%d:%d", event.Type, event.ID, i+1)
+			if err := s.repo.Create(ctx, t); err != nil {
+				return err
+			}
+			if err := s.recordRevision(ctx, t.ID, userID, domain.RevisionCreate, "derivative event", nil, t); err != nil {
+				return err
+			}
+		}
+		event.TradeID = &closing.ID
+		if len(trades) > 1 {
+			event.UnderlyingTradeID = &trades[1].ID
+		}
+
+		// events are often recorded after the fact, replay so the trades land where they belong
+		if history, err = s.repo.GetByUserID(ctx, userID); err != nil {
+			return err
+		}
+		if err := s.refreshPositions(ctx, userID, history); err != nil {
+			return err
+		}
+		return s.derivRepo.Update(ctx, event)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+func (s *tradeService) newDerivativeEvent(ctx context.Context, userID uint, input DerivativeEventInput) (*domain.DerivativeEvent, *domain.Instrument, error) {
+	eventType := strings.ToUpper(strings.TrimSpace(input.Type))
+	if !IsValidDerivativeEvent(eventType) {
+		return nil, nil, fmt.Errorf("unsupported derivative event %q", input.Type)
+	}
+	if input.Quantity.IsNegative() {
+		return nil, nil, errors.New("quantity cannot be negative")
+	}
+	instrument, err := resolveInstrument(ctx, s.instRepo, input.Symbol)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !instrument.IsDerivative() || instrument.Expiry == nil {
+		return nil, nil, fmt.Errorf("%s is not a future or an option", instrument.Symbol)
+	}
+
+	if err := s.checkAccount(ctx, userID, input.AccountID); err != nil {
+		return nil, nil, err
+	}
+
+	event := &domain.DerivativeEvent{
+		UserID:     userID,
+		AccountID:  input.AccountID,
+		Type:       eventType,
+		Symbol:     instrument.Symbol,
+		Quantity:   input.Quantity,
+		Price:      decimal.Zero,
+		Notes:      input.Notes,
+		OccurredAt: time.Now(),
+	}
+	if input.OccurredAt != nil {
+		event.OccurredAt = *input.OccurredAt
+	}
+
+	switch {
+	case eventType == domain.DerivativeExpiration:
+		if instrument.Expiry.After(time.Now()) {
+			return nil, nil, fmt.Errorf("%s only expires on %s", instrument.Symbol, instrument.Expiry.Format(time.RFC3339))
+		}
+		event.OccurredAt = *instrument.Expiry
+		if instrument.AssetClass == domain.AssetClassFuture {
+			if !input.SettlementPrice.IsPositive() {
+				return nil, nil, errors.New("futures expire at their final settlement price, settlement_price is required")
+			}
+			event.Price = input.SettlementPrice
+		}
+	case instrument.AssetClass != domain.AssetClassOption:
+		return nil, nil, fmt.Errorf("only options can be exercised or assigned, %s is a future", instrument.Symbol)
+	case event.OccurredAt.After(time.Now()):
+		return nil, nil, errors.New("occurred_at cannot be in the future")
+	case event.OccurredAt.After(*instrument.Expiry):
+		return nil, nil, fmt.Errorf("%s expired on %s", instrument.Symbol, instrument.Expiry.Format(time.RFC3339))
+	}
+	if eventType != domain.DerivativeExpiration {
+		event.Underlying = instrument.Underlying
+		event.Strike = instrument.Strike
+	}
+	if !input.SettlementPrice.IsZero() && event.Price.IsZero() {
+		return nil, nil, errors.New("settlement_price is only for futures expirations")
+	}
+	return event, instrument, nil
+}
+
+// contractsHeld is the open position in symbol at the moment at, negative when short; history is the trades of one account
+func (s *tradeService) contractsHeld(ctx context.Context, history []domain.Trade, symbol string, at time.Time) (decimal.Decimal, error) {
+	trades, err := s.adjustTrades(ctx, history, time.Now())
+	if err != nil {
+		return decimal.Zero, err
+	}
+	book, err := matchLotsWithShorts(tradesUntil(trades, at), domain.CostMethodFIFO)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	return openQuantity(book.open[symbol]).Sub(openQuantity(book.short[symbol])), nil
+}
+
+// sizeDerivativeEvent checks the event fits the open position and defaults its quantity to all of it
+func sizeDerivativeEvent(event *domain.DerivativeEvent, held decimal.Decimal) error {
+	switch {
+	case held.IsZero():
+		return fmt.Errorf("no open %s position at %s", event.Symbol, event.OccurredAt.Format(time.RFC3339))
+	case event.Type == domain.DerivativeExercise && held.IsNegative():
+		return fmt.Errorf("%s is held short, a short option is assigned, not exercised", event.Symbol)
+	case event.Type == domain.DerivativeAssignment && held.IsPositive():
+		return fmt.Errorf("%s is held long, a long option is exercised, not assigned", event.Symbol)
+	}
+	if event.Quantity.IsZero() {
+		event.Quantity = held.Abs()
+	}
+	if event.Quantity.GreaterThan(held.Abs()) {
+		return fmt.Errorf("only %s %s contracts are open", held.Abs(), event.Symbol)
+	}
+	return nil
+}
+
+// deliveryTrade is the underlying leg of an exercise or assignment, bought or sold at the strike
+func (s *tradeService) deliveryTrade(ctx context.Context, userID uint, event *domain.DerivativeEvent, instrument *domain.Instrument, long bool) (*domain.Trade, error) {
+	if event.Underlying == "" {
+		return nil, fmt.Errorf("%s has no underlying to deliver", event.Symbol)
+	}
+	// the holder of a call and the writer of a put end up buying
+	buys := (instrument.OptionType == domain.OptionCall) == long
+	input := TradeInput{
+		AccountID:  event.AccountID,
+		Symbol:     event.Underlying,
+		Type:       "SELL",
+		Price:      event.Strike,
+		Quantity:   event.Quantity.Mul(instrument.ContractMultiplier()),
+		ExecutedAt: &event.OccurredAt,
+	}
+	if buys {
+		input.Type = "BUY"
+	}
+	if err := s.prepareInput(ctx, &input); err != nil {
+		return nil, fmt.Errorf("underlying %s: %w", event.Underlying, err)
+	}
+	if input.Type == "BUY" {
+		if err := s.checkBuyingPower(ctx, userID, input); err != nil {
+			return nil, err
+		}
+	}
+	event.Underlying = input.Symbol
+	event.UnderlyingQuantity = input.Quantity
+
+	return &domain.Trade{
+		UserID:     userID,
+		AccountID:  event.AccountID,
+		Symbol:     input.Symbol,
+		Type:       input.Type,
+		Price:      input.Price,
+		Quantity:   input.Quantity,
+		Leverage:   decimal.NewFromInt(1),
+		Multiplier: input.Multiplier,
+		Notes:      derivativeNotes(event),
+		Source:     domain.TradeSourceDerivative,
+		ExecutedAt: event.OccurredAt,
+	}, nil
+}
+
+func derivativeNotes(event *domain.DerivativeEvent) string {
+	notes := strings.ToLower(event.Type) + " of " + event.Quantity.String() + " " + event.Symbol
+	if event.Notes != "" {
+		notes += ": " + event.Notes
+	}
+	return notes
+}
+
+// @desc: derivative events of the user, oldest first
+func (s *tradeService) ListDerivativeEvents(ctx context.Context, userID uint) ([]domain.DerivativeEvent, error) {
+	if s.derivRepo == nil {
+		return []domain.DerivativeEvent{}, nil
+	}
+	events, err := s.derivRepo.GetByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if events == nil {
+		events = []domain.DerivativeEvent{}
+	}
+	return events, nil
+}
+
+// @desc: fill the underlying exposure of every futures and options item, after the items were valued
+func (s *tradeService) addDerivativeExposure(ctx context.Context, items []PortfolioItem, at *time.Time) error {
+	if s.instRepo == nil {
+		return nil
+	}
+	reference := time.Now()
+	if at != nil {
+		reference = *at
+	}
+	for i := range items {
+		instrument, err := s.instRepo.GetBySymbol(ctx, items[i].Symbol)
+		if err != nil {
+			return err
+		}
+		if instrument == nil || !instrument.IsDerivative() {
+			continue
+		}
+		exposure := derivativeExposure(items[i], instrument, reference)
+		if price := s.underlyingPrice(ctx, items[i], instrument, at); price != nil {
+			exposure.UnderlyingPrice = price
+			notional := exposure.UnderlyingQuantity.Mul(*price)
+			exposure.Notional = &notional
+			if instrument.AssetClass == domain.AssetClassOption {
+				intrinsic := optionIntrinsicValue(instrument, *price).Mul(items[i].Quantity).Mul(items[i].Multiplier)
+				exposure.IntrinsicValue = &intrinsic
+			}
+		}
+		items[i].Derivative = exposure
+	}
+	return nil
+}
+
+func derivativeExposure(item PortfolioItem, instrument *domain.Instrument, at time.Time) *DerivativeExposure {
+	exposure := &DerivativeExposure{
+		AssetClass:         instrument.AssetClass,
+		Underlying:         instrument.Underlying,
+		OptionType:         instrument.OptionType,
+		Expiry:             instrument.Expiry,
+		UnderlyingQuantity: item.Quantity.Mul(item.Multiplier),
+	}
+	if instrument.AssetClass == domain.AssetClassOption {
+		strike := instrument.Strike
+		exposure.Strike = &strike
+		if instrument.OptionType == domain.OptionPut {
+			exposure.UnderlyingQuantity = exposure.UnderlyingQuantity.Neg()
+		}
+	}
+	if instrument.Expiry != nil {
+		exposure.Expired = at.After(*instrument.Expiry)
+		if !exposure.Expired {
+			exposure.DaysToExpiry = int(instrument.Expiry.Sub(at).Hours() / 24)
+		}
+	}
+	return exposure
+}
+
+// underlyingPrice quotes the underlying, a future without one is exposed at its own price
+func (s *tradeService) underlyingPrice(ctx context.Context, item PortfolioItem, instrument *domain.Instrument, at *time.Time) *decimal.Decimal {
+	if instrument.Underlying == "" {
+		if item.PriceAsOf == nil || instrument.AssetClass != domain.AssetClassFuture {
+			return nil
+		}
+		price := item.Price
+		return &price
+	}
+	quote, err := s.quote(ctx, instrument.Underlying, at)
+	if err != nil || quote == nil {
+		return nil
+	}
+	return &quote.Price
+}
+
+// optionIntrinsicValue is what one unit of the underlying under the option is worth when exercised at price
+func optionIntrinsicValue(instrument *domain.Instrument, price decimal.Decimal) decimal.Decimal {
+	if instrument.OptionType == domain.OptionPut {
+		return decimal.Max(instrument.Strike.Sub(price), decimal.Zero)
+	}
+	return decimal.Max(price.Sub(instrument.Strike), decimal.Zero)
+}
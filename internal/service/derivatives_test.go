@@ -0,0 +1,121 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/MonalBarse/tradelog/internal/domain"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type MockDerivativeEventRepo struct {
+	mock.Mock
+}
+
+func (m *MockDerivativeEventRepo) Create(ctx context.Context, event *domain.DerivativeEvent) error {
+	return m.Called(ctx, event).Error(0)
+}
+
+func (m *MockDerivativeEventRepo) Update(ctx context.Context, event *domain.DerivativeEvent) error {
+	return m.Called(ctx, event).Error(0)
+}
+
+func (m *MockDerivativeEventRepo) GetByUserID(ctx context.Context, userID uint) ([]domain.DerivativeEvent, error) {
+	args := m.Called(ctx, userID)
+	events, _ := args.Get(0).([]domain.DerivativeEvent)
+	return events, args.Error(1)
+}
+
+func aaplInstruments(expiry time.Time) (stock, call, put *domain.Instrument) {
+	stock = &domain.Instrument{Symbol: "AAPL/USD", BaseAsset: "AAPL", QuoteAsset: "USD", AssetClass: domain.AssetClassEquity, Active: true}
+	call = &domain.Instrument{
+		Symbol: "AAPL240621C200/USD", BaseAsset: "AAPL240621C200", QuoteAsset: "USD", AssetClass: domain.AssetClassOption, Active: true,
+		Underlying: "AAPL/USD", Multiplier: d("100"), Expiry: &expiry, OptionType: domain.OptionCall, Strike: d("200"),
+	}
+	put = &domain.Instrument{
+		Symbol: "AAPL240621P180/USD", BaseAsset: "AAPL240621P180", QuoteAsset: "USD", AssetClass: domain.AssetClassOption, Active: true,
+		Underlying: "AAPL/USD", Multiplier: d("100"), Expiry: &expiry, OptionType: domain.OptionPut, Strike: d("180"),
+	}
+	return stock, call, put
+}
+
+func TestSettleDerivative_ExerciseDeliversUnderlyingAtStrike(t *testing.T) {
+	repo, instRepo, derivRepo := &memTradeRepo{}, new(MockInstrumentRepo), new(MockDerivativeEventRepo)
+	stock, call, _ := aaplInstruments(time.Now().AddDate(0, 1, 0))
+	service := NewTradeService(repo, WithInstrumentRepository(instRepo), WithDerivativeEventRepository(derivRepo))
+	ctx := context.Background()
+
+	instRepo.On("GetBySymbol", ctx, "AAPL/USD").Return(stock, nil)
+	instRepo.On("GetBySymbol", ctx, "AAPL240621C200/USD").Return(call, nil)
+	derivRepo.On("Create", ctx, mock.Anything).Return(nil)
+	derivRepo.On("Update", ctx, mock.Anything).Return(nil)
+
+	require.NoError(t, service.CreateTrade(ctx, 1, TradeInput{Symbol: "AAPL240621C200/USD", Type: "BUY", Price: d("5"), Quantity: d("2")}))
+	require.Len(t, repo.trades, 1)
+	assert.True(t, d("100").Equal(repo.trades[0].Multiplier))
+
+	_, err := service.SettleDerivative(ctx, 1, DerivativeEventInput{Type: "ASSIGNMENT", Symbol: "AAPL240621C200/USD"})
+	assert.ErrorContains(t, err, "a long option is exercised")
+
+	event, err := service.SettleDerivative(ctx, 1, DerivativeEventInput{Type: "exercise", Symbol: "AAPL240621C200/USD"})
+	require.NoError(t, err)
+	assert.True(t, d("2").Equal(event.Quantity))
+	assert.True(t, d("200").Equal(event.UnderlyingQuantity))
+	require.Len(t, repo.trades, 3)
+	assert.Equal(t, domain.TradeSourceDerivative, repo.trades[2].Source)
+
+	portfolio, err := service.GetPortfolio(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, portfolio, 1)
+	assert.Equal(t, "AAPL/USD", portfolio[0].Symbol)
+	assert.True(t, d("200").Equal(portfolio[0].Quantity))
+	assert.True(t, d("40000").Equal(portfolio[0].CostBasis))
+
+	report, err := service.GetPnL(ctx, 1, nil, "")
+	require.NoError(t, err)
+	assert.True(t, d("-1000").Equal(report.TotalRealized)) // the premium, 2 * 5 * 100
+}
+
+func TestGetPortfolio_DerivativeExposure(t *testing.T) {
+	expiry := time.Now().AddDate(0, 0, 10)
+	stock, _, put := aaplInstruments(expiry)
+	expired := time.Now().AddDate(0, 0, -1)
+	future := &domain.Instrument{Symbol: "ESZ4/USD", QuoteAsset: "USD", AssetClass: domain.AssetClassFuture, Active: true, Multiplier: d("50"), Expiry: &expired}
+
+	repo := &memTradeRepo{trades: []domain.Trade{
+		{ID: 1, UserID: 1, Symbol: "AAPL240621P180/USD", Type: "SELL", Price: d("3"), Quantity: d("1"), Multiplier: d("100"), ExecutedAt: time.Now().Add(-time.Hour)},
+		{ID: 2, UserID: 1, Symbol: "ESZ4/USD", Type: "BUY", Price: d("5000"), Quantity: d("1"), Multiplier: d("50"), ExecutedAt: expired.Add(-time.Hour)},
+	}}
+	instRepo := new(MockInstrumentRepo)
+	prices := map[string]decimal.Decimal{"AAPL/USD": d("170"), "AAPL240621P180/USD": d("11"), "ESZ4/USD": d("5100")}
+	service := NewTradeService(repo,
+		WithInstrumentRepository(instRepo),
+		WithPriceProvider(NewStaticPriceProvider(prices), time.Hour),
+	)
+	ctx := context.Background()
+	instRepo.On("GetBySymbol", ctx, "AAPL/USD").Return(stock, nil)
+	instRepo.On("GetBySymbol", ctx, "AAPL240621P180/USD").Return(put, nil)
+	instRepo.On("GetBySymbol", ctx, "ESZ4/USD").Return(future, nil)
+
+	portfolio, err := service.GetPortfolio(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, portfolio, 2)
+
+	short := portfolio[0]
+	assert.Equal(t, "AAPL240621P180/USD", short.Symbol)
+	assert.True(t, d("-1100").Equal(short.Value)) // -1 * 11 * 100
+	require.NotNil(t, short.Derivative)
+	assert.True(t, d("100").Equal(short.Derivative.UnderlyingQuantity)) // a short put gains as AAPL rises
+	assert.True(t, d("17000").Equal(*short.Derivative.Notional))
+	assert.True(t, d("-1000").Equal(*short.Derivative.IntrinsicValue)) // -1 * (180 - 170) * 100
+	assert.False(t, short.Derivative.Expired)
+
+	futures := portfolio[1]
+	assert.True(t, d("255000").Equal(futures.Value))
+	assert.True(t, futures.Derivative.Expired)
+	assert.Equal(t, []string{"ESZ4/USD"}, SummarizePortfolio(portfolio, "").Expired)
+}
@@ -0,0 +1,438 @@
+package service
+
+import (
+	"archive/zip"
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/MonalBarse/tradelog/internal/domain"
+	"github.com/MonalBarse/tradelog/internal/repository"
+	"github.com/shopspring/decimal"
+)
+
+/*
+NOTE:
+	Exports are written row by row straight into the response. Trades are read
+	page by page with the listing's keyset cursor, so memory stays flat however
+	many trades match. The one exception is the cost basis of SELLs: it comes
+	from the lot engine, which needs the user's whole history, so it is only
+	filled in when the export is for a single user.
+	XLSX is written by hand (a zip of a few XML parts) to keep the row streaming.
+*/
+
+// Export formats
+const (
+	ExportCSV    = "csv"
+	ExportNDJSON = "ndjson"
+	ExportXLSX   = "xlsx"
+)
+
+// exportPageSize is how many trades are read from the database at a time
+const exportPageSize = 500
+
+// IsValidExportFormat reports whether format is one of the supported export formats
+func IsValidExportFormat(format string) bool {
+	switch format {
+	case ExportCSV, ExportNDJSON, ExportXLSX:
+		return true
+	}
+	return false
+}
+
+// ExportContentType is the media type of an export format
+func ExportContentType(format string) string {
+	switch format {
+	case ExportNDJSON:
+		return "application/x-ndjson"
+	case ExportXLSX:
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	}
+	return "text/csv"
+}
+
+// Column of an exported table, numeric columns are numbers in NDJSON and XLSX
+type Column struct {
+	Name    string
+	Numeric bool
+}
+
+// TableWriter writes an export one row at a time, values line up with the columns it was created with
+type TableWriter interface {
+	WriteRow(values []string) error
+	Close() error // flushes, the underlying writer stays open
+}
+
+// NewTableWriter starts an export in format, CSV and XLSX write the header row right away
+func NewTableWriter(format string, w io.Writer, columns []Column) (TableWriter, error) {
+	switch format {
+	case ExportCSV:
+		return newCSVTableWriter(w, columns)
+	case ExportNDJSON:
+		return &ndjsonTableWriter{w: bufio.NewWriter(w), columns: columns}, nil
+	case ExportXLSX:
+		return newXLSXTableWriter(w, columns)
+	}
+	return nil, fmt.Errorf("unsupported export format %q", format)
+}
+
+// ---------- CSV ----------
+
+type csvTableWriter struct {
+	w *csv.Writer
+}
+
+func newCSVTableWriter(w io.Writer, columns []Column) (*csvTableWriter, error) {
+	t := &csvTableWriter{csv.NewWriter(w)}
+	header := make([]string, len(columns))
+	for i, col := range columns {
+		header[i] = col.Name
+	}
+	return t, t.w.Write(header)
+}
+
+func (t *csvTableWriter) WriteRow(values []string) error {
+	return t.w.Write(values)
+}
+
+func (t *csvTableWriter) Close() error {
+	t.w.Flush()
+	return t.w.Error()
+}
+
+// ---------- NDJSON ----------
+
+type ndjsonTableWriter struct {
+	w       *bufio.Writer
+	columns []Column
+}
+
+// WriteRow writes one object per line with the keys in column order, empty values are null
+func (t *ndjsonTableWriter) WriteRow(values []string) error {
+	t.w.WriteByte('{')
+	for i, col := range t.columns {
+		if i > 0 {
+			t.w.WriteByte(',')
+		}
+		key, _ := json.Marshal(col.Name)
+		t.w.Write(key)
+		t.w.WriteByte(':')
+
+		switch {
+		case values[i] == "":
+			t.w.WriteString("null")
+		case col.Numeric:
+			t.w.WriteString(values[i])
+		default:
+			value, _ := json.Marshal(values[i])
+			t.w.Write(value)
+		}
+	}
+	t.w.WriteString("}\n")
+	return nil // bufio keeps the first write error, Close reports it
+}
+
+func (t *ndjsonTableWriter) Close() error {
+	return t.w.Flush()
+}
+
+// ---------- XLSX ----------
+
+// the fixed parts of a one-sheet workbook
+var xlsxParts = []struct{ name, body string }{
+	{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/></Types>`},
+	{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`},
+	{"xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="Export" sheetId="1" r:id="rId1"/></sheets></workbook>`},
+	{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/></Relationships>`},
+}
+
+type xlsxTableWriter struct {
+	zip     *zip.Writer
+	sheet   *bufio.Writer
+	columns []Column
+}
+
+// newXLSXTableWriter writes the fixed parts, then keeps the sheet part open for the rows
+func newXLSXTableWriter(w io.Writer, columns []Column) (*xlsxTableWriter, error) {
+	zw := zip.NewWriter(w)
+	for _, part := range xlsxParts {
+		f, err := zw.Create(part.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(f, part.body); err != nil {
+			return nil, err
+		}
+	}
+
+	f, err := zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	t := &xlsxTableWriter{zip: zw, sheet: bufio.NewWriter(f), columns: columns}
+	t.sheet.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n" +
+		`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+
+	header := make([]string, len(columns))
+	for i, col := range columns {
+		header[i] = col.Name
+	}
+	return t, t.writeRow(header, false)
+}
+
+func (t *xlsxTableWriter) WriteRow(values []string) error {
+	return t.writeRow(values, true)
+}
+
+// writeRow writes inline string cells, or number cells for numeric columns when typed is set
+func (t *xlsxTableWriter) writeRow(values []string, typed bool) error {
+	t.sheet.WriteString("<row>")
+	for i, value := range values {
+		switch {
+		case value == "":
+			t.sheet.WriteString("<c/>") // cells have no reference, an empty one keeps the next in its column
+		case typed && t.columns[i].Numeric:
+			t.sheet.WriteString("<c><v>" + value + "</v></c>")
+		default:
+			t.sheet.WriteString(`<c t="inlineStr"><is><t xml:space="preserve">`)
+			if err := xml.EscapeText(t.sheet, []byte(value)); err != nil {
+				return err
+			}
+			t.sheet.WriteString("</t></is></c>")
+		}
+	}
+	_, err := t.sheet.WriteString("</row>")
+	return err
+}
+
+func (t *xlsxTableWriter) Close() error {
+	t.sheet.WriteString("</sheetData></worksheet>")
+	if err := t.sheet.Flush(); err != nil {
+		return err
+	}
+	return t.zip.Close()
+}
+
+// ---------- trades ----------
+
+var tradeExportColumns = []Column{
+	{Name: "id", Numeric: true},
+	{Name: "user_id", Numeric: true},
+	{Name: "executed_at"},
+	{Name: "symbol"},
+	{Name: "type"},
+	{Name: "quantity", Numeric: true},
+	{Name: "price", Numeric: true},
+	{Name: "value", Numeric: true},      // quantity * price
+	{Name: "quote_fees", Numeric: true}, // fees paid in the quote currency
+	{Name: "base_fees", Numeric: true},  // fees paid in the base asset
+	{Name: "other_fees"},                // e.g. "0.001 BNB"
+	{Name: "cost_basis", Numeric: true}, // BUY: value + quote fees, SELL: cost of the lots it closed
+	{Name: "realized_pnl", Numeric: true},
+	{Name: "notes"},
+	{Name: "source"},
+	{Name: "external_id"},
+}
+
+// sellResult is what the lot engine matched a SELL against
+type sellResult struct {
+	costBasis, realizedPnL decimal.Decimal
+}
+
+// @desc: stream every trade matching the filter, Limit and Cursor are ignored
+// @flow: validate filter -> match lots when the export is for one user -> write page after page
+func (s *tradeService) ExportTrades(ctx context.Context, filter TradeFilter, format string, w io.Writer) error {
+	if filter.SortBy != "" && !repository.IsValidTradeSort(filter.SortBy) {
+		return fmt.Errorf("%w: unsupported sort %q", ErrInvalidFilter, filter.SortBy)
+	}
+	if filter.Symbol != "" {
+		filter.Symbol = domain.NormalizeSymbol(filter.Symbol)
+	}
+	filter.Limit, filter.Cursor = exportPageSize, ""
+
+	var sells map[uint]sellResult
+	if filter.UserID != nil {
+		var err error
+		if sells, err = s.sellResults(ctx, *filter.UserID); err != nil {
+			return err
+		}
+	}
+
+	table, err := NewTableWriter(format, w, tradeExportColumns)
+	if err != nil {
+		return err
+	}
+	for {
+		trades, err := s.repo.List(ctx, filter)
+		if err != nil {
+			return err
+		}
+		for _, t := range trades {
+			if err := table.WriteRow(tradeExportRow(t, sells)); err != nil {
+				return err
+			}
+		}
+		if len(trades) < filter.Limit {
+			break
+		}
+		filter.Cursor = repository.NextTradeCursor(filter, trades[len(trades)-1])
+	}
+	return table.Close()
+}
+
+// sellResults replays the user's trades and sums the closed lots of every SELL, and of every BUY that covered a short
+func (s *tradeService) sellResults(ctx context.Context, userID uint) (map[uint]sellResult, error) {
+	method, err := s.costMethod(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	trades, err := s.userTrades(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	book, err := matchLotsWithShorts(trades, method)
+	if err != nil {
+		return nil, err
+	}
+
+	sells := make(map[uint]sellResult)
+	for _, closed := range book.closed {
+		r := sells[closed.CloseTradeID]
+		r.costBasis = r.costBasis.Add(closed.CostBasis)
+		r.realizedPnL = r.realizedPnL.Add(closed.RealizedPnL)
+		sells[closed.CloseTradeID] = r
+	}
+	return sells, nil
+}
+
+func tradeExportRow(t domain.Trade, sells map[uint]sellResult) []string {
+	base, quote := domain.SplitSymbol(t.Symbol)
+	value := t.Notional()
+	quoteFees, baseFees := feeTotals(t)
+
+	var otherFees string
+	for _, fee := range t.Fees {
+		if fee.Currency != "" && fee.Currency != base && fee.Currency != quote {
+			if otherFees != "" {
+				otherFees += "; "
+			}
+			otherFees += fee.Amount.String() + " " + fee.Currency
+		}
+	}
+
+	var costBasis, realized string
+	switch t.Type {
+	case "BUY":
+		costBasis = value.Add(quoteFees).String()
+		if r, ok := sells[t.ID]; ok { // the BUY covered a short
+			realized = r.realizedPnL.String()
+		}
+	case "SELL":
+		if r, ok := sells[t.ID]; ok {
+			costBasis, realized = r.costBasis.String(), r.realizedPnL.String()
+		}
+	}
+
+	return []string{
+		strconv.FormatUint(uint64(t.ID), 10),
+		strconv.FormatUint(uint64(t.UserID), 10),
+		t.ExecutedAt.UTC().Format(time.RFC3339),
+		t.Symbol,
+		t.Type,
+		t.Quantity.String(),
+		t.Price.String(),
+		value.String(),
+		quoteFees.String(),
+		baseFees.String(),
+		otherFees,
+		costBasis,
+		realized,
+		t.Notes,
+		t.Source,
+		t.ExternalID,
+	}
+}
+
+// ---------- portfolio ----------
+
+var portfolioExportColumns = []Column{
+	{Name: "symbol"},
+	{Name: "quantity", Numeric: true},
+	{Name: "average_cost", Numeric: true},
+	{Name: "cost_basis", Numeric: true},
+	{Name: "currency"},
+	{Name: "price", Numeric: true},
+	{Name: "price_as_of"},
+	{Name: "price_stale"},
+	{Name: "value", Numeric: true},
+	{Name: "unrealized_pnl", Numeric: true}, // empty when there is no price
+	{Name: "base_currency"},
+	{Name: "base_cost_basis", Numeric: true},
+	{Name: "base_value", Numeric: true},
+	{Name: "side"}, // LONG or SHORT, a short has negative quantity, cost and value
+}
+
+// @desc: write the holdings as GetPortfolio (at nil) or GetPortfolioAt computes them
+func (s *tradeService) ExportPortfolio(ctx context.Context, userID uint, at *time.Time, format string, w io.Writer) error {
+	items, err := s.portfolio(ctx, userID, nil, at)
+	if err != nil {
+		return err
+	}
+
+	table, err := NewTableWriter(format, w, portfolioExportColumns)
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		if err := table.WriteRow(portfolioExportRow(item)); err != nil {
+			return err
+		}
+	}
+	return table.Close()
+}
+
+func portfolioExportRow(item PortfolioItem) []string {
+	priced := item.PriceAsOf != nil // no quote: price and value are zero, not known
+
+	var price, priceAsOf, value, unrealized string
+	if priced {
+		price, value = item.Price.String(), item.Value.String()
+		priceAsOf = item.PriceAsOf.UTC().Format(time.RFC3339)
+		unrealized = item.Value.Sub(item.CostBasis).String()
+	}
+
+	var baseCost, baseValue string
+	if item.FXError == "" {
+		baseCost = item.BaseCostBasis.String()
+		if priced {
+			baseValue = item.BaseValue.String()
+		}
+	}
+
+	return []string{
+		item.Symbol,
+		item.Quantity.String(),
+		item.AverageCost.String(),
+		item.CostBasis.String(),
+		item.Currency,
+		price,
+		priceAsOf,
+		strconv.FormatBool(item.PriceStale),
+		value,
+		unrealized,
+		item.BaseCurrency,
+		baseCost,
+		baseValue,
+		item.Side,
+	}
+}
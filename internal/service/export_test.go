@@ -0,0 +1,115 @@
+package service
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/MonalBarse/tradelog/internal/domain"
+	"github.com/MonalBarse/tradelog/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportTrades_PagesAndSellCostBasis(t *testing.T) {
+	mockRepo := new(MockTradeRepo)
+	service := NewTradeService(mockRepo)
+	ctx := context.Background()
+
+	history := lotHistory()
+	mockRepo.On("GetByUserID", ctx, uint(1)).Return(history, nil)
+
+	// a full first page makes the export ask for the next one
+	firstPage := make([]domain.Trade, exportPageSize)
+	for i := range firstPage {
+		firstPage[i] = domain.Trade{ID: uint(1000 + i), Symbol: "ETH/USD", Type: "BUY", Price: d("10"), Quantity: d("1"), ExecutedAt: history[0].ExecutedAt}
+	}
+	firstPage[0] = history[2]
+	firstPage[0].Fees = []domain.TradeFee{{Type: domain.FeeTypeCommission, Amount: d("0.5"), Currency: "BNB"}}
+	mockRepo.On("List", ctx, mock.MatchedBy(func(f repository.TradeFilter) bool { return f.Cursor == "" })).Return(firstPage, nil).Once()
+	mockRepo.On("List", ctx, mock.MatchedBy(func(f repository.TradeFilter) bool { return f.Cursor != "" })).Return(history[:1], nil).Once()
+
+	uid := uint(1)
+	var out bytes.Buffer
+	require.NoError(t, service.ExportTrades(ctx, TradeFilter{UserID: &uid, Desc: true}, ExportCSV, &out))
+
+	records, err := csv.NewReader(&out).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 1+exportPageSize+1)
+	assert.Equal(t, "cost_basis", records[0][11])
+
+	sell := records[1]
+	assert.Equal(t, []string{"3", "SELL", "4500", "0.5 BNB", "2000", "2500"}, []string{sell[0], sell[4], sell[7], sell[10], sell[11], sell[12]})
+	buy := records[len(records)-1]
+	assert.Equal(t, []string{"1", "1000", ""}, []string{buy[0], buy[11], buy[12]})
+	mockRepo.AssertExpectations(t)
+}
+
+func TestTableWriter_NDJSONAndXLSX(t *testing.T) {
+	columns := []Column{{Name: "symbol"}, {Name: "price", Numeric: true}, {Name: "notes"}}
+	rows := [][]string{{"BTC/USD", "65000.5", "a < b & \"c\""}, {"ETH/USD", "", ""}}
+
+	var out bytes.Buffer
+	table, err := NewTableWriter(ExportNDJSON, &out, columns)
+	require.NoError(t, err)
+	for _, row := range rows {
+		require.NoError(t, table.WriteRow(row))
+	}
+	require.NoError(t, table.Close())
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 2)
+	assert.True(t, strings.HasPrefix(lines[0], `{"symbol":"BTC/USD","price":65000.5,"notes":`), lines[0])
+	var first, second map[string]any
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &first))
+	assert.Equal(t, `a < b & "c"`, first["notes"])
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &second))
+	assert.Nil(t, second["price"])
+
+	out.Reset()
+	table, err = NewTableWriter(ExportXLSX, &out, columns)
+	require.NoError(t, err)
+	for _, row := range rows {
+		require.NoError(t, table.WriteRow(row))
+	}
+	require.NoError(t, table.Close())
+
+	archive, err := zip.NewReader(bytes.NewReader(out.Bytes()), int64(out.Len()))
+	require.NoError(t, err)
+	var sheet string
+	for _, f := range archive.File {
+		if f.Name == "xl/worksheets/sheet1.xml" {
+			r, err := f.Open()
+			require.NoError(t, err)
+			body, _ := io.ReadAll(r)
+			sheet = string(body)
+		}
+	}
+	assert.Len(t, archive.File, 5)
+	assert.Contains(t, sheet, `<c><v>65000.5</v></c>`)
+	assert.Contains(t, sheet, `a &lt; b &amp; &#34;c&#34;`)
+	assert.Equal(t, 3, strings.Count(sheet, "<row>")) // header + 2
+}
+
+func TestExportPortfolio_UnpricedHoldingHasNoValue(t *testing.T) {
+	mockRepo := new(MockTradeRepo)
+	service := NewTradeService(mockRepo)
+	ctx := context.Background()
+
+	mockRepo.On("GetByUserID", ctx, uint(1)).Return(lotHistory(), nil)
+
+	var out bytes.Buffer
+	require.NoError(t, service.ExportPortfolio(ctx, 1, nil, ExportCSV, &out))
+
+	records, err := csv.NewReader(&out).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, []string{"BTC/USD", "5", "200", "1000", "USD", ""}, records[1][:6])
+	assert.Empty(t, records[1][9]) // unrealized_pnl
+}
@@ -0,0 +1,78 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/MonalBarse/tradelog/internal/domain"
+	"github.com/shopspring/decimal"
+)
+
+/*
+NOTE:
+	How a fee line is folded into a trade depends on its currency:
+		quote currency (USD for BTC/USD) -> added to the BUY cost, taken off the SELL proceeds
+		base asset (BTC for BTC/USD)     -> BUY receives less, SELL gives up more
+		anything else (e.g. BNB)         -> recorded on the trade only, it can't be folded without a rate
+	A fee without a currency is assumed to be in the quote currency.
+*/
+
+// IsValidFeeType reports whether feeType is one of the supported fee types
+func IsValidFeeType(feeType string) bool {
+	switch feeType {
+	case domain.FeeTypeCommission, domain.FeeTypeExchange, domain.FeeTypeFunding, domain.FeeTypeTax, domain.FeeTypeSlippage:
+		return true
+	}
+	return false
+}
+
+// @desc: validate fee lines and default their currency to the symbol's quote currency
+func normalizeFees(symbol, tradeType string, quantity decimal.Decimal, fees []domain.TradeFee) error {
+	_, quote := domain.SplitSymbol(symbol)
+	for i := range fees {
+		fee := &fees[i]
+		if !IsValidFeeType(fee.Type) {
+			return fmt.Errorf("unsupported fee type %q", fee.Type)
+		}
+		if fee.Amount.IsNegative() {
+			return errors.New("fee amount cannot be negative")
+		}
+		fee.Currency = strings.ToUpper(strings.TrimSpace(fee.Currency))
+		if fee.Currency == "" {
+			fee.Currency = quote
+		}
+	}
+
+	t := domain.Trade{Symbol: symbol, Type: tradeType, Quantity: quantity, Fees: fees}
+	if _, baseFees := feeTotals(t); tradeType == "BUY" && baseFees.GreaterThanOrEqual(quantity) {
+		return errors.New("fees paid in the base asset must be less than the quantity bought")
+	}
+	return nil
+}
+
+// feeTotals splits a trade's fees into what is paid in the quote currency and in the base asset
+func feeTotals(t domain.Trade) (quoteFees, baseFees decimal.Decimal) {
+	base, quote := domain.SplitSymbol(t.Symbol)
+	for _, fee := range t.Fees {
+		switch {
+		case fee.Currency == "" || strings.EqualFold(fee.Currency, quote):
+			quoteFees = quoteFees.Add(fee.Amount)
+		case strings.EqualFold(fee.Currency, base):
+			baseFees = baseFees.Add(fee.Amount)
+		}
+	}
+	return quoteFees, baseFees
+}
+
+// positionDelta is the signed change a trade makes to the holding, net of base asset fees
+func positionDelta(t domain.Trade) decimal.Decimal {
+	_, baseFees := feeTotals(t)
+	switch t.Type {
+	case "BUY":
+		return t.Quantity.Sub(baseFees)
+	case "SELL":
+		return t.Quantity.Add(baseFees).Neg()
+	}
+	return decimal.Zero
+}
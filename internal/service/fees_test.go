@@ -0,0 +1,92 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/MonalBarse/tradelog/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatchLots_FoldsFees(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	trades := []domain.Trade{
+		{ID: 1, Symbol: "BTC/USD", Type: "BUY", Price: d("100"), Quantity: d("10"), ExecutedAt: start, Fees: []domain.TradeFee{
+			{Type: domain.FeeTypeCommission, Amount: d("10"), Currency: "USD"},
+			{Type: domain.FeeTypeExchange, Amount: d("1"), Currency: "BTC"}, // paid in the base asset
+		}},
+		{ID: 2, Symbol: "BTC/USD", Type: "SELL", Price: d("200"), Quantity: d("9"), ExecutedAt: start.Add(time.Hour), Fees: []domain.TradeFee{
+			{Type: domain.FeeTypeTax, Amount: d("18"), Currency: "USD"},
+			{Type: domain.FeeTypeCommission, Amount: d("5"), Currency: "BNB"}, // not folded
+		}},
+	}
+
+	book, err := matchLots(trades, domain.CostMethodFIFO)
+	require.NoError(t, err)
+
+	assert.Empty(t, book.holdings()) // 9 received, 9 sold
+	require.Len(t, book.closed, 1)
+	closed := book.closed[0]
+	assert.True(t, d("1010").Equal(closed.CostBasis))  // 10 * 100 + 10
+	assert.True(t, d("1782").Equal(closed.Proceeds))   // 9 * 200 - 18
+	assert.True(t, d("772").Equal(closed.RealizedPnL)) // 1782 - 1010
+	assert.True(t, d("18").Equal(closed.Fees))
+}
+
+func TestCreateTrade_BaseAssetFeeCountsAgainstPosition(t *testing.T) {
+	mockRepo := new(MockTradeRepo)
+	service := NewTradeService(mockRepo)
+	ctx := context.Background()
+
+	mockRepo.On("GetByUserID", ctx, uint(1)).Return([]domain.Trade{
+		{Symbol: "BTC/USD", Type: "BUY", Quantity: d("10")},
+	}, nil)
+
+	err := service.CreateTrade(ctx, 1, TradeInput{
+		Symbol:   "BTC/USD",
+		Type:     "SELL",
+		Price:    d("100"),
+		Quantity: d("10"),
+		Fees:     []domain.TradeFee{{Type: domain.FeeTypeCommission, Amount: d("0.01"), Currency: "BTC"}},
+	})
+
+	assert.EqualError(t, err, "insufficient funds: you cannot sell more than you own")
+}
+
+func TestCreateTrade_DefaultsFeeCurrency(t *testing.T) {
+	mockRepo := new(MockTradeRepo)
+	service := NewTradeService(mockRepo)
+	ctx := context.Background()
+
+	mockRepo.On("Create", ctx, mock.MatchedBy(func(trade *domain.Trade) bool {
+		return len(trade.Fees) == 1 && trade.Fees[0].Currency == "USD"
+	})).Return(nil)
+
+	err := service.CreateTrade(ctx, 1, TradeInput{
+		Symbol:   "BTC/USD",
+		Type:     "BUY",
+		Price:    d("100"),
+		Quantity: d("1"),
+		Fees:     []domain.TradeFee{{Type: domain.FeeTypeCommission, Amount: d("1")}},
+	})
+
+	require.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestCreateTrade_RejectsUnknownFeeType(t *testing.T) {
+	service := NewTradeService(new(MockTradeRepo))
+
+	err := service.CreateTrade(context.Background(), 1, TradeInput{
+		Symbol:   "BTC/USD",
+		Type:     "BUY",
+		Price:    d("100"),
+		Quantity: d("1"),
+		Fees:     []domain.TradeFee{{Type: "REBATE", Amount: d("1")}},
+	})
+
+	assert.EqualError(t, err, `unsupported fee type "REBATE"`)
+}
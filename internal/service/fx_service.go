@@ -0,0 +1,209 @@
+package service
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/MonalBarse/tradelog/internal/domain"
+	"github.com/MonalBarse/tradelog/internal/repository"
+	"github.com/shopspring/decimal"
+)
+
+// Sources of stored FX rates
+const (
+	FXSourceManual = "manual"
+	FXSourceFile   = "file"
+)
+
+// pivotCurrency is used to cross two currencies that have no direct rate
+const pivotCurrency = "USD"
+
+var ErrRateNotFound = errors.New("fx rate not found")
+
+type FXService interface {
+	SetRate(ctx context.Context, base, quote string, rate decimal.Decimal, asOf *time.Time) (*domain.FXRate, error)
+	ImportCSV(ctx context.Context, r io.Reader) (int, error)
+	ListLatest(ctx context.Context) ([]domain.FXRate, error)
+}
+
+type fxService struct {
+	repo repository.FXRepository
+}
+
+func NewFXService(repo repository.FXRepository) FXService {
+	return &fxService{repo}
+}
+
+// @desc: enter a rate by hand, asOf defaults to now
+func (s *fxService) SetRate(ctx context.Context, base, quote string, rate decimal.Decimal, asOf *time.Time) (*domain.FXRate, error) {
+	fx := domain.FXRate{
+		Base:   strings.ToUpper(strings.TrimSpace(base)),
+		Quote:  strings.ToUpper(strings.TrimSpace(quote)),
+		Rate:   rate,
+		AsOf:   time.Now(),
+		Source: FXSourceManual,
+	}
+	if asOf != nil {
+		fx.AsOf = *asOf
+	}
+	if err := validateRate(fx); err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.BulkUpsert(ctx, []domain.FXRate{fx}); err != nil {
+		return nil, err
+	}
+	return &fx, nil
+}
+
+// @desc: import rates from CSV with the columns base,quote,rate,as_of (header row required)
+func (s *fxService) ImportCSV(ctx context.Context, r io.Reader) (int, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return 0, fmt.Errorf("reading header: %w", err)
+	}
+	cols := make(map[string]int, len(header))
+	for i, name := range header {
+		cols[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"base", "quote", "rate", "as_of"} {
+		if _, ok := cols[required]; !ok {
+			return 0, fmt.Errorf("missing column %q", required)
+		}
+	}
+
+	var rates []domain.FXRate
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return 0, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		rate, err := decimal.NewFromString(strings.TrimSpace(record[cols["rate"]]))
+		if err != nil {
+			return 0, fmt.Errorf("line %d: invalid rate: %w", line, err)
+		}
+		asOf, err := parseTimestamp(strings.TrimSpace(record[cols["as_of"]]))
+		if err != nil {
+			return 0, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		fx := domain.FXRate{
+			Base:   strings.ToUpper(strings.TrimSpace(record[cols["base"]])),
+			Quote:  strings.ToUpper(strings.TrimSpace(record[cols["quote"]])),
+			Rate:   rate,
+			AsOf:   asOf,
+			Source: FXSourceFile,
+		}
+		if err := validateRate(fx); err != nil {
+			return 0, fmt.Errorf("line %d: %w", line, err)
+		}
+		rates = append(rates, fx)
+	}
+
+	// all or nothing: a bad line anywhere stops the import before anything is stored
+	if err := s.repo.BulkUpsert(ctx, rates); err != nil {
+		return 0, err
+	}
+	return len(rates), nil
+}
+
+func (s *fxService) ListLatest(ctx context.Context) ([]domain.FXRate, error) {
+	return s.repo.ListLatest(ctx)
+}
+
+func validateRate(fx domain.FXRate) error {
+	if fx.Base == "" || fx.Quote == "" {
+		return errors.New("base and quote currency are required")
+	}
+	if fx.Base == fx.Quote {
+		return errors.New("base and quote currency must differ")
+	}
+	if !fx.Rate.IsPositive() {
+		return errors.New("rate must be positive")
+	}
+	return nil
+}
+
+// fxConverter converts amounts between currencies, rates are cached for the lifetime of one request
+type fxConverter struct {
+	repo  repository.FXRepository
+	cache map[string]decimal.Decimal
+}
+
+func newFXConverter(repo repository.FXRepository) *fxConverter {
+	return &fxConverter{repo: repo, cache: make(map[string]decimal.Decimal)}
+}
+
+// @desc: convert amount from one currency to another with the latest rate at or before at
+// An empty currency means "unknown" and is treated as already being in the target currency.
+func (c *fxConverter) Convert(ctx context.Context, amount decimal.Decimal, from, to string, at time.Time) (decimal.Decimal, error) {
+	rate, err := c.Rate(ctx, from, to, at)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	return amount.Mul(rate), nil
+}
+
+// @flow: same currency -> direct rate -> inverse rate -> cross through the pivot currency
+func (c *fxConverter) Rate(ctx context.Context, from, to string, at time.Time) (decimal.Decimal, error) {
+	from, to = strings.ToUpper(from), strings.ToUpper(to)
+	if from == "" || from == to {
+		return decimal.NewFromInt(1), nil
+	}
+
+	key := from + "/" + to + "@" + at.UTC().Format(time.RFC3339)
+	if rate, ok := c.cache[key]; ok {
+		return rate, nil
+	}
+
+	rate, err := c.lookup(ctx, from, to, at)
+	if errors.Is(err, ErrRateNotFound) && from != pivotCurrency && to != pivotCurrency {
+		var toPivot, fromPivot decimal.Decimal
+		if toPivot, err = c.lookup(ctx, from, pivotCurrency, at); err == nil {
+			if fromPivot, err = c.lookup(ctx, pivotCurrency, to, at); err == nil {
+				rate = toPivot.Mul(fromPivot)
+			}
+		}
+	}
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("%s to %s: %w", from, to, err)
+	}
+
+	c.cache[key] = rate
+	return rate, nil
+}
+
+func (c *fxConverter) lookup(ctx context.Context, from, to string, at time.Time) (decimal.Decimal, error) {
+	if c.repo == nil {
+		return decimal.Zero, ErrRateNotFound
+	}
+
+	direct, err := c.repo.GetLatestAt(ctx, from, to, at)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	if direct != nil {
+		return direct.Rate, nil
+	}
+
+	inverse, err := c.repo.GetLatestAt(ctx, to, from, at)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	if inverse != nil {
+		return decimal.NewFromInt(1).Div(inverse.Rate), nil
+	}
+	return decimal.Zero, ErrRateNotFound
+}
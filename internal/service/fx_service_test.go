@@ -0,0 +1,102 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MonalBarse/tradelog/internal/domain"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type MockFXRepo struct {
+	mock.Mock
+}
+
+func (m *MockFXRepo) BulkUpsert(ctx context.Context, rates []domain.FXRate) error {
+	return m.Called(ctx, rates).Error(0)
+}
+
+func (m *MockFXRepo) GetLatestAt(ctx context.Context, base, quote string, at time.Time) (*domain.FXRate, error) {
+	args := m.Called(ctx, base, quote, at)
+	rate, _ := args.Get(0).(*domain.FXRate)
+	return rate, args.Error(1)
+}
+
+func (m *MockFXRepo) ListLatest(ctx context.Context) ([]domain.FXRate, error) {
+	args := m.Called(ctx)
+	return args.Get(0).([]domain.FXRate), args.Error(1)
+}
+
+// fxRates answers GetLatestAt from a fixed table of "BASE/QUOTE" rates, unknown pairs return nil
+func fxRates(rates map[string]string) *MockFXRepo {
+	repo := new(MockFXRepo)
+	for pair, v := range rates {
+		base, quote := domain.SplitSymbol(pair)
+		repo.On("GetLatestAt", mock.Anything, base, quote, mock.Anything).Return(&domain.FXRate{Base: base, Quote: quote, Rate: d(v)}, nil)
+	}
+	repo.On("GetLatestAt", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil, nil)
+	return repo
+}
+
+func TestFXConverter_DirectInverseAndCross(t *testing.T) {
+	conv := newFXConverter(fxRates(map[string]string{"EUR/USD": "1.25", "USD/INR": "80"}))
+	ctx := context.Background()
+	now := time.Now()
+
+	rate, err := conv.Rate(ctx, "EUR", "USD", now)
+	require.NoError(t, err)
+	assert.True(t, d("1.25").Equal(rate))
+
+	rate, err = conv.Rate(ctx, "USD", "EUR", now)
+	require.NoError(t, err)
+	assert.True(t, d("0.8").Equal(rate))
+
+	rate, err = conv.Rate(ctx, "EUR", "INR", now)
+	require.NoError(t, err)
+	assert.True(t, d("100").Equal(rate)) // EUR -> USD -> INR
+
+	_, err = conv.Rate(ctx, "GBP", "INR", now)
+	assert.ErrorIs(t, err, ErrRateNotFound)
+}
+
+func TestImportFXCSV_AllOrNothing(t *testing.T) {
+	repo := new(MockFXRepo)
+	fx := NewFXService(repo)
+
+	_, err := fx.ImportCSV(context.Background(), strings.NewReader("base,quote,rate,as_of\nEUR,USD,1.1,2024-01-01\nEUR,EUR,1,2024-01-01\n"))
+	assert.ErrorContains(t, err, "line 3")
+	repo.AssertNotCalled(t, "BulkUpsert", mock.Anything, mock.Anything)
+}
+
+func TestGetPortfolio_ConvertsToBaseCurrency(t *testing.T) {
+	tradeRepo, userRepo := new(MockTradeRepo), new(MockUserRepo)
+	ctx := context.Background()
+
+	trades := []domain.Trade{
+		{ID: 1, Symbol: "RELIANCE/INR", Type: "BUY", Quantity: d("10"), Price: d("2400"), ExecutedAt: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{ID: 2, Symbol: "AAPL/USD", Type: "BUY", Quantity: d("2"), Price: d("150"), ExecutedAt: time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC)},
+		{ID: 3, Symbol: "SAP/EUR", Type: "BUY", Quantity: d("1"), Price: d("100"), ExecutedAt: time.Date(2024, 1, 4, 0, 0, 0, 0, time.UTC)},
+	}
+	tradeRepo.On("GetByUserID", ctx, uint(1)).Return(trades, nil)
+	userRepo.On("FindByID", ctx, uint(1)).Return(&domain.User{ID: 1, CostMethod: domain.CostMethodFIFO, BaseCurrency: "USD"}, nil)
+
+	service := NewTradeService(tradeRepo,
+		WithUserRepository(userRepo),
+		WithFXRepository(fxRates(map[string]string{"USD/INR": "80"})),
+		WithPriceProvider(NewStaticPriceProvider(map[string]decimal.Decimal{"RELIANCE/INR": d("2800"), "AAPL/USD": d("200"), "SAP/EUR": d("120")}), time.Hour),
+	)
+
+	portfolio, err := service.GetPortfolio(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, portfolio, 3)
+
+	summary := SummarizePortfolio(portfolio, "USD")
+	assert.True(t, d("600").Equal(summary.TotalCostBasis)) // 24000/80 + 300
+	assert.True(t, d("750").Equal(summary.TotalValue))     // 28000/80 + 400
+	assert.Equal(t, []string{"SAP/EUR"}, summary.Unconverted)
+}
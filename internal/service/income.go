@@ -0,0 +1,357 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/MonalBarse/tradelog/internal/domain"
+	"github.com/MonalBarse/tradelog/internal/repository"
+	"github.com/shopspring/decimal"
+)
+
+/*
+NOTE:
+	An income event is the record of what was paid, the effect on balances is
+	made by the rows it creates in the same transaction:
+	- cash dividends and interest: a DIVIDEND / INTEREST cash movement of the net amount
+	- stock dividends, staking rewards and airdrops: a BUY of the net units at
+	  their fair value (Price), marked with source "income", so the units are a
+	  lot with a cost basis like any other and a later SELL can close them.
+	  The BUY settles no cash.
+	Income is valued in the base currency at the rate of the day it was received;
+	in-kind income is worth its units times Price in the symbol's quote currency.
+*/
+
+var ErrIncomeTrade = errors.New("trade was created by an income event and cannot be changed")
+
+// IncomeInput carries a user recorded income event, Amount is the gross amount
+type IncomeInput struct {
+	AccountID      *uint // account the holding is in, nil for the default account
+	Type           string
+	Symbol         string
+	Currency       string // defaults to the quote currency of the symbol, in-kind income is always in the base asset
+	Amount         decimal.Decimal
+	WithholdingTax decimal.Decimal
+	Price          decimal.Decimal // in-kind only
+	Notes          string
+	OccurredAt     *time.Time // defaults to now
+}
+
+// SymbolIncome is the income of one holding in the base currency, with its yield on the cost of what is held now
+type SymbolIncome struct {
+	Symbol         string           `json:"symbol"` // the currency for interest on cash
+	Gross          decimal.Decimal  `json:"gross"`
+	WithholdingTax decimal.Decimal  `json:"withholding_tax"`
+	Net            decimal.Decimal  `json:"net"`
+	CostBasis      decimal.Decimal  `json:"cost_basis"`              // of the open position, zero when nothing is held
+	YieldOnCost    *decimal.Decimal `json:"yield_on_cost,omitempty"` // net / cost basis, nil without an open position
+	Events         int              `json:"events"`
+}
+
+// IncomeTypeTotal is the net income of one event type in the base currency
+type IncomeTypeTotal struct {
+	Type string          `json:"type"`
+	Net  decimal.Decimal `json:"net"`
+}
+
+// IncomeSummary totals income events in the base currency
+type IncomeSummary struct {
+	BaseCurrency        string            `json:"base_currency"`
+	Year                int               `json:"year,omitempty"`
+	BySymbol            []SymbolIncome    `json:"by_symbol"`
+	ByType              []IncomeTypeTotal `json:"by_type"`
+	TotalGross          decimal.Decimal   `json:"total_gross"`
+	TotalWithholdingTax decimal.Decimal   `json:"total_withholding_tax"`
+	TotalNet            decimal.Decimal   `json:"total_net"`
+	Unconverted         []string          `json:"unconverted,omitempty"` // currencies without an FX rate, left out of totals
+}
+
+// WithIncomeRepository enables income events
+func WithIncomeRepository(incomeRepo repository.IncomeRepository) TradeServiceOption {
+	return func(s *tradeService) {
+		s.incomeRepo = incomeRepo
+	}
+}
+
+// IsValidIncomeType reports whether incomeType is one of the supported income event types
+func IsValidIncomeType(incomeType string) bool {
+	switch incomeType {
+	case domain.IncomeCashDividend, domain.IncomeInterest, domain.IncomeStockDividend, domain.IncomeStaking, domain.IncomeAirdrop:
+		return true
+	}
+	return false
+}
+
+// @desc: record income received on a holding
+// @flow: validate -> lock user -> save event -> cash movement or income BUY -> link it to the event
+func (s *tradeService) RecordIncome(ctx context.Context, userID uint, input IncomeInput) (*domain.IncomeEvent, error) {
+	if s.incomeRepo == nil {
+		return nil, errors.New("income tracking is not available")
+	}
+	event, err := s.newIncomeEvent(ctx, userID, input)
+	if err != nil {
+		return nil, err
+	}
+	if !domain.IsInKindIncome(event.Type) && s.cashRepo == nil {
+		return nil, errors.New("cash income needs the cash ledger")
+	}
+
+	err = s.repo.WithUserLock(ctx, userID, func(ctx context.Context) error {
+		if err := s.incomeRepo.Create(ctx, event); err != nil {
+			return err
+		}
+
+		if !domain.IsInKindIncome(event.Type) {
+			movementType := domain.CashDividend
+			if event.Type == domain.IncomeInterest {
+				movementType = domain.CashInterest
+			}
+			movement := &domain.CashMovement{
+				UserID:     userID,
+				Type:       movementType,
+				Currency:   event.Currency,
+				Amount:     event.Net(),
+				Notes:      incomeNotes(event),
+				OccurredAt: event.OccurredAt,
+			}
+			if err := s.cashRepo.Create(ctx, movement); err != nil {
+				return err
+			}
+			event.CashMovementID = &movement.ID
+			return s.incomeRepo.Update(ctx, event)
+		}
+
+		trade := &domain.Trade{
+			UserID:     userID,
+			AccountID:  event.AccountID,
+			Symbol:     event.Symbol,
+			Type:       "BUY",
+			Price:      event.Price,
+			Quantity:   event.Net(),
+			Notes:      incomeNotes(event),
+			Source:     domain.TradeSourceIncome,
+			ExternalID: fmt.Sprintf("%s:%d", event.Type, event.ID),
+			ExecutedAt: event.OccurredAt,
+		}
+		if err := s.repo.Create(ctx, trade); err != nil {
+			return err
+		}
+		// income may be recorded late, replay so the lot lands where it belongs
+		history, err := s.repo.GetByUserID(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if err := s.refreshPositions(ctx, userID, history); err != nil {
+			return err
+		}
+		if err := s.recordRevision(ctx, trade.ID, userID, domain.RevisionCreate, "income event", nil, trade); err != nil {
+			return err
+		}
+		event.TradeID = &trade.ID
+		return s.incomeRepo.Update(ctx, event)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+func (s *tradeService) newIncomeEvent(ctx context.Context, userID uint, input IncomeInput) (*domain.IncomeEvent, error) {
+	incomeType := strings.ToUpper(strings.TrimSpace(input.Type))
+	if !IsValidIncomeType(incomeType) {
+		return nil, fmt.Errorf("unsupported income type %q", input.Type)
+	}
+	if !input.Amount.IsPositive() {
+		return nil, errors.New("amount must be positive")
+	}
+	if input.WithholdingTax.IsNegative() || input.WithholdingTax.GreaterThanOrEqual(input.Amount) {
+		return nil, errors.New("withholding tax must be at least zero and less than the amount")
+	}
+
+	if err := s.checkAccount(ctx, userID, input.AccountID); err != nil {
+		return nil, err
+	}
+
+	event := &domain.IncomeEvent{
+		UserID:         userID,
+		AccountID:      input.AccountID,
+		Type:           incomeType,
+		Currency:       strings.ToUpper(strings.TrimSpace(input.Currency)),
+		Amount:         input.Amount,
+		WithholdingTax: input.WithholdingTax,
+		Notes:          input.Notes,
+		OccurredAt:     time.Now(),
+	}
+	if input.OccurredAt != nil {
+		if input.OccurredAt.After(time.Now()) {
+			return nil, errors.New("occurred_at cannot be in the future")
+		}
+		event.OccurredAt = *input.OccurredAt
+	}
+
+	if input.Symbol != "" {
+		event.Symbol = domain.NormalizeSymbol(input.Symbol)
+		if s.instRepo != nil {
+			instrument, err := resolveInstrument(ctx, s.instRepo, input.Symbol)
+			if err != nil {
+				return nil, err
+			}
+			event.Symbol = instrument.Symbol
+		}
+	}
+	base, quote := domain.SplitSymbol(event.Symbol)
+
+	if domain.IsInKindIncome(incomeType) {
+		if base == "" || quote == "" {
+			return nil, errors.New("in-kind income needs a BASE/QUOTE symbol")
+		}
+		if event.Currency != "" && event.Currency != base {
+			return nil, fmt.Errorf("in-kind income is received in %s", base)
+		}
+		if input.Price.IsNegative() {
+			return nil, errors.New("price cannot be negative")
+		}
+		event.Currency = base
+		event.Price = input.Price
+		return event, nil
+	}
+
+	if !input.Price.IsZero() {
+		return nil, errors.New("price is only used for in-kind income")
+	}
+	if incomeType == domain.IncomeCashDividend && event.Symbol == "" {
+		return nil, errors.New("a cash dividend needs the symbol it is paid on")
+	}
+	if event.Currency == "" {
+		event.Currency = quote
+	}
+	if event.Currency == "" {
+		return nil, errors.New("currency is required")
+	}
+	return event, nil
+}
+
+// incomeNotes labels the cash movement or trade an income event creates
+func incomeNotes(event *domain.IncomeEvent) string {
+	label := strings.ToLower(strings.ReplaceAll(event.Type, "_", " "))
+	if event.Symbol != "" {
+		label += " " + event.Symbol
+	}
+	if event.WithholdingTax.IsPositive() {
+		label += fmt.Sprintf(" (gross %s, withheld %s)", event.Amount, event.WithholdingTax)
+	}
+	if event.Notes != "" {
+		label += ": " + event.Notes
+	}
+	return label
+}
+
+// @desc: income events of the user, oldest first
+func (s *tradeService) ListIncome(ctx context.Context, userID uint) ([]domain.IncomeEvent, error) {
+	if s.incomeRepo == nil {
+		return []domain.IncomeEvent{}, nil
+	}
+	events, err := s.incomeRepo.GetByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if events == nil {
+		events = []domain.IncomeEvent{}
+	}
+	return events, nil
+}
+
+// @desc: income per holding and type in the base currency, optionally for one calendar year
+// @flow: value every event at its date -> total per symbol and type -> yield on the cost of the open positions
+func (s *tradeService) GetIncomeSummary(ctx context.Context, userID uint, year int) (*IncomeSummary, error) {
+	events, err := s.ListIncome(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	settings, err := s.GetSettings(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &IncomeSummary{BaseCurrency: settings.BaseCurrency, Year: year, BySymbol: []SymbolIncome{}, ByType: []IncomeTypeTotal{}}
+	bySymbol := make(map[string]*SymbolIncome)
+	byType := make(map[string]decimal.Decimal)
+	unconverted := make(map[string]bool)
+	conv := newFXConverter(s.fxRepo)
+
+	for _, e := range events {
+		if year != 0 && e.OccurredAt.Year() != year {
+			continue
+		}
+		gross, withheld, currency := incomeValue(e)
+		rate, err := conv.Rate(ctx, currency, settings.BaseCurrency, e.OccurredAt)
+		if errors.Is(err, ErrRateNotFound) {
+			unconverted[currency] = true
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		gross, withheld = gross.Mul(rate), withheld.Mul(rate)
+
+		key := e.Symbol
+		if key == "" {
+			key = e.Currency
+		}
+		item, ok := bySymbol[key]
+		if !ok {
+			item = &SymbolIncome{Symbol: key}
+			bySymbol[key] = item
+		}
+		item.Gross = item.Gross.Add(gross)
+		item.WithholdingTax = item.WithholdingTax.Add(withheld)
+		item.Net = item.Gross.Sub(item.WithholdingTax)
+		item.Events++
+		byType[e.Type] = byType[e.Type].Add(gross.Sub(withheld))
+
+		summary.TotalGross = summary.TotalGross.Add(gross)
+		summary.TotalWithholdingTax = summary.TotalWithholdingTax.Add(withheld)
+	}
+	summary.TotalNet = summary.TotalGross.Sub(summary.TotalWithholdingTax)
+
+	holdings, err := s.GetPortfolio(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range holdings {
+		item, ok := bySymbol[h.Symbol]
+		if !ok || h.FXError != "" || !h.BaseCostBasis.IsPositive() {
+			continue
+		}
+		item.CostBasis = h.BaseCostBasis
+		yield := item.Net.Div(h.BaseCostBasis)
+		item.YieldOnCost = &yield
+	}
+
+	for _, item := range bySymbol {
+		summary.BySymbol = append(summary.BySymbol, *item)
+	}
+	sort.Slice(summary.BySymbol, func(i, j int) bool { return summary.BySymbol[i].Symbol < summary.BySymbol[j].Symbol })
+	for incomeType, net := range byType {
+		summary.ByType = append(summary.ByType, IncomeTypeTotal{Type: incomeType, Net: net})
+	}
+	sort.Slice(summary.ByType, func(i, j int) bool { return summary.ByType[i].Type < summary.ByType[j].Type })
+	for currency := range unconverted {
+		summary.Unconverted = append(summary.Unconverted, currency)
+	}
+	sort.Strings(summary.Unconverted)
+	return summary, nil
+}
+
+// incomeValue is the gross and withheld value of an event and the currency they are in
+func incomeValue(e domain.IncomeEvent) (gross, withheld decimal.Decimal, currency string) {
+	if !domain.IsInKindIncome(e.Type) {
+		return e.Amount, e.WithholdingTax, e.Currency
+	}
+	_, quote := domain.SplitSymbol(e.Symbol)
+	return e.Amount.Mul(e.Price), e.WithholdingTax.Mul(e.Price), quote
+}
@@ -0,0 +1,123 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/MonalBarse/tradelog/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type MockIncomeRepo struct {
+	mock.Mock
+}
+
+func (m *MockIncomeRepo) Create(ctx context.Context, event *domain.IncomeEvent) error {
+	return m.Called(ctx, event).Error(0)
+}
+
+func (m *MockIncomeRepo) Update(ctx context.Context, event *domain.IncomeEvent) error {
+	return m.Called(ctx, event).Error(0)
+}
+
+func (m *MockIncomeRepo) GetByUserID(ctx context.Context, userID uint) ([]domain.IncomeEvent, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).([]domain.IncomeEvent), args.Error(1)
+}
+
+func TestRecordIncome_StakingAddsLot(t *testing.T) {
+	repo := &memTradeRepo{}
+	mockIncome := new(MockIncomeRepo)
+	service := NewTradeService(repo, WithIncomeRepository(mockIncome))
+	ctx := context.Background()
+
+	mockIncome.On("Create", ctx, mock.Anything).Run(func(args mock.Arguments) {
+		args.Get(1).(*domain.IncomeEvent).ID = 7
+	}).Return(nil)
+	mockIncome.On("Update", ctx, mock.Anything).Return(nil)
+
+	event, err := service.RecordIncome(ctx, 1, IncomeInput{Type: "staking", Symbol: "eth-usd", Amount: d("0.55"), WithholdingTax: d("0.05"), Price: d("2000")})
+	require.NoError(t, err)
+	assert.Equal(t, "ETH", event.Currency)
+	require.NotNil(t, event.TradeID)
+
+	trade := repo.trades[0]
+	assert.Equal(t, domain.TradeSourceIncome, trade.Source)
+	assert.Equal(t, "STAKING:7", trade.ExternalID)
+	assert.True(t, d("0.5").Equal(trade.Quantity))
+
+	portfolio, err := service.GetPortfolio(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, portfolio, 1)
+	assert.True(t, d("1000").Equal(portfolio[0].CostBasis))
+
+	// the units are received, no cash is paid for them
+	assert.Empty(t, tradeCashEntries(trade))
+}
+
+func TestRecordIncome_CashDividendNetOfWithholding(t *testing.T) {
+	mockRepo := new(MockTradeRepo)
+	mockIncome := new(MockIncomeRepo)
+	mockCash := new(MockCashRepo)
+	service := NewTradeService(mockRepo, WithIncomeRepository(mockIncome), WithCashRepository(mockCash))
+	ctx := context.Background()
+
+	mockIncome.On("Create", ctx, mock.Anything).Return(nil)
+	mockIncome.On("Update", ctx, mock.Anything).Return(nil)
+	mockCash.On("Create", ctx, mock.MatchedBy(func(m *domain.CashMovement) bool {
+		return m.Type == domain.CashDividend && m.Currency == "USD" && m.Amount.Equal(d("8.5"))
+	})).Return(nil)
+
+	_, err := service.RecordIncome(ctx, 1, IncomeInput{Type: domain.IncomeCashDividend, Symbol: "AAPL/USD", Amount: d("10"), WithholdingTax: d("1.5")})
+	require.NoError(t, err)
+	mockCash.AssertExpectations(t)
+
+	_, err = service.RecordIncome(ctx, 1, IncomeInput{Type: domain.IncomeCashDividend, Currency: "USD", Amount: d("10")})
+	assert.ErrorContains(t, err, "symbol")
+	_, err = service.RecordIncome(ctx, 1, IncomeInput{Type: domain.IncomeAirdrop, Symbol: "UNI/USD", Currency: "USD", Amount: d("10")})
+	assert.ErrorContains(t, err, "received in UNI")
+	_, err = service.RecordIncome(ctx, 1, IncomeInput{Type: domain.IncomeInterest, Currency: "USD", Amount: d("10"), WithholdingTax: d("10")})
+	assert.Error(t, err)
+}
+
+func TestGetIncomeSummary_YieldAndTotalReturn(t *testing.T) {
+	mockRepo := new(MockTradeRepo)
+	mockIncome := new(MockIncomeRepo)
+	service := NewTradeService(mockRepo, WithIncomeRepository(mockIncome))
+	ctx := context.Background()
+
+	at := time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)
+	trades := []domain.Trade{
+		{ID: 1, Symbol: "AAPL/USD", Type: "BUY", Price: d("100"), Quantity: d("10"), ExecutedAt: at.AddDate(0, -1, 0)},
+		{ID: 2, Symbol: "ETH/USD", Type: "BUY", Price: d("2000"), Quantity: d("0.5"), Source: domain.TradeSourceIncome, ExecutedAt: at},
+	}
+	events := []domain.IncomeEvent{
+		{ID: 1, Type: domain.IncomeCashDividend, Symbol: "AAPL/USD", Currency: "USD", Amount: d("10"), WithholdingTax: d("1.5"), OccurredAt: at},
+		{ID: 2, Type: domain.IncomeStaking, Symbol: "ETH/USD", Currency: "ETH", Amount: d("0.5"), Price: d("2000"), OccurredAt: at},
+		{ID: 3, Type: domain.IncomeInterest, Currency: "USD", Amount: d("4"), OccurredAt: at.AddDate(-1, 0, 0)},
+	}
+	mockRepo.On("GetByUserID", ctx, uint(1)).Return(trades, nil)
+	mockIncome.On("GetByUserID", ctx, uint(1)).Return(events, nil)
+
+	summary, err := service.GetIncomeSummary(ctx, 1, 2024)
+	require.NoError(t, err)
+	require.Len(t, summary.BySymbol, 2)
+
+	aapl := summary.BySymbol[0]
+	assert.Equal(t, "AAPL/USD", aapl.Symbol)
+	assert.True(t, d("8.5").Equal(aapl.Net))
+	require.NotNil(t, aapl.YieldOnCost)
+	assert.True(t, d("0.0085").Equal(*aapl.YieldOnCost))
+	assert.True(t, d("1008.5").Equal(summary.TotalNet), summary.TotalNet.String()) // interest of 2023 left out
+	require.Len(t, summary.ByType, 2)
+	assert.Equal(t, domain.IncomeStaking, summary.ByType[1].Type)
+	assert.True(t, d("1000").Equal(summary.ByType[1].Net))
+
+	report, err := service.GetPnL(ctx, 1, nil, "")
+	require.NoError(t, err)
+	assert.True(t, d("1012.5").Equal(report.TotalIncome))
+	assert.True(t, report.TotalReturn.Equal(report.Total.Add(report.TotalIncome)))
+}
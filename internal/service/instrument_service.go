@@ -0,0 +1,330 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/MonalBarse/tradelog/internal/domain"
+	"github.com/MonalBarse/tradelog/internal/repository"
+	"github.com/shopspring/decimal"
+)
+
+/*
+NOTE:
+	The instrument registry is the single source of truth for symbols.
+	Trades are always stored with the canonical symbol of their instrument,
+	so "btc-usd", "BTCUSD" and "BTC/USD" all end up in the same position.
+	Because trades only hold the symbol, an instrument that has trades keeps
+	its symbol and can't be deleted: a renamed ticker is a new instrument plus
+	a SYMBOL_CHANGE corporate action, a delisted one is deactivated, which
+	still lets its positions be closed.
+*/
+
+var (
+	ErrInstrumentNotFound = errors.New("instrument not found")
+	ErrInstrumentExists   = errors.New("instrument already exists")
+	ErrInstrumentInUse    = errors.New("instrument is in use")
+)
+
+// InstrumentInput carries the admin supplied fields of an instrument
+type InstrumentInput struct {
+	Symbol      string
+	AssetClass  string
+	TickSize    decimal.Decimal
+	LotSize     decimal.Decimal
+	MinQuantity decimal.Decimal
+	Active      bool
+
+	MaxLeverage       decimal.Decimal
+	MaintenanceMargin decimal.Decimal
+
+	// futures and options only
+	Underlying string
+	Multiplier decimal.Decimal
+	Expiry     *time.Time
+	OptionType string
+	Strike     decimal.Decimal
+}
+
+type InstrumentService interface {
+	Create(ctx context.Context, input InstrumentInput) (*domain.Instrument, error)
+	Update(ctx context.Context, id uint, input InstrumentInput) (*domain.Instrument, error)
+	Delete(ctx context.Context, id uint) error
+	List(ctx context.Context) ([]domain.Instrument, error)
+}
+
+type instrumentService struct {
+	repo      repository.InstrumentRepository
+	tradeRepo repository.TradeRepository
+}
+
+func NewInstrumentService(repo repository.InstrumentRepository, tradeRepo repository.TradeRepository) InstrumentService {
+	return &instrumentService{repo, tradeRepo}
+}
+
+// IsValidAssetClass reports whether assetClass is one of the supported asset classes
+func IsValidAssetClass(assetClass string) bool {
+	switch assetClass {
+	case domain.AssetClassCrypto, domain.AssetClassEquity, domain.AssetClassFX, domain.AssetClassCommodity, domain.AssetClassETF,
+		domain.AssetClassFuture, domain.AssetClassOption:
+		return true
+	}
+	return false
+}
+
+// @desc: register a new instrument
+// @flow: normalize symbol -> validate -> make sure the symbol is free -> save
+func (s *instrumentService) Create(ctx context.Context, input InstrumentInput) (*domain.Instrument, error) {
+	instrument := &domain.Instrument{}
+	if err := applyInstrumentInput(instrument, input); err != nil {
+		return nil, err
+	}
+
+	existing, err := s.repo.GetBySymbol(ctx, instrument.Symbol)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, ErrInstrumentExists
+	}
+
+	if err := s.repo.Create(ctx, instrument); err != nil {
+		return nil, err
+	}
+	return instrument, nil
+}
+
+// @desc: replace the definition of an instrument, the symbol may only change to one that is free and only while it has no trades
+func (s *instrumentService) Update(ctx context.Context, id uint, input InstrumentInput) (*domain.Instrument, error) {
+	instrument, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if instrument == nil {
+		return nil, ErrInstrumentNotFound
+	}
+	updated := *instrument
+	if err := applyInstrumentInput(&updated, input); err != nil {
+		return nil, err
+	}
+
+	if updated.Symbol != instrument.Symbol {
+		trades, err := s.tradeRepo.CountBySymbol(ctx, instrument.Symbol)
+		if err != nil {
+			return nil, err
+		}
+		if trades > 0 {
+			return nil, fmt.Errorf("%w: %s has trades, register %s and record a SYMBOL_CHANGE corporate action instead", ErrInstrumentInUse, instrument.Symbol, updated.Symbol)
+		}
+	}
+	instrument = &updated
+
+	existing, err := s.repo.GetBySymbol(ctx, instrument.Symbol)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil && existing.ID != id {
+		return nil, ErrInstrumentExists
+	}
+
+	if err := s.repo.Update(ctx, instrument); err != nil {
+		return nil, err
+	}
+	return instrument, nil
+}
+
+// @desc: remove an instrument nothing refers to
+// @flow: load -> no trades of the symbol -> no future or option on it -> delete
+func (s *instrumentService) Delete(ctx context.Context, id uint) error {
+	instrument, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if instrument == nil {
+		return ErrInstrumentNotFound
+	}
+
+	trades, err := s.tradeRepo.CountBySymbol(ctx, instrument.Symbol)
+	if err != nil {
+		return err
+	}
+	if trades > 0 {
+		return fmt.Errorf("%w: %s has trades, deactivate it instead", ErrInstrumentInUse, instrument.Symbol)
+	}
+
+	instruments, err := s.repo.List(ctx)
+	if err != nil {
+		return err
+	}
+	for _, other := range instruments {
+		if other.Underlying == instrument.Symbol {
+			return fmt.Errorf("%w: %s is the underlying of %s", ErrInstrumentInUse, instrument.Symbol, other.Symbol)
+		}
+	}
+	return s.repo.Delete(ctx, id)
+}
+
+func (s *instrumentService) List(ctx context.Context) ([]domain.Instrument, error) {
+	return s.repo.List(ctx)
+}
+
+func applyInstrumentInput(instrument *domain.Instrument, input InstrumentInput) error {
+	symbol := domain.NormalizeSymbol(input.Symbol)
+	base, quote := domain.SplitSymbol(symbol)
+	if base == "" || quote == "" || strings.Contains(quote, "/") {
+		return fmt.Errorf("symbol %q must look like BASE/QUOTE", input.Symbol)
+	}
+
+	assetClass := strings.ToUpper(strings.TrimSpace(input.AssetClass))
+	if !IsValidAssetClass(assetClass) {
+		return fmt.Errorf("unsupported asset class %q", input.AssetClass)
+	}
+
+	for name, v := range map[string]decimal.Decimal{"tick size": input.TickSize, "lot size": input.LotSize, "min quantity": input.MinQuantity, "max leverage": input.MaxLeverage, "maintenance margin": input.MaintenanceMargin, "multiplier": input.Multiplier, "strike": input.Strike} {
+		if v.IsNegative() {
+			return fmt.Errorf("%s cannot be negative", name)
+		}
+	}
+	if input.MaxLeverage.IsPositive() && input.MaxLeverage.LessThan(decimal.NewFromInt(1)) {
+		return errors.New("max leverage must be at least 1")
+	}
+	if input.MaintenanceMargin.GreaterThanOrEqual(decimal.NewFromInt(1)) {
+		return errors.New("maintenance margin is a share of the position value and must be below 1")
+	}
+
+	if err := applyContractTerms(instrument, assetClass, quote, input); err != nil {
+		return err
+	}
+
+	instrument.Symbol = symbol
+	instrument.BaseAsset = base
+	instrument.QuoteAsset = quote
+	instrument.AssetClass = assetClass
+	instrument.TickSize = input.TickSize
+	instrument.LotSize = input.LotSize
+	instrument.MinQuantity = input.MinQuantity
+	instrument.Active = input.Active
+	instrument.MaxLeverage = input.MaxLeverage
+	instrument.MaintenanceMargin = input.MaintenanceMargin
+	return nil
+}
+
+// applyContractTerms validates and sets the terms of a future or option, spot instruments must leave them empty
+func applyContractTerms(instrument *domain.Instrument, assetClass, quote string, input InstrumentInput) error {
+	underlying := domain.NormalizeSymbol(input.Underlying)
+	optionType := strings.ToUpper(strings.TrimSpace(input.OptionType))
+
+	switch assetClass {
+	case domain.AssetClassFuture, domain.AssetClassOption:
+		if input.Expiry == nil {
+			return fmt.Errorf("%s instruments need an expiry", strings.ToLower(assetClass))
+		}
+		if underlying != "" {
+			base, underlyingQuote := domain.SplitSymbol(underlying)
+			if base == "" || underlyingQuote == "" {
+				return fmt.Errorf("underlying %q must look like BASE/QUOTE", input.Underlying)
+			}
+			if underlyingQuote != quote {
+				return fmt.Errorf("underlying %s must be quoted in %s like the contract", underlying, quote)
+			}
+		}
+	default:
+		if underlying != "" || input.Expiry != nil || optionType != "" || !input.Multiplier.IsZero() || !input.Strike.IsZero() {
+			return errors.New("underlying, multiplier, expiry, option type and strike are only for futures and options")
+		}
+	}
+
+	if assetClass == domain.AssetClassOption {
+		if underlying == "" {
+			return errors.New("options need an underlying")
+		}
+		if optionType != domain.OptionCall && optionType != domain.OptionPut {
+			return fmt.Errorf("option type must be CALL or PUT, got %q", input.OptionType)
+		}
+		if !input.Strike.IsPositive() {
+			return errors.New("options need a positive strike")
+		}
+	} else if optionType != "" || !input.Strike.IsZero() {
+		return errors.New("option type and strike are only for options")
+	}
+
+	instrument.Underlying = underlying
+	instrument.Multiplier = input.Multiplier
+	instrument.Expiry = input.Expiry
+	instrument.OptionType = optionType
+	instrument.Strike = input.Strike
+	return nil
+}
+
+// @desc: find the instrument a user typed symbol refers to
+// @flow: normalize -> exact canonical match -> separator-less match ("BTCUSD") -> must be active
+func resolveInstrument(ctx context.Context, repo repository.InstrumentRepository, raw string) (*domain.Instrument, error) {
+	instrument, err := lookupInstrument(ctx, repo, raw)
+	if err != nil {
+		return nil, err
+	}
+	if !instrument.Active {
+		return nil, fmt.Errorf("instrument %s is not active", instrument.Symbol)
+	}
+	return instrument, nil
+}
+
+// lookupInstrument finds the instrument of a user supplied symbol like resolveInstrument, inactive ones included
+func lookupInstrument(ctx context.Context, repo repository.InstrumentRepository, raw string) (*domain.Instrument, error) {
+	symbol := domain.NormalizeSymbol(raw)
+	instrument, err := repo.GetBySymbol(ctx, symbol)
+	if err != nil {
+		return nil, err
+	}
+
+	if instrument == nil && !strings.Contains(symbol, "/") {
+		instruments, err := repo.List(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for i := range instruments {
+			if instruments[i].BaseAsset+instruments[i].QuoteAsset == symbol {
+				instrument = &instruments[i]
+				break
+			}
+		}
+	}
+
+	if instrument == nil {
+		return nil, fmt.Errorf("unknown instrument %q", raw)
+	}
+	return instrument, nil
+}
+
+// @desc: check price and quantity against the tick size, lot size and minimum quantity of the instrument
+func validateAgainstInstrument(instrument *domain.Instrument, price, quantity decimal.Decimal) error {
+	if instrument.TickSize.IsPositive() && !price.Mod(instrument.TickSize).IsZero() {
+		return fmt.Errorf("price %s is not a multiple of the %s tick size %s", price, instrument.Symbol, instrument.TickSize)
+	}
+	if instrument.LotSize.IsPositive() && !quantity.Mod(instrument.LotSize).IsZero() {
+		return fmt.Errorf("quantity %s is not a multiple of the %s lot size %s", quantity, instrument.Symbol, instrument.LotSize)
+	}
+	if quantity.LessThan(instrument.MinQuantity) {
+		return fmt.Errorf("quantity %s is below the %s minimum of %s", quantity, instrument.Symbol, instrument.MinQuantity)
+	}
+	return nil
+}
+
+// @desc: futures and options can't be traded after they expired
+func validateExpiry(instrument *domain.Instrument, at time.Time) error {
+	if instrument.Expiry != nil && at.After(*instrument.Expiry) {
+		return fmt.Errorf("%s expired on %s", instrument.Symbol, instrument.Expiry.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// @desc: check the leverage of a trade against the maximum of the instrument
+func validateLeverage(instrument *domain.Instrument, leverage decimal.Decimal) error {
+	if instrument.MaxLeverage.IsPositive() && leverage.GreaterThan(instrument.MaxLeverage) {
+		return fmt.Errorf("leverage %s is above the %s maximum of %s", leverage, instrument.Symbol, instrument.MaxLeverage)
+	}
+	return nil
+}
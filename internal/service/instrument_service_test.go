@@ -0,0 +1,148 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/MonalBarse/tradelog/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type MockInstrumentRepo struct {
+	mock.Mock
+}
+
+func (m *MockInstrumentRepo) Create(ctx context.Context, instrument *domain.Instrument) error {
+	return m.Called(ctx, instrument).Error(0)
+}
+
+func (m *MockInstrumentRepo) Update(ctx context.Context, instrument *domain.Instrument) error {
+	return m.Called(ctx, instrument).Error(0)
+}
+
+func (m *MockInstrumentRepo) Delete(ctx context.Context, id uint) error {
+	return m.Called(ctx, id).Error(0)
+}
+
+func (m *MockInstrumentRepo) GetByID(ctx context.Context, id uint) (*domain.Instrument, error) {
+	args := m.Called(ctx, id)
+	instrument, _ := args.Get(0).(*domain.Instrument)
+	return instrument, args.Error(1)
+}
+
+func (m *MockInstrumentRepo) GetBySymbol(ctx context.Context, symbol string) (*domain.Instrument, error) {
+	args := m.Called(ctx, symbol)
+	instrument, _ := args.Get(0).(*domain.Instrument)
+	return instrument, args.Error(1)
+}
+
+func (m *MockInstrumentRepo) List(ctx context.Context) ([]domain.Instrument, error) {
+	args := m.Called(ctx)
+	return args.Get(0).([]domain.Instrument), args.Error(1)
+}
+
+func btcInstrument() *domain.Instrument {
+	return &domain.Instrument{
+		ID: 1, Symbol: "BTC/USD", BaseAsset: "BTC", QuoteAsset: "USD", AssetClass: domain.AssetClassCrypto,
+		TickSize: d("0.01"), LotSize: d("0.0001"), MinQuantity: d("0.001"), Active: true,
+	}
+}
+
+func TestCreateTrade_NormalizesSymbolThroughRegistry(t *testing.T) {
+	tradeRepo, instRepo := new(MockTradeRepo), new(MockInstrumentRepo)
+	service := NewTradeService(tradeRepo, WithInstrumentRepository(instRepo))
+	ctx := context.Background()
+
+	instRepo.On("GetBySymbol", ctx, "BTCUSD").Return(nil, nil)
+	instRepo.On("List", ctx).Return([]domain.Instrument{*btcInstrument()}, nil)
+	tradeRepo.On("Create", ctx, mock.MatchedBy(func(trade *domain.Trade) bool { return trade.Symbol == "BTC/USD" })).Return(nil)
+
+	err := service.CreateTrade(ctx, 1, TradeInput{Symbol: " btcusd", Type: "BUY", Price: d("65000.25"), Quantity: d("0.5")})
+	require.NoError(t, err)
+	tradeRepo.AssertExpectations(t)
+}
+
+func TestCreateTrade_ValidatesAgainstInstrument(t *testing.T) {
+	tradeRepo, instRepo := new(MockTradeRepo), new(MockInstrumentRepo)
+	service := NewTradeService(tradeRepo, WithInstrumentRepository(instRepo))
+	ctx := context.Background()
+
+	inactive := btcInstrument()
+	inactive.Symbol, inactive.Active = "ETH/USD", false
+	instRepo.On("GetBySymbol", ctx, "BTC/USD").Return(btcInstrument(), nil)
+	instRepo.On("GetBySymbol", ctx, "ETH/USD").Return(inactive, nil)
+	instRepo.On("GetBySymbol", ctx, "DOGE/USD").Return(nil, nil)
+
+	cases := map[string]TradeInput{
+		"not a multiple of the BTC/USD tick size": {Symbol: "btc-usd", Type: "BUY", Price: d("65000.001"), Quantity: d("1")},
+		"not a multiple of the BTC/USD lot size":  {Symbol: "BTC/USD", Type: "BUY", Price: d("65000"), Quantity: d("0.00015")},
+		"below the BTC/USD minimum":               {Symbol: "BTC/USD", Type: "BUY", Price: d("65000"), Quantity: d("0.0005")},
+		"instrument ETH/USD is not active":        {Symbol: "eth_usd", Type: "BUY", Price: d("3000"), Quantity: d("1")},
+		"unknown instrument":                      {Symbol: "DOGE/USD", Type: "BUY", Price: d("1"), Quantity: d("1")},
+	}
+	for want, input := range cases {
+		err := service.CreateTrade(ctx, 1, input)
+		assert.ErrorContains(t, err, want)
+	}
+	tradeRepo.AssertNotCalled(t, "Create", mock.Anything, mock.Anything)
+}
+
+func TestCreateInstrument_RejectsDuplicateSymbol(t *testing.T) {
+	repo := new(MockInstrumentRepo)
+	instruments := NewInstrumentService(repo, new(MockTradeRepo))
+	ctx := context.Background()
+
+	repo.On("GetBySymbol", ctx, "BTC/USD").Return(btcInstrument(), nil)
+
+	_, err := instruments.Create(ctx, InstrumentInput{Symbol: "btc-usd", AssetClass: "crypto", Active: true})
+	assert.ErrorIs(t, err, ErrInstrumentExists)
+}
+
+func TestCreateInstrument_ValidatesContractTerms(t *testing.T) {
+	instruments := NewInstrumentService(new(MockInstrumentRepo), new(MockTradeRepo))
+	ctx := context.Background()
+	expiry := time.Date(2024, time.June, 21, 20, 0, 0, 0, time.UTC)
+
+	_, err := instruments.Create(ctx, InstrumentInput{Symbol: "AAPL/USD", AssetClass: "EQUITY", Multiplier: d("100")})
+	assert.ErrorContains(t, err, "only for futures and options")
+	_, err = instruments.Create(ctx, InstrumentInput{Symbol: "ESZ4/USD", AssetClass: "FUTURE", Multiplier: d("50")})
+	assert.ErrorContains(t, err, "need an expiry")
+	_, err = instruments.Create(ctx, InstrumentInput{Symbol: "AAPL240621C200/USD", AssetClass: "OPTION", Underlying: "AAPL/USD", Expiry: &expiry, OptionType: "call"})
+	assert.ErrorContains(t, err, "positive strike")
+	_, err = instruments.Create(ctx, InstrumentInput{Symbol: "AAPL240621C200/USD", AssetClass: "OPTION", Underlying: "AAPL/EUR", Expiry: &expiry, OptionType: "CALL", Strike: d("200")})
+	assert.ErrorContains(t, err, "must be quoted in USD")
+}
+
+func TestInstrument_InUseKeepsItsSymbolAndIsNotDeleted(t *testing.T) {
+	repo, tradeRepo := new(MockInstrumentRepo), new(MockTradeRepo)
+	instruments := NewInstrumentService(repo, tradeRepo)
+	ctx := context.Background()
+	expiry := time.Date(2024, time.June, 28, 8, 0, 0, 0, time.UTC)
+
+	repo.On("GetByID", ctx, uint(1)).Return(btcInstrument(), nil)
+	repo.On("GetBySymbol", ctx, "BTC/USD").Return(btcInstrument(), nil)
+	repo.On("GetBySymbol", ctx, "XBT/USD").Return(nil, nil)
+	repo.On("Update", ctx, mock.Anything).Return(nil)
+	repo.On("Delete", ctx, uint(1)).Return(nil)
+	repo.On("List", ctx).Return([]domain.Instrument{*btcInstrument(), {ID: 2, Symbol: "BTCM24/USD", AssetClass: domain.AssetClassFuture, Underlying: "BTC/USD", Expiry: &expiry}}, nil)
+	tradeRepo.On("CountBySymbol", ctx, "BTC/USD").Return(int64(3), nil).Times(2)
+	tradeRepo.On("CountBySymbol", ctx, "BTC/USD").Return(int64(0), nil)
+
+	// deactivating keeps the symbol, its positions can still be closed
+	instrument, err := instruments.Update(ctx, 1, InstrumentInput{Symbol: "BTC/USD", AssetClass: "CRYPTO"})
+	require.NoError(t, err)
+	assert.False(t, instrument.Active)
+
+	assert.ErrorIs(t, instruments.Delete(ctx, 1), ErrInstrumentInUse)
+
+	_, err = instruments.Update(ctx, 1, InstrumentInput{Symbol: "XBT/USD", AssetClass: "CRYPTO", Active: true})
+	assert.ErrorIs(t, err, ErrInstrumentInUse)
+	assert.ErrorContains(t, err, "SYMBOL_CHANGE")
+
+	// without trades the future on it still holds the instrument
+	assert.ErrorContains(t, instruments.Delete(ctx, 1), "underlying of BTCM24/USD")
+	repo.AssertNotCalled(t, "Delete", ctx, uint(1))
+}
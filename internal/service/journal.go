@@ -0,0 +1,447 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MonalBarse/tradelog/internal/domain"
+	"github.com/shopspring/decimal"
+)
+
+/*
+NOTE:
+	The journal is built from the same lot matching as the portfolio, so every
+	SELL names the lots it closes with their cost, acquisition date and a
+	"trade-<id>" label, and Beancount / Ledger can check the books against us.
+	The gain posting is left without an amount, both tools fill it in as
+	proceeds minus cost. Fees follow fees.go: quote fees are in the lot cost or
+	taken off the proceeds, fees in another asset are an expense of that asset.
+	A SELL that goes short books the units it sold beyond the open lots as a
+	negative lot at the price it got, the BUY covering it names that lot again.
+	With the AVERAGE method lots are repriced when sold, Beancount's default
+	(strict) booking would not find them, so holding accounts are opened with
+	"NONE" booking there.
+	Trades of symbols without a quote currency have no cost currency and are
+	written as comments only, like the cash ledger ignores them.
+*/
+
+// Journal formats
+const (
+	JournalBeancount = "beancount"
+	JournalLedger    = "ledger"
+)
+
+// Accounts of the exported journal, holdings and cash get one sub account per asset
+const (
+	journalHoldings    = "Assets:Trading"
+	journalCash        = "Assets:Cash"
+	journalGains       = "Income:Trading:Gains"
+	journalTradeFees   = "Expenses:Trading:Fees"
+	journalDividends   = "Income:Dividends"
+	journalInterest    = "Income:Interest"
+	journalStaking     = "Income:Staking"
+	journalAirdrops    = "Income:Airdrops"
+	journalAccountFees = "Expenses:Fees"
+	journalBorrowFees  = "Expenses:Trading:BorrowFees"
+	journalTransfers   = "Equity:Transfers"
+)
+
+// IsValidJournalFormat reports whether format is one of the supported journal formats
+func IsValidJournalFormat(format string) bool {
+	return format == JournalBeancount || format == JournalLedger
+}
+
+type journalEntry struct {
+	at       time.Time
+	title    string
+	meta     [][2]string
+	postings []journalPosting
+	comment  string // entries with a comment only are written as a comment line
+}
+
+type journalPosting struct {
+	account   string
+	amount    decimal.Decimal
+	commodity string
+	elided    bool     // amount is left to the tool
+	cost      *lotCost // lot the units are added to or taken from
+	price     *decimal.Decimal
+	priceCcy  string
+}
+
+type lotCost struct {
+	unit     decimal.Decimal
+	currency string
+	date     time.Time
+	label    string
+}
+
+// @desc: write the user's trades, fees and cash movements as a Beancount or Ledger journal
+// @flow: load trades + cash movements -> match lots with the user's cost method -> build entries in time order -> render
+func (s *tradeService) ExportJournal(ctx context.Context, userID uint, format string, w io.Writer) error {
+	if !IsValidJournalFormat(format) {
+		return fmt.Errorf("unsupported journal format %q", format)
+	}
+	settings, err := s.GetSettings(ctx, userID)
+	if err != nil {
+		return err
+	}
+	trades, err := s.userTrades(ctx, userID)
+	if err != nil {
+		return err
+	}
+	var movements []domain.CashMovement
+	if s.cashRepo != nil {
+		if movements, err = s.cashRepo.GetByUserID(ctx, userID); err != nil {
+			return err
+		}
+	}
+
+	entries, err := journalEntries(trades, movements, settings.CostMethod)
+	if err != nil {
+		return err
+	}
+
+	out := bufio.NewWriter(w)
+	if format == JournalBeancount {
+		writeBeancount(out, entries, settings)
+	} else {
+		writeLedger(out, entries, userID)
+	}
+	return out.Flush()
+}
+
+// journalEntries turns trades and cash movements into balanced entries, sorted by time
+func journalEntries(trades []domain.Trade, movements []domain.CashMovement, method string) ([]journalEntry, error) {
+	book, err := matchLotsWithShorts(trades, method)
+	if err != nil {
+		return nil, err
+	}
+	closedBy := make(map[uint][]ClosedLot)
+	for _, closed := range book.closed {
+		closedBy[closed.CloseTradeID] = append(closedBy[closed.CloseTradeID], closed)
+	}
+
+	var entries []journalEntry
+	for _, m := range movements {
+		entries = append(entries, cashJournalEntry(m))
+	}
+	for _, t := range sortTrades(trades) {
+		entries = append(entries, tradeJournalEntry(t, closedBy[t.ID]))
+	}
+
+	// movements come first at the same instant, a deposit funds a BUY made in the same second
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].at.Before(entries[j].at) })
+	return entries, nil
+}
+
+func tradeJournalEntry(t domain.Trade, closed []ClosedLot) journalEntry {
+	base, quote := domain.SplitSymbol(t.Symbol)
+	entry := journalEntry{
+		at:    t.ExecutedAt,
+		title: fmt.Sprintf("%s %s %s @ %s %s", t.Type, t.Quantity, base, t.Price, quote),
+		meta:  [][2]string{{"trade_id", strconv.FormatUint(uint64(t.ID), 10)}, {"executed_at", t.ExecutedAt.UTC().Format(time.RFC3339)}},
+	}
+	if t.Notes != "" {
+		entry.meta = append(entry.meta, [2]string{"notes", t.Notes})
+	}
+	if t.ExternalID != "" {
+		entry.meta = append(entry.meta, [2]string{"external_id", t.Source + ":" + t.ExternalID})
+	}
+	if quote == "" {
+		entry.comment = fmt.Sprintf("trade %d (%s %s %s) skipped: the symbol has no quote currency", t.ID, t.Type, t.Quantity, t.Symbol)
+		return entry
+	}
+
+	holdings := journalHoldings + ":" + accountName(base)
+	quoteFees, _ := feeTotals(t)
+	price := t.Price.Mul(t.ContractMultiplier()) // per contract, like the lot cost
+
+	switch t.Type {
+	case "BUY":
+		// the same cost the lot engine gives the lot, so sells can name it exactly
+		qty := positionDelta(t)
+		cost := t.Notional().Add(quoteFees)
+		counter := journalCash + ":" + accountName(quote)
+		if t.Source == domain.TradeSourceIncome {
+			counter = incomeJournalAccount(t.ExternalID) // in-kind income is booked at its fair value
+		}
+		// short lots covered by this BUY come back first, the rest is a new lot
+		opened := qty
+		for _, lot := range closed {
+			entry.postings = append(entry.postings, journalPosting{
+				account: holdings, amount: lot.Quantity, commodity: base,
+				cost:  &lotCost{unit: lot.UnitCost, currency: quote, date: lot.AcquiredAt, label: lotLabel(lot.OpenTradeID)},
+				price: &price, priceCcy: quote,
+			})
+			opened = opened.Sub(lot.Quantity)
+		}
+		if opened.IsPositive() {
+			entry.postings = append(entry.postings, journalPosting{account: holdings, amount: opened, commodity: base, cost: &lotCost{unit: cost.Div(qty), currency: quote, date: t.ExecutedAt, label: lotLabel(t.ID)}, price: &price, priceCcy: quote})
+		}
+		entry.postings = append(entry.postings, journalPosting{account: counter, amount: cost.Neg(), commodity: quote})
+		if len(closed) > 0 {
+			entry.postings = append(entry.postings, journalPosting{account: journalGains, commodity: quote, elided: true})
+		}
+	case "SELL":
+		disposed := positionDelta(t).Neg()
+		proceeds := t.Notional().Sub(quoteFees)
+		shorted := disposed
+		for _, lot := range closed {
+			entry.postings = append(entry.postings, journalPosting{
+				account: holdings, amount: lot.Quantity.Neg(), commodity: base,
+				cost:  &lotCost{unit: lot.UnitCost, currency: quote, date: lot.AcquiredAt, label: lotLabel(lot.OpenTradeID)},
+				price: &price, priceCcy: quote,
+			})
+			shorted = shorted.Sub(lot.Quantity)
+		}
+		if shorted.IsPositive() {
+			entry.postings = append(entry.postings, journalPosting{account: holdings, amount: shorted.Neg(), commodity: base, cost: &lotCost{unit: proceeds.Div(disposed), currency: quote, date: t.ExecutedAt, label: lotLabel(t.ID)}, price: &price, priceCcy: quote})
+		}
+		entry.postings = append(entry.postings,
+			journalPosting{account: journalCash + ":" + accountName(quote), amount: proceeds, commodity: quote},
+			journalPosting{account: journalGains, commodity: quote, elided: true},
+		)
+	}
+
+	// fees in a third asset (e.g. BNB) are paid from that asset's cash account
+	for _, fee := range t.Fees {
+		if fee.Currency == "" || strings.EqualFold(fee.Currency, base) || strings.EqualFold(fee.Currency, quote) || fee.Amount.IsZero() {
+			continue
+		}
+		entry.postings = append(entry.postings,
+			journalPosting{account: journalTradeFees, amount: fee.Amount, commodity: fee.Currency},
+			journalPosting{account: journalCash + ":" + accountName(fee.Currency), amount: fee.Amount.Neg(), commodity: fee.Currency},
+		)
+	}
+	return entry
+}
+
+func cashJournalEntry(m domain.CashMovement) journalEntry {
+	counter := journalTransfers
+	switch m.Type {
+	case domain.CashDividend:
+		counter = journalDividends
+	case domain.CashInterest:
+		counter = journalInterest
+	case domain.CashFee:
+		counter = journalAccountFees
+	case domain.CashBorrowFee:
+		counter = journalBorrowFees
+	}
+
+	entry := journalEntry{
+		at:    m.OccurredAt,
+		title: strings.ToLower(m.Type),
+		meta:  [][2]string{{"movement_id", strconv.FormatUint(uint64(m.ID), 10)}},
+		postings: []journalPosting{
+			{account: journalCash + ":" + accountName(m.Currency), amount: m.Amount, commodity: m.Currency},
+			{account: counter, amount: m.Amount.Neg(), commodity: m.Currency},
+		},
+	}
+	if m.Notes != "" {
+		entry.meta = append(entry.meta, [2]string{"notes", m.Notes})
+	}
+	return entry
+}
+
+// incomeJournalAccount is the income account of an in-kind income BUY, its external id starts with the income type
+func incomeJournalAccount(externalID string) string {
+	incomeType, _, _ := strings.Cut(externalID, ":")
+	switch incomeType {
+	case domain.IncomeStaking:
+		return journalStaking
+	case domain.IncomeAirdrop:
+		return journalAirdrops
+	}
+	return journalDividends
+}
+
+func lotLabel(tradeID uint) string {
+	return "trade-" + strconv.FormatUint(uint64(tradeID), 10)
+}
+
+// accountName makes an asset usable as an account component: letters, digits and dashes, starting with a capital or digit
+func accountName(asset string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'A' && r <= 'Z', r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-':
+			return r
+		}
+		return '-'
+	}, strings.ToUpper(asset))
+	if name == "" || name[0] == '-' {
+		name = "X" + name
+	}
+	return name
+}
+
+// ---------- Beancount ----------
+
+// beancountCommodity fits Beancount's currency syntax: capital first, capitals, digits and '._- in between
+func beancountCommodity(asset string) string {
+	name := []rune(strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '\'', r == '.', r == '_', r == '-':
+			return r
+		}
+		return '-'
+	}, strings.ToUpper(asset)))
+	if len(name) == 0 || name[0] < 'A' || name[0] > 'Z' {
+		name = append([]rune("X"), name...)
+	}
+	if last := name[len(name)-1]; !(last >= 'A' && last <= 'Z') && !(last >= '0' && last <= '9') {
+		name = append(name, 'X')
+	}
+	if len(name) > 24 {
+		name = name[:24]
+	}
+	return string(name)
+}
+
+func beancountString(s string) string {
+	return strconv.Quote(strings.ReplaceAll(s, "\n", " "))
+}
+
+func writeBeancount(w *bufio.Writer, entries []journalEntry, settings *UserSettings) {
+	fmt.Fprintf(w, "; exported by tradelog, cost method %s\n", settings.CostMethod)
+	fmt.Fprintf(w, "option \"operating_currency\" %s\n\n", beancountString(beancountCommodity(settings.BaseCurrency)))
+
+	// commodities and accounts are declared on the day they are first used
+	commodities, accounts := firstUse(entries)
+	for _, c := range commodities {
+		fmt.Fprintf(w, "%s commodity %s\n", c.at.UTC().Format("2006-01-02"), beancountCommodity(c.name))
+	}
+	w.WriteString("\n")
+	for _, a := range accounts {
+		fmt.Fprintf(w, "%s open %s", a.at.UTC().Format("2006-01-02"), a.name)
+		if settings.CostMethod == domain.CostMethodAverage && strings.HasPrefix(a.name, journalHoldings+":") {
+			w.WriteString(` "NONE"`)
+		}
+		w.WriteString("\n")
+	}
+
+	for _, e := range entries {
+		w.WriteString("\n")
+		date := e.at.UTC().Format("2006-01-02")
+		if e.comment != "" {
+			fmt.Fprintf(w, "; %s %s\n", date, e.comment)
+			continue
+		}
+		fmt.Fprintf(w, "%s * %s\n", date, beancountString(e.title))
+		for _, m := range e.meta {
+			fmt.Fprintf(w, "  %s: %s\n", m[0], beancountString(m[1]))
+		}
+		for _, p := range e.postings {
+			if p.elided {
+				fmt.Fprintf(w, "  %s\n", p.account)
+				continue
+			}
+			fmt.Fprintf(w, "  %-28s %s %s", p.account, p.amount, beancountCommodity(p.commodity))
+			if p.cost != nil {
+				fmt.Fprintf(w, " {%s %s, %s, %s}", p.cost.unit, beancountCommodity(p.cost.currency), p.cost.date.UTC().Format("2006-01-02"), beancountString(p.cost.label))
+			}
+			if p.price != nil {
+				fmt.Fprintf(w, " @ %s %s", p.price, beancountCommodity(p.priceCcy))
+			}
+			w.WriteString("\n")
+		}
+	}
+}
+
+// ---------- Ledger ----------
+
+// ledgerCommodity quotes commodities Ledger would otherwise read as part of the amount
+func ledgerCommodity(asset string) string {
+	for _, r := range asset {
+		if !(r >= 'A' && r <= 'Z') && !(r >= 'a' && r <= 'z') {
+			return strconv.Quote(asset)
+		}
+	}
+	return asset
+}
+
+func writeLedger(w *bufio.Writer, entries []journalEntry, userID uint) {
+	fmt.Fprintf(w, "; exported by tradelog for user %d\n\n", userID)
+
+	commodities, accounts := firstUse(entries)
+	for _, c := range commodities {
+		fmt.Fprintf(w, "commodity %s\n", ledgerCommodity(c.name))
+	}
+	w.WriteString("\n")
+	for _, a := range accounts {
+		fmt.Fprintf(w, "account %s\n", a.name)
+	}
+
+	for _, e := range entries {
+		w.WriteString("\n")
+		date := e.at.UTC().Format("2006/01/02")
+		if e.comment != "" {
+			fmt.Fprintf(w, "; %s %s\n", date, e.comment)
+			continue
+		}
+		fmt.Fprintf(w, "%s * %s\n", date, strings.ReplaceAll(e.title, "\n", " "))
+		for _, m := range e.meta {
+			fmt.Fprintf(w, "    ; %s: %s\n", m[0], strings.ReplaceAll(m[1], "\n", " "))
+		}
+		for _, p := range e.postings {
+			if p.elided {
+				fmt.Fprintf(w, "    %s\n", p.account)
+				continue
+			}
+			fmt.Fprintf(w, "    %-28s  %s %s", p.account, p.amount, ledgerCommodity(p.commodity))
+			// no "@ price" next to a lot cost, Ledger would book the difference to its own gains account
+			if p.cost != nil {
+				fmt.Fprintf(w, " {%s %s} [%s] (%s)", p.cost.unit, ledgerCommodity(p.cost.currency), p.cost.date.UTC().Format("2006/01/02"), p.cost.label)
+			} else if p.price != nil {
+				fmt.Fprintf(w, " @ %s %s", p.price, ledgerCommodity(p.priceCcy))
+			}
+			w.WriteString("\n")
+		}
+	}
+}
+
+// ---------- declarations ----------
+
+type firstSeen struct {
+	name string
+	at   time.Time
+}
+
+// firstUse lists every commodity and account with the time it first appears, sorted by name
+func firstUse(entries []journalEntry) (commodities, accounts []firstSeen) {
+	seenCommodity := make(map[string]time.Time)
+	seenAccount := make(map[string]time.Time)
+	note := func(seen map[string]time.Time, name string, at time.Time) {
+		if first, ok := seen[name]; !ok || at.Before(first) {
+			seen[name] = at
+		}
+	}
+	for _, e := range entries {
+		for _, p := range e.postings {
+			note(seenAccount, p.account, e.at)
+			if p.commodity != "" {
+				note(seenCommodity, p.commodity, e.at)
+			}
+			if p.cost != nil {
+				note(seenCommodity, p.cost.currency, e.at)
+			}
+		}
+	}
+
+	list := func(seen map[string]time.Time) []firstSeen {
+		out := make([]firstSeen, 0, len(seen))
+		for name, at := range seen {
+			out = append(out, firstSeen{name, at})
+		}
+		sort.Slice(out, func(i, j int) bool { return out[i].name < out[j].name })
+		return out
+	}
+	return list(seenCommodity), list(seenAccount)
+}
@@ -0,0 +1,82 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/MonalBarse/tradelog/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func journalFixture() ([]domain.Trade, []domain.CashMovement) {
+	trades := lotHistory()
+	trades[2].Fees = []domain.TradeFee{{Type: domain.FeeTypeCommission, Amount: d("0.5"), Currency: "BNB"}}
+	movements := []domain.CashMovement{
+		{ID: 7, Type: domain.CashDeposit, Currency: "USD", Amount: d("5000"), OccurredAt: trades[0].ExecutedAt},
+	}
+	return trades, movements
+}
+
+func TestExportJournal_Beancount(t *testing.T) {
+	mockRepo := new(MockTradeRepo)
+	mockCash := new(MockCashRepo)
+	service := NewTradeService(mockRepo, WithCashRepository(mockCash))
+	ctx := context.Background()
+
+	trades, movements := journalFixture()
+	mockRepo.On("GetByUserID", ctx, uint(1)).Return(trades, nil)
+	mockCash.On("GetByUserID", ctx, uint(1)).Return(movements, nil)
+
+	var out bytes.Buffer
+	require.NoError(t, service.ExportJournal(ctx, 1, JournalBeancount, &out))
+	journal := out.String()
+
+	assert.Contains(t, journal, "2024-01-01 commodity BTC\n")
+	assert.Contains(t, journal, "2024-01-01 open Assets:Trading:BTC\n")
+	assert.Contains(t, journal, "2024-01-01 open Assets:Cash:BNB\n") // opened on the day of the SELL that first pays a fee in BNB
+	assert.Contains(t, journal, `Assets:Trading:BTC           10 BTC {100 USD, 2024-01-01, "trade-1"} @ 100 USD`)
+
+	// the SELL closes 10 of the first lot and 5 of the second (FIFO), the gain is left to Beancount
+	assert.Contains(t, journal, "2024-01-01 * \"SELL 15 BTC @ 300 USD\"\n"+
+		"  trade_id: \"3\"\n"+
+		"  executed_at: \"2024-01-01T02:00:00Z\"\n"+
+		"  Assets:Trading:BTC           -10 BTC {100 USD, 2024-01-01, \"trade-1\"} @ 300 USD\n"+
+		"  Assets:Trading:BTC           -5 BTC {200 USD, 2024-01-01, \"trade-2\"} @ 300 USD\n"+
+		"  Assets:Cash:USD              4500 USD\n"+
+		"  Income:Trading:Gains\n"+
+		"  Expenses:Trading:Fees        0.5 BNB\n"+
+		"  Assets:Cash:BNB              -0.5 BNB\n")
+
+	// the deposit comes before the BUY of the same instant
+	assert.Less(t, bytes.Index(out.Bytes(), []byte(`"deposit"`)), bytes.Index(out.Bytes(), []byte(`"BUY 10 BTC`)))
+}
+
+func TestExportJournal_LedgerAverageCost(t *testing.T) {
+	trades, _ := journalFixture()
+	entries, err := journalEntries(trades, nil, domain.CostMethodAverage)
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	w := bufio.NewWriter(&out)
+	writeLedger(w, entries, 1)
+	require.NoError(t, w.Flush())
+	journal := out.String()
+
+	assert.Contains(t, journal, "commodity BTC\n")
+	assert.Contains(t, journal, "2024/01/01 * BUY 10 BTC @ 100 USD\n")
+	// both lots are closed at the pool average of 150, no "@ price" next to a lot cost
+	assert.Contains(t, journal, "    Assets:Trading:BTC            -10 BTC {150 USD} [2024/01/01] (trade-1)\n")
+	assert.Contains(t, journal, "    Assets:Trading:BTC            -5 BTC {150 USD} [2024/01/01] (trade-2)\n")
+	assert.NotContains(t, journal, ") @ ")
+}
+
+func TestJournalNames(t *testing.T) {
+	assert.Equal(t, "X1INCH", beancountCommodity("1inch"))
+	assert.Equal(t, "BRK.B", beancountCommodity("BRK.B"))
+	assert.Equal(t, "BRK-B", accountName("BRK.B"))
+	assert.Equal(t, `"1INCH"`, ledgerCommodity("1INCH"))
+	assert.Equal(t, "BTC", ledgerCommodity("BTC"))
+}
@@ -0,0 +1,434 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/MonalBarse/tradelog/internal/domain"
+	"github.com/shopspring/decimal"
+)
+
+/*
+NOTE:
+	The lot engine replays a user's trades in execution order.
+	Every BUY opens a lot, every SELL closes quantity from the open lots of the
+	same symbol. Which lots get closed first depends on the cost method.
+	Everything that needs a cost basis (portfolio, P&L, taxes) reads from here.
+
+	With short selling allowed, a SELL beyond the open quantity opens a short
+	lot for the rest, and a BUY covers open short lots (by the same method,
+	SPECIFIC covers oldest first) before what is left opens a long lot. A short
+	lot keeps the proceeds of the SELL as its CostBasis, covering it is a
+	ClosedLot with Short set where CostBasis is what the BUY paid.
+	Futures and options trade in contracts, cost and proceeds are the notional
+	(quantity * price * multiplier) so a lot's UnitCost is per contract.
+	A trade on margin gives its lot the margin it posted, closing part of a lot
+	releases the same share of its margin.
+	Every account is a book of its own: a SELL only closes lots and a BUY only
+	covers shorts of the account it was made in, trades without an account
+	share the default one. The lots of all accounts together are the
+	consolidated view.
+	Trades are only stored once CreateTrade has checked them against the user's
+	setting, so reads always replay with shorts allowed.
+*/
+
+// Lot is the still-open remainder of a single BUY trade, or of a SELL for a short lot
+type Lot struct {
+	TradeID    uint            `json:"trade_id"`
+	AccountID  uint            `json:"account_id,omitempty"` // 0 for the default account
+	Symbol     string          `json:"symbol"`
+	Quantity   decimal.Decimal `json:"quantity"`
+	UnitCost   decimal.Decimal `json:"unit_cost"`
+	CostBasis  decimal.Decimal `json:"cost_basis"`
+	Margin     decimal.Decimal `json:"margin"` // still posted for the lot, zero for a cash trade
+	AcquiredAt time.Time       `json:"acquired_at"`
+}
+
+// ClosedLot records how much of a lot a SELL trade consumed and what it earned
+type ClosedLot struct {
+	Symbol       string          `json:"symbol"`
+	AccountID    uint            `json:"account_id,omitempty"`
+	OpenTradeID  uint            `json:"open_trade_id"`
+	CloseTradeID uint            `json:"close_trade_id"`
+	Quantity     decimal.Decimal `json:"quantity"`
+	UnitCost     decimal.Decimal `json:"unit_cost"`
+	CostBasis    decimal.Decimal `json:"cost_basis"`
+	ClosePrice   decimal.Decimal `json:"close_price"` // net of sell fees
+	Proceeds     decimal.Decimal `json:"proceeds"`    // net of sell fees
+	Fees         decimal.Decimal `json:"fees"`        // sell fees allocated to this lot, buy fees are in the cost basis
+	RealizedPnL  decimal.Decimal `json:"realized_pnl"`
+	AcquiredAt   time.Time       `json:"acquired_at"`
+	DisposedAt   time.Time       `json:"disposed_at"`
+	Short        bool            `json:"short,omitempty"` // a BUY covered a short, UnitCost and Proceeds are those of the SELL that opened it
+}
+
+type lotBook struct {
+	method     string
+	allowShort shortPolicy
+	open       map[string][]*Lot
+	short      map[string][]*Lot // open short lots, quantities and proceeds positive
+	closed     []ClosedLot
+	multiplier map[string]decimal.Decimal // contract size per symbol, of its latest trade
+}
+
+// IsValidCostMethod reports whether method is one of the supported lot matching methods
+func IsValidCostMethod(method string) bool {
+	switch method {
+	case domain.CostMethodFIFO, domain.CostMethodLIFO, domain.CostMethodAverage, domain.CostMethodSpecific:
+		return true
+	}
+	return false
+}
+
+// shortPolicy tells in which accounts a SELL beyond the open lots opens a short position
+type shortPolicy func(accountID uint) bool
+
+func shortNever(uint) bool  { return false }
+func shortAlways(uint) bool { return true }
+
+// @desc: replay trades and match every SELL against open lots, a SELL beyond them is an error
+// @flow: sort by execution time -> open lot on BUY -> close lots on SELL
+func matchLots(trades []domain.Trade, method string) (*lotBook, error) {
+	return replayLots(trades, method, shortNever)
+}
+
+// matchLotsWithShorts replays trades opening short lots where a SELL goes beyond the open lots
+func matchLotsWithShorts(trades []domain.Trade, method string) (*lotBook, error) {
+	return replayLots(trades, method, shortAlways)
+}
+
+func replayLots(trades []domain.Trade, method string, allowShort shortPolicy) (*lotBook, error) {
+	book := newLotBook(method, allowShort)
+	for _, t := range sortTrades(trades) {
+		if err := book.apply(t); err != nil {
+			return nil, err
+		}
+	}
+	return book, nil
+}
+
+func newLotBook(method string, allowShort shortPolicy) *lotBook {
+	return &lotBook{method: method, allowShort: allowShort, open: make(map[string][]*Lot), short: make(map[string][]*Lot), multiplier: make(map[string]decimal.Decimal)}
+}
+
+// sortTrades returns a copy of trades ordered by execution time (ID breaks ties, a trade not saved yet goes last)
+func sortTrades(trades []domain.Trade) []domain.Trade {
+	sorted := make([]domain.Trade, len(trades))
+	copy(sorted, trades)
+	sort.SliceStable(sorted, func(i, j int) bool { return tradeBefore(sorted[i], sorted[j]) })
+	return sorted
+}
+
+// tradeBefore is the replay order of sortTrades
+func tradeBefore(a, b domain.Trade) bool {
+	if a.ExecutedAt.Equal(b.ExecutedAt) {
+		return a.ID-1 < b.ID-1 // ID 0 wraps around to the largest value
+	}
+	return a.ExecutedAt.Before(b.ExecutedAt)
+}
+
+func (b *lotBook) apply(t domain.Trade) error {
+	b.multiplier[t.Symbol] = t.ContractMultiplier()
+	switch t.Type {
+	case "BUY":
+		// quote fees raise the cost, base fees shrink what was received
+		quoteFees, _ := feeTotals(t)
+		qty := positionDelta(t)
+		cost := t.Notional().Add(quoteFees)
+		remaining := b.cover(t, qty, cost, quoteFees)
+		if !remaining.IsPositive() {
+			return nil
+		}
+		b.open[t.Symbol] = append(b.open[t.Symbol], &Lot{
+			TradeID:    t.ID,
+			AccountID:  t.AccountKey(),
+			Symbol:     t.Symbol,
+			Quantity:   remaining,
+			UnitCost:   cost.Div(qty),
+			CostBasis:  cost.Mul(remaining).Div(qty),
+			Margin:     t.Margin.Mul(remaining).Div(qty),
+			AcquiredAt: t.ExecutedAt,
+		})
+	case "SELL":
+		return b.sell(t)
+	}
+	return nil
+}
+
+func (b *lotBook) sell(t domain.Trade) error {
+	lots := accountLots(b.open[t.Symbol], t.AccountKey())
+	available := openQuantity(lots)
+	disposed := positionDelta(t).Neg() // quantity sold plus fees paid in the base asset
+	if available.LessThan(disposed) && !b.allowShort(t.AccountKey()) {
+		return fmt.Errorf("%s sells %s %s but only %s is open", tradeLabel(t), disposed, t.Symbol, available)
+	}
+
+	quoteFees, _ := feeTotals(t)
+	sale := disposal{
+		trade:     t,
+		quantity:  disposed,
+		proceeds:  t.Notional().Sub(quoteFees),
+		quoteFees: quoteFees,
+	}
+
+	remaining := decimal.Min(available, disposed)
+	if b.method == domain.CostMethodSpecific {
+		for _, sel := range t.LotSelections {
+			lot := findLot(lots, sel.LotTradeID)
+			if lot == nil || lot.Quantity.LessThan(sel.Quantity) {
+				return fmt.Errorf("%s closes %s from lot %d which is not open", tradeLabel(t), sel.Quantity, sel.LotTradeID)
+			}
+			b.close(lot, sel.Quantity, sale)
+			remaining = remaining.Sub(sel.Quantity)
+		}
+	}
+
+	if b.method == domain.CostMethodAverage {
+		// every open lot is repriced to the pool average before closing
+		avg := averageCost(lots)
+		for _, lot := range lots {
+			lot.UnitCost = avg
+			lot.CostBasis = lot.Quantity.Mul(avg)
+		}
+	}
+
+	// whatever is left is matched by position: newest first for LIFO, oldest first otherwise
+	for i := range lots {
+		if !remaining.IsPositive() {
+			break
+		}
+		lot := lots[i]
+		if b.method == domain.CostMethodLIFO {
+			lot = lots[len(lots)-1-i]
+		}
+		qty := decimal.Min(lot.Quantity, remaining)
+		if qty.IsZero() {
+			continue
+		}
+		b.close(lot, qty, sale)
+		remaining = remaining.Sub(qty)
+	}
+	b.prune(b.open, t.Symbol)
+
+	if shortQty := disposed.Sub(available); shortQty.IsPositive() {
+		proceeds := sale.proceeds.Mul(shortQty).Div(sale.quantity)
+		b.short[t.Symbol] = append(b.short[t.Symbol], &Lot{
+			TradeID:    t.ID,
+			AccountID:  t.AccountKey(),
+			Symbol:     t.Symbol,
+			Quantity:   shortQty,
+			UnitCost:   sale.proceeds.Div(sale.quantity),
+			CostBasis:  proceeds,
+			Margin:     t.Margin.Mul(shortQty).Div(disposed),
+			AcquiredAt: t.ExecutedAt,
+		})
+	}
+	return nil
+}
+
+// cover closes open short lots with qty units a BUY received for cost, it returns the quantity left to open a long lot
+func (b *lotBook) cover(t domain.Trade, qty, cost, quoteFees decimal.Decimal) decimal.Decimal {
+	lots := accountLots(b.short[t.Symbol], t.AccountKey())
+	if len(lots) == 0 {
+		return qty
+	}
+	if b.method == domain.CostMethodAverage {
+		avg := averageCost(lots)
+		for _, lot := range lots {
+			lot.UnitCost = avg
+			lot.CostBasis = lot.Quantity.Mul(avg)
+		}
+	}
+
+	remaining := qty
+	for i := range lots {
+		if !remaining.IsPositive() {
+			break
+		}
+		lot := lots[i]
+		if b.method == domain.CostMethodLIFO {
+			lot = lots[len(lots)-1-i]
+		}
+		covered := decimal.Min(lot.Quantity, remaining)
+		if covered.IsZero() {
+			continue
+		}
+		proceeds := lot.CostBasis.Mul(covered).Div(lot.Quantity)
+		paid := cost.Mul(covered).Div(qty)
+		b.closed = append(b.closed, ClosedLot{
+			Symbol:       lot.Symbol,
+			AccountID:    lot.AccountID,
+			OpenTradeID:  lot.TradeID,
+			CloseTradeID: t.ID,
+			Quantity:     covered,
+			UnitCost:     lot.UnitCost,
+			CostBasis:    paid,
+			ClosePrice:   cost.Div(qty),
+			Proceeds:     proceeds,
+			Fees:         quoteFees.Mul(covered).Div(qty),
+			RealizedPnL:  proceeds.Sub(paid),
+			AcquiredAt:   lot.AcquiredAt,
+			DisposedAt:   t.ExecutedAt,
+			Short:        true,
+		})
+		lot.Margin = lot.Margin.Sub(lot.Margin.Mul(covered).Div(lot.Quantity))
+		lot.Quantity = lot.Quantity.Sub(covered)
+		lot.CostBasis = lot.CostBasis.Sub(proceeds)
+		remaining = remaining.Sub(covered)
+	}
+	b.prune(b.short, t.Symbol)
+	return remaining
+}
+
+// disposal is a SELL with its fees folded in, proceeds are shared by the closed lots pro rata
+type disposal struct {
+	trade     domain.Trade
+	quantity  decimal.Decimal
+	proceeds  decimal.Decimal
+	quoteFees decimal.Decimal
+}
+
+func (b *lotBook) close(lot *Lot, qty decimal.Decimal, sale disposal) {
+	// multiply before dividing so whole lots and whole sales stay exact
+	cost := lot.CostBasis.Mul(qty).Div(lot.Quantity)
+	proceeds := sale.proceeds.Mul(qty).Div(sale.quantity)
+	b.closed = append(b.closed, ClosedLot{
+		Symbol:       lot.Symbol,
+		AccountID:    lot.AccountID,
+		OpenTradeID:  lot.TradeID,
+		CloseTradeID: sale.trade.ID,
+		Quantity:     qty,
+		UnitCost:     lot.UnitCost,
+		CostBasis:    cost,
+		ClosePrice:   sale.proceeds.Div(sale.quantity),
+		Proceeds:     proceeds,
+		Fees:         sale.quoteFees.Mul(qty).Div(sale.quantity),
+		RealizedPnL:  proceeds.Sub(cost),
+		AcquiredAt:   lot.AcquiredAt,
+		DisposedAt:   sale.trade.ExecutedAt,
+	})
+	lot.Margin = lot.Margin.Sub(lot.Margin.Mul(qty).Div(lot.Quantity))
+	lot.Quantity = lot.Quantity.Sub(qty)
+	lot.CostBasis = lot.CostBasis.Sub(cost)
+}
+
+// prune drops fully closed lots so they don't show up as open
+func (b *lotBook) prune(open map[string][]*Lot, symbol string) {
+	var kept []*Lot
+	for _, lot := range open[symbol] {
+		if lot.Quantity.IsPositive() {
+			kept = append(kept, lot)
+		}
+	}
+	if len(kept) == 0 {
+		delete(open, symbol)
+		return
+	}
+	open[symbol] = kept
+}
+
+// holdings summarises the open lots per symbol and side, sorted by symbol with the long side first.
+// Within an account a symbol is either long or short, across accounts it can be both, then it has an item per side.
+// A short item has negative quantity and cost basis (the proceeds to give back)
+// so that value minus cost basis is its unrealized P&L like for a long one.
+func (b *lotBook) holdings() []PortfolioItem {
+	symbols := make([]string, 0, len(b.open)+len(b.short))
+	for symbol := range b.open {
+		symbols = append(symbols, symbol)
+	}
+	for symbol := range b.short {
+		if _, long := b.open[symbol]; !long {
+			symbols = append(symbols, symbol)
+		}
+	}
+	sort.Strings(symbols)
+
+	type side struct {
+		lots []*Lot
+		name string
+		sign decimal.Decimal
+	}
+	portfolio := make([]PortfolioItem, 0, len(symbols))
+	for _, symbol := range symbols {
+		for _, s := range []side{{b.open[symbol], PositionLong, decimal.NewFromInt(1)}, {b.short[symbol], PositionShort, decimal.NewFromInt(-1)}} {
+			if len(s.lots) > 0 {
+				portfolio = append(portfolio, b.holding(symbol, s.lots, s.name, s.sign))
+			}
+		}
+	}
+	return portfolio
+}
+
+// holding is the item of one side of a symbol, sign is -1 for the short side
+func (b *lotBook) holding(symbol string, lots []*Lot, side string, sign decimal.Decimal) PortfolioItem {
+	item := PortfolioItem{
+		Symbol:     symbol,
+		Side:       side,
+		Quantity:   openQuantity(lots).Mul(sign),
+		CostBasis:  openCost(lots).Mul(sign),
+		Value:      decimal.Zero, // valued at market by the service
+		Multiplier: b.multiplier[symbol],
+		Lots:       make([]Lot, 0, len(lots)),
+	}
+	item.AverageCost = averageCost(lots)
+	for _, lot := range lots {
+		open := *lot
+		open.Quantity = lot.Quantity.Mul(sign)
+		open.CostBasis = lot.CostBasis.Mul(sign)
+		item.Lots = append(item.Lots, open)
+	}
+	return item
+}
+
+// accountLots keeps the lots of one account, the book of a trade
+func accountLots(lots []*Lot, accountID uint) []*Lot {
+	var kept []*Lot
+	for _, lot := range lots {
+		if lot.AccountID == accountID {
+			kept = append(kept, lot)
+		}
+	}
+	return kept
+}
+
+func findLot(lots []*Lot, tradeID uint) *Lot {
+	for _, lot := range lots {
+		if lot.TradeID == tradeID {
+			return lot
+		}
+	}
+	return nil
+}
+
+func openQuantity(lots []*Lot) decimal.Decimal {
+	total := decimal.Zero
+	for _, lot := range lots {
+		total = total.Add(lot.Quantity)
+	}
+	return total
+}
+
+func openCost(lots []*Lot) decimal.Decimal {
+	total := decimal.Zero
+	for _, lot := range lots {
+		total = total.Add(lot.CostBasis)
+	}
+	return total
+}
+
+func averageCost(lots []*Lot) decimal.Decimal {
+	qty := openQuantity(lots)
+	if qty.IsZero() {
+		return decimal.Zero
+	}
+	return openCost(lots).Div(qty)
+}
+
+// tradeLabel names a trade in error messages
+func tradeLabel(t domain.Trade) string {
+	if t.ID == 0 {
+		return fmt.Sprintf("the new trade at %s", t.ExecutedAt.Format(time.RFC3339))
+	}
+	return fmt.Sprintf("trade %d", t.ID)
+}
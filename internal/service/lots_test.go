@@ -0,0 +1,140 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/MonalBarse/tradelog/internal/domain"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func d(v string) decimal.Decimal {
+	return decimal.RequireFromString(v)
+}
+
+// two buys at 100 and 200, then a partial sell at 300
+func lotHistory() []domain.Trade {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	return []domain.Trade{
+		{ID: 1, Symbol: "BTC/USD", Type: "BUY", Price: d("100"), Quantity: d("10"), ExecutedAt: start},
+		{ID: 2, Symbol: "BTC/USD", Type: "BUY", Price: d("200"), Quantity: d("10"), ExecutedAt: start.Add(time.Hour)},
+		{ID: 3, Symbol: "BTC/USD", Type: "SELL", Price: d("300"), Quantity: d("15"), ExecutedAt: start.Add(2 * time.Hour)},
+	}
+}
+
+func TestMatchLots_FIFO(t *testing.T) {
+	book, err := matchLots(lotHistory(), domain.CostMethodFIFO)
+	require.NoError(t, err)
+
+	holdings := book.holdings()
+	require.Len(t, holdings, 1)
+	assert.True(t, d("5").Equal(holdings[0].Quantity))
+	assert.True(t, d("1000").Equal(holdings[0].CostBasis)) // 5 left of the 200 lot
+	assert.Equal(t, uint(2), holdings[0].Lots[0].TradeID)
+
+	require.Len(t, book.closed, 2)
+	assert.True(t, d("2000").Equal(book.closed[0].RealizedPnL)) // 10 * (300 - 100)
+	assert.True(t, d("500").Equal(book.closed[1].RealizedPnL))  // 5 * (300 - 200)
+}
+
+func TestMatchLots_LIFO(t *testing.T) {
+	book, err := matchLots(lotHistory(), domain.CostMethodLIFO)
+	require.NoError(t, err)
+
+	holdings := book.holdings()
+	require.Len(t, holdings, 1)
+	assert.True(t, d("500").Equal(holdings[0].CostBasis)) // 5 left of the 100 lot
+	assert.Equal(t, uint(1), holdings[0].Lots[0].TradeID)
+}
+
+func TestMatchLots_Average(t *testing.T) {
+	book, err := matchLots(lotHistory(), domain.CostMethodAverage)
+	require.NoError(t, err)
+
+	holdings := book.holdings()
+	require.Len(t, holdings, 1)
+	assert.True(t, d("150").Equal(holdings[0].AverageCost))
+	assert.True(t, d("750").Equal(holdings[0].CostBasis))
+}
+
+func TestMatchLots_Specific(t *testing.T) {
+	trades := lotHistory()
+	trades[2].Quantity = d("4")
+	trades[2].LotSelections = []domain.LotSelection{{LotTradeID: 2, Quantity: d("4")}}
+
+	book, err := matchLots(trades, domain.CostMethodSpecific)
+	require.NoError(t, err)
+
+	require.Len(t, book.closed, 1)
+	assert.Equal(t, uint(2), book.closed[0].OpenTradeID)
+	assert.True(t, d("400").Equal(book.closed[0].RealizedPnL))
+}
+
+func TestMatchLots_Oversell(t *testing.T) {
+	trades := lotHistory()
+	trades[2].Quantity = d("25")
+
+	_, err := matchLots(trades, domain.CostMethodFIFO)
+	assert.Error(t, err)
+}
+
+func TestMatchLotsWithShorts_SellBeyondHoldingGoesShort(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	trades := []domain.Trade{
+		{ID: 1, Symbol: "TSLA/USD", Type: "BUY", Price: d("100"), Quantity: d("5"), ExecutedAt: start},
+		{ID: 2, Symbol: "TSLA/USD", Type: "SELL", Price: d("150"), Quantity: d("8"), ExecutedAt: start.Add(time.Hour)},
+		{ID: 3, Symbol: "TSLA/USD", Type: "BUY", Price: d("120"), Quantity: d("4"), ExecutedAt: start.Add(2 * time.Hour)},
+	}
+
+	book, err := matchLotsWithShorts(trades[:2], domain.CostMethodFIFO)
+	require.NoError(t, err)
+	holdings := book.holdings()
+	require.Len(t, holdings, 1)
+	assert.Equal(t, PositionShort, holdings[0].Side)
+	assert.True(t, d("-3").Equal(holdings[0].Quantity))
+	assert.True(t, d("-450").Equal(holdings[0].CostBasis))
+	assert.True(t, d("150").Equal(holdings[0].AverageCost))
+
+	book, err = matchLotsWithShorts(trades, domain.CostMethodFIFO)
+	require.NoError(t, err)
+	require.Len(t, book.closed, 2)
+	assert.True(t, d("250").Equal(book.closed[0].RealizedPnL)) // long lot: 5 * (150 - 100)
+	cover := book.closed[1]
+	assert.True(t, cover.Short)
+	assert.Equal(t, uint(2), cover.OpenTradeID)
+	assert.Equal(t, uint(3), cover.CloseTradeID)
+	assert.True(t, d("90").Equal(cover.RealizedPnL)) // 3 * (150 - 120)
+
+	// the unit left over after covering opens a long lot
+	holdings = book.holdings()
+	require.Len(t, holdings, 1)
+	assert.Equal(t, PositionLong, holdings[0].Side)
+	assert.True(t, d("1").Equal(holdings[0].Quantity))
+	assert.True(t, d("120").Equal(holdings[0].CostBasis))
+
+	// strict replay still refuses the same history
+	_, err = matchLots(trades, domain.CostMethodFIFO)
+	assert.Error(t, err)
+}
+
+func TestCreateTrade_LotSelectionMustCoverQuantity(t *testing.T) {
+	mockRepo := new(MockTradeRepo)
+	service := NewTradeService(mockRepo)
+	ctx := context.Background()
+
+	mockRepo.On("GetByUserID", ctx, uint(1)).Return(lotHistory()[:2], nil)
+
+	err := service.CreateTrade(ctx, 1, TradeInput{
+		Symbol:   "BTC/USD",
+		Type:     "SELL",
+		Price:    d("300"),
+		Quantity: d("5"),
+		Lots:     []domain.LotSelection{{LotTradeID: 1, Quantity: d("3")}},
+	})
+
+	assert.EqualError(t, err, "selected lots must add up to the trade quantity")
+	mockRepo.AssertNotCalled(t, "Create")
+}
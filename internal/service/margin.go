@@ -0,0 +1,135 @@
+package service
+
+import (
+	"context"
+
+	"github.com/shopspring/decimal"
+)
+
+/*
+NOTE:
+	A trade on margin posts its notional / Leverage as collateral, the rest
+	is borrowed. The lots it opens carry that margin (see lots.go), so the
+	margin used by a position is what its open lots still hold.
+	At the current price a leveraged position is checked like an exchange does,
+	everything in the quote currency of the symbol:
+	- equity      = margin used + unrealized P&L (value - cost basis)
+	- maintenance = |value| * maintenance margin of the instrument
+	- ratio       = maintenance / equity, the position is liquidated at 1
+	The liquidation price is where equity falls to the maintenance margin:
+	    (cost basis - margin used) / (quantity - |quantity| * maintenance margin)
+	which works for shorts too since their quantity and cost basis are negative.
+	Positions at or above the warning ratio (0.8 unless configured) are flagged.
+*/
+
+// Margin states of a leveraged position
+const (
+	MarginOK          = "OK"
+	MarginWarning     = "WARNING"     // ratio is at or above the warning ratio
+	MarginLiquidation = "LIQUIDATION" // equity no longer covers the maintenance margin
+	MarginUnknown     = "UNKNOWN"     // no price to check the position with
+)
+
+var defaultMarginWarningRatio = decimal.RequireFromString("0.8")
+
+// MarginStatus is the margin of a leveraged position at the current price, amounts in the quote currency
+type MarginStatus struct {
+	Used             decimal.Decimal  `json:"used"`     // posted by the open lots
+	Leverage         decimal.Decimal  `json:"leverage"` // |cost basis| / margin used
+	MaintenanceRate  decimal.Decimal  `json:"maintenance_rate"`
+	Maintenance      *decimal.Decimal `json:"maintenance,omitempty"` // required at the current price
+	Equity           *decimal.Decimal `json:"equity,omitempty"`      // margin used plus unrealized P&L
+	Ratio            *decimal.Decimal `json:"ratio,omitempty"`       // maintenance / equity, liquidation at 1
+	LiquidationPrice *decimal.Decimal `json:"liquidation_price,omitempty"`
+	Status           string           `json:"status"`
+}
+
+// WithMarginWarningRatio sets the margin ratio from which leveraged positions are flagged, zero keeps the default of 0.8
+func WithMarginWarningRatio(ratio decimal.Decimal) TradeServiceOption {
+	return func(s *tradeService) {
+		s.marginWarning = ratio
+	}
+}
+
+// tradeMargin is the collateral a trade posts, zero for a cash trade
+func tradeMargin(notional, leverage decimal.Decimal) decimal.Decimal {
+	if !leverage.GreaterThan(decimal.NewFromInt(1)) {
+		return decimal.Zero
+	}
+	return notional.Div(leverage)
+}
+
+// @desc: fill the margin status of every leveraged item, after the items were valued
+func (s *tradeService) addMarginStatus(ctx context.Context, items []PortfolioItem) error {
+	warnAt := s.marginWarning
+	if !warnAt.IsPositive() {
+		warnAt = defaultMarginWarningRatio
+	}
+	for i := range items {
+		used := decimal.Zero
+		for _, lot := range items[i].Lots {
+			used = used.Add(lot.Margin)
+		}
+		if !used.IsPositive() {
+			continue
+		}
+
+		rate := decimal.Zero
+		if s.instRepo != nil {
+			instrument, err := s.instRepo.GetBySymbol(ctx, items[i].Symbol)
+			if err != nil {
+				return err
+			}
+			if instrument != nil {
+				rate = instrument.MaintenanceMargin
+			}
+		}
+		items[i].Margin = marginStatus(items[i], used, rate, warnAt)
+	}
+	return nil
+}
+
+func marginStatus(item PortfolioItem, used, rate, warnAt decimal.Decimal) *MarginStatus {
+	status := &MarginStatus{
+		Used:            used,
+		Leverage:        item.CostBasis.Abs().Div(used),
+		MaintenanceRate: rate,
+		Status:          MarginUnknown,
+	}
+	multiplier := item.Multiplier
+	if !multiplier.IsPositive() { // spot
+		multiplier = decimal.NewFromInt(1)
+	}
+	if divisor := item.Quantity.Sub(item.Quantity.Abs().Mul(rate)).Mul(multiplier); !divisor.IsZero() {
+		if price := item.CostBasis.Sub(used).Div(divisor); price.IsPositive() {
+			status.LiquidationPrice = &price
+		}
+	}
+	if item.PriceAsOf == nil {
+		return status
+	}
+
+	maintenance := item.Value.Abs().Mul(rate)
+	equity := used.Add(item.Value.Sub(item.CostBasis))
+	status.Maintenance, status.Equity = &maintenance, &equity
+	if !equity.IsPositive() {
+		status.Status = MarginLiquidation
+		return status
+	}
+	ratio := maintenance.Div(equity)
+	status.Ratio = &ratio
+	switch {
+	case ratio.GreaterThanOrEqual(decimal.NewFromInt(1)):
+		status.Status = MarginLiquidation
+	case ratio.GreaterThanOrEqual(warnAt):
+		status.Status = MarginWarning
+	default:
+		status.Status = MarginOK
+	}
+	return status
+}
+
+// atRisk reports whether a position is flagged as close to or past liquidation
+func (m *MarginStatus) atRisk() bool {
+	return m != nil && (m.Status == MarginWarning || m.Status == MarginLiquidation)
+}
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateTrade_LeveragedBuyPostsMarginAndIsFlagged(t *testing.T) {
+	repo, instRepo := &memTradeRepo{}, new(MockInstrumentRepo)
+	instrument := btcInstrument()
+	instrument.MaxLeverage, instrument.MaintenanceMargin = d("10"), d("0.05")
+	ctx := context.Background()
+
+	instRepo.On("GetBySymbol", ctx, "BTC/USD").Return(instrument, nil)
+	prices := map[string]decimal.Decimal{"BTC/USD": d("51000")}
+	service := NewTradeService(repo,
+		WithInstrumentRepository(instRepo),
+		WithPriceProvider(NewStaticPriceProvider(prices), time.Hour),
+	)
+
+	buy := TradeInput{Symbol: "BTC/USD", Type: "BUY", Price: d("60000"), Quantity: d("1"), Leverage: d("20")}
+	assert.ErrorContains(t, service.CreateTrade(ctx, 1, buy), "above the BTC/USD maximum of 10")
+
+	buy.Leverage = d("5")
+	require.NoError(t, service.CreateTrade(ctx, 1, buy))
+	require.Len(t, repo.trades, 1)
+	assert.True(t, d("12000").Equal(repo.trades[0].Margin))
+
+	portfolio, err := service.GetPortfolio(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, portfolio, 1)
+	margin := portfolio[0].Margin
+	require.NotNil(t, margin)
+	assert.True(t, d("12000").Equal(margin.Used))
+	assert.True(t, d("5").Equal(margin.Leverage))
+	assert.True(t, d("3000").Equal(*margin.Equity)) // 12000 - (60000 - 51000)
+	assert.Equal(t, MarginWarning, margin.Status)   // 2550 / 3000 = 0.85
+	assert.Equal(t, []string{"BTC/USD"}, SummarizePortfolio(portfolio, "").AtRisk)
+}
+
+func TestMarginStatus_LiquidationPriceLongAndShort(t *testing.T) {
+	now := time.Now()
+	long := PortfolioItem{Symbol: "BTC/USD", Quantity: d("1"), CostBasis: d("60000"), Value: d("52000"), PriceAsOf: &now}
+	status := marginStatus(long, d("12000"), d("0.05"), defaultMarginWarningRatio)
+	assert.Equal(t, MarginOK, status.Status) // 2600 / 4000
+	assert.Equal(t, "50526.32", status.LiquidationPrice.StringFixed(2))
+
+	long.Value = d("50000")
+	assert.Equal(t, MarginLiquidation, marginStatus(long, d("12000"), d("0.05"), defaultMarginWarningRatio).Status)
+
+	short := PortfolioItem{Symbol: "BTC/USD", Side: PositionShort, Quantity: d("-1"), CostBasis: d("-60000"), Value: d("-65000"), PriceAsOf: &now}
+	status = marginStatus(short, d("12000"), d("0.05"), defaultMarginWarningRatio)
+	assert.True(t, d("7000").Equal(*status.Equity))
+	assert.Equal(t, "68571.43", status.LiquidationPrice.StringFixed(2))
+
+	future := PortfolioItem{Symbol: "ESZ4/USD", Quantity: d("1"), Multiplier: d("50"), CostBasis: d("5000"), Value: d("5000"), PriceAsOf: &now}
+	status = marginStatus(future, d("500"), d("0.05"), defaultMarginWarningRatio)
+	assert.Equal(t, "94.74", status.LiquidationPrice.StringFixed(2)) // (5000 - 500) / (0.95 * 50)
+
+	short.PriceAsOf = nil
+	assert.Equal(t, MarginUnknown, marginStatus(short, d("12000"), d("0.05"), defaultMarginWarningRatio).Status)
+	assert.False(t, (*MarginStatus)(nil).atRisk())
+}
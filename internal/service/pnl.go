@@ -0,0 +1,299 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/MonalBarse/tradelog/internal/domain"
+	"github.com/MonalBarse/tradelog/internal/repository"
+	"github.com/shopspring/decimal"
+)
+
+// Grouping periods for realized P&L totals
+const (
+	PeriodDay   = "day"
+	PeriodMonth = "month"
+)
+
+// Where the mark price of an open position came from
+const (
+	MarkSourceSupplied = "supplied" // passed with the request
+	MarkSourceStored   = "stored"   // saved earlier by the user
+	MarkSourceMarket   = "market"   // quoted by the price provider, or the user's own last fill when it has none
+)
+
+// PnLReport is the realized and unrealized profit and loss of a user
+// Per lot and per symbol figures are in the symbol's quote currency, report totals in the user's base currency.
+type PnLReport struct {
+	CostMethod      string               `json:"cost_method"`
+	BaseCurrency    string               `json:"base_currency"`
+	Realized        []ClosedLot          `json:"realized"`
+	Unrealized      []UnrealizedPosition `json:"unrealized"`
+	BySymbol        []SymbolPnL          `json:"by_symbol"`
+	ByPeriod        []PeriodPnL          `json:"by_period"`
+	TotalRealized   decimal.Decimal      `json:"total_realized"`
+	TotalUnrealized decimal.Decimal      `json:"total_unrealized"`
+	Total           decimal.Decimal      `json:"total"`
+	TotalIncome     decimal.Decimal      `json:"total_income"`          // dividends, interest and in-kind income net of withholding tax
+	TotalBorrowFees decimal.Decimal      `json:"total_borrow_fees"`     // charged on short positions
+	TotalReturn     decimal.Decimal      `json:"total_return"`          // total plus income minus borrow fees
+	Unconverted     []string             `json:"unconverted,omitempty"` // currencies without an FX rate, left out of totals
+}
+
+// UnrealizedPosition values an open position at its mark price
+type UnrealizedPosition struct {
+	Symbol        string          `json:"symbol"`
+	Quantity      decimal.Decimal `json:"quantity"`
+	CostBasis     decimal.Decimal `json:"cost_basis"`
+	MarkPrice     decimal.Decimal `json:"mark_price"`
+	MarkSource    string          `json:"mark_source"` // empty when no mark is known, the position is then left out of totals
+	MarketValue   decimal.Decimal `json:"market_value"`
+	UnrealizedPnL decimal.Decimal `json:"unrealized_pnl"`
+}
+
+// SymbolPnL is the realized plus unrealized result of a single symbol
+type SymbolPnL struct {
+	Symbol     string          `json:"symbol"`
+	Currency   string          `json:"currency"`
+	Realized   decimal.Decimal `json:"realized"`
+	Unrealized decimal.Decimal `json:"unrealized"`
+	Total      decimal.Decimal `json:"total"`
+}
+
+// PeriodPnL is the realized P&L of all disposals in a day ("2006-01-02") or month ("2006-01"), in the base currency
+type PeriodPnL struct {
+	Period   string          `json:"period"`
+	Realized decimal.Decimal `json:"realized"`
+}
+
+// WithMarkPriceRepository enables stored mark prices for unrealized P&L
+func WithMarkPriceRepository(markRepo repository.MarkPriceRepository) TradeServiceOption {
+	return func(s *tradeService) {
+		s.markRepo = markRepo
+	}
+}
+
+// @desc: realized P&L per closed lot and unrealized P&L per open position
+// @flow: match lots -> resolve marks (supplied, stored, then market) -> total per symbol and period
+func (s *tradeService) GetPnL(ctx context.Context, userID uint, marks map[string]decimal.Decimal, period string) (*PnLReport, error) {
+	if period == "" {
+		period = PeriodDay
+	}
+	if period != PeriodDay && period != PeriodMonth {
+		return nil, errors.New("period must be day or month")
+	}
+	overrides := make(map[string]decimal.Decimal, len(marks))
+	for raw, price := range marks {
+		symbol, err := s.canonicalSymbol(ctx, raw)
+		if err != nil {
+			return nil, err
+		}
+		overrides[symbol] = price
+	}
+	marks = overrides
+
+	trades, err := s.userTrades(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	settings, err := s.GetSettings(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	book, err := matchLotsWithShorts(trades, settings.CostMethod)
+	if err != nil {
+		return nil, err
+	}
+
+	fills := newLastFills(trades)
+	stored, err := s.storedMarks(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &PnLReport{
+		CostMethod:      settings.CostMethod,
+		BaseCurrency:    settings.BaseCurrency,
+		Realized:        book.closed,
+		Unrealized:      []UnrealizedPosition{},
+		TotalRealized:   decimal.Zero,
+		TotalUnrealized: decimal.Zero,
+	}
+	if report.Realized == nil {
+		report.Realized = []ClosedLot{}
+	}
+
+	symbols := make(map[string]*SymbolPnL)
+	symbolTotals := func(symbol string) *SymbolPnL {
+		if _, ok := symbols[symbol]; !ok {
+			_, currency := domain.SplitSymbol(symbol)
+			symbols[symbol] = &SymbolPnL{Symbol: symbol, Currency: currency, Realized: decimal.Zero, Unrealized: decimal.Zero}
+		}
+		return symbols[symbol]
+	}
+
+	conv := newFXConverter(s.fxRepo)
+	unconverted := make(map[string]bool)
+	toBase := func(amount decimal.Decimal, symbol string, at time.Time) (decimal.Decimal, bool) {
+		_, currency := domain.SplitSymbol(symbol)
+		converted, err := conv.Convert(ctx, amount, currency, settings.BaseCurrency, at)
+		if err != nil {
+			unconverted[currency] = true
+			return decimal.Zero, false
+		}
+		return converted, true
+	}
+
+	periods := make(map[string]decimal.Decimal)
+	layout := "2006-01-02"
+	if period == PeriodMonth {
+		layout = "2006-01"
+	}
+
+	for _, closed := range book.closed {
+		totals := symbolTotals(closed.Symbol)
+		totals.Realized = totals.Realized.Add(closed.RealizedPnL)
+
+		if realized, ok := toBase(closed.RealizedPnL, closed.Symbol, closed.DisposedAt); ok {
+			key := closed.DisposedAt.UTC().Format(layout)
+			periods[key] = periods[key].Add(realized)
+			report.TotalRealized = report.TotalRealized.Add(realized)
+		}
+	}
+
+	for _, item := range book.holdings() {
+		position := UnrealizedPosition{
+			Symbol:    item.Symbol,
+			Quantity:  item.Quantity,
+			CostBasis: item.CostBasis,
+		}
+
+		if price, ok := marks[item.Symbol]; ok {
+			position.MarkPrice, position.MarkSource = price, MarkSourceSupplied
+		} else if price, ok := stored[item.Symbol]; ok {
+			position.MarkPrice, position.MarkSource = price, MarkSourceStored
+		} else if s.prices != nil {
+			if quote, err := s.prices.GetQuote(ctx, item.Symbol); err == nil {
+				position.MarkPrice, position.MarkSource = quote.Price, MarkSourceMarket
+			} else if fill, ok := fills[item.Symbol]; ok {
+				position.MarkPrice, position.MarkSource = fill.Price, MarkSourceMarket
+			}
+		}
+
+		if position.MarkSource != "" {
+			position.MarketValue = item.Quantity.Mul(position.MarkPrice).Mul(item.Multiplier)
+			position.UnrealizedPnL = position.MarketValue.Sub(item.CostBasis)
+
+			totals := symbolTotals(item.Symbol)
+			totals.Unrealized = totals.Unrealized.Add(position.UnrealizedPnL)
+			if unrealized, ok := toBase(position.UnrealizedPnL, item.Symbol, time.Now()); ok {
+				report.TotalUnrealized = report.TotalUnrealized.Add(unrealized)
+			}
+		}
+
+		report.Unrealized = append(report.Unrealized, position)
+	}
+
+	report.BySymbol = make([]SymbolPnL, 0, len(symbols))
+	for _, totals := range symbols {
+		totals.Total = totals.Realized.Add(totals.Unrealized)
+		report.BySymbol = append(report.BySymbol, *totals)
+	}
+	sort.Slice(report.BySymbol, func(i, j int) bool { return report.BySymbol[i].Symbol < report.BySymbol[j].Symbol })
+
+	report.ByPeriod = make([]PeriodPnL, 0, len(periods))
+	for key, realized := range periods {
+		report.ByPeriod = append(report.ByPeriod, PeriodPnL{Period: key, Realized: realized})
+	}
+	sort.Slice(report.ByPeriod, func(i, j int) bool { return report.ByPeriod[i].Period < report.ByPeriod[j].Period })
+
+	// in-kind income is a lot at its fair value, its later gains are already in the P&L above
+	events, err := s.ListIncome(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range events {
+		gross, withheld, currency := incomeValue(e)
+		net, err := conv.Convert(ctx, gross.Sub(withheld), currency, settings.BaseCurrency, e.OccurredAt)
+		if err != nil {
+			unconverted[currency] = true
+			continue
+		}
+		report.TotalIncome = report.TotalIncome.Add(net)
+	}
+
+	fees, err := s.ListBorrowFees(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, fee := range fees {
+		charged, err := conv.Convert(ctx, fee.Amount, fee.Currency, settings.BaseCurrency, fee.ChargedAt)
+		if err != nil {
+			unconverted[fee.Currency] = true
+			continue
+		}
+		report.TotalBorrowFees = report.TotalBorrowFees.Add(charged)
+	}
+
+	for currency := range unconverted {
+		report.Unconverted = append(report.Unconverted, currency)
+	}
+	sort.Strings(report.Unconverted)
+
+	report.Total = report.TotalRealized.Add(report.TotalUnrealized)
+	report.TotalReturn = report.Total.Add(report.TotalIncome).Sub(report.TotalBorrowFees)
+	return report, nil
+}
+
+// @desc: save the price a user wants a symbol marked at
+func (s *tradeService) SetMarkPrice(ctx context.Context, userID uint, symbol string, price decimal.Decimal) error {
+	if s.markRepo == nil {
+		return errors.New("stored mark prices are not available")
+	}
+	if strings.TrimSpace(symbol) == "" {
+		return errors.New("symbol is required")
+	}
+	if !price.IsPositive() {
+		return errors.New("price must be positive")
+	}
+	symbol, err := s.canonicalSymbol(ctx, symbol)
+	if err != nil {
+		return err
+	}
+
+	return s.markRepo.Upsert(ctx, &domain.MarkPrice{UserID: userID, Symbol: symbol, Price: price})
+}
+
+// canonicalSymbol is the symbol trades are stored under for a user supplied one ("btc-usd" -> "BTC/USD"),
+// marks of delisted instruments still resolve
+func (s *tradeService) canonicalSymbol(ctx context.Context, raw string) (string, error) {
+	if s.instRepo == nil {
+		return domain.NormalizeSymbol(raw), nil
+	}
+	instrument, err := lookupInstrument(ctx, s.instRepo, raw)
+	if err != nil {
+		return "", err
+	}
+	return instrument.Symbol, nil
+}
+
+func (s *tradeService) storedMarks(ctx context.Context, userID uint) (map[string]decimal.Decimal, error) {
+	marks := make(map[string]decimal.Decimal)
+	if s.markRepo == nil {
+		return marks, nil
+	}
+
+	stored, err := s.markRepo.GetByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range stored {
+		marks[m.Symbol] = m.Price
+	}
+	return marks, nil
+}
@@ -0,0 +1,83 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/MonalBarse/tradelog/internal/domain"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type MockMarkPriceRepo struct {
+	mock.Mock
+}
+
+func (m *MockMarkPriceRepo) Upsert(ctx context.Context, mark *domain.MarkPrice) error {
+	return m.Called(ctx, mark).Error(0)
+}
+
+func (m *MockMarkPriceRepo) GetByUserID(ctx context.Context, userID uint) ([]domain.MarkPrice, error) {
+	args := m.Called(ctx, userID)
+	marks, _ := args.Get(0).([]domain.MarkPrice)
+	return marks, args.Error(1)
+}
+
+func TestGetPnL_RealizedAndUnrealized(t *testing.T) {
+	mockRepo := new(MockTradeRepo)
+	service := NewTradeService(mockRepo)
+	ctx := context.Background()
+
+	mockRepo.On("GetByUserID", ctx, uint(1)).Return(lotHistory(), nil)
+
+	report, err := service.GetPnL(ctx, 1, map[string]decimal.Decimal{"BTC/USD": d("250")}, PeriodMonth)
+	require.NoError(t, err)
+
+	assert.True(t, d("2500").Equal(report.TotalRealized))  // FIFO: 2000 + 500
+	assert.True(t, d("250").Equal(report.TotalUnrealized)) // 5 * 250 - 1000
+	assert.True(t, d("2750").Equal(report.Total))
+
+	require.Len(t, report.Unrealized, 1)
+	assert.Equal(t, MarkSourceSupplied, report.Unrealized[0].MarkSource)
+
+	require.Len(t, report.ByPeriod, 1)
+	assert.Equal(t, "2024-01", report.ByPeriod[0].Period)
+
+	require.Len(t, report.BySymbol, 1)
+	assert.True(t, d("2750").Equal(report.BySymbol[0].Total))
+}
+
+func TestGetPnL_UnmarkedPositionIsLeftOutOfTotals(t *testing.T) {
+	mockRepo := new(MockTradeRepo)
+	service := NewTradeService(mockRepo)
+	ctx := context.Background()
+
+	mockRepo.On("GetByUserID", ctx, uint(1)).Return(lotHistory(), nil)
+
+	report, err := service.GetPnL(ctx, 1, nil, "")
+	require.NoError(t, err)
+
+	assert.True(t, report.TotalUnrealized.IsZero())
+	assert.Equal(t, "", report.Unrealized[0].MarkSource)
+}
+
+func TestMarks_SymbolsAreNormalized(t *testing.T) {
+	mockRepo, markRepo := new(MockTradeRepo), new(MockMarkPriceRepo)
+	service := NewTradeService(mockRepo, WithMarkPriceRepository(markRepo))
+	ctx := context.Background()
+
+	mockRepo.On("GetByUserID", ctx, uint(1)).Return(lotHistory(), nil)
+	markRepo.On("GetByUserID", ctx, uint(1)).Return([]domain.MarkPrice{}, nil)
+	markRepo.On("Upsert", ctx, mock.MatchedBy(func(m *domain.MarkPrice) bool { return m.Symbol == "BTC/USD" })).Return(nil)
+
+	require.NoError(t, service.SetMarkPrice(ctx, 1, "btc-usd", d("250")))
+	markRepo.AssertCalled(t, "Upsert", ctx, mock.Anything)
+
+	report, err := service.GetPnL(ctx, 1, map[string]decimal.Decimal{"btc_usd": d("250")}, "")
+	require.NoError(t, err)
+	require.Len(t, report.Unrealized, 1)
+	assert.Equal(t, MarkSourceSupplied, report.Unrealized[0].MarkSource)
+	assert.True(t, d("250").Equal(report.TotalUnrealized))
+}
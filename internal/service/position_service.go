@@ -0,0 +1,240 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/MonalBarse/tradelog/internal/domain"
+	"github.com/MonalBarse/tradelog/internal/repository"
+	"github.com/shopspring/decimal"
+)
+
+/*
+NOTE:
+	The positions table is a cache of what replaying the trades gives, kept up to
+	date in the same transaction as every new trade. It answers "how much do I
+	hold" without loading the whole trade history.
+	Trades stay the source of truth: Rebuild recomputes the table from them and
+	Check reports rows that no longer match. Both read the trades adjusted for
+	corporate actions, like every other replay.
+*/
+
+// PositionDrift is a stored position that differs from what the trades say
+type PositionDrift struct {
+	UserID            uint            `json:"user_id"`
+	AccountID         uint            `json:"account_id"`
+	Symbol            string          `json:"symbol"`
+	StoredQuantity    decimal.Decimal `json:"stored_quantity"`
+	ExpectedQuantity  decimal.Decimal `json:"expected_quantity"`
+	StoredCostBasis   decimal.Decimal `json:"stored_cost_basis"`
+	ExpectedCostBasis decimal.Decimal `json:"expected_cost_basis"`
+}
+
+type PositionService interface {
+	Rebuild(ctx context.Context, userID uint) ([]domain.Position, error)
+	RebuildAll(ctx context.Context) (int, error)
+	Check(ctx context.Context) ([]PositionDrift, error)
+}
+
+type positionService struct {
+	repo       repository.PositionRepository
+	tradeRepo  repository.TradeRepository
+	actionRepo repository.CorporateActionRepository
+}
+
+// NewPositionService takes the corporate actions to replay trades with, actionRepo may be nil
+func NewPositionService(repo repository.PositionRepository, tradeRepo repository.TradeRepository, actionRepo repository.CorporateActionRepository) PositionService {
+	return &positionService{repo: repo, tradeRepo: tradeRepo, actionRepo: actionRepo}
+}
+
+func (s *positionService) corporateActions(ctx context.Context) ([]domain.CorporateAction, error) {
+	if s.actionRepo == nil {
+		return nil, nil
+	}
+	return s.actionRepo.List(ctx)
+}
+
+// @desc: recompute the positions of a user from their trades
+// @flow: lock user -> load trades -> replay -> replace stored positions
+func (s *positionService) Rebuild(ctx context.Context, userID uint) ([]domain.Position, error) {
+	var positions []domain.Position
+	err := s.tradeRepo.WithUserLock(ctx, userID, func(ctx context.Context) error {
+		trades, err := s.tradeRepo.GetByUserID(ctx, userID)
+		if err != nil {
+			return err
+		}
+		actions, err := s.corporateActions(ctx)
+		if err != nil {
+			return err
+		}
+		positions = buildPositions(userID, applyCorporateActions(trades, actions, time.Now()))
+		return s.repo.ReplaceForUser(ctx, userID, positions)
+	})
+	return positions, err
+}
+
+// @desc: rebuild the positions of every user that has trades, returns the number of users
+func (s *positionService) RebuildAll(ctx context.Context) (int, error) {
+	trades, err := s.tradeRepo.GetAll(ctx)
+	if err != nil {
+		return 0, err
+	}
+	users := userIDs(trades)
+	for _, userID := range users {
+		if _, err := s.Rebuild(ctx, userID); err != nil {
+			return 0, fmt.Errorf("user %d: %w", userID, err)
+		}
+	}
+	return len(users), nil
+}
+
+// @desc: compare every stored position with a replay of the trades, missing rows count as zero
+func (s *positionService) Check(ctx context.Context) ([]PositionDrift, error) {
+	trades, err := s.tradeRepo.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stored, err := s.repo.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	actions, err := s.corporateActions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	trades = applyCorporateActions(trades, actions, time.Now())
+
+	byUser := make(map[uint][]domain.Trade)
+	for _, t := range trades {
+		byUser[t.UserID] = append(byUser[t.UserID], t)
+	}
+
+	type key struct {
+		userID    uint
+		accountID uint
+		symbol    string
+	}
+	expected := make(map[key]domain.Position)
+	for userID, userTrades := range byUser {
+		for _, p := range buildPositions(userID, userTrades) {
+			expected[key{userID, p.AccountID, p.Symbol}] = p
+		}
+	}
+	actual := make(map[key]domain.Position, len(stored))
+	for _, p := range stored {
+		actual[key{p.UserID, p.AccountID, p.Symbol}] = p
+	}
+
+	keys := make(map[key]bool, len(expected)+len(actual))
+	for k := range expected {
+		keys[k] = true
+	}
+	for k := range actual {
+		keys[k] = true
+	}
+
+	drifts := []PositionDrift{}
+	for k := range keys {
+		want, have := expected[k], actual[k]
+		if want.Quantity.Equal(have.Quantity) && want.CostBasis.Equal(have.CostBasis) {
+			continue
+		}
+		drifts = append(drifts, PositionDrift{
+			UserID:            k.userID,
+			AccountID:         k.accountID,
+			Symbol:            k.symbol,
+			StoredQuantity:    have.Quantity,
+			ExpectedQuantity:  want.Quantity,
+			StoredCostBasis:   have.CostBasis,
+			ExpectedCostBasis: want.CostBasis,
+		})
+	}
+	sort.Slice(drifts, func(i, j int) bool {
+		if drifts[i].UserID == drifts[j].UserID {
+			if drifts[i].Symbol == drifts[j].Symbol {
+				return drifts[i].AccountID < drifts[j].AccountID
+			}
+			return drifts[i].Symbol < drifts[j].Symbol
+		}
+		return drifts[i].UserID < drifts[j].UserID
+	})
+	return drifts, nil
+}
+
+// buildPositions replays a user's trades into one position per account and symbol ever traded
+func buildPositions(userID uint, trades []domain.Trade) []domain.Position {
+	type key struct {
+		accountID uint
+		symbol    string
+	}
+	byKey := make(map[key]*domain.Position)
+	for _, t := range sortTrades(trades) {
+		k := key{t.AccountKey(), t.Symbol}
+		p, ok := byKey[k]
+		if !ok {
+			p = &domain.Position{UserID: userID, AccountID: k.accountID, Symbol: t.Symbol}
+			byKey[k] = p
+		}
+		applyToPosition(p, t)
+	}
+
+	positions := make([]domain.Position, 0, len(byKey))
+	for _, p := range byKey {
+		positions = append(positions, *p)
+	}
+	sort.Slice(positions, func(i, j int) bool {
+		if positions[i].Symbol == positions[j].Symbol {
+			return positions[i].AccountID < positions[j].AccountID
+		}
+		return positions[i].Symbol < positions[j].Symbol
+	})
+	return positions
+}
+
+// @desc: fold one trade into a position, trades that add to it add their amount, trades that reduce it remove cost in proportion (average cost).
+// A short position has negative quantity and cost basis, its cost is the proceeds of the SELLs that opened it.
+// Whether a SELL may go short was decided when the trade was stored, the table follows the trades.
+func applyToPosition(p *domain.Position, t domain.Trade) {
+	delta := positionDelta(t)
+	amount := t.Notional() // paid by a BUY, received by a SELL, net of quote fees below
+	quoteFees, _ := feeTotals(t)
+	if t.Type == "BUY" {
+		amount = amount.Add(quoteFees).Neg()
+	} else {
+		amount = amount.Sub(quoteFees)
+	}
+
+	// the part of the trade that reduces the position, the rest opens or adds to one on the other side
+	reduced := decimal.Zero
+	if p.Quantity.Sign()*delta.Sign() < 0 {
+		reduced = decimal.Min(p.Quantity.Abs(), delta.Abs())
+		// multiply before dividing so closing everything leaves exactly zero
+		p.CostBasis = p.CostBasis.Sub(p.CostBasis.Mul(reduced).Div(p.Quantity.Abs()))
+	}
+	if rest := delta.Abs().Sub(reduced); rest.IsPositive() {
+		p.CostBasis = p.CostBasis.Sub(amount.Mul(rest).Div(delta.Abs()))
+	}
+
+	p.Quantity = p.Quantity.Add(delta)
+	if p.Quantity.IsZero() {
+		p.CostBasis = decimal.Zero
+	}
+	p.TradeCount++
+	p.LastTradeID = t.ID
+	p.UpdatedAt = time.Now()
+}
+
+func userIDs(trades []domain.Trade) []uint {
+	seen := make(map[uint]bool)
+	var ids []uint
+	for _, t := range trades {
+		if !seen[t.UserID] {
+			seen[t.UserID] = true
+			ids = append(ids, t.UserID)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
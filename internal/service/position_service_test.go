@@ -0,0 +1,113 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/MonalBarse/tradelog/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type MockPositionRepo struct {
+	mock.Mock
+}
+
+func (m *MockPositionRepo) Get(ctx context.Context, userID, accountID uint, symbol string) (*domain.Position, error) {
+	args := m.Called(ctx, userID, accountID, symbol)
+	position, _ := args.Get(0).(*domain.Position)
+	return position, args.Error(1)
+}
+
+func (m *MockPositionRepo) GetByUserID(ctx context.Context, userID uint) ([]domain.Position, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).([]domain.Position), args.Error(1)
+}
+
+func (m *MockPositionRepo) GetAll(ctx context.Context) ([]domain.Position, error) {
+	args := m.Called(ctx)
+	return args.Get(0).([]domain.Position), args.Error(1)
+}
+
+func (m *MockPositionRepo) Upsert(ctx context.Context, position *domain.Position) error {
+	return m.Called(ctx, position).Error(0)
+}
+
+func (m *MockPositionRepo) ReplaceForUser(ctx context.Context, userID uint, positions []domain.Position) error {
+	return m.Called(ctx, userID, positions).Error(0)
+}
+
+func TestBuildPositions_AverageCost(t *testing.T) {
+	positions := buildPositions(1, lotHistory())
+	require.Len(t, positions, 1)
+
+	assert.True(t, d("5").Equal(positions[0].Quantity))
+	assert.True(t, d("750").Equal(positions[0].CostBasis)) // 3000 - 3000 * 15 / 20
+	assert.Equal(t, 3, positions[0].TradeCount)
+	assert.Equal(t, uint(3), positions[0].LastTradeID)
+}
+
+func TestBuildPositions_ShortAndCover(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	trades := []domain.Trade{
+		{ID: 1, Symbol: "TSLA/USD", Type: "BUY", Price: d("100"), Quantity: d("5"), ExecutedAt: start},
+		{ID: 2, Symbol: "TSLA/USD", Type: "SELL", Price: d("150"), Quantity: d("8"), ExecutedAt: start.Add(time.Hour)},
+	}
+	positions := buildPositions(1, trades)
+	require.Len(t, positions, 1)
+	assert.True(t, d("-3").Equal(positions[0].Quantity))
+	assert.True(t, d("-450").Equal(positions[0].CostBasis)) // proceeds of the 3 sold short
+
+	trades = append(trades, domain.Trade{ID: 3, Symbol: "TSLA/USD", Type: "BUY", Price: d("120"), Quantity: d("4"), ExecutedAt: start.Add(2 * time.Hour)})
+	positions = buildPositions(1, trades)
+	assert.True(t, d("1").Equal(positions[0].Quantity))
+	assert.True(t, d("120").Equal(positions[0].CostBasis))
+}
+
+func TestCreateTrade_UpdatesStoredPosition(t *testing.T) {
+	tradeRepo, posRepo := new(MockTradeRepo), new(MockPositionRepo)
+	service := NewTradeService(tradeRepo, WithPositionRepository(posRepo))
+	ctx := context.Background()
+
+	held := &domain.Position{UserID: 1, Symbol: "BTC/USD", Quantity: d("5"), CostBasis: d("750"), TradeCount: 3}
+	posRepo.On("Get", ctx, uint(1), uint(0), "BTC/USD").Return(held, nil)
+	tradeRepo.On("Create", ctx, mock.Anything).Return(nil)
+	posRepo.On("Upsert", ctx, mock.MatchedBy(func(p *domain.Position) bool {
+		return p.Quantity.Equal(d("3")) && p.CostBasis.Equal(d("450")) && p.TradeCount == 4
+	})).Return(nil)
+
+	err := service.CreateTrade(ctx, 1, TradeInput{Symbol: "BTC/USD", Type: "SELL", Price: d("300"), Quantity: d("2")})
+	require.NoError(t, err)
+
+	// the balance check came from the positions table, not from replaying trades
+	tradeRepo.AssertNotCalled(t, "GetByUserID", mock.Anything, mock.Anything)
+	posRepo.AssertExpectations(t)
+}
+
+func TestCheckPositions_ReportsDrift(t *testing.T) {
+	tradeRepo, posRepo := new(MockTradeRepo), new(MockPositionRepo)
+	positions := NewPositionService(posRepo, tradeRepo, nil)
+	ctx := context.Background()
+
+	trades := lotHistory()
+	for i := range trades {
+		trades[i].UserID = 1
+	}
+	trades = append(trades, domain.Trade{ID: 4, UserID: 2, Symbol: "ETH/USD", Type: "BUY", Price: d("10"), Quantity: d("1")})
+	tradeRepo.On("GetAll", ctx).Return(trades, nil)
+	posRepo.On("GetAll", ctx).Return([]domain.Position{
+		{UserID: 1, Symbol: "BTC/USD", Quantity: d("6"), CostBasis: d("750")},
+		{UserID: 3, Symbol: "SOL/USD", Quantity: d("0"), CostBasis: d("0")}, // zero row without trades is fine
+	}, nil)
+
+	drifts, err := positions.Check(ctx)
+	require.NoError(t, err)
+	require.Len(t, drifts, 2)
+
+	assert.Equal(t, "BTC/USD", drifts[0].Symbol)
+	assert.True(t, d("5").Equal(drifts[0].ExpectedQuantity))
+	assert.Equal(t, "ETH/USD", drifts[1].Symbol) // no stored row at all
+	assert.True(t, drifts[1].StoredQuantity.IsZero())
+}
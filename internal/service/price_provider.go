@@ -0,0 +1,334 @@
+package service
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MonalBarse/tradelog/internal/repository"
+	"github.com/shopspring/decimal"
+)
+
+/*
+NOTE:
+	A PriceProvider answers "what is SYMBOL worth right now".
+	Providers are small and composable: a chain tries sources in order and a
+	cache sits in front of slow ones (HTTP). Every quote carries the time it was
+	observed so callers can flag stale prices instead of trusting them blindly.
+*/
+
+// Sources reported on quotes
+const (
+	PriceSourceStatic    = "static"
+	PriceSourceFile      = "file"
+	PriceSourceLastTrade = "last_trade"
+	PriceSourceHTTP      = "http"
+	PriceSourceHistory   = "history"
+)
+
+var ErrPriceNotFound = errors.New("price not found")
+
+// Quote is a market price for a symbol and the moment it was observed
+type Quote struct {
+	Symbol string          `json:"symbol"`
+	Price  decimal.Decimal `json:"price"`
+	AsOf   time.Time       `json:"as_of"`
+	Source string          `json:"source"`
+}
+
+type PriceProvider interface {
+	GetQuote(ctx context.Context, symbol string) (*Quote, error)
+}
+
+// HistoricalPriceProvider can also answer "what was SYMBOL worth at a given moment"
+type HistoricalPriceProvider interface {
+	PriceProvider
+	GetQuoteAt(ctx context.Context, symbol string, at time.Time) (*Quote, error)
+}
+
+// ---------- static table ----------
+
+type staticPriceProvider struct {
+	prices map[string]decimal.Decimal
+	asOf   time.Time
+	source string
+}
+
+// NewStaticPriceProvider serves a fixed price table, quotes are stamped with the creation time
+func NewStaticPriceProvider(prices map[string]decimal.Decimal) PriceProvider {
+	return &staticPriceProvider{prices: prices, asOf: time.Now(), source: PriceSourceStatic}
+}
+
+func (p *staticPriceProvider) GetQuote(ctx context.Context, symbol string) (*Quote, error) {
+	price, ok := p.prices[symbol]
+	if !ok {
+		return nil, ErrPriceNotFound
+	}
+	return &Quote{Symbol: symbol, Price: price, AsOf: p.asOf, Source: p.source}, nil
+}
+
+// ParsePriceTable reads "BTC/USD=65000,ETH/USD=3000" into a price table
+func ParsePriceTable(raw string) (map[string]decimal.Decimal, error) {
+	prices := make(map[string]decimal.Decimal)
+	for _, pair := range strings.Split(raw, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		symbol, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("price %q must look like SYMBOL=PRICE", pair)
+		}
+		price, err := decimal.NewFromString(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid price for %s: %w", symbol, err)
+		}
+		prices[strings.TrimSpace(symbol)] = price
+	}
+	return prices, nil
+}
+
+// ---------- price file (CSV or JSON) ----------
+
+type filePriceProvider struct {
+	quotes map[string]Quote
+}
+
+type priceFileRow struct {
+	Symbol string          `json:"symbol"`
+	Price  decimal.Decimal `json:"price"`
+	AsOf   *time.Time      `json:"as_of"`
+}
+
+// NewFilePriceProvider loads prices from a .csv (symbol,price[,as_of]) or .json file.
+// Rows without a timestamp are stamped with the file modification time.
+func NewFilePriceProvider(path string) (PriceProvider, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []priceFileRow
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		rows, err = readPriceCSV(f)
+	case ".json":
+		err = json.NewDecoder(f).Decode(&rows)
+	default:
+		err = fmt.Errorf("unsupported price file %s, use .csv or .json", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	p := &filePriceProvider{quotes: make(map[string]Quote, len(rows))}
+	for _, row := range rows {
+		asOf := info.ModTime()
+		if row.AsOf != nil {
+			asOf = *row.AsOf
+		}
+		p.quotes[row.Symbol] = Quote{Symbol: row.Symbol, Price: row.Price, AsOf: asOf, Source: PriceSourceFile}
+	}
+	return p, nil
+}
+
+func readPriceCSV(r io.Reader) ([]priceFileRow, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []priceFileRow
+	for i, record := range records {
+		if len(record) < 2 {
+			return nil, fmt.Errorf("line %d: expected symbol,price[,as_of]", i+1)
+		}
+		price, err := decimal.NewFromString(strings.TrimSpace(record[1]))
+		if err != nil {
+			if i == 0 {
+				continue // header row
+			}
+			return nil, fmt.Errorf("line %d: invalid price: %w", i+1, err)
+		}
+		row := priceFileRow{Symbol: strings.TrimSpace(record[0]), Price: price}
+		if len(record) > 2 && strings.TrimSpace(record[2]) != "" {
+			asOf, err := time.Parse(time.RFC3339, strings.TrimSpace(record[2]))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: as_of must be RFC3339: %w", i+1, err)
+			}
+			row.AsOf = &asOf
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func (p *filePriceProvider) GetQuote(ctx context.Context, symbol string) (*Quote, error) {
+	quote, ok := p.quotes[symbol]
+	if !ok {
+		return nil, ErrPriceNotFound
+	}
+	return &quote, nil
+}
+
+// ---------- historical price store ----------
+
+type historicalPriceProvider struct {
+	repo repository.PriceRepository
+}
+
+// NewHistoricalPriceProvider quotes the close of the last stored candle at or before the requested time
+func NewHistoricalPriceProvider(repo repository.PriceRepository) HistoricalPriceProvider {
+	return &historicalPriceProvider{repo}
+}
+
+func (p *historicalPriceProvider) GetQuote(ctx context.Context, symbol string) (*Quote, error) {
+	return p.GetQuoteAt(ctx, symbol, time.Now())
+}
+
+func (p *historicalPriceProvider) GetQuoteAt(ctx context.Context, symbol string, at time.Time) (*Quote, error) {
+	point, err := p.repo.GetLatestAt(ctx, symbol, at)
+	if err != nil {
+		return nil, err
+	}
+	if point == nil {
+		return nil, ErrPriceNotFound
+	}
+	return &Quote{Symbol: symbol, Price: point.Close, AsOf: point.Timestamp, Source: PriceSourceHistory}, nil
+}
+
+// ---------- HTTP JSON quote source ----------
+
+type httpPriceProvider struct {
+	baseURL string
+	client  *http.Client
+}
+
+// NewHTTPPriceProvider fetches GET {baseURL}?symbol=SYMBOL and expects {"symbol","price","as_of"} back
+func NewHTTPPriceProvider(baseURL string, client *http.Client) PriceProvider {
+	if client == nil {
+		client = &http.Client{Timeout: 5 * time.Second}
+	}
+	return &httpPriceProvider{baseURL: baseURL, client: client}
+}
+
+func (p *httpPriceProvider) GetQuote(ctx context.Context, symbol string) (*Quote, error) {
+	u, err := url.Parse(p.baseURL)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	q.Set("symbol", symbol)
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrPriceNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("quote source returned %s", resp.Status)
+	}
+
+	var body priceFileRow
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("invalid quote response: %w", err)
+	}
+
+	quote := &Quote{Symbol: symbol, Price: body.Price, AsOf: time.Now(), Source: PriceSourceHTTP}
+	if body.AsOf != nil {
+		quote.AsOf = *body.AsOf
+	}
+	return quote, nil
+}
+
+// ---------- chain ----------
+
+type chainPriceProvider struct {
+	providers []PriceProvider
+}
+
+// NewChainPriceProvider asks each provider in order and returns the first quote found
+func NewChainPriceProvider(providers ...PriceProvider) PriceProvider {
+	return &chainPriceProvider{providers}
+}
+
+func (p *chainPriceProvider) GetQuote(ctx context.Context, symbol string) (*Quote, error) {
+	lastErr := ErrPriceNotFound
+	for _, provider := range p.providers {
+		quote, err := provider.GetQuote(ctx, symbol)
+		if err == nil {
+			return quote, nil
+		}
+		if !errors.Is(err, ErrPriceNotFound) {
+			lastErr = err // keep real failures so they are not hidden behind "not found"
+		}
+	}
+	return nil, lastErr
+}
+
+// ---------- cache ----------
+
+type cachedPriceProvider struct {
+	next   PriceProvider
+	ttl    time.Duration
+	mu     sync.Mutex
+	quotes map[string]cachedQuote
+}
+
+type cachedQuote struct {
+	quote     Quote
+	fetchedAt time.Time
+}
+
+// NewCachedPriceProvider remembers quotes of next for ttl, misses are not cached
+func NewCachedPriceProvider(next PriceProvider, ttl time.Duration) PriceProvider {
+	return &cachedPriceProvider{next: next, ttl: ttl, quotes: make(map[string]cachedQuote)}
+}
+
+func (p *cachedPriceProvider) GetQuote(ctx context.Context, symbol string) (*Quote, error) {
+	p.mu.Lock()
+	cached, ok := p.quotes[symbol]
+	p.mu.Unlock()
+	if ok && time.Since(cached.fetchedAt) < p.ttl {
+		quote := cached.quote
+		return &quote, nil
+	}
+
+	quote, err := p.next.GetQuote(ctx, symbol)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	p.quotes[symbol] = cachedQuote{quote: *quote, fetchedAt: time.Now()}
+	p.mu.Unlock()
+	return quote, nil
+}
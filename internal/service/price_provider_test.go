@@ -0,0 +1,138 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingProvider counts how often it was asked, to prove the cache works
+type countingProvider struct {
+	calls int
+}
+
+func (p *countingProvider) GetQuote(ctx context.Context, symbol string) (*Quote, error) {
+	p.calls++
+	return &Quote{Symbol: symbol, Price: d("1"), AsOf: time.Now()}, nil
+}
+
+func TestFilePriceProvider_CSVAndJSON(t *testing.T) {
+	dir := t.TempDir()
+	csvPath := filepath.Join(dir, "prices.csv")
+	jsonPath := filepath.Join(dir, "prices.json")
+	require.NoError(t, os.WriteFile(csvPath, []byte("symbol,price,as_of\nBTC/USD,65000,2024-05-01T10:00:00Z\nETH/USD,3000,\n"), 0o644))
+	require.NoError(t, os.WriteFile(jsonPath, []byte(`[{"symbol":"SOL/USD","price":"150.5"}]`), 0o644))
+
+	ctx := context.Background()
+
+	fromCSV, err := NewFilePriceProvider(csvPath)
+	require.NoError(t, err)
+	quote, err := fromCSV.GetQuote(ctx, "BTC/USD")
+	require.NoError(t, err)
+	assert.True(t, d("65000").Equal(quote.Price))
+	assert.Equal(t, 2024, quote.AsOf.Year())
+
+	fromJSON, err := NewFilePriceProvider(jsonPath)
+	require.NoError(t, err)
+	quote, err = fromJSON.GetQuote(ctx, "SOL/USD")
+	require.NoError(t, err)
+	assert.True(t, d("150.5").Equal(quote.Price))
+
+	_, err = fromJSON.GetQuote(ctx, "BTC/USD")
+	assert.ErrorIs(t, err, ErrPriceNotFound)
+}
+
+func TestHTTPPriceProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("symbol") != "BTC/USD" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"symbol":"BTC/USD","price":64000.25,"as_of":"2024-05-01T10:00:00Z"}`))
+	}))
+	defer server.Close()
+
+	provider := NewHTTPPriceProvider(server.URL, server.Client())
+	ctx := context.Background()
+
+	quote, err := provider.GetQuote(ctx, "BTC/USD")
+	require.NoError(t, err)
+	assert.True(t, d("64000.25").Equal(quote.Price))
+	assert.Equal(t, PriceSourceHTTP, quote.Source)
+
+	_, err = provider.GetQuote(ctx, "DOGE/USD")
+	assert.ErrorIs(t, err, ErrPriceNotFound)
+}
+
+func TestChainAndCachePriceProviders(t *testing.T) {
+	counting := &countingProvider{}
+	static := NewStaticPriceProvider(map[string]decimal.Decimal{"BTC/USD": d("65000")})
+	provider := NewCachedPriceProvider(NewChainPriceProvider(static, counting), time.Minute)
+	ctx := context.Background()
+
+	quote, err := provider.GetQuote(ctx, "BTC/USD")
+	require.NoError(t, err)
+	assert.Equal(t, PriceSourceStatic, quote.Source)
+
+	provider.GetQuote(ctx, "ETH/USD")
+	provider.GetQuote(ctx, "ETH/USD")
+	assert.Equal(t, 1, counting.calls)
+}
+
+func TestGetPortfolio_ValuesAtMarketAndFlagsStaleQuotes(t *testing.T) {
+	mockRepo := new(MockTradeRepo)
+	ctx := context.Background()
+
+	old := time.Now().Add(-time.Hour)
+	mockRepo.On("GetByUserID", ctx, uint(1)).Return(lotHistory(), nil)
+	path := filepath.Join(t.TempDir(), "prices.json")
+	require.NoError(t, os.WriteFile(path, []byte(`[{"symbol":"BTC/USD","price":"250","as_of":"`+old.Format(time.RFC3339)+`"}]`), 0o644))
+	prices, err := NewFilePriceProvider(path)
+	require.NoError(t, err)
+
+	service := NewTradeService(mockRepo, WithPriceProvider(prices, 15*time.Minute))
+
+	portfolio, err := service.GetPortfolio(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, portfolio, 1)
+	assert.True(t, d("1250").Equal(portfolio[0].Value))
+	assert.Equal(t, PriceSourceFile, portfolio[0].PriceSource)
+	assert.True(t, portfolio[0].PriceStale)
+}
+
+func TestGetPortfolio_LastTradeFallbackOnlyReadsTheUsersOwnFills(t *testing.T) {
+	repo := &memTradeRepo{}
+	prices := NewStaticPriceProvider(map[string]decimal.Decimal{"ETH/USD": d("3000")})
+	service := NewTradeService(repo, WithPriceProvider(prices, 0))
+	ctx := context.Background()
+
+	early, late := time.Now().Add(-48*time.Hour), time.Now().Add(-time.Hour)
+	require.NoError(t, service.CreateTrade(ctx, 2, TradeInput{Symbol: "BTC/USD", Type: "BUY", Price: d("40000"), Quantity: d("1"), ExecutedAt: &early}))
+	require.NoError(t, service.CreateTrade(ctx, 1, TradeInput{Symbol: "BTC/USD", Type: "BUY", Price: d("60000"), Quantity: d("1"), ExecutedAt: &late}))
+
+	// user 1 traded BTC last and highest, user 2 is still priced at its own fill
+	portfolio, err := service.GetPortfolio(ctx, 2)
+	require.NoError(t, err)
+	require.Len(t, portfolio, 1)
+	assert.Equal(t, PriceSourceLastTrade, portfolio[0].PriceSource)
+	assert.True(t, d("40000").Equal(portfolio[0].Price))
+	assert.True(t, early.Equal(*portfolio[0].PriceAsOf))
+
+	report, err := service.GetPnL(ctx, 2, nil, PeriodDay)
+	require.NoError(t, err)
+	require.Len(t, report.Unrealized, 1)
+	assert.True(t, d("40000").Equal(report.Unrealized[0].MarkPrice))
+
+	portfolio, err = service.GetPortfolio(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, portfolio, 1)
+	assert.True(t, d("60000").Equal(portfolio[0].Price))
+}
@@ -0,0 +1,233 @@
+package service
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MonalBarse/tradelog/internal/domain"
+	"github.com/MonalBarse/tradelog/internal/repository"
+	"github.com/shopspring/decimal"
+)
+
+// Resolutions a price series can be aggregated to, "raw" returns the stored candles
+var resolutions = map[string]time.Duration{
+	"1m":  time.Minute,
+	"5m":  5 * time.Minute,
+	"15m": 15 * time.Minute,
+	"1h":  time.Hour,
+	"4h":  4 * time.Hour,
+	"1d":  24 * time.Hour,
+	"1w":  7 * 24 * time.Hour,
+}
+
+const ingestBatchSize = 1000
+
+type PriceService interface {
+	ImportCSV(ctx context.Context, r io.Reader, symbol string) (int, error)
+	GetSeries(ctx context.Context, symbol string, from, to time.Time, resolution string) ([]domain.PricePoint, error)
+}
+
+type priceService struct {
+	repo     repository.PriceRepository
+	instRepo repository.InstrumentRepository
+}
+
+func NewPriceService(repo repository.PriceRepository, instRepo repository.InstrumentRepository) PriceService {
+	return &priceService{repo, instRepo}
+}
+
+// @desc: bulk ingest candles from CSV
+// @flow: read header -> parse rows -> resolve symbols through the registry -> upsert in batches
+// Columns are matched by header name: symbol,timestamp,open,high,low,close,volume.
+// The symbol column may be left out when symbol is given (one file per symbol).
+// A candle repeated in the file replaces the earlier one, like a re-import does.
+func (s *priceService) ImportCSV(ctx context.Context, r io.Reader, symbol string) (int, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return 0, fmt.Errorf("reading header: %w", err)
+	}
+	cols := make(map[string]int, len(header))
+	for i, name := range header {
+		cols[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"timestamp", "open", "high", "low", "close"} {
+		if _, ok := cols[required]; !ok {
+			return 0, fmt.Errorf("missing column %q", required)
+		}
+	}
+	if _, ok := cols["symbol"]; !ok && symbol == "" {
+		return 0, errors.New("file has no symbol column, pass the symbol explicitly")
+	}
+
+	// canonical symbol per symbol as written in the file, each is looked up once
+	symbols := make(map[string]string)
+	if symbol != "" {
+		canonical, err := s.canonicalSymbol(ctx, symbol)
+		if err != nil {
+			return 0, err
+		}
+		symbols[symbol], symbol = canonical, canonical
+	}
+
+	total := 0
+	batch := make([]domain.PricePoint, 0, ingestBatchSize)
+	// a batch may not hit the same row twice, Postgres rejects such an upsert
+	inBatch := make(map[string]int, ingestBatchSize)
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return total, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		point, err := parsePriceRecord(record, cols, symbol)
+		if err != nil {
+			return total, fmt.Errorf("line %d: %w", line, err)
+		}
+		canonical, ok := symbols[point.Symbol]
+		if !ok {
+			if canonical, err = s.canonicalSymbol(ctx, point.Symbol); err != nil {
+				return total, fmt.Errorf("line %d: %w", line, err)
+			}
+			symbols[point.Symbol] = canonical
+		}
+		point.Symbol = canonical
+
+		key := point.Symbol + "\x00" + point.Timestamp.Format(time.RFC3339Nano)
+		if i, ok := inBatch[key]; ok {
+			batch[i] = point
+			continue
+		}
+		inBatch[key] = len(batch)
+		batch = append(batch, point)
+
+		if len(batch) == ingestBatchSize {
+			if err := s.repo.BulkUpsert(ctx, batch); err != nil {
+				return total, err
+			}
+			total += len(batch)
+			batch = batch[:0]
+			clear(inBatch)
+		}
+	}
+
+	if err := s.repo.BulkUpsert(ctx, batch); err != nil {
+		return total, err
+	}
+	return total + len(batch), nil
+}
+
+func parsePriceRecord(record []string, cols map[string]int, symbol string) (domain.PricePoint, error) {
+	field := func(name string) string {
+		if i, ok := cols[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	point := domain.PricePoint{Symbol: symbol, Volume: decimal.Zero}
+	if s := field("symbol"); s != "" {
+		point.Symbol = s
+	}
+
+	ts, err := parseTimestamp(field("timestamp"))
+	if err != nil {
+		return point, err
+	}
+	point.Timestamp = ts
+
+	for name, dst := range map[string]*decimal.Decimal{"open": &point.Open, "high": &point.High, "low": &point.Low, "close": &point.Close} {
+		v, err := decimal.NewFromString(field(name))
+		if err != nil {
+			return point, fmt.Errorf("invalid %s: %w", name, err)
+		}
+		*dst = v
+	}
+	if v := field("volume"); v != "" {
+		if point.Volume, err = decimal.NewFromString(v); err != nil {
+			return point, fmt.Errorf("invalid volume: %w", err)
+		}
+	}
+	return point, nil
+}
+
+// parseTimestamp accepts RFC3339, "2006-01-02 15:04:05", "2006-01-02" (UTC) or unix seconds
+func parseTimestamp(value string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	if secs, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(secs, 0).UTC(), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid timestamp %q", value)
+}
+
+// @desc: candles of a symbol over a range, aggregated to the requested resolution
+func (s *priceService) GetSeries(ctx context.Context, symbol string, from, to time.Time, resolution string) ([]domain.PricePoint, error) {
+	if symbol == "" {
+		return nil, errors.New("symbol is required")
+	}
+	if to.Before(from) {
+		return nil, errors.New("to must not be before from")
+	}
+	symbol, err := s.canonicalSymbol(ctx, symbol)
+	if err != nil {
+		return nil, err
+	}
+
+	points, err := s.repo.GetRange(ctx, symbol, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	if resolution == "" || resolution == "raw" {
+		return points, nil
+	}
+	step, ok := resolutions[resolution]
+	if !ok {
+		return nil, fmt.Errorf("unsupported resolution %q", resolution)
+	}
+	return resample(points, step), nil
+}
+
+// canonicalSymbol is the symbol candles are stored under, the one trades of the instrument carry
+func (s *priceService) canonicalSymbol(ctx context.Context, raw string) (string, error) {
+	instrument, err := lookupInstrument(ctx, s.instRepo, raw)
+	if err != nil {
+		return "", err
+	}
+	return instrument.Symbol, nil
+}
+
+// resample folds sorted candles into buckets of step: first open, max high, min low, last close, summed volume
+func resample(points []domain.PricePoint, step time.Duration) []domain.PricePoint {
+	var out []domain.PricePoint
+	for _, p := range points {
+		bucket := p.Timestamp.UTC().Truncate(step)
+		if n := len(out); n > 0 && out[n-1].Timestamp.Equal(bucket) {
+			last := &out[n-1]
+			last.High = decimal.Max(last.High, p.High)
+			last.Low = decimal.Min(last.Low, p.Low)
+			last.Close = p.Close
+			last.Volume = last.Volume.Add(p.Volume)
+			continue
+		}
+		p.ID = 0
+		p.Timestamp = bucket
+		out = append(out, p)
+	}
+	return out
+}
@@ -0,0 +1,143 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MonalBarse/tradelog/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type MockPriceRepo struct {
+	mock.Mock
+}
+
+func (m *MockPriceRepo) BulkUpsert(ctx context.Context, points []domain.PricePoint) error {
+	stored := make([]domain.PricePoint, len(points))
+	copy(stored, points)
+	args := m.Called(ctx, stored)
+	return args.Error(0)
+}
+
+func (m *MockPriceRepo) GetRange(ctx context.Context, symbol string, from, to time.Time) ([]domain.PricePoint, error) {
+	args := m.Called(ctx, symbol, from, to)
+	return args.Get(0).([]domain.PricePoint), args.Error(1)
+}
+
+func (m *MockPriceRepo) GetLatestAt(ctx context.Context, symbol string, at time.Time) (*domain.PricePoint, error) {
+	args := m.Called(ctx, symbol, at)
+	point, _ := args.Get(0).(*domain.PricePoint)
+	return point, args.Error(1)
+}
+
+func TestImportCSV(t *testing.T) {
+	repo, instRepo := new(MockPriceRepo), new(MockInstrumentRepo)
+	prices := NewPriceService(repo, instRepo)
+	ctx := context.Background()
+
+	instRepo.On("GetBySymbol", ctx, "BTC/USD").Return(btcInstrument(), nil)
+
+	repo.On("BulkUpsert", ctx, mock.MatchedBy(func(points []domain.PricePoint) bool {
+		return len(points) == 2 && points[0].Symbol == "BTC/USD" && d("105").Equal(points[1].Close)
+	})).Return(nil)
+
+	csv := "timestamp,open,high,low,close,volume\n" +
+		"2024-01-01T00:00:00Z,100,110,90,104,5\n" +
+		"2024-01-01T01:00:00Z,104,108,100,105,3\n"
+	n, err := prices.ImportCSV(ctx, strings.NewReader(csv), "BTC/USD")
+
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	repo.AssertExpectations(t)
+}
+
+func TestImportCSV_ReportsBadLine(t *testing.T) {
+	prices := NewPriceService(new(MockPriceRepo), new(MockInstrumentRepo))
+
+	csv := "symbol,timestamp,open,high,low,close\nBTC/USD,yesterday,1,1,1,1\n"
+	_, err := prices.ImportCSV(context.Background(), strings.NewReader(csv), "")
+
+	assert.ErrorContains(t, err, "line 2")
+}
+
+func TestGetSeries_Resamples(t *testing.T) {
+	repo, instRepo := new(MockPriceRepo), new(MockInstrumentRepo)
+	prices := NewPriceService(repo, instRepo)
+	ctx := context.Background()
+
+	instRepo.On("GetBySymbol", ctx, "BTC/USD").Return(btcInstrument(), nil)
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(48 * time.Hour)
+	repo.On("GetRange", ctx, "BTC/USD", start, end).Return([]domain.PricePoint{
+		{Symbol: "BTC/USD", Timestamp: start, Open: d("100"), High: d("110"), Low: d("90"), Close: d("104"), Volume: d("5")},
+		{Symbol: "BTC/USD", Timestamp: start.Add(time.Hour), Open: d("104"), High: d("120"), Low: d("100"), Close: d("115"), Volume: d("3")},
+		{Symbol: "BTC/USD", Timestamp: start.Add(25 * time.Hour), Open: d("115"), High: d("116"), Low: d("111"), Close: d("112"), Volume: d("1")},
+	}, nil)
+
+	series, err := prices.GetSeries(ctx, "BTC/USD", start, end, "1d")
+	require.NoError(t, err)
+	require.Len(t, series, 2)
+
+	day := series[0]
+	assert.True(t, d("100").Equal(day.Open))
+	assert.True(t, d("120").Equal(day.High))
+	assert.True(t, d("90").Equal(day.Low))
+	assert.True(t, d("115").Equal(day.Close))
+	assert.True(t, d("8").Equal(day.Volume))
+}
+
+func TestImportCSV_StoresCanonicalSymbolsOncePerCandle(t *testing.T) {
+	repo, instRepo := new(MockPriceRepo), new(MockInstrumentRepo)
+	prices := NewPriceService(repo, instRepo)
+	ctx := context.Background()
+
+	instRepo.On("GetBySymbol", ctx, "BTC/USD").Return(btcInstrument(), nil)
+	instRepo.On("GetBySymbol", ctx, "BTCUSD").Return(nil, nil)
+	instRepo.On("List", ctx).Return([]domain.Instrument{*btcInstrument()}, nil)
+	repo.On("BulkUpsert", ctx, mock.Anything).Return(nil)
+
+	csv := "symbol,timestamp,open,high,low,close\n" +
+		"btc-usd,2024-01-01T00:00:00Z,100,110,90,104\n" +
+		"BTCUSD,2024-01-01T01:00:00Z,104,108,100,105\n" +
+		"BTC/USD,2024-01-01T00:00:00Z,100,111,90,106\n" // corrects the first candle
+	n, err := prices.ImportCSV(ctx, strings.NewReader(csv), "")
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	points := repo.Calls[0].Arguments.Get(1).([]domain.PricePoint)
+	require.Len(t, points, 2)
+	assert.Equal(t, "BTC/USD", points[0].Symbol)
+	assert.Equal(t, "BTC/USD", points[1].Symbol)
+	assert.True(t, d("106").Equal(points[0].Close))
+	instRepo.AssertNumberOfCalls(t, "List", 1)
+
+	repo.On("GetRange", ctx, "BTC/USD", mock.Anything, mock.Anything).Return([]domain.PricePoint{}, nil)
+	_, err = prices.GetSeries(ctx, "btc_usd", time.Time{}, time.Now(), "")
+	require.NoError(t, err)
+	repo.AssertCalled(t, "GetRange", ctx, "BTC/USD", mock.Anything, mock.Anything)
+}
+
+func TestGetPortfolioAt_UsesHistory(t *testing.T) {
+	tradeRepo := new(MockTradeRepo)
+	priceRepo := new(MockPriceRepo)
+	ctx := context.Background()
+
+	// after the two buys, before the sell
+	at := time.Date(2024, 1, 1, 1, 30, 0, 0, time.UTC)
+	tradeRepo.On("GetByUserID", ctx, uint(1)).Return(lotHistory(), nil)
+	priceRepo.On("GetLatestAt", ctx, "BTC/USD", at).Return(&domain.PricePoint{Close: d("150"), Timestamp: at.Add(-time.Minute)}, nil)
+
+	service := NewTradeService(tradeRepo, WithHistoricalPrices(NewHistoricalPriceProvider(priceRepo)))
+
+	portfolio, err := service.GetPortfolioAt(ctx, 1, at)
+	require.NoError(t, err)
+	require.Len(t, portfolio, 1)
+	assert.True(t, d("20").Equal(portfolio[0].Quantity))
+	assert.True(t, d("3000").Equal(portfolio[0].Value))
+	assert.Equal(t, PriceSourceHistory, portfolio[0].PriceSource)
+}
@@ -0,0 +1,420 @@
+package service
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MonalBarse/tradelog/internal/domain"
+	"github.com/shopspring/decimal"
+)
+
+/*
+NOTE:
+	Every closed lot of the lot engine is one disposal of the tax report.
+	A disposal is long-term when it happens after the acquisition date plus the
+	jurisdiction's holding period (calendar months, so leap years and month
+	lengths work out like the tax rules count them); otherwise it is short-term.
+	Proceeds are converted to the base currency at the disposal date, the cost
+	basis at the acquisition date. Disposals without an FX rate stay in the
+	list but are left out of the yearly summary.
+	Where the jurisdiction has wash sales (US) the closed lots go through the
+	wash-sale analyzer first: washed losses get code W and an adjustment, and
+	replacement shares are classified from their adjusted holding period.
+	Covering a short is a disposal too, always short-term: AcquiredAt is the
+	short sale, which brought in the proceeds, and DisposedAt the cover, which
+	paid the cost, so the two are converted at those dates the other way round.
+*/
+
+// Holding period terms
+const (
+	TermShort = "SHORT"
+	TermLong  = "LONG"
+)
+
+// TaxJurisdiction holds the rules that classify and group disposals
+type TaxJurisdiction struct {
+	Code           string         `json:"code"`
+	YearStart      time.Month     `json:"year_start"`            // first month of the tax year
+	LongTermMonths int            `json:"long_term_months"`      // held longer than this is long-term
+	AssetClass     map[string]int `json:"asset_class,omitempty"` // holding periods that differ by instrument asset class
+	WashSales      bool           `json:"wash_sales"`            // losses washed by a replacement BUY within 30 days are disallowed
+}
+
+// defaultJurisdictions are used unless overridden with WithTaxHoldingPeriods
+var defaultJurisdictions = map[string]TaxJurisdiction{
+	"US": {Code: "US", YearStart: time.January, LongTermMonths: 12, WashSales: true},
+	"IN": {Code: "IN", YearStart: time.April, LongTermMonths: 24, AssetClass: map[string]int{domain.AssetClassEquity: 12}},
+	"DE": {Code: "DE", YearStart: time.January, LongTermMonths: 12},
+	"AU": {Code: "AU", YearStart: time.July, LongTermMonths: 12},
+}
+
+const defaultJurisdiction = "US"
+
+var ErrUnknownJurisdiction = errors.New("unknown tax jurisdiction")
+
+// TaxDisposal is one line of the capital gains report, amounts are in the base currency unless noted
+type TaxDisposal struct {
+	TaxYear       string          `json:"tax_year"`
+	Term          string          `json:"term"`
+	Symbol        string          `json:"symbol"`
+	Quantity      decimal.Decimal `json:"quantity"`
+	AcquiredAt    time.Time       `json:"acquired_at"`
+	DisposedAt    time.Time       `json:"disposed_at"`
+	HoldingDays   int             `json:"holding_days"`
+	Currency      string          `json:"currency"`       // quote currency of the symbol
+	LocalProceeds decimal.Decimal `json:"local_proceeds"` // in Currency, net of sell fees
+	LocalCost     decimal.Decimal `json:"local_cost"`     // in Currency, buy fees included
+	Proceeds      decimal.Decimal `json:"proceeds"`
+	CostBasis     decimal.Decimal `json:"cost_basis"`
+	Gain          decimal.Decimal `json:"gain"`
+	Code          string          `json:"code,omitempty"`         // Form 8949 adjustment code, W for a wash sale
+	Adjustment    decimal.Decimal `json:"adjustment"`             // disallowed loss added back to the gain
+	HoldingFrom   *time.Time      `json:"holding_from,omitempty"` // holding period start of replacement shares
+	Short         bool            `json:"short,omitempty"`        // a covered short sale
+	OpenTradeID   uint            `json:"open_trade_id"`
+	CloseTradeID  uint            `json:"close_trade_id"`
+	FXError       string          `json:"fx_error,omitempty"` // set when no rate was found, base amounts stay zero
+}
+
+// TaxYearSummary totals the disposals of one tax year by term
+type TaxYearSummary struct {
+	TaxYear           string          `json:"tax_year"`
+	ShortTermProceeds decimal.Decimal `json:"short_term_proceeds"`
+	ShortTermCost     decimal.Decimal `json:"short_term_cost"`
+	ShortTermGain     decimal.Decimal `json:"short_term_gain"`
+	LongTermProceeds  decimal.Decimal `json:"long_term_proceeds"`
+	LongTermCost      decimal.Decimal `json:"long_term_cost"`
+	LongTermGain      decimal.Decimal `json:"long_term_gain"`
+	TotalGain         decimal.Decimal `json:"total_gain"`
+	Disposals         int             `json:"disposals"`
+}
+
+// TaxReport is the capital gains report of a user
+type TaxReport struct {
+	Jurisdiction TaxJurisdiction  `json:"jurisdiction"`
+	CostMethod   string           `json:"cost_method"`
+	BaseCurrency string           `json:"base_currency"`
+	Disposals    []TaxDisposal    `json:"disposals"`
+	Summary      []TaxYearSummary `json:"summary"`
+	Unconverted  []string         `json:"unconverted,omitempty"` // currencies without an FX rate, left out of the summary
+}
+
+// WithTaxHoldingPeriods overrides holding periods, see ParseHoldingPeriods
+func WithTaxHoldingPeriods(overrides map[string]TaxJurisdiction) TradeServiceOption {
+	return func(s *tradeService) {
+		s.jurisdictions = overrides
+	}
+}
+
+// ParseHoldingPeriods reads "US=12,IN=24,IN/EQUITY=12" (months) into jurisdiction overrides.
+// Jurisdictions that are not built in start from a calendar tax year.
+func ParseHoldingPeriods(raw string) (map[string]TaxJurisdiction, error) {
+	out := make(map[string]TaxJurisdiction)
+	for _, pair := range strings.Split(raw, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		months, err := strconv.Atoi(strings.TrimSpace(value))
+		if !ok || err != nil || months < 0 {
+			return nil, fmt.Errorf("holding period %q must look like CODE=MONTHS or CODE/ASSET_CLASS=MONTHS", pair)
+		}
+		code, class, _ := strings.Cut(strings.ToUpper(strings.TrimSpace(key)), "/")
+
+		j, ok := out[code]
+		if !ok {
+			j = lookupJurisdiction(code, nil)
+		}
+		if class == "" {
+			j.LongTermMonths = months
+		} else {
+			classes := make(map[string]int, len(j.AssetClass)+1)
+			for k, v := range j.AssetClass {
+				classes[k] = v
+			}
+			classes[class] = months
+			j.AssetClass = classes
+		}
+		out[code] = j
+	}
+	return out, nil
+}
+
+// lookupJurisdiction prefers overrides, then the built-in rules, then a calendar year with a 12 month period
+func lookupJurisdiction(code string, overrides map[string]TaxJurisdiction) TaxJurisdiction {
+	if j, ok := overrides[code]; ok {
+		return j
+	}
+	if j, ok := defaultJurisdictions[code]; ok {
+		return j
+	}
+	return TaxJurisdiction{Code: code, YearStart: time.January, LongTermMonths: 12}
+}
+
+func (s *tradeService) jurisdiction(code string) (TaxJurisdiction, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if code == "" {
+		code = defaultJurisdiction
+	}
+	_, overridden := s.jurisdictions[code]
+	if _, builtIn := defaultJurisdictions[code]; !builtIn && !overridden {
+		return TaxJurisdiction{}, fmt.Errorf("%w %q", ErrUnknownJurisdiction, code)
+	}
+	return lookupJurisdiction(code, s.jurisdictions), nil
+}
+
+// Term classifies a holding: long-term when disposed after acquiredAt plus the holding period of the asset class
+func (j TaxJurisdiction) Term(assetClass string, acquiredAt, disposedAt time.Time) string {
+	months := j.LongTermMonths
+	if m, ok := j.AssetClass[assetClass]; ok {
+		months = m
+	}
+	acquired := acquiredAt.UTC()
+	y, m, d := acquired.Date()
+	threshold := time.Date(y, m+time.Month(months), d, 0, 0, 0, 0, time.UTC)
+	if threshold.Day() != d {
+		threshold = time.Date(y, m+time.Month(months)+1, 0, 0, 0, 0, 0, time.UTC) // 31st into a shorter month: the anniversary is its last day
+	}
+	if !disposedAt.UTC().Before(threshold.AddDate(0, 0, 1)) {
+		return TermLong
+	}
+	return TermShort
+}
+
+// TaxYear labels the tax year at: "2024" for calendar years, "2024-25" for years that start later
+func (j TaxJurisdiction) TaxYear(at time.Time) string {
+	at = at.UTC()
+	start := j.YearStart
+	if start == 0 {
+		start = time.January
+	}
+	year := at.Year()
+	if at.Month() < start {
+		year--
+	}
+	if start == time.January {
+		return strconv.Itoa(year)
+	}
+	return fmt.Sprintf("%d-%02d", year, (year+1)%100)
+}
+
+// @desc: capital gains report, optionally for one tax year only
+// @flow: match lots -> apply wash sales where the jurisdiction has them -> classify and convert every closed lot -> total per tax year
+func (s *tradeService) GetTaxReport(ctx context.Context, userID uint, jurisdiction string, taxYear string) (*TaxReport, error) {
+	j, err := s.jurisdiction(jurisdiction)
+	if err != nil {
+		return nil, err
+	}
+	settings, err := s.GetSettings(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	trades, err := s.userTrades(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	book, err := matchLotsWithShorts(trades, settings.CostMethod)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &TaxReport{
+		Jurisdiction: j,
+		CostMethod:   settings.CostMethod,
+		BaseCurrency: settings.BaseCurrency,
+		Disposals:    []TaxDisposal{},
+		Summary:      []TaxYearSummary{},
+	}
+
+	lots := plainLots(book.closed)
+	if j.WashSales {
+		lots, _ = applyWashSales(trades, book.closed)
+	}
+
+	conv := newFXConverter(s.fxRepo)
+	classes := make(map[string]string)
+	unconverted := make(map[string]bool)
+	years := make(map[string]*TaxYearSummary)
+
+	for _, lot := range lots {
+		year := j.TaxYear(lot.DisposedAt)
+		if taxYear != "" && year != taxYear {
+			continue
+		}
+
+		class, err := s.assetClass(ctx, lot.Symbol, classes)
+		if err != nil {
+			return nil, err
+		}
+		_, currency := domain.SplitSymbol(lot.Symbol)
+		disposal := TaxDisposal{
+			TaxYear:       year,
+			Term:          j.Term(class, lot.HoldingFrom, lot.DisposedAt),
+			Symbol:        lot.Symbol,
+			Quantity:      lot.Quantity,
+			AcquiredAt:    lot.AcquiredAt,
+			DisposedAt:    lot.DisposedAt,
+			HoldingDays:   int(lot.DisposedAt.Sub(lot.HoldingFrom).Hours() / 24),
+			Currency:      currency,
+			LocalProceeds: lot.Proceeds,
+			LocalCost:     lot.CostBasis,
+			OpenTradeID:   lot.OpenTradeID,
+			CloseTradeID:  lot.CloseTradeID,
+			Short:         lot.Short,
+		}
+		proceedsAt, costAt := lot.DisposedAt, lot.AcquiredAt
+		if lot.Short {
+			disposal.Term = TermShort
+			proceedsAt, costAt = lot.AcquiredAt, lot.DisposedAt
+		}
+		if !lot.HoldingFrom.Equal(lot.AcquiredAt) {
+			holdingFrom := lot.HoldingFrom
+			disposal.HoldingFrom = &holdingFrom
+		}
+		if lot.Disallowed.IsPositive() {
+			disposal.Code = "W"
+		}
+
+		// the carried basis keeps the rate of the washed disposal it came from
+		proceeds, err := conv.Convert(ctx, lot.Proceeds, currency, settings.BaseCurrency, proceedsAt)
+		if err == nil {
+			disposal.CostBasis, err = conv.Convert(ctx, lot.CostBasis.Sub(lot.BasisAdjustment), currency, settings.BaseCurrency, costAt)
+		}
+		if err == nil && !lot.BasisAdjustment.IsZero() {
+			var carried decimal.Decimal
+			carried, err = conv.Convert(ctx, lot.BasisAdjustment, currency, settings.BaseCurrency, lot.WashedAt)
+			disposal.CostBasis = disposal.CostBasis.Add(carried)
+		}
+		if err == nil && lot.Disallowed.IsPositive() {
+			disposal.Adjustment, err = conv.Convert(ctx, lot.Disallowed, currency, settings.BaseCurrency, lot.DisposedAt)
+		}
+		if err != nil {
+			if !errors.Is(err, ErrRateNotFound) {
+				return nil, err
+			}
+			disposal.FXError = err.Error()
+			unconverted[currency] = true
+			report.Disposals = append(report.Disposals, disposal)
+			continue
+		}
+		disposal.Proceeds = proceeds
+		disposal.Gain = proceeds.Sub(disposal.CostBasis).Add(disposal.Adjustment)
+		report.Disposals = append(report.Disposals, disposal)
+
+		summary, ok := years[year]
+		if !ok {
+			summary = &TaxYearSummary{TaxYear: year}
+			years[year] = summary
+		}
+		summary.Disposals++
+		if disposal.Term == TermLong {
+			summary.LongTermProceeds = summary.LongTermProceeds.Add(disposal.Proceeds)
+			summary.LongTermCost = summary.LongTermCost.Add(disposal.CostBasis)
+			summary.LongTermGain = summary.LongTermGain.Add(disposal.Gain)
+		} else {
+			summary.ShortTermProceeds = summary.ShortTermProceeds.Add(disposal.Proceeds)
+			summary.ShortTermCost = summary.ShortTermCost.Add(disposal.CostBasis)
+			summary.ShortTermGain = summary.ShortTermGain.Add(disposal.Gain)
+		}
+		summary.TotalGain = summary.TotalGain.Add(disposal.Gain)
+	}
+
+	for _, summary := range years {
+		report.Summary = append(report.Summary, *summary)
+	}
+	sort.Slice(report.Summary, func(i, k int) bool { return report.Summary[i].TaxYear < report.Summary[k].TaxYear })
+	for currency := range unconverted {
+		report.Unconverted = append(report.Unconverted, currency)
+	}
+	sort.Strings(report.Unconverted)
+	return report, nil
+}
+
+// assetClass of a symbol from the instrument registry, empty when there is none
+func (s *tradeService) assetClass(ctx context.Context, symbol string, cache map[string]string) (string, error) {
+	if s.instRepo == nil {
+		return "", nil
+	}
+	if class, ok := cache[symbol]; ok {
+		return class, nil
+	}
+	inst, err := s.instRepo.GetBySymbol(ctx, symbol)
+	if err != nil {
+		return "", err
+	}
+	class := ""
+	if inst != nil {
+		class = inst.AssetClass
+	}
+	cache[symbol] = class
+	return class, nil
+}
+
+// form8949Header follows the columns of IRS Form 8949, Part I holds short-term and Part II long-term disposals
+var form8949Header = []string{
+	"Part",
+	"(a) Description of property",
+	"(b) Date acquired",
+	"(c) Date sold or disposed of",
+	"(d) Proceeds",
+	"(e) Cost or other basis",
+	"(f) Code(s)",
+	"(g) Amount of adjustment",
+	"(h) Gain or (loss)",
+}
+
+// WriteForm8949 writes the converted disposals as Form 8949 rows, short-term first, amounts rounded to cents
+func WriteForm8949(w io.Writer, report *TaxReport) error {
+	disposals := make([]TaxDisposal, 0, len(report.Disposals))
+	for _, d := range report.Disposals {
+		if d.FXError == "" {
+			disposals = append(disposals, d)
+		}
+	}
+	sort.SliceStable(disposals, func(i, k int) bool {
+		if disposals[i].Term != disposals[k].Term {
+			return disposals[i].Term == TermShort
+		}
+		return disposals[i].DisposedAt.Before(disposals[k].DisposedAt)
+	})
+
+	out := csv.NewWriter(w)
+	if err := out.Write(form8949Header); err != nil {
+		return err
+	}
+	for _, d := range disposals {
+		part := "I"
+		if d.Term == TermLong {
+			part = "II"
+		}
+		base, _ := domain.SplitSymbol(d.Symbol)
+		adjustment := ""
+		if !d.Adjustment.IsZero() {
+			adjustment = d.Adjustment.StringFixed(2)
+		}
+		err := out.Write([]string{
+			part,
+			d.Quantity.String() + " " + base,
+			d.AcquiredAt.UTC().Format("01/02/2006"),
+			d.DisposedAt.UTC().Format("01/02/2006"),
+			d.Proceeds.StringFixed(2),
+			d.CostBasis.StringFixed(2),
+			d.Code,
+			adjustment,
+			d.Gain.StringFixed(2),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
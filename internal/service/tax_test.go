@@ -0,0 +1,98 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"testing"
+	"time"
+
+	"github.com/MonalBarse/tradelog/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTaxJurisdiction_Term(t *testing.T) {
+	us := defaultJurisdictions["US"]
+	acquired := time.Date(2023, 3, 15, 14, 0, 0, 0, time.UTC)
+
+	// held "more than one year": the anniversary itself is still short-term
+	assert.Equal(t, TermShort, us.Term("", acquired, time.Date(2024, 3, 15, 23, 0, 0, 0, time.UTC)))
+	assert.Equal(t, TermLong, us.Term("", acquired, time.Date(2024, 3, 16, 0, 0, 0, 0, time.UTC)))
+
+	// leap day: the anniversary falls on Feb 28
+	leap := time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, TermShort, us.Term("", leap, time.Date(2025, 2, 28, 12, 0, 0, 0, time.UTC)))
+	assert.Equal(t, TermLong, us.Term("", leap, time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)))
+
+	in := defaultJurisdictions["IN"]
+	sold := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, TermLong, in.Term(domain.AssetClassEquity, acquired, sold))
+	assert.Equal(t, TermShort, in.Term(domain.AssetClassCrypto, acquired, sold))
+}
+
+func TestTaxJurisdiction_TaxYear(t *testing.T) {
+	assert.Equal(t, "2024", defaultJurisdictions["US"].TaxYear(time.Date(2024, 3, 31, 0, 0, 0, 0, time.UTC)))
+	assert.Equal(t, "2023-24", defaultJurisdictions["IN"].TaxYear(time.Date(2024, 3, 31, 0, 0, 0, 0, time.UTC)))
+	assert.Equal(t, "2024-25", defaultJurisdictions["IN"].TaxYear(time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)))
+	assert.Equal(t, "1999-00", defaultJurisdictions["AU"].TaxYear(time.Date(1999, 7, 1, 0, 0, 0, 0, time.UTC)))
+}
+
+func TestParseHoldingPeriods(t *testing.T) {
+	periods, err := ParseHoldingPeriods("us=6, IN/CRYPTO=36, FR=24")
+	require.NoError(t, err)
+	assert.Equal(t, 6, periods["US"].LongTermMonths)
+	assert.Equal(t, 24, periods["IN"].LongTermMonths) // built-in rules are kept
+	assert.Equal(t, map[string]int{domain.AssetClassEquity: 12, domain.AssetClassCrypto: 36}, periods["IN"].AssetClass)
+	assert.Equal(t, time.January, periods["FR"].YearStart)
+	assert.Equal(t, 12, defaultJurisdictions["IN"].AssetClass[domain.AssetClassEquity])
+
+	_, err = ParseHoldingPeriods("US")
+	assert.Error(t, err)
+}
+
+func TestGetTaxReport_SummaryAndForm8949(t *testing.T) {
+	mockRepo := new(MockTradeRepo)
+	service := NewTradeService(mockRepo)
+	ctx := context.Background()
+
+	start := time.Date(2023, 1, 10, 0, 0, 0, 0, time.UTC)
+	trades := []domain.Trade{
+		{ID: 1, Symbol: "BTC/USD", Type: "BUY", Price: d("100"), Quantity: d("10"), ExecutedAt: start},
+		{ID: 2, Symbol: "BTC/USD", Type: "BUY", Price: d("200"), Quantity: d("10"), ExecutedAt: start.AddDate(0, 6, 0)},
+		{ID: 3, Symbol: "BTC/USD", Type: "SELL", Price: d("150.555"), Quantity: d("15"), ExecutedAt: start.AddDate(1, 1, 0)},
+	}
+	mockRepo.On("GetByUserID", ctx, uint(1)).Return(trades, nil)
+
+	report, err := service.GetTaxReport(ctx, 1, "", "")
+	require.NoError(t, err)
+	require.Len(t, report.Disposals, 2)
+	assert.Equal(t, TermLong, report.Disposals[0].Term)
+	assert.Equal(t, TermShort, report.Disposals[1].Term)
+
+	require.Len(t, report.Summary, 1)
+	summary := report.Summary[0]
+	assert.Equal(t, "2024", summary.TaxYear)
+	assert.True(t, d("505.55").Equal(summary.LongTermGain), summary.LongTermGain.String())     // 10 * 150.555 - 1000
+	assert.True(t, d("-247.225").Equal(summary.ShortTermGain), summary.ShortTermGain.String()) // 5 * 150.555 - 1000
+	assert.True(t, d("258.325").Equal(summary.TotalGain))
+
+	report, err = service.GetTaxReport(ctx, 1, "US", "2023")
+	require.NoError(t, err)
+	assert.Empty(t, report.Disposals)
+
+	_, err = service.GetTaxReport(ctx, 1, "XX", "")
+	assert.ErrorIs(t, err, ErrUnknownJurisdiction)
+
+	report, err = service.GetTaxReport(ctx, 1, "US", "2024")
+	require.NoError(t, err)
+	var out bytes.Buffer
+	require.NoError(t, WriteForm8949(&out, report))
+	records, err := csv.NewReader(&out).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+	assert.Equal(t, form8949Header, records[0])
+	// short-term (Part I) rows come first
+	assert.Equal(t, []string{"I", "5 BTC", "07/10/2023", "02/10/2024", "752.78", "1000.00", "", "", "-247.23"}, records[1])
+	assert.Equal(t, []string{"II", "10 BTC", "01/10/2023", "02/10/2024", "1505.55", "1000.00", "", "", "505.55"}, records[2])
+}
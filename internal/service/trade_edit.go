@@ -0,0 +1,351 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/MonalBarse/tradelog/internal/domain"
+	"github.com/shopspring/decimal"
+)
+
+/*
+NOTE:
+	Corrections go through the same user lock as new trades. Every edit, delete
+	and restore replays the user's whole history with the change applied and is
+	rejected if any SELL in the timeline would then sell more than was held
+	(or close a lot that is no longer open).
+	Each change appends an immutable TradeRevision with full before/after snapshots.
+*/
+
+var ErrTradeNotFound = errors.New("trade not found")
+
+// TradePatch holds the fields of a trade correction, nil fields are left as they are
+type TradePatch struct {
+	Symbol     *string
+	Type       *string
+	Price      *decimal.Decimal
+	Quantity   *decimal.Decimal
+	Notes      *string
+	ExecutedAt *time.Time
+	Fees       *[]domain.TradeFee     // replaces all fee lines
+	Lots       *[]domain.LotSelection // replaces the lot selection of a SELL
+	Leverage   *decimal.Decimal       // the margin is recomputed from it
+	AccountID  *uint                  // moves the trade to another account, 0 for the default one
+}
+
+// @desc: correct a trade of the user
+// @flow: lock user -> load trade -> apply patch -> validate fields -> replay history with the correction -> save -> refresh positions -> record revision
+func (s *tradeService) UpdateTrade(ctx context.Context, userID, tradeID uint, patch TradePatch, reason string) (*domain.Trade, error) {
+	if s.revRepo == nil {
+		return nil, errors.New("trade history is not available")
+	}
+
+	var updated domain.Trade
+	err := s.repo.WithUserLock(ctx, userID, func(ctx context.Context) error {
+		trade, err := s.ownTrade(ctx, userID, tradeID, false)
+		if err != nil {
+			return err
+		}
+		if trade.Source == domain.TradeSourceIncome {
+			return ErrIncomeTrade
+		}
+		if trade.Source == domain.TradeSourceDerivative {
+			return ErrDerivativeTrade
+		}
+		before := *trade
+
+		updated = *trade
+		if err := s.applyPatch(ctx, &updated, patch); err != nil {
+			return err
+		}
+
+		trades, err := s.repo.GetByUserID(ctx, userID)
+		if err != nil {
+			return err
+		}
+		for i := range trades {
+			if trades[i].ID == tradeID {
+				trades[i] = updated
+			}
+		}
+		if err := s.checkTimeline(ctx, userID, trades); err != nil {
+			return err
+		}
+
+		if err := s.repo.Update(ctx, &updated); err != nil {
+			return err
+		}
+		if err := s.refreshPositions(ctx, userID, trades); err != nil {
+			return err
+		}
+		return s.recordRevision(ctx, tradeID, userID, domain.RevisionUpdate, reason, &before, &updated)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+// @desc: soft delete a trade of the user, refused if later SELLs depend on it
+func (s *tradeService) DeleteTrade(ctx context.Context, userID, tradeID uint, reason string) error {
+	if s.revRepo == nil {
+		return errors.New("trade history is not available")
+	}
+
+	return s.repo.WithUserLock(ctx, userID, func(ctx context.Context) error {
+		trade, err := s.ownTrade(ctx, userID, tradeID, false)
+		if err != nil {
+			return err
+		}
+		if trade.Source == domain.TradeSourceIncome {
+			return ErrIncomeTrade
+		}
+		if trade.Source == domain.TradeSourceDerivative {
+			return ErrDerivativeTrade
+		}
+
+		trades, err := s.repo.GetByUserID(ctx, userID)
+		if err != nil {
+			return err
+		}
+		kept := make([]domain.Trade, 0, len(trades))
+		for _, t := range trades {
+			if t.ID != tradeID {
+				kept = append(kept, t)
+			}
+		}
+		if err := s.checkTimeline(ctx, userID, kept); err != nil {
+			return err
+		}
+
+		if err := s.repo.Delete(ctx, tradeID); err != nil {
+			return err
+		}
+		if err := s.refreshPositions(ctx, userID, kept); err != nil {
+			return err
+		}
+		return s.recordRevision(ctx, tradeID, userID, domain.RevisionDelete, reason, trade, nil)
+	})
+}
+
+// @desc: bring back a soft-deleted trade of the user, refused if it no longer fits the timeline
+func (s *tradeService) RestoreTrade(ctx context.Context, userID, tradeID uint, reason string) (*domain.Trade, error) {
+	if s.revRepo == nil {
+		return nil, errors.New("trade history is not available")
+	}
+
+	var restored *domain.Trade
+	err := s.repo.WithUserLock(ctx, userID, func(ctx context.Context) error {
+		trade, err := s.ownTrade(ctx, userID, tradeID, true)
+		if err != nil {
+			return err
+		}
+		if !trade.DeletedAt.Valid {
+			return errors.New("trade is not deleted")
+		}
+
+		trades, err := s.repo.GetByUserID(ctx, userID)
+		if err != nil {
+			return err
+		}
+		trades = append(trades, *trade)
+		if err := s.checkTimeline(ctx, userID, trades); err != nil {
+			return err
+		}
+
+		if err := s.repo.Restore(ctx, tradeID); err != nil {
+			return err
+		}
+		if err := s.refreshPositions(ctx, userID, trades); err != nil {
+			return err
+		}
+		trade.DeletedAt.Valid = false
+		restored = trade
+		return s.recordRevision(ctx, tradeID, userID, domain.RevisionRestore, reason, nil, trade)
+	})
+	return restored, err
+}
+
+// @desc: every recorded change of a trade of the user, deleted trades included
+func (s *tradeService) GetTradeHistory(ctx context.Context, userID, tradeID uint) ([]domain.TradeRevision, error) {
+	if s.revRepo == nil {
+		return nil, errors.New("trade history is not available")
+	}
+	if _, err := s.ownTrade(ctx, userID, tradeID, true); err != nil {
+		return nil, err
+	}
+	return s.revRepo.GetByTradeID(ctx, tradeID)
+}
+
+// ownTrade loads a trade and hides trades of other users behind ErrTradeNotFound
+func (s *tradeService) ownTrade(ctx context.Context, userID, tradeID uint, withDeleted bool) (*domain.Trade, error) {
+	trade, err := s.repo.GetByID(ctx, tradeID, withDeleted)
+	if err != nil {
+		return nil, err
+	}
+	if trade == nil || trade.UserID != userID {
+		return nil, ErrTradeNotFound
+	}
+	return trade, nil
+}
+
+// @desc: apply the patch and run the same field checks as CreateTrade
+func (s *tradeService) applyPatch(ctx context.Context, trade *domain.Trade, patch TradePatch) error {
+	if patch.Symbol != nil {
+		trade.Symbol = domain.NormalizeSymbol(*patch.Symbol)
+	}
+	if patch.AccountID != nil {
+		if err := s.checkAccount(ctx, trade.UserID, accountRef(*patch.AccountID)); err != nil {
+			return err
+		}
+		trade.AccountID = accountRef(*patch.AccountID)
+	}
+	if patch.Type != nil {
+		if *patch.Type != "BUY" && *patch.Type != "SELL" {
+			return errors.New("type must be BUY or SELL")
+		}
+		trade.Type = *patch.Type
+	}
+	if patch.Price != nil {
+		trade.Price = *patch.Price
+	}
+	if patch.Quantity != nil {
+		trade.Quantity = *patch.Quantity
+	}
+	if patch.Notes != nil {
+		trade.Notes = *patch.Notes
+	}
+	if patch.ExecutedAt != nil {
+		if patch.ExecutedAt.After(time.Now()) {
+			return errors.New("executed_at cannot be in the future")
+		}
+		trade.ExecutedAt = *patch.ExecutedAt
+	}
+	if patch.Fees != nil {
+		trade.Fees = *patch.Fees
+	}
+	if patch.Lots != nil {
+		trade.LotSelections = *patch.Lots
+	}
+	if patch.Leverage != nil {
+		trade.Leverage = *patch.Leverage
+	}
+	if trade.Leverage.IsZero() {
+		trade.Leverage = decimal.NewFromInt(1)
+	}
+
+	if !trade.Quantity.IsPositive() {
+		return errors.New("quantity must be positive")
+	}
+	if !trade.Price.IsPositive() {
+		return errors.New("price must be positive")
+	}
+	if trade.Leverage.LessThan(decimal.NewFromInt(1)) {
+		return errors.New("leverage must be at least 1")
+	}
+	if trade.Type == "BUY" {
+		if patch.Lots != nil && len(*patch.Lots) > 0 {
+			return errors.New("lots can only be selected for SELL trades")
+		}
+		trade.LotSelections = nil
+	}
+
+	if s.instRepo != nil {
+		instrument, err := resolveInstrument(ctx, s.instRepo, trade.Symbol)
+		if err != nil {
+			return err
+		}
+		if err := validateAgainstInstrument(instrument, trade.Price, trade.Quantity); err != nil {
+			return err
+		}
+		if err := validateLeverage(instrument, trade.Leverage); err != nil {
+			return err
+		}
+		if err := validateExpiry(instrument, trade.ExecutedAt); err != nil {
+			return err
+		}
+		if patch.Symbol != nil {
+			trade.Multiplier = instrument.ContractMultiplier()
+		}
+		trade.Symbol = instrument.Symbol
+	}
+	trade.Margin = tradeMargin(trade.Notional(), trade.Leverage)
+
+	if err := normalizeFees(trade.Symbol, trade.Type, trade.Quantity, trade.Fees); err != nil {
+		return err
+	}
+
+	if len(trade.LotSelections) > 0 {
+		total := decimal.Zero
+		for _, sel := range trade.LotSelections {
+			if !sel.Quantity.IsPositive() {
+				return errors.New("selected lot quantity must be positive")
+			}
+			total = total.Add(sel.Quantity)
+		}
+		if !total.Equal(trade.Quantity) {
+			return errors.New("selected lots must add up to the trade quantity")
+		}
+	}
+	return nil
+}
+
+// @desc: replay the user's history and reject it if any SELL oversells (unless short selling is on) or closes a lot that is not open
+func (s *tradeService) checkTimeline(ctx context.Context, userID uint, trades []domain.Trade) error {
+	settings, err := s.GetSettings(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if trades, err = s.adjustTrades(ctx, trades, time.Now()); err != nil {
+		return err
+	}
+	allowShort, err := s.shortPolicy(ctx, userID, settings)
+	if err != nil {
+		return err
+	}
+	if _, err := replayLots(trades, settings.CostMethod, allowShort); err != nil {
+		return timelineError(err)
+	}
+	return nil
+}
+
+func timelineError(err error) error {
+	return fmt.Errorf("change rejected, the trade history would no longer add up: %w", err)
+}
+
+// refreshPositions recomputes the stored positions of the user from the corrected history
+func (s *tradeService) refreshPositions(ctx context.Context, userID uint, trades []domain.Trade) error {
+	if s.posRepo == nil {
+		return nil
+	}
+	trades, err := s.adjustTrades(ctx, trades, time.Now())
+	if err != nil {
+		return err
+	}
+	return s.posRepo.ReplaceForUser(ctx, userID, buildPositions(userID, trades))
+}
+
+// @desc: append an immutable revision with JSON snapshots of the trade before and after the change
+func (s *tradeService) recordRevision(ctx context.Context, tradeID, changedBy uint, action, reason string, before, after *domain.Trade) error {
+	if s.revRepo == nil {
+		return nil
+	}
+	revision := &domain.TradeRevision{TradeID: tradeID, Action: action, ChangedBy: changedBy, Reason: reason}
+	for _, snap := range []struct {
+		trade *domain.Trade
+		dst   *string
+	}{{before, &revision.Before}, {after, &revision.After}} {
+		if snap.trade == nil {
+			continue
+		}
+		raw, err := json.Marshal(snap.trade)
+		if err != nil {
+			return err
+		}
+		*snap.dst = string(raw)
+	}
+	return s.revRepo.Create(ctx, revision)
+}
@@ -0,0 +1,118 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/MonalBarse/tradelog/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+type MockRevisionRepo struct {
+	mock.Mock
+}
+
+func (m *MockRevisionRepo) Create(ctx context.Context, revision *domain.TradeRevision) error {
+	return m.Called(ctx, revision).Error(0)
+}
+
+func (m *MockRevisionRepo) GetByTradeID(ctx context.Context, tradeID uint) ([]domain.TradeRevision, error) {
+	args := m.Called(ctx, tradeID)
+	return args.Get(0).([]domain.TradeRevision), args.Error(1)
+}
+
+// userHistory is lotHistory owned by user 1
+func userHistory() []domain.Trade {
+	trades := lotHistory()
+	for i := range trades {
+		trades[i].UserID = 1
+	}
+	return trades
+}
+
+func TestUpdateTrade_RecordsRevision(t *testing.T) {
+	tradeRepo, revRepo := new(MockTradeRepo), new(MockRevisionRepo)
+	service := NewTradeService(tradeRepo, WithRevisionRepository(revRepo))
+	ctx := context.Background()
+
+	buy := userHistory()[0]
+	tradeRepo.On("GetByID", ctx, uint(1), false).Return(&buy, nil)
+	tradeRepo.On("GetByUserID", ctx, uint(1)).Return(userHistory(), nil)
+	tradeRepo.On("Update", ctx, mock.MatchedBy(func(trade *domain.Trade) bool { return trade.Price.Equal(d("110")) })).Return(nil)
+	revRepo.On("Create", ctx, mock.MatchedBy(func(r *domain.TradeRevision) bool {
+		return r.Action == domain.RevisionUpdate && r.ChangedBy == 1 && r.Reason == "typo" &&
+			strings.Contains(r.Before, `"price":"100"`) && strings.Contains(r.After, `"price":"110"`)
+	})).Return(nil)
+
+	price := d("110")
+	trade, err := service.UpdateTrade(ctx, 1, 1, TradePatch{Price: &price}, "typo")
+	require.NoError(t, err)
+	assert.True(t, d("110").Equal(trade.Price))
+	tradeRepo.AssertExpectations(t)
+	revRepo.AssertExpectations(t)
+}
+
+func TestUpdateTrade_RejectsCorrectionThatOversellsLater(t *testing.T) {
+	tradeRepo, revRepo := new(MockTradeRepo), new(MockRevisionRepo)
+	service := NewTradeService(tradeRepo, WithRevisionRepository(revRepo))
+	ctx := context.Background()
+
+	buy := userHistory()[0]
+	tradeRepo.On("GetByID", ctx, uint(1), false).Return(&buy, nil)
+	tradeRepo.On("GetByUserID", ctx, uint(1)).Return(userHistory(), nil)
+
+	// 4 + 10 bought would leave the later SELL of 15 short
+	qty := d("4")
+	_, err := service.UpdateTrade(ctx, 1, 1, TradePatch{Quantity: &qty}, "partial fill")
+	assert.ErrorContains(t, err, "trade 3 sells 15 BTC/USD but only 14 is open")
+	tradeRepo.AssertNotCalled(t, "Update", mock.Anything, mock.Anything)
+	revRepo.AssertNotCalled(t, "Create", mock.Anything, mock.Anything)
+}
+
+func TestDeleteTrade_ChecksLaterSells(t *testing.T) {
+	tradeRepo, revRepo := new(MockTradeRepo), new(MockRevisionRepo)
+	service := NewTradeService(tradeRepo, WithRevisionRepository(revRepo))
+	ctx := context.Background()
+
+	history := userHistory()
+	tradeRepo.On("GetByID", ctx, uint(2), false).Return(&history[1], nil)
+	tradeRepo.On("GetByID", ctx, uint(3), false).Return(&history[2], nil)
+	tradeRepo.On("GetByUserID", ctx, uint(1)).Return(history, nil)
+	tradeRepo.On("Delete", ctx, uint(3)).Return(nil)
+	revRepo.On("Create", ctx, mock.MatchedBy(func(r *domain.TradeRevision) bool {
+		return r.Action == domain.RevisionDelete && r.After == "" && r.Before != ""
+	})).Return(nil)
+
+	assert.Error(t, service.DeleteTrade(ctx, 1, 2, "duplicate")) // the SELL needs this BUY
+	require.NoError(t, service.DeleteTrade(ctx, 1, 3, "never happened"))
+	tradeRepo.AssertNotCalled(t, "Delete", ctx, uint(2))
+}
+
+func TestRestoreTrade(t *testing.T) {
+	tradeRepo, revRepo := new(MockTradeRepo), new(MockRevisionRepo)
+	service := NewTradeService(tradeRepo, WithRevisionRepository(revRepo))
+	ctx := context.Background()
+
+	history := userHistory()
+	deleted := history[2]
+	deleted.DeletedAt = gorm.DeletedAt{Valid: true}
+	tradeRepo.On("GetByID", ctx, uint(3), true).Return(&deleted, nil)
+	tradeRepo.On("GetByID", ctx, uint(1), true).Return(&history[0], nil)
+	tradeRepo.On("GetByUserID", ctx, uint(1)).Return(history[:2], nil)
+	tradeRepo.On("Restore", ctx, uint(3)).Return(nil)
+	revRepo.On("Create", ctx, mock.Anything).Return(nil)
+
+	_, err := service.RestoreTrade(ctx, 1, 1, "oops")
+	assert.EqualError(t, err, "trade is not deleted")
+
+	trade, err := service.RestoreTrade(ctx, 1, 3, "was real after all")
+	require.NoError(t, err)
+	assert.False(t, trade.DeletedAt.Valid)
+
+	_, err = service.RestoreTrade(ctx, 2, 3, "not mine")
+	assert.ErrorIs(t, err, ErrTradeNotFound)
+}
@@ -0,0 +1,396 @@
+package service
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/MonalBarse/tradelog/internal/domain"
+	"github.com/shopspring/decimal"
+)
+
+/*
+NOTE:
+	An import is one transaction under the user lock. Rows are sorted by
+	execution time and go through the checks of CreateTrade, each row seeing
+	the rows before it: the history is loaded once, buying power is kept as a
+	running balance and the history plus the rows is replayed once, so a file
+	of MaxImportRows costs about as much as one backdated trade. A stored SELL
+	the rows leave short fails the last row before it in that symbol and account.
+	The rows are inserted in batches and the positions rebuilt once.
+	Every failing row is reported; if there is any, or the import is a dry run,
+	nothing is stored.
+	Rows carrying an external (broker) trade id that the user already has from the
+	same source, or that appear twice in the file, are skipped, not failed, so the
+	same statement can be imported again safely.
+*/
+
+// MaxImportRows caps the size of one import file
+const MaxImportRows = 10000
+
+// ImportSourceCSV is the source of trades imported through a column mapping
+const ImportSourceCSV = "csv"
+
+// errRollback aborts the import transaction on purpose, it never leaves this file
+var errRollback = errors.New("import rolled back")
+
+// ImportMapping names the CSV header of every trade field, empty names fall back to the field name itself
+type ImportMapping struct {
+	Symbol      string `json:"symbol"`
+	Type        string `json:"type"`
+	Price       string `json:"price"`
+	Quantity    string `json:"quantity"`
+	ExecutedAt  string `json:"executed_at"`
+	Notes       string `json:"notes"`
+	Fee         string `json:"fee"`          // optional commission column
+	FeeCurrency string `json:"fee_currency"` // optional, defaults to the quote currency
+	ExternalID  string `json:"external_id"`  // optional trade id column, used to skip rows already imported
+
+	TimeLayout string         `json:"time_layout"` // Go layout for executed_at, RFC3339 and the parseTimestamp formats by default
+	Location   *time.Location `json:"-"`           // zone of timestamps without an offset, UTC by default
+}
+
+// ImportRow is one parsed CSV line, Input.AccountID is the account the row is booked into
+type ImportRow struct {
+	Line  int
+	Input TradeInput
+}
+
+// ImportRowError ties a problem to the CSV line it came from
+type ImportRowError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// ImportReport is the outcome of an import or dry run
+type ImportReport struct {
+	DryRun     bool             `json:"dry_run"`
+	Rows       int              `json:"rows"`
+	Imported   int              `json:"imported"`   // 0 unless the import was committed
+	Duplicates int              `json:"duplicates"` // rows skipped because their external id is already stored
+	Errors     []ImportRowError `json:"errors"`
+}
+
+// @desc: read trades from CSV with the header mapped by m, rows that don't parse are reported by line
+func ParseTradeCSV(r io.Reader, m ImportMapping) ([]ImportRow, []ImportRowError, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading header: %w", err)
+	}
+	cols := make(map[string]int, len(header))
+	for i, name := range header {
+		cols[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	column := func(mapped, fallback string) string {
+		if mapped == "" {
+			mapped = fallback
+		}
+		return strings.ToLower(strings.TrimSpace(mapped))
+	}
+	names := map[string]string{
+		"symbol":       column(m.Symbol, "symbol"),
+		"type":         column(m.Type, "type"),
+		"price":        column(m.Price, "price"),
+		"quantity":     column(m.Quantity, "quantity"),
+		"executed_at":  column(m.ExecutedAt, "executed_at"),
+		"notes":        column(m.Notes, "notes"),
+		"fee":          column(m.Fee, "fee"),
+		"fee_currency": column(m.FeeCurrency, "fee_currency"),
+		"external_id":  column(m.ExternalID, "external_id"),
+	}
+	for _, required := range []string{"symbol", "type", "price", "quantity", "executed_at"} {
+		if _, ok := cols[names[required]]; !ok {
+			return nil, nil, fmt.Errorf("missing column %q for %s", names[required], required)
+		}
+	}
+
+	var rows []ImportRow
+	var rowErrors []ImportRowError
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if len(rows)+len(rowErrors) == MaxImportRows {
+			return nil, nil, fmt.Errorf("more than %d rows, split the file", MaxImportRows)
+		}
+
+		field := func(name string) string {
+			if i, ok := cols[names[name]]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		input, err := parseImportRecord(field, m)
+		if err != nil {
+			rowErrors = append(rowErrors, ImportRowError{Line: line, Error: err.Error()})
+			continue
+		}
+		rows = append(rows, ImportRow{Line: line, Input: input})
+	}
+	return rows, rowErrors, nil
+}
+
+func parseImportRecord(field func(string) string, m ImportMapping) (TradeInput, error) {
+	input := TradeInput{Symbol: field("symbol"), Notes: field("notes"), Source: ImportSourceCSV, ExternalID: field("external_id")}
+
+	switch strings.ToUpper(field("type")) {
+	case "BUY", "B":
+		input.Type = "BUY"
+	case "SELL", "S":
+		input.Type = "SELL"
+	default:
+		return input, fmt.Errorf("type %q must be BUY or SELL", field("type"))
+	}
+
+	var err error
+	if input.Price, err = decimal.NewFromString(field("price")); err != nil {
+		return input, fmt.Errorf("invalid price %q", field("price"))
+	}
+	if input.Quantity, err = decimal.NewFromString(field("quantity")); err != nil {
+		return input, fmt.Errorf("invalid quantity %q", field("quantity"))
+	}
+
+	executedAt, err := parseImportTime(field("executed_at"), m)
+	if err != nil {
+		return input, err
+	}
+	input.ExecutedAt = &executedAt
+
+	if raw := field("fee"); raw != "" {
+		amount, err := decimal.NewFromString(raw)
+		if err != nil {
+			return input, fmt.Errorf("invalid fee %q", raw)
+		}
+		if !amount.IsZero() {
+			input.Fees = []domain.TradeFee{{Type: domain.FeeTypeCommission, Amount: amount, Currency: field("fee_currency")}}
+		}
+	}
+	return input, nil
+}
+
+// parseImportTime uses the mapping's layout, or RFC3339 and the price ingest formats, in the mapping's zone
+func parseImportTime(value string, m ImportMapping) (time.Time, error) {
+	loc := m.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	if m.TimeLayout != "" {
+		t, err := time.ParseInLocation(m.TimeLayout, value, loc)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("executed_at %q does not match layout %q", value, m.TimeLayout)
+		}
+		return t, nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid executed_at %q", value)
+}
+
+// @desc: validate and store imported rows all or nothing
+// @flow: sort rows by time -> lock user -> skip known external ids -> prepare + check each row -> replay history plus rows once -> stop on any error or dry run -> insert in batches -> rebuild positions
+func (s *tradeService) ImportTrades(ctx context.Context, userID uint, rows []ImportRow, dryRun bool) (*ImportReport, error) {
+	sorted := make([]ImportRow, len(rows))
+	copy(sorted, rows)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Input.ExecutedAt.Before(*sorted[j].Input.ExecutedAt)
+	})
+
+	report := &ImportReport{DryRun: dryRun, Rows: len(rows), Errors: []ImportRowError{}}
+	err := s.repo.WithUserLock(ctx, userID, func(ctx context.Context) error {
+		known, err := s.knownExternalIDs(ctx, userID, sorted)
+		if err != nil {
+			return err
+		}
+		history, err := s.repo.GetByUserID(ctx, userID)
+		if err != nil {
+			return err
+		}
+		balances, err := s.buyingPower(ctx, userID)
+		if err != nil {
+			return err
+		}
+		accounts := make(map[uint]error) // statements name few accounts, each is looked up once
+
+		var pending []importedTrade
+		for _, row := range sorted {
+			input := row.Input
+			if input.ExternalID != "" {
+				key := input.Source + "\x00" + input.ExternalID
+				if known[key] {
+					report.Duplicates++
+					continue
+				}
+				known[key] = true // a second copy further down the file is a duplicate too
+			}
+			err := s.prepareInput(ctx, &input)
+			if err == nil {
+				err = s.checkImportRow(ctx, userID, input, balances, accounts)
+			}
+			if err != nil {
+				report.Errors = append(report.Errors, ImportRowError{Line: row.Line, Error: err.Error()})
+				continue
+			}
+			trade := newTrade(userID, input)
+			if balances != nil {
+				for currency, amount := range cashBalances(tradeCashEntries(*trade)) {
+					balances[currency] = balances[currency].Add(amount)
+				}
+			}
+			pending = append(pending, importedTrade{line: row.Line, trade: trade})
+		}
+
+		timelineErrors, err := s.checkImportTimeline(ctx, userID, history, pending)
+		if err != nil {
+			return err
+		}
+		report.Errors = append(report.Errors, timelineErrors...)
+		if len(report.Errors) > 0 || dryRun {
+			return errRollback
+		}
+
+		trades := make([]*domain.Trade, len(pending))
+		for i := range pending {
+			trades[i] = pending[i].trade
+		}
+		if err := s.repo.CreateBatch(ctx, trades); err != nil {
+			return err
+		}
+		for _, t := range trades {
+			if err := s.recordRevision(ctx, t.ID, userID, domain.RevisionCreate, "", nil, t); err != nil {
+				return err
+			}
+			history = append(history, *t)
+		}
+		return s.refreshPositions(ctx, userID, history)
+	})
+	if err != nil && !errors.Is(err, errRollback) {
+		return nil, err
+	}
+
+	sort.Slice(report.Errors, func(i, j int) bool { return report.Errors[i].Line < report.Errors[j].Line })
+	if err == nil {
+		report.Imported = len(rows) - report.Duplicates
+	}
+	return report, nil
+}
+
+// importedTrade is a checked row waiting to be stored
+type importedTrade struct {
+	line  int
+	trade *domain.Trade
+}
+
+// checkImportRow runs the checks of checkAndCreate that do not need the timeline, balances is the running buying power or nil
+// and accounts the outcome of the account checks so far
+func (s *tradeService) checkImportRow(ctx context.Context, userID uint, input TradeInput, balances map[string]decimal.Decimal, accounts map[uint]error) error {
+	if input.AccountID != nil {
+		err, checked := accounts[*input.AccountID]
+		if !checked {
+			err = s.checkAccount(ctx, userID, input.AccountID)
+			accounts[*input.AccountID] = err
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if input.Type == "BUY" && balances != nil {
+		if err := checkCashNeeded(balances, input); err != nil {
+			return err
+		}
+	}
+	if input.Type == "SELL" && len(input.Lots) > 0 {
+		return s.validateLotSelection(ctx, userID, input, *input.ExecutedAt)
+	}
+	return nil
+}
+
+// @desc: replay the history with the imported trades once, like checkTimeline, and tie every failure to a row
+func (s *tradeService) checkImportTimeline(ctx context.Context, userID uint, history []domain.Trade, pending []importedTrade) ([]ImportRowError, error) {
+	settings, err := s.GetSettings(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// lines[i] is the line of trades[i], 0 for a stored trade; the new trades keep ID 0 and replay after stored ones at the same instant
+	trades := make([]domain.Trade, 0, len(history)+len(pending))
+	trades = append(trades, history...)
+	lines := make([]int, len(history), len(history)+len(pending))
+	for _, p := range pending {
+		trades = append(trades, *p.trade)
+		lines = append(lines, p.line)
+	}
+	if trades, err = s.adjustTrades(ctx, trades, time.Now()); err != nil {
+		return nil, err
+	}
+	order := make([]int, len(trades))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return tradeBefore(trades[order[i]], trades[order[j]]) })
+
+	var rowErrors []ImportRowError
+	allowShort, err := s.shortPolicy(ctx, userID, settings)
+	if err != nil {
+		return nil, err
+	}
+	book := newLotBook(settings.CostMethod, allowShort)
+	lastRow := make(map[string]int) // account and symbol -> line of the last row replayed
+	for _, i := range order {
+		t := trades[i]
+		key := fmt.Sprintf("%d\x00%s", t.AccountKey(), t.Symbol)
+		err := book.apply(t)
+		switch {
+		case err == nil:
+			if lines[i] > 0 {
+				lastRow[key] = lines[i]
+			}
+			continue
+		case lines[i] > 0:
+			rowErrors = append(rowErrors, ImportRowError{Line: lines[i], Error: timelineError(err).Error()})
+		case lastRow[key] > 0:
+			rowErrors = append(rowErrors, ImportRowError{Line: lastRow[key], Error: timelineError(err).Error()})
+		default:
+			return nil, timelineError(err) // the stored history does not add up on its own
+		}
+	}
+	return rowErrors, nil
+}
+
+// knownExternalIDs looks up the external ids of rows already stored, keyed by source and id
+func (s *tradeService) knownExternalIDs(ctx context.Context, userID uint, rows []ImportRow) (map[string]bool, error) {
+	bySource := make(map[string][]string)
+	for _, row := range rows {
+		if row.Input.ExternalID != "" {
+			bySource[row.Input.Source] = append(bySource[row.Input.Source], row.Input.ExternalID)
+		}
+	}
+
+	known := make(map[string]bool)
+	for source, ids := range bySource {
+		found, err := s.repo.GetExternalIDs(ctx, userID, source, ids)
+		if err != nil {
+			return nil, err
+		}
+		for id := range found {
+			known[source+"\x00"+id] = true
+		}
+	}
+	return known, nil
+}
@@ -0,0 +1,126 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MonalBarse/tradelog/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTradeCSV_MappingAndTimezone(t *testing.T) {
+	csv := "Date,Ticker,Side,Qty,Px,Commission\n" +
+		"2024-03-01 10:00:00,btc-usd,B,2,100,1.5\n" +
+		"2024-03-02 10:00:00,BTC/USD,HOLD,1,100,0\n" +
+		"2024-03-03 10:00:00,BTC/USD,S,abc,100,0\n"
+	loc, _ := time.LoadLocation("Asia/Kolkata")
+
+	rows, rowErrors, err := ParseTradeCSV(strings.NewReader(csv), ImportMapping{
+		Symbol: "Ticker", Type: "Side", Quantity: "Qty", Price: "Px", ExecutedAt: "Date", Fee: "Commission", Location: loc,
+	})
+	require.NoError(t, err)
+
+	require.Len(t, rows, 1)
+	assert.Equal(t, 2, rows[0].Line)
+	assert.Equal(t, "BUY", rows[0].Input.Type)
+	assert.Equal(t, "2024-03-01T04:30:00Z", rows[0].Input.ExecutedAt.UTC().Format(time.RFC3339))
+	require.Len(t, rows[0].Input.Fees, 1)
+	assert.True(t, d("1.5").Equal(rows[0].Input.Fees[0].Amount))
+
+	assert.Equal(t, []ImportRowError{
+		{Line: 3, Error: `type "HOLD" must be BUY or SELL`},
+		{Line: 4, Error: `invalid quantity "abc"`},
+	}, rowErrors)
+
+	_, _, err = ParseTradeCSV(strings.NewReader("symbol,type,price\n"), ImportMapping{})
+	assert.ErrorContains(t, err, `missing column "quantity"`)
+}
+
+func TestImportTrades_ChronologicalAndAllOrNothing(t *testing.T) {
+	mem := &memTradeRepo{}
+	service := NewTradeService(mem)
+	ctx := context.Background()
+
+	at := func(day int) *time.Time {
+		t := time.Date(2024, 1, day, 0, 0, 0, 0, time.UTC)
+		return &t
+	}
+	rows := []ImportRow{
+		{Line: 2, Input: TradeInput{Symbol: "BTC/USD", Type: "SELL", Price: d("120"), Quantity: d("3"), ExecutedAt: at(5)}},
+		{Line: 3, Input: TradeInput{Symbol: "BTC/USD", Type: "BUY", Price: d("100"), Quantity: d("5"), ExecutedAt: at(1)}},
+		{Line: 4, Input: TradeInput{Symbol: "BTC/USD", Type: "SELL", Price: d("130"), Quantity: d("3"), ExecutedAt: at(6)}},
+	}
+
+	// the file order SELL before BUY is fine, but the second SELL oversells
+	report, err := service.ImportTrades(ctx, 1, rows, false)
+	require.NoError(t, err)
+	assert.Equal(t, 0, report.Imported)
+	require.Len(t, report.Errors, 1)
+	assert.Equal(t, 4, report.Errors[0].Line)
+	assert.Empty(t, mem.trades)
+
+	rows[2].Input.Quantity = d("2")
+	report, err = service.ImportTrades(ctx, 1, rows, false)
+	require.NoError(t, err)
+	assert.Empty(t, report.Errors)
+	assert.Equal(t, 3, report.Imported)
+	assert.Len(t, mem.trades, 3)
+}
+
+func TestImportTrades_DryRunStoresNothing(t *testing.T) {
+	mockRepo := new(MockTradeRepo)
+	service := NewTradeService(mockRepo)
+	ctx := context.Background()
+
+	executedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	mockRepo.On("GetByUserID", ctx, uint(1)).Return([]domain.Trade{}, nil)
+	mockRepo.On("Create", ctx, mock.Anything).Return(nil)
+
+	report, err := service.ImportTrades(ctx, 1, []ImportRow{
+		{Line: 2, Input: TradeInput{Symbol: "BTC/USD", Type: "BUY", Price: d("100"), Quantity: d("1"), ExecutedAt: &executedAt}},
+	}, true)
+	require.NoError(t, err)
+	assert.True(t, report.DryRun)
+	assert.Equal(t, 0, report.Imported)
+	assert.Empty(t, report.Errors)
+}
+
+func TestImportTrades_ReplaysHistoryOnceAndBlamesTheRow(t *testing.T) {
+	mockRepo := new(MockTradeRepo)
+	service := NewTradeService(mockRepo)
+	ctx := context.Background()
+
+	day := func(d int) *time.Time {
+		t := time.Date(2024, 1, d, 0, 0, 0, 0, time.UTC)
+		return &t
+	}
+	mockRepo.On("GetByUserID", ctx, uint(1)).Return([]domain.Trade{
+		{ID: 1, UserID: 1, Symbol: "BTC/USD", Type: "BUY", Price: d("100"), Quantity: d("5"), ExecutedAt: *day(1)},
+		{ID: 2, UserID: 1, Symbol: "BTC/USD", Type: "SELL", Price: d("110"), Quantity: d("4"), ExecutedAt: *day(10)},
+	}, nil)
+	mockRepo.On("CreateBatch", ctx, mock.Anything).Return(nil)
+
+	// the imported SELL fits on its own but leaves the stored SELL of day 10 short
+	rows := []ImportRow{
+		{Line: 2, Input: TradeInput{Symbol: "BTC/USD", Type: "BUY", Price: d("90"), Quantity: d("1"), ExecutedAt: day(2)}},
+		{Line: 3, Input: TradeInput{Symbol: "BTC/USD", Type: "SELL", Price: d("105"), Quantity: d("3"), ExecutedAt: day(5)}},
+	}
+	report, err := service.ImportTrades(ctx, 1, rows, false)
+	require.NoError(t, err)
+	require.Len(t, report.Errors, 1)
+	assert.Equal(t, 3, report.Errors[0].Line)
+	assert.Contains(t, report.Errors[0].Error, "trade 2 sells 4")
+	mockRepo.AssertNotCalled(t, "CreateBatch", ctx, mock.Anything)
+
+	rows[1].Input.Quantity = d("2")
+	report, err = service.ImportTrades(ctx, 1, rows, false)
+	require.NoError(t, err)
+	assert.Empty(t, report.Errors)
+	assert.Equal(t, 2, report.Imported)
+	mockRepo.AssertNumberOfCalls(t, "GetByUserID", 2) // once per import
+	mockRepo.AssertNumberOfCalls(t, "CreateBatch", 1)
+}
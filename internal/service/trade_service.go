@@ -3,6 +3,9 @@ package service
 import (
 	"context"
 	"errors"
+	"fmt"
+	"io"
+	"strings"
 	"time"
 
 	"github.com/MonalBarse/tradelog/internal/domain"
@@ -10,115 +13,808 @@ import (
 	"github.com/shopspring/decimal"
 )
 
+// Sides of a portfolio item
+const (
+	PositionLong  = "LONG"
+	PositionShort = "SHORT"
+)
+
 // PortfolioItem represents the user's holding of a specific asset
+// A short position has negative quantity, cost basis and value, its average cost is the price it was sold at.
+// Futures and options are counted in contracts, their average cost is per contract and value is quantity * price * multiplier.
 type PortfolioItem struct {
-	Symbol   string          `json:"symbol"`
-	Quantity decimal.Decimal `json:"quantity"`
-	Value    decimal.Decimal `json:"value"` // Current market value (we'll just use last price for now)
+	Symbol      string          `json:"symbol"`
+	Side        string          `json:"side"`
+	Quantity    decimal.Decimal `json:"quantity"`
+	AverageCost decimal.Decimal `json:"average_cost"`
+	CostBasis   decimal.Decimal `json:"cost_basis"`
+	Price       decimal.Decimal `json:"price"`
+	PriceAsOf   *time.Time      `json:"price_as_of"`
+	PriceSource string          `json:"price_source,omitempty"`
+	PriceStale  bool            `json:"price_stale"`           // quote is older than the configured max age
+	PriceError  string          `json:"price_error,omitempty"` // set when no quote could be fetched, value stays zero
+	Value       decimal.Decimal `json:"value"`                 // Current market value (quantity * price * multiplier)
+	Multiplier  decimal.Decimal `json:"multiplier"`            // contract size, 1 for spot
+	Currency    string          `json:"currency"`              // quote currency of the symbol, cost and value are in it
+
+	BaseCurrency  string          `json:"base_currency"`
+	FXRate        decimal.Decimal `json:"fx_rate"` // 1 Currency = FXRate BaseCurrency
+	BaseCostBasis decimal.Decimal `json:"base_cost_basis"`
+	BaseValue     decimal.Decimal `json:"base_value"`
+	FXError       string          `json:"fx_error,omitempty"` // set when no rate was found, base amounts stay zero
+
+	BorrowFees *decimal.Decimal `json:"borrow_fees,omitempty"` // shorts only, fees charged since the oldest open short lot, in Currency
+	Margin     *MarginStatus    `json:"margin,omitempty"`      // leveraged positions only
+
+	Derivative *DerivativeExposure `json:"derivative,omitempty"` // futures and options only
+
+	Accounts []AccountHolding `json:"accounts,omitempty"` // consolidated view only, what each account holds of the item
+
+	Lots []Lot `json:"lots"`
+}
+
+// PortfolioSummary is the consolidated value of all holdings in the user's base currency
+type PortfolioSummary struct {
+	BaseCurrency   string          `json:"base_currency"`
+	TotalCostBasis decimal.Decimal `json:"total_cost_basis"`
+	TotalValue     decimal.Decimal `json:"total_value"`
+	Unconverted    []string        `json:"unconverted,omitempty"` // symbols left out because no FX rate was found
+	AtRisk         []string        `json:"at_risk,omitempty"`     // leveraged positions close to or past liquidation
+	Expired        []string        `json:"expired,omitempty"`     // futures and options past their expiry, waiting for a derivative event
+	Accounts       []AccountTotal  `json:"accounts,omitempty"`    // consolidated view only, totals per account
+}
+
+const defaultBaseCurrency = "USD"
+
+// Page sizes of trade listings
+const (
+	DefaultTradePageSize = 50
+	MaxTradePageSize     = 500
+)
+
+// TradeFilter narrows, sorts and pages trade listings, see repository.TradeFilter
+type TradeFilter = repository.TradeFilter
+
+var (
+	ErrInvalidFilter = errors.New("invalid trade filter")
+	ErrInvalidCursor = repository.ErrInvalidCursor
+)
+
+// TradePage is one page of a trade listing
+type TradePage struct {
+	Trades     []domain.Trade `json:"data"`
+	Total      int64          `json:"total"`                 // trades matching the filter across all pages
+	NextCursor string         `json:"next_cursor,omitempty"` // empty on the last page
+}
+
+// TradeInput carries the user supplied fields of a new trade
+type TradeInput struct {
+	AccountID  *uint // optional, nil books the trade in the user's default account
+	Symbol     string
+	Type       string
+	Price      decimal.Decimal
+	Quantity   decimal.Decimal
+	Notes      string
+	ExecutedAt *time.Time            // optional, defaults to now; earlier times journal a past trade
+	Fees       []domain.TradeFee     // optional fee lines
+	Lots       []domain.LotSelection // optional, names the BUY lots a SELL closes
+	Leverage   decimal.Decimal       // optional, zero or 1 for a cash trade
+	Multiplier decimal.Decimal       // contract size, taken from the instrument registry
+	Source     string                // importer name, set by imports only
+	ExternalID string                // broker trade id, set by imports only
+}
+
+// UserSettings holds the per-user preferences that drive trade accounting
+type UserSettings struct {
+	CostMethod   string `json:"cost_method"`
+	StrictCash   bool   `json:"strict_cash"`   // BUYs must be covered by the cash balance of the quote currency
+	BaseCurrency string `json:"base_currency"` // reporting currency of portfolio and P&L totals
+	AllowShort   bool   `json:"allow_short"`   // in the default account a SELL beyond the holding opens a short position instead of being rejected, other accounts have their own flag
 }
 
 type TradeService interface {
-	CreateTrade(ctx context.Context, userID uint, symbol, tradeType string, price, quantity decimal.Decimal) error
-	GetUserTrades(ctx context.Context, userID uint) ([]domain.Trade, error)
-	GetAllTrades(ctx context.Context) ([]domain.Trade, error)
+	CreateTrade(ctx context.Context, userID uint, input TradeInput) error
+	ListTrades(ctx context.Context, filter TradeFilter) (*TradePage, error)
+	ImportTrades(ctx context.Context, userID uint, rows []ImportRow, dryRun bool) (*ImportReport, error)
+	ExportTrades(ctx context.Context, filter TradeFilter, format string, w io.Writer) error
+	UpdateTrade(ctx context.Context, userID, tradeID uint, patch TradePatch, reason string) (*domain.Trade, error)
+	DeleteTrade(ctx context.Context, userID, tradeID uint, reason string) error
+	RestoreTrade(ctx context.Context, userID, tradeID uint, reason string) (*domain.Trade, error)
+	GetTradeHistory(ctx context.Context, userID, tradeID uint) ([]domain.TradeRevision, error)
 	GetPortfolio(ctx context.Context, userID uint) ([]PortfolioItem, error)
+	GetPortfolioAt(ctx context.Context, userID uint, at time.Time) ([]PortfolioItem, error)
+	GetAccountPortfolio(ctx context.Context, userID, accountID uint, at *time.Time) ([]PortfolioItem, string, error)
+	ExportPortfolio(ctx context.Context, userID uint, at *time.Time, format string, w io.Writer) error
+	ExportJournal(ctx context.Context, userID uint, format string, w io.Writer) error
+	GetTaxReport(ctx context.Context, userID uint, jurisdiction string, taxYear string) (*TaxReport, error)
+	GetWashSales(ctx context.Context, userID uint) (*WashSaleReport, error)
+	RecordIncome(ctx context.Context, userID uint, input IncomeInput) (*domain.IncomeEvent, error)
+	ListIncome(ctx context.Context, userID uint) ([]domain.IncomeEvent, error)
+	GetIncomeSummary(ctx context.Context, userID uint, year int) (*IncomeSummary, error)
+	RecordBorrowFee(ctx context.Context, userID uint, input BorrowFeeInput) (*domain.BorrowFee, error)
+	ListBorrowFees(ctx context.Context, userID uint) ([]domain.BorrowFee, error)
+	SettleDerivative(ctx context.Context, userID uint, input DerivativeEventInput) (*domain.DerivativeEvent, error)
+	ListDerivativeEvents(ctx context.Context, userID uint) ([]domain.DerivativeEvent, error)
+	GetSettings(ctx context.Context, userID uint) (*UserSettings, error)
+	UpdateSettings(ctx context.Context, userID uint, settings UserSettings) error
+	GetPnL(ctx context.Context, userID uint, marks map[string]decimal.Decimal, period string) (*PnLReport, error)
+	SetMarkPrice(ctx context.Context, userID uint, symbol string, price decimal.Decimal) error
 }
 
 type tradeService struct {
-	repo repository.TradeRepository
+	repo       repository.TradeRepository
+	userRepo   repository.UserRepository
+	markRepo   repository.MarkPriceRepository
+	cashRepo   repository.CashRepository
+	fxRepo     repository.FXRepository
+	instRepo   repository.InstrumentRepository
+	posRepo    repository.PositionRepository
+	revRepo    repository.RevisionRepository
+	actionRepo repository.CorporateActionRepository
+	incomeRepo repository.IncomeRepository
+	borrowRepo repository.BorrowFeeRepository
+	derivRepo  repository.DerivativeEventRepository
+	acctRepo   repository.AccountRepository
+	prices     PriceProvider
+	history    HistoricalPriceProvider
+	maxAge     time.Duration // quotes older than this are flagged stale
+
+	marginWarning decimal.Decimal // margin ratio from which leveraged positions are flagged
+
+	jurisdictions map[string]TaxJurisdiction // holding period overrides for tax reports
+}
+
+// TradeServiceOption wires an optional collaborator into the trade service
+type TradeServiceOption func(*tradeService)
+
+// WithUserRepository enables per-user settings such as the cost method
+func WithUserRepository(userRepo repository.UserRepository) TradeServiceOption {
+	return func(s *tradeService) {
+		s.userRepo = userRepo
+	}
+}
+
+// WithCashRepository enables buying power checks for users in strict cash mode
+func WithCashRepository(cashRepo repository.CashRepository) TradeServiceOption {
+	return func(s *tradeService) {
+		s.cashRepo = cashRepo
+	}
+}
+
+// WithFXRepository converts portfolio and P&L figures into the user's base currency
+func WithFXRepository(fxRepo repository.FXRepository) TradeServiceOption {
+	return func(s *tradeService) {
+		s.fxRepo = fxRepo
+	}
 }
 
-func NewTradeService(repo repository.TradeRepository) TradeService {
-	return &tradeService{repo}
+// WithInstrumentRepository validates new trades against the instrument registry and stores their canonical symbol
+func WithInstrumentRepository(instRepo repository.InstrumentRepository) TradeServiceOption {
+	return func(s *tradeService) {
+		s.instRepo = instRepo
+	}
+}
+
+// WithPositionRepository keeps the positions table up to date with every trade and reads holdings from it
+func WithPositionRepository(posRepo repository.PositionRepository) TradeServiceOption {
+	return func(s *tradeService) {
+		s.posRepo = posRepo
+	}
+}
+
+// WithRevisionRepository enables editing, deleting and restoring trades, every change is recorded as a revision
+func WithRevisionRepository(revRepo repository.RevisionRepository) TradeServiceOption {
+	return func(s *tradeService) {
+		s.revRepo = revRepo
+	}
+}
+
+// WithPriceProvider values portfolio positions at market, quotes older than maxAge are flagged stale
+func WithPriceProvider(prices PriceProvider, maxAge time.Duration) TradeServiceOption {
+	return func(s *tradeService) {
+		s.prices = prices
+		s.maxAge = maxAge
+	}
+}
+
+// WithHistoricalPrices values portfolios at past dates from the stored price history
+func WithHistoricalPrices(history HistoricalPriceProvider) TradeServiceOption {
+	return func(s *tradeService) {
+		s.history = history
+	}
+}
+
+func NewTradeService(repo repository.TradeRepository, opts ...TradeServiceOption) TradeService {
+	s := &tradeService{repo: repo}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
 // @desc: create trade
-// @flow: validate SELL -> check funds -> create trade record
-func (s *tradeService) CreateTrade(ctx context.Context, userID uint, symbol, tradeType string, price, quantity decimal.Decimal) error {
-	if quantity.LessThanOrEqual(decimal.Zero) { // quantity <= 0
+// @flow: resolve instrument -> validate fees -> lock user -> check buying power for BUY -> validate SELL -> check funds (fees included) -> check lot selection -> create trade record
+// Everything that reads the user's balances runs under the user lock together with the insert, so two
+// concurrent SELLs can't both pass the balance check.
+func (s *tradeService) CreateTrade(ctx context.Context, userID uint, input TradeInput) error {
+	if err := s.prepareInput(ctx, &input); err != nil {
+		return err
+	}
+
+	return s.repo.WithUserLock(ctx, userID, func(ctx context.Context) error {
+		return s.checkAndCreate(ctx, userID, input)
+	})
+}
+
+// prepareInput runs the checks that don't depend on the user's holdings and normalizes symbol and fees
+func (s *tradeService) prepareInput(ctx context.Context, input *TradeInput) error {
+	if input.Quantity.LessThanOrEqual(decimal.Zero) { // quantity <= 0
 		return errors.New("quantity must be positive")
 	}
 
-	if price.LessThanOrEqual(decimal.Zero) {
+	if input.Price.LessThanOrEqual(decimal.Zero) {
 		return errors.New("price must be positive")
 	}
 
-	if tradeType == "SELL" {
-		currentBalance, err := s.calculatePosition(ctx, userID, symbol)
+	if input.Leverage.IsZero() {
+		input.Leverage = decimal.NewFromInt(1)
+	}
+	if input.Leverage.LessThan(decimal.NewFromInt(1)) {
+		return errors.New("leverage must be at least 1")
+	}
+
+	input.Symbol = domain.NormalizeSymbol(input.Symbol)
+	if s.instRepo != nil {
+		instrument, err := resolveInstrument(ctx, s.instRepo, input.Symbol)
 		if err != nil {
 			return err
 		}
+		if err := validateAgainstInstrument(instrument, input.Price, input.Quantity); err != nil {
+			return err
+		}
+		if err := validateLeverage(instrument, input.Leverage); err != nil {
+			return err
+		}
+		executedAt := time.Now()
+		if input.ExecutedAt != nil {
+			executedAt = *input.ExecutedAt
+		}
+		if err := validateExpiry(instrument, executedAt); err != nil {
+			return err
+		}
+		input.Symbol = instrument.Symbol
+		input.Multiplier = instrument.ContractMultiplier()
+	}
+
+	if len(input.Lots) > 0 && input.Type != "SELL" {
+		return errors.New("lots can only be selected for SELL trades")
+	}
 
-		if currentBalance.LessThan(quantity) { // currentBalance < quantity
+	if input.ExecutedAt != nil && input.ExecutedAt.After(time.Now()) {
+		return errors.New("executed_at cannot be in the future")
+	}
+
+	return normalizeFees(input.Symbol, input.Type, input.Quantity, input.Fees)
+}
+
+// checkAndCreate validates the trade against the user's holdings and stores it, callers hold the user lock.
+// A trade stamped now only has to fit the current position. A backdated trade is checked against the
+// position at its execution time and must not leave any later SELL short, so the whole timeline is replayed.
+func (s *tradeService) checkAndCreate(ctx context.Context, userID uint, input TradeInput) error {
+	if err := s.checkAccount(ctx, userID, input.AccountID); err != nil {
+		return err
+	}
+
+	trade := newTrade(userID, input)
+	backdated := input.ExecutedAt != nil
+
+	if input.Type == "BUY" {
+		if err := s.checkBuyingPower(ctx, userID, input); err != nil {
+			return err
+		}
+	}
+
+	settings, err := s.GetSettings(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	allowShort := settings.AllowShort
+	if input.Type == "SELL" && input.AccountID != nil {
+		account, err := s.ownAccount(ctx, userID, *input.AccountID)
+		if err != nil {
+			return err
+		}
+		allowShort = account.AllowShort
+	}
+
+	if input.Type == "SELL" && !backdated && !allowShort {
+		currentBalance, err := s.calculatePosition(ctx, userID, trade.AccountKey(), input.Symbol)
+		if err != nil {
+			return err
+		}
+
+		// fees paid in the base asset leave the position too
+		required := positionDelta(domain.Trade{Symbol: input.Symbol, Type: input.Type, Quantity: input.Quantity, Fees: input.Fees}).Neg()
+		if currentBalance.LessThan(required) { // currentBalance < quantity
 			return errors.New("insufficient funds: you cannot sell more than you own")
 		}
+
+	}
+
+	if input.Type == "SELL" && len(input.Lots) > 0 {
+		if err := s.validateLotSelection(ctx, userID, input, trade.ExecutedAt); err != nil {
+			return err
+		}
 	}
 
+	var history []domain.Trade
+	if backdated {
+		if history, err = s.repo.GetByUserID(ctx, userID); err != nil {
+			return err
+		}
+		if err := s.checkTimeline(ctx, userID, append(history, *trade)); err != nil {
+			return err
+		}
+	}
+
+	if err := s.repo.Create(ctx, trade); err != nil {
+		return err
+	}
+	if backdated {
+		// folding a past trade in at the end would get the average cost wrong, replay instead
+		if err := s.refreshPositions(ctx, userID, append(history, *trade)); err != nil {
+			return err
+		}
+	} else if err := s.updatePosition(ctx, *trade); err != nil {
+		return err
+	}
+	return s.recordRevision(ctx, trade.ID, userID, domain.RevisionCreate, "", nil, trade)
+}
+
+// newTrade builds the trade of a prepared input, executed now unless the input is backdated
+func newTrade(userID uint, input TradeInput) *domain.Trade {
 	trade := &domain.Trade{
-		UserID:     userID,
-		Symbol:     symbol,
-		Type:       tradeType,
-		Price:      price,
-		Quantity:   quantity,
-		ExecutedAt: time.Now(),
+		UserID:        userID,
+		AccountID:     input.AccountID,
+		Symbol:        input.Symbol,
+		Type:          input.Type,
+		Price:         input.Price,
+		Quantity:      input.Quantity,
+		Notes:         input.Notes,
+		Fees:          input.Fees,
+		LotSelections: input.Lots,
+		Leverage:      input.Leverage,
+		Multiplier:    input.Multiplier,
+		Source:        input.Source,
+		ExternalID:    input.ExternalID,
+		ExecutedAt:    time.Now(),
+	}
+	if trade.Multiplier.IsZero() {
+		trade.Multiplier = decimal.NewFromInt(1)
 	}
-	return s.repo.Create(ctx, trade)
+	trade.Margin = tradeMargin(trade.Notional(), trade.Leverage)
+	if input.ExecutedAt != nil {
+		trade.ExecutedAt = *input.ExecutedAt
+	}
+	return trade
+}
+
+// @desc: fold a new trade into the stored position, runs in the transaction that created the trade
+func (s *tradeService) updatePosition(ctx context.Context, trade domain.Trade) error {
+	if s.posRepo == nil {
+		return nil
+	}
+	position, err := s.posRepo.Get(ctx, trade.UserID, trade.AccountKey(), trade.Symbol)
+	if err != nil {
+		return err
+	}
+	if position == nil {
+		position = &domain.Position{UserID: trade.UserID, AccountID: trade.AccountKey(), Symbol: trade.Symbol}
+	}
+	applyToPosition(position, trade)
+	return s.posRepo.Upsert(ctx, position)
 }
 
-func (s *tradeService) GetUserTrades(ctx context.Context, userID uint) ([]domain.Trade, error) {
-	return s.repo.GetByUserID(ctx, userID)
+// @desc: make sure the selected lots are open BUY lots of the symbol in the account at the time of the SELL and cover all of it
+func (s *tradeService) validateLotSelection(ctx context.Context, userID uint, input TradeInput, at time.Time) error {
+	trades, err := s.userTrades(ctx, userID)
+	if err != nil {
+		return err
+	}
+	trades = tradesUntil(trades, at)
+
+	method, err := s.costMethod(ctx, userID)
+	if err != nil {
+		return err
+	}
+	book, err := matchLotsWithShorts(trades, method)
+	if err != nil {
+		return err
+	}
+
+	total := decimal.Zero
+	selected := make(map[uint]decimal.Decimal)
+	for _, sel := range input.Lots {
+		if !sel.Quantity.IsPositive() {
+			return errors.New("selected lot quantity must be positive")
+		}
+		selected[sel.LotTradeID] = selected[sel.LotTradeID].Add(sel.Quantity)
+		total = total.Add(sel.Quantity)
+	}
+	if !total.Equal(input.Quantity) {
+		return errors.New("selected lots must add up to the trade quantity")
+	}
+
+	for lotID, qty := range selected {
+		lot := findLot(accountLots(book.open[input.Symbol], accountKey(input.AccountID)), lotID)
+		if lot == nil {
+			return fmt.Errorf("lot %d is not an open %s lot", lotID, input.Symbol)
+		}
+		if lot.Quantity.LessThan(qty) {
+			return fmt.Errorf("lot %d only has %s open", lotID, lot.Quantity)
+		}
+	}
+	return nil
 }
 
-func (s *tradeService) GetAllTrades(ctx context.Context) ([]domain.Trade, error) {
-	return s.repo.GetAll(ctx)
+// @desc: one page of trades matching the filter plus the total count
+// @flow: clamp page size -> fetch one row more than asked -> the extra row means there is a next page -> count
+func (s *tradeService) ListTrades(ctx context.Context, filter TradeFilter) (*TradePage, error) {
+	if filter.Limit <= 0 {
+		filter.Limit = DefaultTradePageSize
+	}
+	if filter.Limit > MaxTradePageSize {
+		filter.Limit = MaxTradePageSize
+	}
+	if filter.SortBy != "" && !repository.IsValidTradeSort(filter.SortBy) {
+		return nil, fmt.Errorf("%w: unsupported sort %q", ErrInvalidFilter, filter.SortBy)
+	}
+	if filter.Symbol != "" {
+		filter.Symbol = domain.NormalizeSymbol(filter.Symbol)
+	}
+
+	size := filter.Limit
+	filter.Limit = size + 1
+	trades, err := s.repo.List(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	filter.Limit = size
+
+	page := &TradePage{Trades: trades}
+	if len(trades) > size {
+		page.Trades = trades[:size]
+		page.NextCursor = repository.NextTradeCursor(filter, page.Trades[size-1])
+	}
+	if page.Trades == nil {
+		page.Trades = []domain.Trade{}
+	}
+
+	if page.Total, err = s.repo.Count(ctx, filter); err != nil {
+		return nil, err
+	}
+	return page, nil
 }
 
 // @desc: get portfolio for user
-// @flow: get trades -> aggregate by symbol -> return holdings
+// @flow: get trades -> match lots with the user's cost method -> value open lots at market
 func (s *tradeService) GetPortfolio(ctx context.Context, userID uint) ([]PortfolioItem, error) {
-	trades, err := s.repo.GetByUserID(ctx, userID)
+	return s.portfolio(ctx, userID, nil, nil)
+}
+
+// @desc: get portfolio as it stood at a past moment, valued with the price history
+func (s *tradeService) GetPortfolioAt(ctx context.Context, userID uint, at time.Time) ([]PortfolioItem, error) {
+	return s.portfolio(ctx, userID, nil, &at)
+}
+
+// portfolio replays trades up to at (nil means now) and values the open lots, of one account or consolidated
+// across all of them when accountID is nil
+func (s *tradeService) portfolio(ctx context.Context, userID uint, accountID *uint, at *time.Time) ([]PortfolioItem, error) {
+	trades, err := s.portfolioTrades(ctx, userID, at)
+	if err != nil {
+		return nil, err
+	}
+	reference := time.Now()
+	if at != nil {
+		trades = tradesUntil(trades, *at)
+		reference = *at
+	}
+	if trades, err = s.adjustTrades(ctx, trades, reference); err != nil {
+		return nil, err
+	}
+	fills := newLastFills(trades)
+	if accountID != nil {
+		trades = accountTrades(trades, *accountID)
+	}
+
+	settings, err := s.GetSettings(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	baseCurrency, err := s.accountBaseCurrency(ctx, userID, accountID, settings.BaseCurrency)
+	if err != nil {
+		return nil, err
+	}
+
+	book, err := matchLotsWithShorts(trades, settings.CostMethod)
+	if err != nil {
+		return nil, err
+	}
+
+	conv := newFXConverter(s.fxRepo)
+	portfolio := book.holdings()
+	if err := s.addBorrowFees(ctx, userID, portfolio, reference); err != nil {
+		return nil, err
+	}
+	for i := range portfolio {
+		s.valueItem(ctx, &portfolio[i], at, fills)
+		convertItem(ctx, conv, &portfolio[i], baseCurrency, reference)
+		if accountID == nil {
+			addAccountHoldings(&portfolio[i])
+		}
+	}
+	if err := s.addMarginStatus(ctx, portfolio); err != nil {
+		return nil, err
+	}
+	if err := s.addDerivativeExposure(ctx, portfolio, at); err != nil {
+		return nil, err
+	}
+	return portfolio, nil
+}
+
+// portfolioTrades loads the trades needed to build the lots, with the positions table only symbols still held
+// (long or short) now and the symbols they were called before a rename
+func (s *tradeService) portfolioTrades(ctx context.Context, userID uint, at *time.Time) ([]domain.Trade, error) {
+	if s.posRepo == nil || at != nil {
+		return s.repo.GetByUserID(ctx, userID)
+	}
+
+	positions, err := s.posRepo.GetByUserID(ctx, userID)
 	if err != nil {
 		return nil, err
 	}
+	var open []string
+	for _, p := range positions {
+		if !p.Quantity.IsZero() {
+			open = append(open, p.Symbol)
+		}
+	}
+	actions, err := s.corporateActions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.repo.GetByUserIDAndSymbols(ctx, userID, formerSymbols(actions, open))
+}
+
+// @desc: express cost and value of an item in the base currency
+func convertItem(ctx context.Context, conv *fxConverter, item *PortfolioItem, baseCurrency string, at time.Time) {
+	_, item.Currency = domain.SplitSymbol(item.Symbol)
+	item.BaseCurrency = baseCurrency
+
+	rate, err := conv.Rate(ctx, item.Currency, baseCurrency, at)
+	if err != nil {
+		item.FXError = err.Error()
+		return
+	}
+	item.FXRate = rate
+	item.BaseCostBasis = item.CostBasis.Mul(rate)
+	item.BaseValue = item.Value.Mul(rate)
+}
 
-	holdings := make(map[string]decimal.Decimal)
+// SummarizePortfolio totals the base currency amounts of converted items
+func SummarizePortfolio(items []PortfolioItem, baseCurrency string) PortfolioSummary {
+	summary := PortfolioSummary{BaseCurrency: baseCurrency, TotalCostBasis: decimal.Zero, TotalValue: decimal.Zero}
+	for _, item := range items {
+		if item.Margin.atRisk() {
+			summary.AtRisk = append(summary.AtRisk, item.Symbol)
+		}
+		if item.Derivative != nil && item.Derivative.Expired {
+			summary.Expired = append(summary.Expired, item.Symbol)
+		}
+		if item.FXError != "" {
+			summary.Unconverted = append(summary.Unconverted, item.Symbol)
+			continue
+		}
+		summary.TotalCostBasis = summary.TotalCostBasis.Add(item.BaseCostBasis)
+		summary.TotalValue = summary.TotalValue.Add(item.BaseValue)
+		summary.Accounts = addAccountTotals(summary.Accounts, item)
+	}
+	return summary
+}
 
+// @desc: fill price and value of a portfolio item, a failed quote is reported on the item instead of failing the portfolio
+func (s *tradeService) valueItem(ctx context.Context, item *PortfolioItem, at *time.Time, fills lastFills) {
+	quote, err := s.quote(ctx, item.Symbol, at)
+	if quote == nil && err == nil {
+		return // no price source configured
+	}
+	if err != nil {
+		if fill, ok := fills[item.Symbol]; ok {
+			quote, err = &fill, nil
+		}
+	}
+	if err != nil {
+		item.PriceError = err.Error()
+		return
+	}
+
+	reference := time.Now()
+	if at != nil {
+		reference = *at
+	}
+
+	asOf := quote.AsOf
+	item.Price = quote.Price
+	item.PriceAsOf = &asOf
+	item.PriceSource = quote.Source
+	item.PriceStale = s.maxAge > 0 && reference.Sub(quote.AsOf) > s.maxAge
+	item.Value = item.Quantity.Mul(quote.Price).Mul(item.Multiplier)
+}
+
+// quote asks the live provider, or the price history when at is set; nil, nil means no source is configured
+func (s *tradeService) quote(ctx context.Context, symbol string, at *time.Time) (*Quote, error) {
+	if at != nil {
+		if s.history == nil {
+			return nil, nil
+		}
+		return s.history.GetQuoteAt(ctx, symbol, *at)
+	}
+	if s.prices == nil {
+		return nil, nil
+	}
+	return s.prices.GetQuote(ctx, symbol)
+}
+
+// lastFills prices each symbol at the latest fill among one user's own trades, the quote of last resort when
+// no price source knows the symbol. It is built per request so one user's fills never price another's holdings.
+type lastFills map[string]Quote
+
+func newLastFills(trades []domain.Trade) lastFills {
+	fills := make(lastFills)
 	for _, t := range trades {
-		if t.Type == "BUY" {
-			holdings[t.Symbol] = holdings[t.Symbol].Add(t.Quantity)
-		} else {
-			holdings[t.Symbol] = holdings[t.Symbol].Sub(t.Quantity)
+		if last, ok := fills[t.Symbol]; ok && t.ExecutedAt.Before(last.AsOf) {
+			continue
 		}
+		fills[t.Symbol] = Quote{Symbol: t.Symbol, Price: t.Price, AsOf: t.ExecutedAt, Source: PriceSourceLastTrade}
 	}
+	return fills
+}
 
-	var portfolio []PortfolioItem
-	for symbol, qty := range holdings {
-		if qty.GreaterThan(decimal.Zero) { // qty > 0
-			portfolio = append(portfolio, PortfolioItem{
-				Symbol:   symbol,
-				Quantity: qty,
-				Value:    decimal.Zero, // Placeholder
-			})
+// tradesUntil keeps the trades executed at or before at
+func tradesUntil(trades []domain.Trade, at time.Time) []domain.Trade {
+	var kept []domain.Trade
+	for _, t := range trades {
+		if !t.ExecutedAt.After(at) {
+			kept = append(kept, t)
 		}
 	}
+	return kept
+}
 
-	return portfolio, nil
+func (s *tradeService) GetSettings(ctx context.Context, userID uint) (*UserSettings, error) {
+	if s.userRepo == nil {
+		return &UserSettings{CostMethod: domain.CostMethodFIFO, BaseCurrency: defaultBaseCurrency}, nil
+	}
+
+	user, err := s.userRepo.FindByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	settings := &UserSettings{CostMethod: user.CostMethod, StrictCash: user.StrictCash, BaseCurrency: user.BaseCurrency, AllowShort: user.AllowShort}
+	if settings.CostMethod == "" {
+		settings.CostMethod = domain.CostMethodFIFO
+	}
+	if settings.BaseCurrency == "" {
+		settings.BaseCurrency = defaultBaseCurrency
+	}
+	return settings, nil
 }
 
-func (s *tradeService) calculatePosition(ctx context.Context, userID uint, symbol string) (decimal.Decimal, error) {
+// @desc: update per-user settings
+// @flow: validate -> load user -> save
+func (s *tradeService) UpdateSettings(ctx context.Context, userID uint, settings UserSettings) error {
+	if !IsValidCostMethod(settings.CostMethod) {
+		return fmt.Errorf("unsupported cost method %q", settings.CostMethod)
+	}
+	settings.BaseCurrency = strings.ToUpper(strings.TrimSpace(settings.BaseCurrency))
+	if settings.BaseCurrency == "" {
+		return errors.New("base currency is required")
+	}
+	if s.userRepo == nil {
+		return errors.New("user settings are not available")
+	}
+
+	user, err := s.userRepo.FindByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	user.CostMethod = settings.CostMethod
+	user.StrictCash = settings.StrictCash
+	user.BaseCurrency = settings.BaseCurrency
+	user.AllowShort = settings.AllowShort
+	return s.userRepo.Update(ctx, user)
+}
+
+func (s *tradeService) costMethod(ctx context.Context, userID uint) (string, error) {
+	settings, err := s.GetSettings(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	return settings.CostMethod, nil
+}
+
+// @desc: in strict cash mode a BUY (plus its cash fees) must be covered by the balance of the quote currency
+func (s *tradeService) checkBuyingPower(ctx context.Context, userID uint, input TradeInput) error {
+	balances, err := s.buyingPower(ctx, userID)
+	if err != nil || balances == nil {
+		return err
+	}
+	return checkCashNeeded(balances, input)
+}
+
+// buyingPower is the cash balance per currency when strict cash is on, nil when buying power is not checked
+func (s *tradeService) buyingPower(ctx context.Context, userID uint) (map[string]decimal.Decimal, error) {
+	if s.cashRepo == nil {
+		return nil, nil
+	}
+	settings, err := s.GetSettings(ctx, userID)
+	if err != nil || !settings.StrictCash {
+		return nil, err
+	}
+
+	movements, err := s.cashRepo.GetByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
 	trades, err := s.repo.GetByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return cashBalances(buildCashLedger(movements, trades)), nil
+}
+
+// checkCashNeeded makes sure the balances can pay for a BUY
+func checkCashNeeded(balances map[string]decimal.Decimal, input TradeInput) error {
+	_, quote := domain.SplitSymbol(input.Symbol)
+	if quote == "" {
+		return fmt.Errorf("cannot check buying power: %s has no quote currency", input.Symbol)
+	}
+
+	// the trade's own cash entries say exactly what it would take out of each currency,
+	// on margin only the margin is needed, the rest is borrowed
+	pending := domain.Trade{Symbol: input.Symbol, Type: input.Type, Price: input.Price, Quantity: input.Quantity, Multiplier: input.Multiplier, Fees: input.Fees}
+	pending.Margin = tradeMargin(pending.Notional(), input.Leverage)
+	required := cashBalances(tradeCashEntries(pending))
+	for currency, amount := range required {
+		if balances[currency].Add(amount).IsNegative() {
+			return fmt.Errorf("insufficient buying power: %s balance is %s, trade needs %s", currency, balances[currency], amount.Neg())
+		}
+	}
+	return nil
+}
+
+// calculatePosition is the quantity of symbol held in an account (0 for the default one)
+func (s *tradeService) calculatePosition(ctx context.Context, userID, accountID uint, symbol string) (decimal.Decimal, error) {
+	if s.posRepo != nil {
+		position, err := s.posRepo.Get(ctx, userID, accountID, symbol)
+		if err != nil || position == nil {
+			return decimal.Zero, err
+		}
+		return position.Quantity, nil
+	}
+
+	trades, err := s.userTrades(ctx, userID)
 	if err != nil {
 		return decimal.Zero, err
 	}
 
 	balance := decimal.Zero // Initialize 0
 	for _, t := range trades {
-		if t.Symbol == symbol {
-			if t.Type == "BUY" {
-				balance = balance.Add(t.Quantity) // +
-			} else if t.Type == "SELL" {
-				balance = balance.Sub(t.Quantity) // -
-			}
+		if t.Symbol == symbol && t.AccountKey() == accountID {
+			balance = balance.Add(positionDelta(t)) // + for BUY, - for SELL, net of base asset fees
 		}
 	}
 	return balance, nil
@@ -2,12 +2,17 @@ package service
 
 import (
 	"context"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/MonalBarse/tradelog/internal/domain"
+	"github.com/MonalBarse/tradelog/internal/repository"
 	"github.com/shopspring/decimal"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 // Mock Repository
@@ -20,13 +25,67 @@ func (m *MockTradeRepo) Create(ctx context.Context, trade *domain.Trade) error {
 	return args.Error(0)
 }
 
+func (m *MockTradeRepo) CreateBatch(ctx context.Context, trades []*domain.Trade) error {
+	return m.Called(ctx, trades).Error(0)
+}
+
+func (m *MockTradeRepo) GetByID(ctx context.Context, id uint, withDeleted bool) (*domain.Trade, error) {
+	args := m.Called(ctx, id, withDeleted)
+	trade, _ := args.Get(0).(*domain.Trade)
+	return trade, args.Error(1)
+}
+
+func (m *MockTradeRepo) Update(ctx context.Context, trade *domain.Trade) error {
+	return m.Called(ctx, trade).Error(0)
+}
+
+func (m *MockTradeRepo) Delete(ctx context.Context, id uint) error {
+	return m.Called(ctx, id).Error(0)
+}
+
+func (m *MockTradeRepo) Restore(ctx context.Context, id uint) error {
+	return m.Called(ctx, id).Error(0)
+}
+
 func (m *MockTradeRepo) GetByUserID(ctx context.Context, userID uint) ([]domain.Trade, error) {
 	args := m.Called(ctx, userID)
 	return args.Get(0).([]domain.Trade), args.Error(1)
 }
 
+func (m *MockTradeRepo) GetByUserIDAndSymbols(ctx context.Context, userID uint, symbols []string) ([]domain.Trade, error) {
+	args := m.Called(ctx, userID, symbols)
+	return args.Get(0).([]domain.Trade), args.Error(1)
+}
+
 func (m *MockTradeRepo) GetAll(ctx context.Context) ([]domain.Trade, error) {
-	return nil, nil
+	args := m.Called(ctx)
+	return args.Get(0).([]domain.Trade), args.Error(1)
+}
+
+func (m *MockTradeRepo) List(ctx context.Context, filter repository.TradeFilter) ([]domain.Trade, error) {
+	args := m.Called(ctx, filter)
+	return args.Get(0).([]domain.Trade), args.Error(1)
+}
+
+func (m *MockTradeRepo) Count(ctx context.Context, filter repository.TradeFilter) (int64, error) {
+	args := m.Called(ctx, filter)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockTradeRepo) GetExternalIDs(ctx context.Context, userID uint, source string, ids []string) (map[string]bool, error) {
+	args := m.Called(ctx, userID, source, ids)
+	found, _ := args.Get(0).(map[string]bool)
+	return found, args.Error(1)
+}
+
+func (m *MockTradeRepo) CountBySymbol(ctx context.Context, symbol string) (int64, error) {
+	args := m.Called(ctx, symbol)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+// WithUserLock runs fn straight away, the mock has no concurrency to guard against
+func (m *MockTradeRepo) WithUserLock(ctx context.Context, userID uint, fn func(ctx context.Context) error) error {
+	return fn(ctx)
 }
 
 func TestCreateTrade_InsufficientFunds(t *testing.T) {
@@ -41,10 +100,221 @@ func TestCreateTrade_InsufficientFunds(t *testing.T) {
 	}, nil)
 
 	// Attempt to Sell 20 BTC
-	err := service.CreateTrade(ctx, 1, "BTC/USD", "SELL", decimal.NewFromInt(50000), decimal.NewFromInt(20))
+	err := service.CreateTrade(ctx, 1, TradeInput{
+		Symbol:   "BTC/USD",
+		Type:     "SELL",
+		Price:    decimal.NewFromInt(50000),
+		Quantity: decimal.NewFromInt(20),
+	})
 
 	// Assert
 	assert.Error(t, err)
 	assert.Equal(t, "insufficient funds: you cannot sell more than you own", err.Error())
 	mockRepo.AssertExpectations(t)
 }
+
+// memTradeRepo is an in-memory TradeRepository with a real per-user lock that rolls back on error,
+// reads are slowed down to widen race windows
+type memTradeRepo struct {
+	mu     sync.Mutex
+	trades []domain.Trade
+	locks  sync.Map // userID -> *sync.Mutex
+}
+
+func (r *memTradeRepo) Create(ctx context.Context, trade *domain.Trade) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	trade.ID = uint(len(r.trades) + 1)
+	r.trades = append(r.trades, *trade)
+	return nil
+}
+
+func (r *memTradeRepo) CreateBatch(ctx context.Context, trades []*domain.Trade) error {
+	for _, trade := range trades {
+		if err := r.Create(ctx, trade); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *memTradeRepo) GetByID(ctx context.Context, id uint, withDeleted bool) (*domain.Trade, error) {
+	return nil, nil
+}
+
+func (r *memTradeRepo) Update(ctx context.Context, trade *domain.Trade) error { return nil }
+func (r *memTradeRepo) Delete(ctx context.Context, id uint) error             { return nil }
+func (r *memTradeRepo) Restore(ctx context.Context, id uint) error            { return nil }
+
+func (r *memTradeRepo) GetByUserID(ctx context.Context, userID uint) ([]domain.Trade, error) {
+	r.mu.Lock()
+	var trades []domain.Trade
+	for _, t := range r.trades {
+		if t.UserID == userID {
+			trades = append(trades, t)
+		}
+	}
+	r.mu.Unlock()
+	time.Sleep(time.Millisecond)
+	return trades, nil
+}
+
+func (r *memTradeRepo) GetByUserIDAndSymbols(ctx context.Context, userID uint, symbols []string) ([]domain.Trade, error) {
+	trades, _ := r.GetByUserID(ctx, userID)
+	var kept []domain.Trade
+	for _, t := range trades {
+		for _, symbol := range symbols {
+			if t.Symbol == symbol {
+				kept = append(kept, t)
+			}
+		}
+	}
+	return kept, nil
+}
+
+func (r *memTradeRepo) GetAll(ctx context.Context) ([]domain.Trade, error) {
+	return r.trades, nil
+}
+
+func (r *memTradeRepo) List(ctx context.Context, filter repository.TradeFilter) ([]domain.Trade, error) {
+	return nil, nil
+}
+
+func (r *memTradeRepo) Count(ctx context.Context, filter repository.TradeFilter) (int64, error) {
+	return 0, nil
+}
+
+func (r *memTradeRepo) GetExternalIDs(ctx context.Context, userID uint, source string, ids []string) (map[string]bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	found := make(map[string]bool)
+	for _, t := range r.trades {
+		for _, id := range ids {
+			if t.UserID == userID && t.Source == source && t.ExternalID == id {
+				found[id] = true
+			}
+		}
+	}
+	return found, nil
+}
+
+func (r *memTradeRepo) CountBySymbol(ctx context.Context, symbol string) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var total int64
+	for _, t := range r.trades {
+		if t.Symbol == symbol {
+			total++
+		}
+	}
+	return total, nil
+}
+
+func (r *memTradeRepo) WithUserLock(ctx context.Context, userID uint, fn func(ctx context.Context) error) error {
+	lock, _ := r.locks.LoadOrStore(userID, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	r.mu.Lock()
+	snapshot := append([]domain.Trade(nil), r.trades...)
+	r.mu.Unlock()
+
+	err := fn(ctx)
+	if err != nil {
+		r.mu.Lock()
+		r.trades = snapshot
+		r.mu.Unlock()
+	}
+	return err
+}
+
+// memTradeRepo locks with a mutex, so this checks that the balance check and the insert share one
+// WithUserLock; the Postgres advisory lock behind it is tested in the repository package
+func TestCreateTrade_ConcurrentSellsCannotOversell(t *testing.T) {
+	repo := &memTradeRepo{}
+	service := NewTradeService(repo)
+	ctx := context.Background()
+
+	require.NoError(t, service.CreateTrade(ctx, 1, TradeInput{Symbol: "BTC/USD", Type: "BUY", Price: d("100"), Quantity: d("10")}))
+
+	var wg sync.WaitGroup
+	var succeeded atomic.Int32
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if service.CreateTrade(ctx, 1, TradeInput{Symbol: "BTC/USD", Type: "SELL", Price: d("110"), Quantity: d("1")}) == nil {
+				succeeded.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(10), succeeded.Load())
+	portfolio, err := service.GetPortfolio(ctx, 1)
+	require.NoError(t, err)
+	assert.Empty(t, portfolio)
+}
+
+func TestListTrades_PagesWithCursor(t *testing.T) {
+	mockRepo := new(MockTradeRepo)
+	service := NewTradeService(mockRepo)
+	ctx := context.Background()
+
+	uid := uint(1)
+	mockRepo.On("List", ctx, mock.MatchedBy(func(f repository.TradeFilter) bool {
+		return f.Limit == 3 && f.Symbol == "BTC/USD" && *f.UserID == 1
+	})).Return(lotHistory(), nil)
+	mockRepo.On("Count", ctx, mock.Anything).Return(int64(7), nil)
+
+	page, err := service.ListTrades(ctx, TradeFilter{UserID: &uid, Symbol: "btc-usd", Limit: 2})
+	require.NoError(t, err)
+
+	assert.Len(t, page.Trades, 2)
+	assert.Equal(t, int64(7), page.Total)
+	assert.Equal(t, repository.NextTradeCursor(TradeFilter{Limit: 2}, lotHistory()[1]), page.NextCursor)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestListTrades_LastPageHasNoCursor(t *testing.T) {
+	mockRepo := new(MockTradeRepo)
+	service := NewTradeService(mockRepo)
+	ctx := context.Background()
+
+	mockRepo.On("List", ctx, mock.Anything).Return(lotHistory(), nil)
+	mockRepo.On("Count", ctx, mock.Anything).Return(int64(3), nil)
+
+	page, err := service.ListTrades(ctx, TradeFilter{SortBy: "price"})
+	require.NoError(t, err)
+	assert.Len(t, page.Trades, 3)
+	assert.Empty(t, page.NextCursor)
+
+	_, err = service.ListTrades(ctx, TradeFilter{SortBy: "user_id"})
+	assert.ErrorIs(t, err, ErrInvalidFilter)
+}
+
+func TestCreateTrade_BackdatedSellIsCheckedAgainstTimeline(t *testing.T) {
+	mockRepo := new(MockTradeRepo)
+	service := NewTradeService(mockRepo)
+	ctx := context.Background()
+
+	mockRepo.On("GetByUserID", ctx, uint(1)).Return(lotHistory(), nil)
+	start := lotHistory()[0].ExecutedAt
+	at := func(d time.Duration) *time.Time { t := start.Add(d); return &t }
+
+	// 5 BTC are held today, but nothing was held before the first BUY
+	err := service.CreateTrade(ctx, 1, TradeInput{Symbol: "BTC/USD", Type: "SELL", Price: d("90"), Quantity: d("1"), ExecutedAt: at(-time.Hour)})
+	assert.ErrorContains(t, err, "the new trade at 2023-12-31T23:00:00Z sells 1 BTC/USD but only 0 is open")
+
+	// 10 were held at that moment, but the SELL of 15 two hours later would come up short
+	err = service.CreateTrade(ctx, 1, TradeInput{Symbol: "BTC/USD", Type: "SELL", Price: d("150"), Quantity: d("8"), ExecutedAt: at(30 * time.Minute)})
+	assert.ErrorContains(t, err, "trade 3 sells 15 BTC/USD but only 12 is open")
+
+	err = service.CreateTrade(ctx, 1, TradeInput{Symbol: "BTC/USD", Type: "SELL", Price: d("1"), Quantity: d("1"), ExecutedAt: at(24 * 365 * 100 * time.Hour)})
+	assert.EqualError(t, err, "executed_at cannot be in the future")
+
+	mockRepo.On("Create", ctx, mock.MatchedBy(func(trade *domain.Trade) bool { return trade.ExecutedAt.Equal(start.Add(30 * time.Minute)) })).Return(nil)
+	err = service.CreateTrade(ctx, 1, TradeInput{Symbol: "BTC/USD", Type: "SELL", Price: d("150"), Quantity: d("5"), ExecutedAt: at(30 * time.Minute)})
+	require.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+}
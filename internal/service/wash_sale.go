@@ -0,0 +1,247 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/MonalBarse/tradelog/internal/domain"
+	"github.com/shopspring/decimal"
+)
+
+/*
+NOTE:
+	US rule: a loss is disallowed when substantially identical shares are bought
+	within 30 days before or after the sale. The disallowed loss is not lost, it is
+	added to the cost basis of the replacement shares, and the holding period of
+	the sold shares is tacked on to theirs.
+
+	The analyzer walks the closed lots in the order they were closed:
+	- a lot closed from a replacement BUY first picks up the basis and holding
+	  period carried to it (split into pieces when only part of the BUY was a
+	  replacement), so a replacement that is sold at a loss again can wash again
+	- a lot closed at a loss looks for BUYs of the same symbol in the window,
+	  earliest first, other than the BUY the lot came from; only shares of a BUY
+	  that are still held at the loss sale and carry no basis yet can replace,
+	  so shares sold before it (or by the same SELL) never pick up a loss that
+	  would then be lost, and every bought share replaces at most one sold share
+	Covered short sales are left alone, the short sale rules are not modelled.
+	Amounts stay in the quote currency of the symbol.
+*/
+
+const washSaleWindow = 30 * 24 * time.Hour
+
+// WashSale is one washed disposal: the loss on Quantity shares moves to the replacement BUY
+type WashSale struct {
+	Symbol                string          `json:"symbol"`
+	Currency              string          `json:"currency"`
+	CloseTradeID          uint            `json:"close_trade_id"` // the SELL at a loss
+	OpenTradeID           uint            `json:"open_trade_id"`  // the BUY of the shares sold
+	DisposedAt            time.Time       `json:"disposed_at"`
+	Quantity              decimal.Decimal `json:"quantity"` // shares washed
+	Loss                  decimal.Decimal `json:"loss"`     // loss of the washed shares, negative
+	DisallowedLoss        decimal.Decimal `json:"disallowed_loss"`
+	ReplacementTradeID    uint            `json:"replacement_trade_id"`
+	ReplacementAcquiredAt time.Time       `json:"replacement_acquired_at"`
+	AdjustedAcquiredAt    time.Time       `json:"adjusted_acquired_at"` // start of the replacement's holding period
+	BasisAdjustment       decimal.Decimal `json:"basis_adjustment"`     // added to the replacement's cost basis
+}
+
+// WashSaleReport lists the wash sales of a user with the disallowed loss per symbol
+type WashSaleReport struct {
+	CostMethod string                     `json:"cost_method"`
+	WashSales  []WashSale                 `json:"wash_sales"`
+	Disallowed map[string]decimal.Decimal `json:"disallowed"` // by symbol, in its quote currency
+}
+
+// adjustedLot is a closed lot, or a piece of one, after wash-sale adjustments
+type adjustedLot struct {
+	ClosedLot
+	Disallowed      decimal.Decimal // loss of this disposal that may not be claimed
+	BasisAdjustment decimal.Decimal // disallowed loss carried in, part of CostBasis
+	WashedAt        time.Time       // disposal the basis adjustment came from
+	HoldingFrom     time.Time       // start of the holding period, before AcquiredAt for replacement shares
+}
+
+// carry is basis and holding period moved onto Quantity shares of a replacement BUY
+type carry struct {
+	quantity    decimal.Decimal
+	basis       decimal.Decimal
+	holdingFrom time.Time
+	washedAt    time.Time
+}
+
+// plainLots wraps closed lots without applying wash sales
+func plainLots(closed []ClosedLot) []adjustedLot {
+	out := make([]adjustedLot, len(closed))
+	for i, lot := range closed {
+		out[i] = adjustedLot{ClosedLot: lot, HoldingFrom: lot.AcquiredAt}
+	}
+	return out
+}
+
+// applyWashSales returns the closed lots with wash-sale adjustments, and the wash sales themselves
+func applyWashSales(trades []domain.Trade, closed []ClosedLot) ([]adjustedLot, []WashSale) {
+	buys := make(map[string][]domain.Trade)
+	bought := make(map[uint]decimal.Decimal)
+	for _, t := range sortTrades(trades) {
+		if t.Type != "BUY" {
+			continue
+		}
+		buys[t.Symbol] = append(buys[t.Symbol], t)
+		bought[t.ID] = positionDelta(t)
+	}
+
+	// disposals of the shares of each BUY, in the order they were closed
+	sold := make(map[uint][]ClosedLot)
+	for _, lot := range closed {
+		if !lot.Short {
+			sold[lot.OpenTradeID] = append(sold[lot.OpenTradeID], lot)
+		}
+	}
+
+	carried := make(map[uint][]carry)
+	var lots []adjustedLot
+	var sales []WashSale
+
+	for _, closedLot := range closed {
+		pieces, rest := splitCarried(closedLot, carried[closedLot.OpenTradeID])
+		carried[closedLot.OpenTradeID] = rest
+
+		for _, lot := range pieces {
+			unwashed := lot.Quantity
+			if lot.RealizedPnL.IsNegative() && !lot.Short {
+				for _, buy := range buys[lot.Symbol] {
+					if unwashed.IsZero() {
+						break
+					}
+					if buy.ID == lot.OpenTradeID || buy.ExecutedAt.Before(lot.DisposedAt.Add(-washSaleWindow)) {
+						continue
+					}
+					if buy.ExecutedAt.After(lot.DisposedAt.Add(washSaleWindow)) {
+						break
+					}
+					available := bought[buy.ID].Sub(soldUntil(sold[buy.ID], lot.DisposedAt)).Sub(carriedQuantity(carried[buy.ID]))
+					if !available.IsPositive() {
+						continue
+					}
+
+					qty := decimal.Min(available, unwashed)
+					loss := lot.RealizedPnL.Mul(qty).Div(lot.Quantity)
+					unwashed = unwashed.Sub(qty)
+					adjustedFrom := buy.ExecutedAt.Add(-lot.DisposedAt.Sub(lot.HoldingFrom))
+
+					_, currency := domain.SplitSymbol(lot.Symbol)
+					sales = append(sales, WashSale{
+						Symbol:                lot.Symbol,
+						Currency:              currency,
+						CloseTradeID:          lot.CloseTradeID,
+						OpenTradeID:           lot.OpenTradeID,
+						DisposedAt:            lot.DisposedAt,
+						Quantity:              qty,
+						Loss:                  loss,
+						DisallowedLoss:        loss.Neg(),
+						ReplacementTradeID:    buy.ID,
+						ReplacementAcquiredAt: buy.ExecutedAt,
+						AdjustedAcquiredAt:    adjustedFrom,
+						BasisAdjustment:       loss.Neg(),
+					})
+					lot.Disallowed = lot.Disallowed.Add(loss.Neg())
+					carried[buy.ID] = append(carried[buy.ID], carry{quantity: qty, basis: loss.Neg(), holdingFrom: adjustedFrom, washedAt: lot.DisposedAt})
+				}
+			}
+			lots = append(lots, lot)
+		}
+	}
+	return lots, sales
+}
+
+// soldUntil is the quantity of the lots disposed of at or before at
+func soldUntil(lots []ClosedLot, at time.Time) decimal.Decimal {
+	total := decimal.Zero
+	for _, lot := range lots {
+		if !lot.DisposedAt.After(at) {
+			total = total.Add(lot.Quantity)
+		}
+	}
+	return total
+}
+
+// carriedQuantity is the number of shares still waiting for the basis carried to them
+func carriedQuantity(carries []carry) decimal.Decimal {
+	total := decimal.Zero
+	for _, c := range carries {
+		total = total.Add(c.quantity)
+	}
+	return total
+}
+
+// splitCarried cuts a closed lot into the pieces that carry a basis adjustment and the rest, consuming carries first in first out
+func splitCarried(lot ClosedLot, carries []carry) ([]adjustedLot, []carry) {
+	var pieces []adjustedLot
+	remaining := lot.Quantity
+	for len(carries) > 0 && remaining.IsPositive() {
+		c := carries[0]
+		qty := decimal.Min(c.quantity, remaining)
+		basis := c.basis.Mul(qty).Div(c.quantity)
+
+		piece := scaleLot(lot, qty)
+		piece.CostBasis = piece.CostBasis.Add(basis)
+		piece.RealizedPnL = piece.Proceeds.Sub(piece.CostBasis)
+		pieces = append(pieces, adjustedLot{ClosedLot: piece, BasisAdjustment: basis, WashedAt: c.washedAt, HoldingFrom: c.holdingFrom})
+
+		remaining = remaining.Sub(qty)
+		if qty.Equal(c.quantity) {
+			carries = carries[1:]
+		} else {
+			carries[0].quantity = c.quantity.Sub(qty)
+			carries[0].basis = c.basis.Sub(basis)
+		}
+	}
+	if remaining.IsPositive() {
+		pieces = append(pieces, adjustedLot{ClosedLot: scaleLot(lot, remaining), HoldingFrom: lot.AcquiredAt})
+	}
+	return pieces, carries
+}
+
+// scaleLot is qty shares of a closed lot, amounts pro rata
+func scaleLot(lot ClosedLot, qty decimal.Decimal) ClosedLot {
+	if qty.Equal(lot.Quantity) {
+		return lot
+	}
+	piece := lot
+	piece.Quantity = qty
+	piece.CostBasis = lot.CostBasis.Mul(qty).Div(lot.Quantity)
+	piece.Proceeds = lot.Proceeds.Mul(qty).Div(lot.Quantity)
+	piece.Fees = lot.Fees.Mul(qty).Div(lot.Quantity)
+	piece.RealizedPnL = piece.Proceeds.Sub(piece.CostBasis)
+	return piece
+}
+
+// @desc: wash sales of a user under the US 30 day rule
+// @flow: match lots -> walk disposals in order -> move disallowed losses onto replacement BUYs
+func (s *tradeService) GetWashSales(ctx context.Context, userID uint) (*WashSaleReport, error) {
+	settings, err := s.GetSettings(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	trades, err := s.userTrades(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	book, err := matchLotsWithShorts(trades, settings.CostMethod)
+	if err != nil {
+		return nil, err
+	}
+
+	_, sales := applyWashSales(trades, book.closed)
+	report := &WashSaleReport{
+		CostMethod: settings.CostMethod,
+		WashSales:  []WashSale{},
+		Disallowed: make(map[string]decimal.Decimal),
+	}
+	for _, sale := range sales {
+		report.WashSales = append(report.WashSales, sale)
+		report.Disallowed[sale.Symbol] = report.Disallowed[sale.Symbol].Add(sale.DisallowedLoss)
+	}
+	return report, nil
+}
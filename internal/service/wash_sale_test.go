@@ -0,0 +1,115 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/MonalBarse/tradelog/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func washHistory() []domain.Trade {
+	day := func(month time.Month, d int) time.Time { return time.Date(2024, month, d, 0, 0, 0, 0, time.UTC) }
+	return []domain.Trade{
+		{ID: 1, Symbol: "AAPL/USD", Type: "BUY", Price: d("100"), Quantity: d("10"), ExecutedAt: day(time.January, 1)},
+		{ID: 2, Symbol: "AAPL/USD", Type: "SELL", Price: d("80"), Quantity: d("10"), ExecutedAt: day(time.March, 1)},
+		{ID: 3, Symbol: "AAPL/USD", Type: "BUY", Price: d("85"), Quantity: d("6"), ExecutedAt: day(time.March, 15)},
+		{ID: 4, Symbol: "AAPL/USD", Type: "SELL", Price: d("95"), Quantity: d("6"), ExecutedAt: day(time.June, 1)},
+	}
+}
+
+func TestGetWashSales_ReplacementWithinWindow(t *testing.T) {
+	mockRepo := new(MockTradeRepo)
+	service := NewTradeService(mockRepo)
+	ctx := context.Background()
+
+	mockRepo.On("GetByUserID", ctx, uint(1)).Return(washHistory(), nil)
+
+	report, err := service.GetWashSales(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, report.WashSales, 1)
+
+	// only 6 of the 10 shares sold at a loss are replaced
+	sale := report.WashSales[0]
+	assert.Equal(t, uint(2), sale.CloseTradeID)
+	assert.Equal(t, uint(3), sale.ReplacementTradeID)
+	assert.True(t, d("6").Equal(sale.Quantity))
+	assert.True(t, d("120").Equal(sale.DisallowedLoss), sale.DisallowedLoss.String())
+	// the 60 days the sold shares were held are tacked on to the replacement
+	assert.Equal(t, time.Date(2024, time.January, 15, 0, 0, 0, 0, time.UTC), sale.AdjustedAcquiredAt)
+	assert.True(t, d("120").Equal(report.Disallowed["AAPL/USD"]))
+}
+
+func TestGetTaxReport_WashSaleAdjustments(t *testing.T) {
+	mockRepo := new(MockTradeRepo)
+	service := NewTradeService(mockRepo)
+	ctx := context.Background()
+
+	mockRepo.On("GetByUserID", ctx, uint(1)).Return(washHistory(), nil)
+
+	report, err := service.GetTaxReport(ctx, 1, "US", "2024")
+	require.NoError(t, err)
+	require.Len(t, report.Disposals, 2)
+
+	washed := report.Disposals[0]
+	assert.Equal(t, "W", washed.Code)
+	assert.True(t, d("120").Equal(washed.Adjustment))
+	assert.True(t, d("-80").Equal(washed.Gain), washed.Gain.String())
+
+	// the replacement carries the disallowed loss in its basis: 510 + 120
+	replacement := report.Disposals[1]
+	assert.True(t, d("630").Equal(replacement.CostBasis), replacement.CostBasis.String())
+	assert.True(t, d("-60").Equal(replacement.Gain))
+	require.NotNil(t, replacement.HoldingFrom)
+	assert.Equal(t, 138, replacement.HoldingDays)
+
+	// over both disposals the whole economic loss of 140 is claimed
+	assert.True(t, d("-140").Equal(report.Summary[0].ShortTermGain))
+
+	// other jurisdictions have no wash-sale rule
+	report, err = service.GetTaxReport(ctx, 1, "DE", "")
+	require.NoError(t, err)
+	assert.Empty(t, report.Disposals[0].Code)
+	assert.True(t, d("-200").Equal(report.Disposals[0].Gain))
+}
+
+func TestApplyWashSales_SameSaleIsNoReplacement(t *testing.T) {
+	start := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	trades := []domain.Trade{
+		{ID: 1, Symbol: "AAPL/USD", Type: "BUY", Price: d("100"), Quantity: d("10"), ExecutedAt: start},
+		{ID: 2, Symbol: "AAPL/USD", Type: "BUY", Price: d("90"), Quantity: d("10"), ExecutedAt: start.AddDate(0, 0, 9)},
+		{ID: 3, Symbol: "AAPL/USD", Type: "SELL", Price: d("80"), Quantity: d("20"), ExecutedAt: start.AddDate(0, 0, 14)},
+	}
+	book, err := matchLots(trades, domain.CostMethodFIFO)
+	require.NoError(t, err)
+
+	lots, sales := applyWashSales(trades, book.closed)
+	assert.Empty(t, sales)
+	assert.Len(t, lots, 2)
+}
+
+func TestGetTaxReport_SharesSoldBeforeTheLossAreNoReplacement(t *testing.T) {
+	mockRepo := new(MockTradeRepo)
+	service := NewTradeService(mockRepo)
+	ctx := context.Background()
+
+	day := func(d int) time.Time { return time.Date(2024, time.January, d, 0, 0, 0, 0, time.UTC) }
+	mockRepo.On("GetByUserID", ctx, uint(1)).Return([]domain.Trade{
+		{ID: 1, Symbol: "AAPL/USD", Type: "BUY", Price: d("100"), Quantity: d("10"), ExecutedAt: day(1)},
+		{ID: 2, Symbol: "AAPL/USD", Type: "SELL", Price: d("110"), Quantity: d("10"), ExecutedAt: day(5)},
+		{ID: 3, Symbol: "AAPL/USD", Type: "BUY", Price: d("100"), Quantity: d("10"), ExecutedAt: day(10)},
+		{ID: 4, Symbol: "AAPL/USD", Type: "SELL", Price: d("90"), Quantity: d("10"), ExecutedAt: day(20)},
+	}, nil)
+
+	// the shares of trade 1 were all sold on the 5th, they cannot take the loss of the 20th
+	sales, err := service.GetWashSales(ctx, 1)
+	require.NoError(t, err)
+	assert.Empty(t, sales.WashSales)
+
+	report, err := service.GetTaxReport(ctx, 1, "US", "2024")
+	require.NoError(t, err)
+	require.Len(t, report.Disposals, 2)
+	assert.True(t, report.Summary[0].ShortTermGain.IsZero(), report.Summary[0].ShortTermGain.String())
+}
@@ -0,0 +1,140 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/MonalBarse/tradelog/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+type AccountHandler struct {
+	service service.AccountService
+}
+
+func NewAccountHandler(service service.AccountService) *AccountHandler {
+	return &AccountHandler{service}
+}
+
+type accountRequest struct {
+	Name         string `json:"name" binding:"required"`
+	Broker       string `json:"broker"`
+	BaseCurrency string `json:"base_currency" binding:"omitempty,min=3,max=5"` // defaults to the user's base currency
+	Type         string `json:"type" binding:"required,oneof=BROKERAGE RETIREMENT EXCHANGE WALLET"`
+	AllowShort   bool   `json:"allow_short"` // a SELL beyond the account's holding opens a short position
+}
+
+func (r accountRequest) input() service.AccountInput {
+	return service.AccountInput{
+		Name:         r.Name,
+		Broker:       r.Broker,
+		BaseCurrency: r.BaseCurrency,
+		Type:         r.Type,
+		AllowShort:   r.AllowShort,
+	}
+}
+
+// @Summary Create Account
+// @Description Open a brokerage, retirement, exchange or wallet account to book trades into. Trades sent without account_id stay in the default account. allow_short lets SELLs beyond the account's holding open short positions, the default account follows the allow_short setting
+// @Tags accounts
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body accountRequest true "Account"
+// @Success 201 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Router /accounts [post]
+func (h *AccountHandler) Create(c *gin.Context) {
+	var req accountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, _ := c.Get("userID")
+
+	account, err := h.service.Create(c.Request.Context(), userID.(uint), req.input())
+	if err != nil {
+		c.JSON(accountErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"data": account})
+}
+
+// @Summary List Accounts
+// @Description All accounts of the logged-in user, by name
+// @Tags accounts
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{}
+// @Router /accounts [get]
+func (h *AccountHandler) List(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	accounts, err := h.service.List(c.Request.Context(), userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch accounts"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": accounts})
+}
+
+// @Summary Update Account
+// @Description Rename an account or change its broker, base currency or type, its trades stay in it
+// @Tags accounts
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Account ID"
+// @Param request body accountRequest true "Account"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /accounts/{id} [patch]
+func (h *AccountHandler) Update(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+
+	var req accountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, _ := c.Get("userID")
+
+	account, err := h.service.Update(c.Request.Context(), userID.(uint), uint(id), req.input())
+	if err != nil {
+		c.JSON(accountErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": account})
+}
+
+func accountErrorStatus(err error) int {
+	if errors.Is(err, service.ErrAccountNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusBadRequest
+}
+
+// @desc: read the optional account_id query parameter, 0 is the default account
+func parseAccountID(c *gin.Context) (*uint, error) {
+	raw := c.Query("account_id")
+	if raw == "" {
+		return nil, nil
+	}
+	id, err := strconv.ParseUint(raw, 10, 64)
+	if err != nil {
+		return nil, errors.New("account_id must be a non-negative integer")
+	}
+	accountID := uint(id)
+	return &accountID, nil
+}
@@ -0,0 +1,99 @@
+package http
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/MonalBarse/tradelog/internal/service"
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+)
+
+type CashHandler struct {
+	service service.CashService
+}
+
+func NewCashHandler(service service.CashService) *CashHandler {
+	return &CashHandler{service}
+}
+
+type cashMovementRequest struct {
+	Type       string          `json:"type" binding:"required,oneof=DEPOSIT WITHDRAWAL DIVIDEND INTEREST FEE"`
+	Currency   string          `json:"currency" binding:"required"`
+	Amount     decimal.Decimal `json:"amount" binding:"required"`
+	Notes      string          `json:"notes"`
+	OccurredAt *time.Time      `json:"occurred_at"` // defaults to now
+}
+
+// @Summary Record Cash Movement
+// @Description Records a deposit, withdrawal, dividend, interest payment or account fee. Amount is always positive.
+// @Tags cash
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body cashMovementRequest true "Cash Movement"
+// @Success 201 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Router /cash [post]
+func (h *CashHandler) RecordMovement(c *gin.Context) {
+	var req cashMovementRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, _ := c.Get("userID")
+
+	movement, err := h.service.RecordMovement(c.Request.Context(), userID.(uint), service.CashMovementInput{
+		Type:       req.Type,
+		Currency:   req.Currency,
+		Amount:     req.Amount,
+		Notes:      req.Notes,
+		OccurredAt: req.OccurredAt,
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"data": movement})
+}
+
+// @Summary Get Cash Ledger
+// @Description Cash movements and trade settlements with the running balance per currency
+// @Tags cash
+// @Produce json
+// @Security BearerAuth
+// @Param currency query string false "Only entries in this currency"
+// @Success 200 {object} map[string]interface{}
+// @Router /cash [get]
+func (h *CashHandler) GetLedger(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	entries, err := h.service.GetLedger(c.Request.Context(), userID.(uint), c.Query("currency"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build cash ledger"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": entries})
+}
+
+// @Summary Get Cash Balances
+// @Description Current cash balance per currency
+// @Tags cash
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{}
+// @Router /cash/balances [get]
+func (h *CashHandler) GetBalances(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	balances, err := h.service.GetBalances(c.Request.Context(), userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to calculate cash balances"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": balances})
+}
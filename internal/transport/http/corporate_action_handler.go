@@ -0,0 +1,124 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/MonalBarse/tradelog/internal/service"
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+)
+
+type CorporateActionHandler struct {
+	service service.CorporateActionService
+}
+
+func NewCorporateActionHandler(service service.CorporateActionService) *CorporateActionHandler {
+	return &CorporateActionHandler{service}
+}
+
+type corporateActionRequest struct {
+	Type        string          `json:"type" binding:"required,oneof=SPLIT SYMBOL_CHANGE"`
+	Symbol      string          `json:"symbol" binding:"required"`
+	NewSymbol   string          `json:"new_symbol"`                      // SYMBOL_CHANGE only
+	RatioFrom   decimal.Decimal `json:"ratio_from"`                      // SPLIT only, a 4:1 split is ratio_to 4, ratio_from 1
+	RatioTo     decimal.Decimal `json:"ratio_to"`                        // SPLIT only, a 1:10 reverse split is ratio_to 1, ratio_from 10
+	EffectiveAt time.Time       `json:"effective_at" binding:"required"` // trades executed before it are adjusted
+	Notes       string          `json:"notes"`
+}
+
+// @Summary List Corporate Actions
+// @Description Splits, reverse splits and symbol changes in the order they took effect
+// @Tags corporate-actions
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{}
+// @Router /corporate-actions [get]
+func (h *CorporateActionHandler) List(c *gin.Context) {
+	actions, err := h.service.List(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch corporate actions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": actions})
+}
+
+// @Summary Create Corporate Action (Admin Only)
+// @Description Record a split or symbol change that has taken effect. Trades are not rewritten, positions, portfolio and lots read trades executed before effective_at adjusted, and stored positions are rebuilt.
+// @Tags corporate-actions
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body corporateActionRequest true "Corporate Action"
+// @Success 201 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Router /admin/corporate-actions [post]
+func (h *CorporateActionHandler) Create(c *gin.Context) {
+	role, _ := c.Get("role")
+	if role != "admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Admins only"})
+		return
+	}
+
+	var req corporateActionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	action, err := h.service.Create(c.Request.Context(), service.CorporateActionInput{
+		Type:        req.Type,
+		Symbol:      req.Symbol,
+		NewSymbol:   req.NewSymbol,
+		RatioFrom:   req.RatioFrom,
+		RatioTo:     req.RatioTo,
+		EffectiveAt: req.EffectiveAt,
+		Notes:       req.Notes,
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"data": action})
+}
+
+// @Summary Delete Corporate Action (Admin Only)
+// @Description Remove a corporate action recorded by mistake, stored positions are rebuilt without it
+// @Tags corporate-actions
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Corporate Action ID"
+// @Success 200 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /admin/corporate-actions/{id} [delete]
+func (h *CorporateActionHandler) Delete(c *gin.Context) {
+	role, _ := c.Get("role")
+	if role != "admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Admins only"})
+		return
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+
+	err = h.service.Delete(c.Request.Context(), uint(id))
+	if errors.Is(err, service.ErrCorporateActionNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Corporate action deleted"})
+}
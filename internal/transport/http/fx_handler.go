@@ -0,0 +1,114 @@
+package http
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/MonalBarse/tradelog/internal/service"
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+)
+
+type FXHandler struct {
+	service service.FXService
+}
+
+func NewFXHandler(service service.FXService) *FXHandler {
+	return &FXHandler{service}
+}
+
+type fxRateRequest struct {
+	Base  string          `json:"base" binding:"required"`
+	Quote string          `json:"quote" binding:"required"`
+	Rate  decimal.Decimal `json:"rate" binding:"required"`
+	AsOf  *time.Time      `json:"as_of"` // defaults to now
+}
+
+// @Summary List FX Rates
+// @Description Latest stored rate of every currency pair
+// @Tags fx
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{}
+// @Router /fx/rates [get]
+func (h *FXHandler) ListRates(c *gin.Context) {
+	rates, err := h.service.ListLatest(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch fx rates"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": rates})
+}
+
+// @Summary Set FX Rate (Admin Only)
+// @Description Enter a rate manually: 1 base = rate quote
+// @Tags fx
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body fxRateRequest true "FX Rate"
+// @Success 201 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Router /fx/rates [post]
+func (h *FXHandler) SetRate(c *gin.Context) {
+	role, _ := c.Get("role")
+	if role != "admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Admins only"})
+		return
+	}
+
+	var req fxRateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rate, err := h.service.SetRate(c.Request.Context(), req.Base, req.Quote, req.Rate, req.AsOf)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"data": rate})
+}
+
+// @Summary Import FX Rates (Admin Only)
+// @Description Upload a CSV file with the columns base,quote,rate,as_of. Nothing is stored if any line is invalid.
+// @Tags fx
+// @Accept multipart/form-data
+// @Produce json
+// @Security BearerAuth
+// @Param file formData file true "CSV file"
+// @Success 201 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Router /fx/rates/import [post]
+func (h *FXHandler) ImportRates(c *gin.Context) {
+	role, _ := c.Get("role")
+	if role != "admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Admins only"})
+		return
+	}
+
+	header, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "file is required"})
+		return
+	}
+	file, err := header.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	defer file.Close()
+
+	n, err := h.service.ImportCSV(c.Request.Context(), file)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"imported": n})
+}
@@ -0,0 +1,198 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/MonalBarse/tradelog/internal/service"
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+)
+
+type InstrumentHandler struct {
+	service service.InstrumentService
+}
+
+func NewInstrumentHandler(service service.InstrumentService) *InstrumentHandler {
+	return &InstrumentHandler{service}
+}
+
+type instrumentRequest struct {
+	Symbol      string          `json:"symbol" binding:"required"`
+	AssetClass  string          `json:"asset_class" binding:"required,oneof=CRYPTO EQUITY FX COMMODITY ETF FUTURE OPTION"`
+	TickSize    decimal.Decimal `json:"tick_size"`
+	LotSize     decimal.Decimal `json:"lot_size"`
+	MinQuantity decimal.Decimal `json:"min_quantity"`
+	Active      *bool           `json:"active"` // defaults to true
+
+	MaxLeverage       decimal.Decimal `json:"max_leverage"`       // zero for no limit
+	MaintenanceMargin decimal.Decimal `json:"maintenance_margin"` // e.g. 0.005 for 0.5% of the position value
+
+	// futures and options only
+	Underlying string          `json:"underlying"`  // e.g. "AAPL/USD", required for options
+	Multiplier decimal.Decimal `json:"multiplier"`  // units of the underlying per contract, e.g. 100
+	Expiry     *time.Time      `json:"expiry"`      // required for futures and options
+	OptionType string          `json:"option_type"` // CALL or PUT
+	Strike     decimal.Decimal `json:"strike"`
+}
+
+func (r instrumentRequest) input() service.InstrumentInput {
+	active := true
+	if r.Active != nil {
+		active = *r.Active
+	}
+	return service.InstrumentInput{
+		Symbol:      r.Symbol,
+		AssetClass:  r.AssetClass,
+		TickSize:    r.TickSize,
+		LotSize:     r.LotSize,
+		MinQuantity: r.MinQuantity,
+		Active:      active,
+
+		MaxLeverage:       r.MaxLeverage,
+		MaintenanceMargin: r.MaintenanceMargin,
+
+		Underlying: r.Underlying,
+		Multiplier: r.Multiplier,
+		Expiry:     r.Expiry,
+		OptionType: r.OptionType,
+		Strike:     r.Strike,
+	}
+}
+
+// @Summary List Instruments
+// @Description All registered instruments, trades can only be logged for active ones
+// @Tags instruments
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{}
+// @Router /instruments [get]
+func (h *InstrumentHandler) List(c *gin.Context) {
+	instruments, err := h.service.List(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch instruments"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": instruments})
+}
+
+// @Summary Create Instrument (Admin Only)
+// @Description Register a symbol with its tick size, lot size, minimum quantity and maximum leverage (zero means no constraint), and the maintenance margin of leveraged positions. Futures (FUTURE) need an expiry and options (OPTION) an underlying, expiry, option type and strike; multiplier is the contract size
+// @Tags instruments
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body instrumentRequest true "Instrument"
+// @Success 201 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Router /admin/instruments [post]
+func (h *InstrumentHandler) Create(c *gin.Context) {
+	role, _ := c.Get("role")
+	if role != "admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Admins only"})
+		return
+	}
+
+	var req instrumentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	instrument, err := h.service.Create(c.Request.Context(), req.input())
+	if err != nil {
+		c.JSON(instrumentErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"data": instrument})
+}
+
+// @Summary Update Instrument (Admin Only)
+// @Description Replace the definition of an instrument, existing trades are not touched. The symbol of an instrument with trades can't change, record a SYMBOL_CHANGE corporate action instead
+// @Tags instruments
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Instrument ID"
+// @Param request body instrumentRequest true "Instrument"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Router /admin/instruments/{id} [put]
+func (h *InstrumentHandler) Update(c *gin.Context) {
+	role, _ := c.Get("role")
+	if role != "admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Admins only"})
+		return
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+
+	var req instrumentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	instrument, err := h.service.Update(c.Request.Context(), uint(id), req.input())
+	if err != nil {
+		c.JSON(instrumentErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": instrument})
+}
+
+// @Summary Delete Instrument (Admin Only)
+// @Description Remove an instrument from the registry. Instruments with trades or derivatives on them can't be removed, deactivate them instead.
+// @Tags instruments
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Instrument ID"
+// @Success 200 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Router /admin/instruments/{id} [delete]
+func (h *InstrumentHandler) Delete(c *gin.Context) {
+	role, _ := c.Get("role")
+	if role != "admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Admins only"})
+		return
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+
+	if err := h.service.Delete(c.Request.Context(), uint(id)); err != nil {
+		c.JSON(instrumentErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Instrument deleted"})
+}
+
+func instrumentErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrInstrumentNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, service.ErrInstrumentExists), errors.Is(err, service.ErrInstrumentInUse):
+		return http.StatusConflict
+	}
+	return http.StatusBadRequest
+}
@@ -0,0 +1,80 @@
+package http
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/MonalBarse/tradelog/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+type PositionHandler struct {
+	service service.PositionService
+}
+
+func NewPositionHandler(service service.PositionService) *PositionHandler {
+	return &PositionHandler{service}
+}
+
+// @Summary Rebuild Positions (Admin Only)
+// @Description Recompute the positions table from trade history, for one user or for everyone
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param user_id query int false "Only this user"
+// @Success 200 {object} map[string]interface{}
+// @Failure 403 {object} map[string]string
+// @Router /admin/positions/rebuild [post]
+func (h *PositionHandler) Rebuild(c *gin.Context) {
+	role, _ := c.Get("role")
+	if role != "admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Admins only"})
+		return
+	}
+
+	if raw := c.Query("user_id"); raw != "" {
+		userID, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user_id"})
+			return
+		}
+		positions, err := h.service.Rebuild(c.Request.Context(), uint(userID))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": positions})
+		return
+	}
+
+	users, err := h.service.RebuildAll(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"users": users})
+}
+
+// @Summary Check Positions (Admin Only)
+// @Description List stored positions that drifted from what the trade history says
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{}
+// @Failure 403 {object} map[string]string
+// @Router /admin/positions/check [get]
+func (h *PositionHandler) Check(c *gin.Context) {
+	role, _ := c.Get("role")
+	if role != "admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Admins only"})
+		return
+	}
+
+	drifts, err := h.service.Check(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": drifts, "consistent": len(drifts) == 0})
+}
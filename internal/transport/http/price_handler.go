@@ -0,0 +1,53 @@
+package http
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/MonalBarse/tradelog/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+type PriceHandler struct {
+	service service.PriceService
+}
+
+func NewPriceHandler(service service.PriceService) *PriceHandler {
+	return &PriceHandler{service}
+}
+
+// @Summary Get Price History
+// @Description OHLCV candles of a symbol over a time range, optionally aggregated (1m, 5m, 15m, 1h, 4h, 1d, 1w)
+// @Tags prices
+// @Produce json
+// @Security BearerAuth
+// @Param symbol query string true "Symbol, e.g. BTC/USD"
+// @Param from query string true "Start of the range (RFC3339)"
+// @Param to query string false "End of the range (RFC3339), defaults to now"
+// @Param resolution query string false "Candle size, defaults to raw"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Router /prices [get]
+func (h *PriceHandler) GetSeries(c *gin.Context) {
+	from, err := time.Parse(time.RFC3339, c.Query("from"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must be an RFC3339 timestamp"})
+		return
+	}
+
+	to := time.Now()
+	if raw := c.Query("to"); raw != "" {
+		if to, err = time.Parse(time.RFC3339, raw); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "to must be an RFC3339 timestamp"})
+			return
+		}
+	}
+
+	points, err := h.service.GetSeries(c.Request.Context(), c.Query("symbol"), from, to, c.Query("resolution"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": points})
+}
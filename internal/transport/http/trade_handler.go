@@ -1,8 +1,17 @@
 package http
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/MonalBarse/tradelog/internal/domain"
 	"github.com/MonalBarse/tradelog/internal/service"
 	"github.com/gin-gonic/gin"
 	"github.com/shopspring/decimal"
@@ -24,15 +33,96 @@ func NewTradeHandler(service service.TradeService) *TradeHandler {
 }
 
 type createTradeRequest struct {
-	Symbol   string          `json:"symbol" binding:"required"`
-	Type     string          `json:"type" binding:"required,oneof=BUY SELL"` // restrict to BUY or SELL
-	Price    decimal.Decimal `json:"price" binding:"required"`
+	Symbol     string                `json:"symbol" binding:"required"`
+	Type       string                `json:"type" binding:"required,oneof=BUY SELL"` // restrict to BUY or SELL
+	Price      decimal.Decimal       `json:"price" binding:"required"`
+	Quantity   decimal.Decimal       `json:"quantity" binding:"required"`
+	Notes      string                `json:"notes"`
+	ExecutedAt *time.Time            `json:"executed_at"` // RFC3339 with offset, defaults to now
+	Fees       []feeRequest          `json:"fees" binding:"omitempty,dive"`
+	Lots       []lotSelectionRequest `json:"lots" binding:"omitempty,dive"` // specific-lot identification for SELLs
+	Leverage   decimal.Decimal       `json:"leverage"`                      // trade on margin, omitted or 1 for a cash trade
+	AccountID  *uint                 `json:"account_id"`                    // account to book into, omitted for the default account
+}
+
+type feeRequest struct {
+	Type     string          `json:"type" binding:"required,oneof=COMMISSION EXCHANGE FUNDING TAX SLIPPAGE"`
+	Amount   decimal.Decimal `json:"amount" binding:"required"`
+	Currency string          `json:"currency"` // defaults to the quote currency of the symbol
+}
+
+type lotSelectionRequest struct {
+	TradeID  uint            `json:"trade_id" binding:"required"` // id of the BUY trade that opened the lot
 	Quantity decimal.Decimal `json:"quantity" binding:"required"`
 }
 
+// only the fields that are sent are changed, fees and lots replace the existing lines when sent
+type updateTradeRequest struct {
+	Symbol     *string                `json:"symbol"`
+	Type       *string                `json:"type" binding:"omitempty,oneof=BUY SELL"`
+	Price      *decimal.Decimal       `json:"price"`
+	Quantity   *decimal.Decimal       `json:"quantity"`
+	Notes      *string                `json:"notes"`
+	ExecutedAt *time.Time             `json:"executed_at"`
+	Fees       *[]feeRequest          `json:"fees" binding:"omitempty,dive"`
+	Lots       *[]lotSelectionRequest `json:"lots" binding:"omitempty,dive"`
+	Leverage   *decimal.Decimal       `json:"leverage"`
+	AccountID  *uint                  `json:"account_id"`                // 0 moves the trade to the default account
+	Reason     string                 `json:"reason" binding:"required"` // why the trade is corrected, kept in the history
+}
+
+type reasonRequest struct {
+	Reason string `json:"reason" binding:"required"`
+}
+
+type incomeRequest struct {
+	AccountID      *uint           `json:"account_id"` // account the holding is in, omitted for the default account
+	Type           string          `json:"type" binding:"required,oneof=CASH_DIVIDEND INTEREST STOCK_DIVIDEND STAKING AIRDROP"`
+	Symbol         string          `json:"symbol"`   // optional for interest on cash
+	Currency       string          `json:"currency"` // defaults to the quote currency of the symbol
+	Amount         decimal.Decimal `json:"amount" binding:"required"`
+	WithholdingTax decimal.Decimal `json:"withholding_tax"`
+	Price          decimal.Decimal `json:"price"` // fair value per unit of in-kind income, its cost basis
+	Notes          string          `json:"notes"`
+	OccurredAt     *time.Time      `json:"occurred_at"` // defaults to now
+}
+
+type borrowFeeRequest struct {
+	AccountID *uint           `json:"account_id"` // account of the short, omitted for the default account
+	Symbol    string          `json:"symbol" binding:"required"`
+	Currency  string          `json:"currency"` // defaults to the quote currency of the symbol
+	Amount    decimal.Decimal `json:"amount" binding:"required"`
+	Rate      decimal.Decimal `json:"rate"` // annual borrow rate, for reference
+	Notes     string          `json:"notes"`
+	ChargedAt *time.Time      `json:"charged_at"` // defaults to now
+}
+
+type derivativeEventRequest struct {
+	AccountID       *uint           `json:"account_id"` // account holding the contracts, omitted for the default account
+	Type            string          `json:"type" binding:"required,oneof=EXPIRATION EXERCISE ASSIGNMENT"`
+	Symbol          string          `json:"symbol" binding:"required"`
+	Quantity        decimal.Decimal `json:"quantity"`         // contracts, defaults to the whole open position
+	SettlementPrice decimal.Decimal `json:"settlement_price"` // futures expirations only
+	Notes           string          `json:"notes"`
+	OccurredAt      *time.Time      `json:"occurred_at"` // defaults to now, expirations happen at the expiry
+}
+
+type markPriceRequest struct {
+	Symbol string          `json:"symbol" binding:"required"`
+	Price  decimal.Decimal `json:"price" binding:"required"`
+}
+
+// only the fields that are sent are changed
+type updateSettingsRequest struct {
+	CostMethod   *string `json:"cost_method" binding:"omitempty,oneof=FIFO LIFO AVERAGE SPECIFIC"`
+	StrictCash   *bool   `json:"strict_cash"`
+	BaseCurrency *string `json:"base_currency" binding:"omitempty,min=3,max=5"`
+	AllowShort   *bool   `json:"allow_short"`
+}
+
 // Swagger Annotations
 // @Summary Create a new trade
-// @Description Records a buy or sell order. Validates sufficient funds for SELL orders.
+// @Description Records a buy or sell order with optional fee lines. Pass executed_at (RFC3339 with timezone) to journal a past trade: a backdated SELL must fit the position at that moment and may not leave any later SELL short. The symbol must name an active instrument ("btc-usd" and "BTCUSD" resolve to "BTC/USD"); price and quantity must respect its tick size, lot size and minimum. Validates sufficient funds for SELL orders, fees included, unless the account allows short selling (the allow_short setting for the default account). Pass leverage to trade on margin: quantity * price / leverage is posted as margin and may not exceed the instrument's max leverage. Pass account_id to book the trade into one of your accounts, a SELL must then be covered by what that account holds.
 // @Tags trades
 // @Accept json
 // @Produce json
@@ -55,8 +145,25 @@ func (h *TradeHandler) CreateTrade(c *gin.Context) {
 		return
 	}
 
+	input := service.TradeInput{
+		Symbol:     req.Symbol,
+		Type:       req.Type,
+		Price:      req.Price,
+		Quantity:   req.Quantity,
+		Notes:      req.Notes,
+		ExecutedAt: req.ExecutedAt,
+		Leverage:   req.Leverage,
+		AccountID:  req.AccountID,
+	}
+	for _, fee := range req.Fees {
+		input.Fees = append(input.Fees, domain.TradeFee{Type: fee.Type, Amount: fee.Amount, Currency: fee.Currency})
+	}
+	for _, lot := range req.Lots {
+		input.Lots = append(input.Lots, domain.LotSelection{LotTradeID: lot.TradeID, Quantity: lot.Quantity})
+	}
+
 	// actualy create trade
-	err := h.service.CreateTrade(c.Request.Context(), userID.(uint), req.Symbol, req.Type, req.Price, req.Quantity)
+	err := h.service.CreateTrade(c.Request.Context(), userID.(uint), input)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -67,32 +174,59 @@ func (h *TradeHandler) CreateTrade(c *gin.Context) {
 
 // Swagger Annotations
 // @Summary List user trades
-// @Description Get all trades for the logged-in user
+// @Description Page through the logged-in user's trades. Pass next_cursor back as cursor to get the following page.
 // @Tags trades
 // @Produce json
 // @Security BearerAuth
+// @Param symbol query string false "Symbol"
+// @Param type query string false "BUY or SELL"
+// @Param from query string false "Executed at or after (RFC3339)"
+// @Param to query string false "Executed at or before (RFC3339)"
+// @Param min_price query string false "Minimum price"
+// @Param max_price query string false "Maximum price"
+// @Param min_quantity query string false "Minimum quantity"
+// @Param max_quantity query string false "Maximum quantity"
+// @Param notes query string false "Text the notes contain"
+// @Param account_id query int false "Only this account, 0 for the default account"
+// @Param sort query string false "executed_at, price, quantity or symbol, prefix with - for descending (default -executed_at)"
+// @Param limit query int false "Page size (default 50, max 500)"
+// @Param cursor query string false "next_cursor of the previous page"
 // @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
 // @Router /trades [get]
 func (h *TradeHandler) ListTrades(c *gin.Context) {
 	userID, _ := c.Get("userID")
 
-	trades, err := h.service.GetUserTrades(c.Request.Context(), userID.(uint))
+	filter, err := parseTradeFilter(c)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch trades"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	uid := userID.(uint)
+	filter.UserID = &uid
 
-	c.JSON(http.StatusOK, gin.H{"data": trades})
+	h.listTrades(c, filter)
 }
 
 // @Summary Get All Trades (Admin Only)
-// @Description Get all trades across all users (admin only)
+// @Description Page through trades across all users (admin only), with the same filters as GET /trades plus user_id
 // @Tags trades
 // @Produce json
 // @Security BearerAuth
+// @Param user_id query int false "Only this user"
+// @Param symbol query string false "Symbol"
+// @Param type query string false "BUY or SELL"
+// @Param from query string false "Executed at or after (RFC3339)"
+// @Param to query string false "Executed at or before (RFC3339)"
+// @Param notes query string false "Text the notes contain"
+// @Param account_id query int false "Only this account, 0 for the default account"
+// @Param sort query string false "executed_at, price, quantity or symbol, prefix with - for descending (default -executed_at)"
+// @Param limit query int false "Page size (default 50, max 500)"
+// @Param cursor query string false "next_cursor of the previous page"
 // @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
 // @Failure 403 {object} map[string]string
-// @Router /trades/all [get]
+// @Router /admin/trades [get]
 func (h *TradeHandler) GetAllTrades(c *gin.Context) {
 	role, _ := c.Get("role")
 	if role != "admin" {
@@ -100,30 +234,967 @@ func (h *TradeHandler) GetAllTrades(c *gin.Context) {
 		return
 	}
 
-	trades, err := h.service.GetAllTrades(c.Request.Context())
+	filter, err := parseTradeFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if raw := c.Query("user_id"); raw != "" {
+		id, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user_id"})
+			return
+		}
+		uid := uint(id)
+		filter.UserID = &uid
+	}
+
+	h.listTrades(c, filter)
+}
+
+func (h *TradeHandler) listTrades(c *gin.Context, filter service.TradeFilter) {
+	page, err := h.service.ListTrades(c.Request.Context(), filter)
+	if errors.Is(err, service.ErrInvalidFilter) || errors.Is(err, service.ErrInvalidCursor) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch trades"})
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// @desc: read the listing query parameters shared by the user and admin endpoints
+func parseTradeFilter(c *gin.Context) (service.TradeFilter, error) {
+	filter := service.TradeFilter{
+		Symbol: c.Query("symbol"),
+		Type:   strings.ToUpper(c.Query("type")),
+		Notes:  c.Query("notes"),
+		Cursor: c.Query("cursor"),
+		Desc:   true, // newest first
+	}
+	if filter.Type != "" && filter.Type != "BUY" && filter.Type != "SELL" {
+		return filter, errors.New("type must be BUY or SELL")
+	}
+
+	accountID, err := parseAccountID(c)
+	if err != nil {
+		return filter, err
+	}
+	filter.AccountID = accountID
+
+	if sort := c.Query("sort"); sort != "" {
+		filter.Desc = strings.HasPrefix(sort, "-")
+		filter.SortBy = strings.TrimPrefix(sort, "-")
+	}
+
+	if raw := c.Query("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 {
+			return filter, errors.New("limit must be a positive integer")
+		}
+		filter.Limit = limit
+	}
+
+	for name, dst := range map[string]**time.Time{"from": &filter.From, "to": &filter.To} {
+		if raw := c.Query(name); raw != "" {
+			t, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				return filter, fmt.Errorf("%s must be an RFC3339 timestamp", name)
+			}
+			*dst = &t
+		}
+	}
+
+	bounds := map[string]**decimal.Decimal{
+		"min_price":    &filter.MinPrice,
+		"max_price":    &filter.MaxPrice,
+		"min_quantity": &filter.MinQuantity,
+		"max_quantity": &filter.MaxQuantity,
+	}
+	for name, dst := range bounds {
+		if raw := c.Query(name); raw != "" {
+			v, err := decimal.NewFromString(raw)
+			if err != nil {
+				return filter, fmt.Errorf("%s must be a number", name)
+			}
+			*dst = &v
+		}
+	}
+	return filter, nil
+}
+
+// @Summary Import trades from CSV or a broker statement
+// @Description Upload a CSV of past trades or a broker export. Rows are validated like POST /trades in chronological order and stored all or nothing. Use dry_run to only get the per-row error report. Rows whose external trade id was imported before are skipped and counted as duplicates. With the default format csv the header is symbol,type,price,quantity,executed_at[,notes,fee,fee_currency,external_id]; mapping renames columns. Other formats are listed by GET /trades/import/formats. Pass account_id to book the whole file into one of your accounts.
+// @Tags trades
+// @Accept multipart/form-data
+// @Produce json
+// @Security BearerAuth
+// @Param file formData file true "CSV file or broker export"
+// @Param format formData string false "csv (default) or a broker format such as binance, coinbase, ibkr, zerodha"
+// @Param mapping formData string false "JSON object from field to CSV header, e.g. {\"symbol\":\"Ticker\",\"executed_at\":\"Date\"}, plus an optional time_layout"
+// @Param timezone formData string false "IANA zone of timestamps without an offset (default UTC), used by csv and ibkr"
+// @Param account_id formData int false "Account every row is booked into, default account when omitted"
+// @Param dry_run formData bool false "Validate only"
+// @Success 200 {object} service.ImportReport
+// @Success 201 {object} service.ImportReport
+// @Failure 400 {object} map[string]string
+// @Failure 422 {object} service.ImportReport
+// @Router /trades/import [post]
+func (h *TradeHandler) ImportTrades(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	header, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "file is required"})
+		return
+	}
+
+	format := strings.ToLower(c.DefaultPostForm("format", service.ImportSourceCSV))
+	var importer service.Importer
+	if format != service.ImportSourceCSV {
+		var ok bool
+		if importer, ok = service.GetImporter(format); !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unknown format, see GET /trades/import/formats"})
+			return
+		}
+	}
+
+	var mapping service.ImportMapping
+	if raw := c.PostForm("mapping"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &mapping); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "mapping must be a JSON object"})
+			return
+		}
+	}
+	if tz := c.PostForm("timezone"); tz != "" {
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unknown timezone"})
+			return
+		}
+		mapping.Location = loc
+	}
+	dryRun, _ := strconv.ParseBool(c.PostForm("dry_run"))
+	var accountID *uint
+	if raw := c.PostForm("account_id"); raw != "" {
+		id, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "account_id must be a non-negative integer"})
+			return
+		}
+		if id > 0 { // 0 is the default account
+			account := uint(id)
+			accountID = &account
+		}
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	defer file.Close()
+
+	var rows []service.ImportRow
+	var parseErrors []service.ImportRowError
+	if importer != nil {
+		rows, parseErrors, err = importer.Parse(file, mapping.Location)
+	} else {
+		rows, parseErrors, err = service.ParseTradeCSV(file, mapping)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	for i := range rows {
+		rows[i].Input.AccountID = accountID
+	}
+
+	// rows that didn't parse block the import, the rest is still validated so the report is complete
+	report, err := h.service.ImportTrades(c.Request.Context(), userID.(uint), rows, dryRun || len(parseErrors) > 0)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import trades"})
+		return
+	}
+	report.DryRun = dryRun
+	report.Rows += len(parseErrors)
+	report.Errors = append(parseErrors, report.Errors...)
+	sort.Slice(report.Errors, func(i, j int) bool { return report.Errors[i].Line < report.Errors[j].Line })
+
+	switch {
+	case len(report.Errors) > 0:
+		c.JSON(http.StatusUnprocessableEntity, report)
+	case dryRun:
+		c.JSON(http.StatusOK, report)
+	default:
+		c.JSON(http.StatusCreated, report)
+	}
+}
+
+// @Summary Correct a trade
+// @Description Change fields of one of your trades. The whole history is replayed with the correction and it is rejected if any later SELL would oversell. A revision with the reason is recorded.
+// @Tags trades
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Trade ID"
+// @Param request body updateTradeRequest true "Changed fields and reason"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /trades/{id} [patch]
+func (h *TradeHandler) UpdateTrade(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	tradeID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+
+	var req updateTradeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	patch := service.TradePatch{
+		Symbol:     req.Symbol,
+		Type:       req.Type,
+		Price:      req.Price,
+		Quantity:   req.Quantity,
+		Notes:      req.Notes,
+		ExecutedAt: req.ExecutedAt,
+		Leverage:   req.Leverage,
+		AccountID:  req.AccountID,
+	}
+	if req.Fees != nil {
+		fees := make([]domain.TradeFee, 0, len(*req.Fees))
+		for _, fee := range *req.Fees {
+			fees = append(fees, domain.TradeFee{Type: fee.Type, Amount: fee.Amount, Currency: fee.Currency})
+		}
+		patch.Fees = &fees
+	}
+	if req.Lots != nil {
+		lots := make([]domain.LotSelection, 0, len(*req.Lots))
+		for _, lot := range *req.Lots {
+			lots = append(lots, domain.LotSelection{LotTradeID: lot.TradeID, Quantity: lot.Quantity})
+		}
+		patch.Lots = &lots
+	}
+
+	trade, err := h.service.UpdateTrade(c.Request.Context(), userID.(uint), uint(tradeID), patch, req.Reason)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch all trades"})
+		c.JSON(tradeErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"data": trades})
+	c.JSON(http.StatusOK, gin.H{"data": trade})
+}
+
+// @Summary Delete a trade
+// @Description Soft delete one of your trades, rejected if later SELLs depend on it. It can be restored later.
+// @Tags trades
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Trade ID"
+// @Param request body reasonRequest true "Reason"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /trades/{id} [delete]
+func (h *TradeHandler) DeleteTrade(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	tradeID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+
+	var req reasonRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.service.DeleteTrade(c.Request.Context(), userID.(uint), uint(tradeID), req.Reason); err != nil {
+		c.JSON(tradeErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Trade deleted"})
+}
+
+// @Summary Restore a deleted trade
+// @Description Bring back one of your soft-deleted trades, rejected if it no longer fits the trade history
+// @Tags trades
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Trade ID"
+// @Param request body reasonRequest true "Reason"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /trades/{id}/restore [post]
+func (h *TradeHandler) RestoreTrade(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	tradeID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+
+	var req reasonRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	trade, err := h.service.RestoreTrade(c.Request.Context(), userID.(uint), uint(tradeID), req.Reason)
+	if err != nil {
+		c.JSON(tradeErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": trade})
+}
+
+// @Summary Trade history
+// @Description Every recorded change of one of your trades with who made it, when, why and snapshots before and after
+// @Tags trades
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Trade ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 404 {object} map[string]string
+// @Router /trades/{id}/history [get]
+func (h *TradeHandler) GetTradeHistory(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	tradeID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+
+	revisions, err := h.service.GetTradeHistory(c.Request.Context(), userID.(uint), uint(tradeID))
+	if err != nil {
+		c.JSON(tradeErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": revisions})
+}
+
+// @Summary Export my trades
+// @Description Download every trade matching the same filters as GET /trades (limit and cursor are ignored) as CSV, NDJSON or XLSX. The file is streamed while it is read. Rows include value, fee totals and cost basis; SELLs carry the cost basis and realized P&L of the lots they closed.
+// @Tags trades
+// @Produce text/csv
+// @Produce application/x-ndjson
+// @Produce application/vnd.openxmlformats-officedocument.spreadsheetml.sheet
+// @Security BearerAuth
+// @Param format query string false "csv (default), ndjson or xlsx"
+// @Param symbol query string false "Symbol"
+// @Param type query string false "BUY or SELL"
+// @Param from query string false "Executed at or after (RFC3339)"
+// @Param to query string false "Executed at or before (RFC3339)"
+// @Param notes query string false "Text the notes contain"
+// @Param account_id query int false "Only this account, 0 for the default account"
+// @Param sort query string false "executed_at, price, quantity or symbol, prefix with - for descending (default -executed_at)"
+// @Success 200 {file} file
+// @Failure 400 {object} map[string]string
+// @Router /trades/export [get]
+func (h *TradeHandler) ExportTrades(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	format := strings.ToLower(c.DefaultQuery("format", service.ExportCSV))
+	if !service.IsValidExportFormat(format) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be csv, ndjson or xlsx"})
+		return
+	}
+	filter, err := parseTradeFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	uid := userID.(uint)
+	filter.UserID = &uid
+
+	export(c, "trades."+format, format, func(w io.Writer) error {
+		return h.service.ExportTrades(c.Request.Context(), filter, format, w)
+	})
+}
+
+// @Summary Export my portfolio
+// @Description Download the holdings of GET /portfolio as CSV, NDJSON or XLSX, with cost basis, market value and unrealized P&L per symbol
+// @Tags trades
+// @Produce text/csv
+// @Produce application/x-ndjson
+// @Produce application/vnd.openxmlformats-officedocument.spreadsheetml.sheet
+// @Security BearerAuth
+// @Param format query string false "csv (default), ndjson or xlsx"
+// @Param as_of query string false "Point in time (RFC3339)"
+// @Success 200 {file} file
+// @Failure 400 {object} map[string]string
+// @Router /portfolio/export [get]
+func (h *TradeHandler) ExportPortfolio(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	format := strings.ToLower(c.DefaultQuery("format", service.ExportCSV))
+	if !service.IsValidExportFormat(format) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be csv, ndjson or xlsx"})
+		return
+	}
+	var asOf *time.Time
+	if raw := c.Query("as_of"); raw != "" {
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "as_of must be an RFC3339 timestamp"})
+			return
+		}
+		asOf = &t
+	}
+
+	export(c, "portfolio."+format, format, func(w io.Writer) error {
+		return h.service.ExportPortfolio(c.Request.Context(), userID.(uint), asOf, format, w)
+	})
+}
+
+// @Summary Export my journal
+// @Description Download trades, fees and cash movements as a Beancount or Ledger journal, with commodity and account declarations and the cost of every lot a SELL closes
+// @Tags trades
+// @Produce plain
+// @Security BearerAuth
+// @Param format query string false "beancount (default) or ledger"
+// @Success 200 {file} file
+// @Failure 400 {object} map[string]string
+// @Router /journal [get]
+func (h *TradeHandler) ExportJournal(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	format := strings.ToLower(c.DefaultQuery("format", service.JournalBeancount))
+	if !service.IsValidJournalFormat(format) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be beancount or ledger"})
+		return
+	}
+	filename := "tradelog.beancount"
+	if format == service.JournalLedger {
+		filename = "tradelog.ledger"
+	}
+
+	c.Header("Content-Type", "text/plain; charset=utf-8")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	if err := h.service.ExportJournal(c.Request.Context(), userID.(uint), format, c.Writer); err != nil {
+		c.Writer.Header().Del("Content-Type")
+		c.Writer.Header().Del("Content-Disposition")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export journal"})
+	}
+}
+
+// @desc: stream an export as a download, an error can only be sent as JSON while nothing is written yet
+func export(c *gin.Context, filename, format string, write func(w io.Writer) error) {
+	c.Header("Content-Type", service.ExportContentType(format))
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	err := write(c.Writer)
+	if err == nil {
+		return
+	}
+	if c.Writer.Written() {
+		c.Error(err) // the client gets a truncated file, nothing better can be done mid-stream
+		c.Abort()
+		return
+	}
+
+	c.Writer.Header().Del("Content-Type")
+	c.Writer.Header().Del("Content-Disposition")
+	if errors.Is(err, service.ErrInvalidFilter) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export"})
+}
+
+func tradeErrorStatus(err error) int {
+	if errors.Is(err, service.ErrTradeNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusBadRequest
 }
 
 // @Summary Get Portfolio
-// @Description Get current holdings calculated from trade history
+// @Description Get holdings with open lots, average cost and cost basis (matched with the user's cost method). Pass as_of to see the portfolio at a past moment, valued from the price history. Short positions are listed with side SHORT, negative quantity and cost basis, and the borrow fees charged on them. Leveraged positions carry their margin used, margin ratio and liquidation price; those close to liquidation are listed in summary.at_risk. Futures and options are valued at quantity * price * multiplier and show their greeks-free exposure to the underlying; contracts past their expiry are listed in summary.expired. Without account_id the portfolio consolidates every account in the user's base currency, with what each account holds of an item and summary.accounts totals; with account_id only that account (0 for the default one) in its base currency. The summary totals every holding in the base currency.
 // @Tags trades
 // @Produce json
 // @Security BearerAuth
+// @Param as_of query string false "Point in time (RFC3339)"
+// @Param account_id query int false "Only this account, 0 for the default account"
 // @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
 // @Router /portfolio [get]
 func (h *TradeHandler) GetPortfolio(c *gin.Context) {
 	userID, _ := c.Get("userID")
 
-	portfolio, err := h.service.GetPortfolio(c.Request.Context(), userID.(uint))
+	var asOf *time.Time
+	if raw := c.Query("as_of"); raw != "" {
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "as_of must be an RFC3339 timestamp"})
+			return
+		}
+		asOf = &t
+	}
+	accountID, err := parseAccountID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if accountID != nil {
+		portfolio, baseCurrency, err := h.service.GetAccountPortfolio(c.Request.Context(), userID.(uint), *accountID, asOf)
+		if err != nil {
+			c.JSON(accountErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"data":    portfolio,
+			"summary": service.SummarizePortfolio(portfolio, baseCurrency),
+		})
+		return
+	}
+
+	var portfolio []service.PortfolioItem
+	if asOf != nil {
+		portfolio, err = h.service.GetPortfolioAt(c.Request.Context(), userID.(uint), *asOf)
+	} else {
+		portfolio, err = h.service.GetPortfolio(c.Request.Context(), userID.(uint))
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to calculate portfolio"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"data": portfolio})
+	settings, err := h.service.GetSettings(c.Request.Context(), userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch settings"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":    portfolio,
+		"summary": service.SummarizePortfolio(portfolio, settings.BaseCurrency),
+	})
+}
+
+// @Summary Get Settings
+// @Description Get the accounting settings of the logged-in user
+// @Tags settings
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{}
+// @Router /settings [get]
+func (h *TradeHandler) GetSettings(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	settings, err := h.service.GetSettings(c.Request.Context(), userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch settings"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": settings})
+}
+
+// @Summary Update Settings
+// @Description Change the cost method (FIFO, LIFO, AVERAGE, SPECIFIC) used to match SELLs against lots, toggle strict cash mode (BUYs need buying power), allow short selling in the default account (a SELL beyond the holding opens a short position, other accounts have their own allow_short) and set the base reporting currency
+// @Tags settings
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body updateSettingsRequest true "Settings"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Router /settings [put]
+func (h *TradeHandler) UpdateSettings(c *gin.Context) {
+	var req updateSettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, _ := c.Get("userID")
+
+	settings, err := h.service.GetSettings(c.Request.Context(), userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch settings"})
+		return
+	}
+	if req.CostMethod != nil {
+		settings.CostMethod = *req.CostMethod
+	}
+	if req.StrictCash != nil {
+		settings.StrictCash = *req.StrictCash
+	}
+	if req.BaseCurrency != nil {
+		settings.BaseCurrency = *req.BaseCurrency
+	}
+	if req.AllowShort != nil {
+		settings.AllowShort = *req.AllowShort
+	}
+
+	err = h.service.UpdateSettings(c.Request.Context(), userID.(uint), *settings)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Settings updated successfully"})
+}
+
+// @Summary Get P&L
+// @Description Realized P&L per closed lot, unrealized P&L per open position and totals per symbol and day/month. Marks passed as mark=SYMBOL:PRICE override stored marks.
+// @Tags pnl
+// @Produce json
+// @Security BearerAuth
+// @Param period query string false "Grouping of realized totals (day or month)"
+// @Param mark query []string false "Mark price as SYMBOL:PRICE, e.g. BTC/USD:65000" collectionFormat(multi)
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Router /pnl [get]
+func (h *TradeHandler) GetPnL(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	marks := make(map[string]decimal.Decimal)
+	for _, raw := range c.QueryArray("mark") {
+		// symbols may contain "/" but never ":", so split on the last colon
+		idx := strings.LastIndex(raw, ":")
+		if idx <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "mark must look like SYMBOL:PRICE"})
+			return
+		}
+		price, err := decimal.NewFromString(raw[idx+1:])
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid mark price for " + raw[:idx]})
+			return
+		}
+		marks[raw[:idx]] = price
+	}
+
+	report, err := h.service.GetPnL(c.Request.Context(), userID.(uint), marks, c.Query("period"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": report})
+}
+
+// @Summary Get tax report
+// @Description Capital gains per disposal with acquisition and disposal dates, proceeds, cost basis, gain and short/long-term classification, plus a summary per tax year. format=csv downloads the disposals in a Form 8949 layout.
+// @Tags tax
+// @Produce json
+// @Produce text/csv
+// @Security BearerAuth
+// @Param jurisdiction query string false "Holding period and tax year rules: US (default), IN, DE, AU or a configured code"
+// @Param year query string false "Tax year, e.g. 2024, or 2024-25 where the tax year does not start in January"
+// @Param format query string false "json (default) or csv"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Router /tax/report [get]
+func (h *TradeHandler) GetTaxReport(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	format := strings.ToLower(c.DefaultQuery("format", "json"))
+	if format != "json" && format != service.ExportCSV {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be json or csv"})
+		return
+	}
+
+	report, err := h.service.GetTaxReport(c.Request.Context(), userID.(uint), c.Query("jurisdiction"), c.Query("year"))
+	if errors.Is(err, service.ErrUnknownJurisdiction) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build tax report"})
+		return
+	}
+
+	if format == service.ExportCSV {
+		filename := "form8949.csv"
+		if year := c.Query("year"); year != "" {
+			filename = "form8949-" + year + ".csv"
+		}
+		export(c, filename, format, func(w io.Writer) error {
+			return service.WriteForm8949(w, report)
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": report})
+}
+
+// @Summary Get wash sales
+// @Description Disposals at a loss with a BUY of the same symbol within 30 days before or after, the disallowed loss and the basis and holding period adjustment of the replacement BUY (US rule)
+// @Tags tax
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{}
+// @Failure 500 {object} map[string]string
+// @Router /tax/wash-sales [get]
+func (h *TradeHandler) GetWashSales(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	report, err := h.service.GetWashSales(c.Request.Context(), userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to analyze wash sales"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": report})
+}
+
+// @Summary Record income
+// @Description Record a cash dividend, interest, stock dividend, staking reward or airdrop. Cash income is paid into the cash ledger net of withholding tax, in-kind income adds the net units to the holding at price (its cost basis).
+// @Tags income
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body incomeRequest true "Income Event"
+// @Success 201 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Router /income [post]
+func (h *TradeHandler) RecordIncome(c *gin.Context) {
+	var req incomeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, _ := c.Get("userID")
+
+	event, err := h.service.RecordIncome(c.Request.Context(), userID.(uint), service.IncomeInput{
+		AccountID:      req.AccountID,
+		Type:           req.Type,
+		Symbol:         req.Symbol,
+		Currency:       req.Currency,
+		Amount:         req.Amount,
+		WithholdingTax: req.WithholdingTax,
+		Price:          req.Price,
+		Notes:          req.Notes,
+		OccurredAt:     req.OccurredAt,
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"data": event})
+}
+
+// @Summary List income
+// @Description All income events of the user, oldest first
+// @Tags income
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{}
+// @Router /income [get]
+func (h *TradeHandler) ListIncome(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	events, err := h.service.ListIncome(c.Request.Context(), userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch income"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": events})
+}
+
+// @Summary Get income summary
+// @Description Income per holding and per type in the base currency, gross, withheld and net, with the yield on the cost of the open position
+// @Tags income
+// @Produce json
+// @Security BearerAuth
+// @Param year query int false "Calendar year, all years when omitted"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Router /income/summary [get]
+func (h *TradeHandler) GetIncomeSummary(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	year := 0
+	if raw := c.Query("year"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "year must be a number"})
+			return
+		}
+		year = parsed
+	}
+
+	summary, err := h.service.GetIncomeSummary(c.Request.Context(), userID.(uint), year)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build income summary"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": summary})
+}
+
+// @Summary Record borrow fee
+// @Description Record a fee charged for borrowing the units of a short position, it is paid out of the cash balance of the quote currency
+// @Tags shorts
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body borrowFeeRequest true "Borrow Fee"
+// @Success 201 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Router /borrow-fees [post]
+func (h *TradeHandler) RecordBorrowFee(c *gin.Context) {
+	var req borrowFeeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, _ := c.Get("userID")
+
+	fee, err := h.service.RecordBorrowFee(c.Request.Context(), userID.(uint), service.BorrowFeeInput{
+		AccountID: req.AccountID,
+		Symbol:    req.Symbol,
+		Currency:  req.Currency,
+		Amount:    req.Amount,
+		Rate:      req.Rate,
+		Notes:     req.Notes,
+		ChargedAt: req.ChargedAt,
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"data": fee})
+}
+
+// @Summary List borrow fees
+// @Description All borrow fees charged on short positions of the user, oldest first
+// @Tags shorts
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{}
+// @Router /borrow-fees [get]
+func (h *TradeHandler) ListBorrowFees(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	fees, err := h.service.ListBorrowFees(c.Request.Context(), userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch borrow fees"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": fees})
+}
+
+// @Summary Record derivative event
+// @Description Expire, exercise or assign futures and options contracts. Expired options close at zero and futures at settlement_price; an exercise (long options) or assignment (short options) also buys or sells contracts * multiplier units of the underlying at the strike
+// @Tags derivatives
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body derivativeEventRequest true "Derivative Event"
+// @Success 201 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Router /derivatives/events [post]
+func (h *TradeHandler) SettleDerivative(c *gin.Context) {
+	var req derivativeEventRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, _ := c.Get("userID")
+
+	event, err := h.service.SettleDerivative(c.Request.Context(), userID.(uint), service.DerivativeEventInput{
+		AccountID:       req.AccountID,
+		Type:            req.Type,
+		Symbol:          req.Symbol,
+		Quantity:        req.Quantity,
+		SettlementPrice: req.SettlementPrice,
+		Notes:           req.Notes,
+		OccurredAt:      req.OccurredAt,
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"data": event})
+}
+
+// @Summary List derivative events
+// @Description All expirations, exercises and assignments of the user, oldest first
+// @Tags derivatives
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{}
+// @Router /derivatives/events [get]
+func (h *TradeHandler) ListDerivativeEvents(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	events, err := h.service.ListDerivativeEvents(c.Request.Context(), userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch derivative events"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": events})
+}
+
+// @Summary Set Mark Price
+// @Description Store the price used to value open positions of a symbol in P&L reports
+// @Tags pnl
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body markPriceRequest true "Mark Price"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Router /pnl/marks [put]
+func (h *TradeHandler) SetMarkPrice(c *gin.Context) {
+	var req markPriceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, _ := c.Get("userID")
+
+	err := h.service.SetMarkPrice(c.Request.Context(), userID.(uint), req.Symbol, req.Price)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Mark price saved successfully"})
+}
+
+// @Summary List import formats
+// @Description Formats accepted by POST /trades/import: csv (mapped columns) and the registered broker statement importers
+// @Tags trades
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} map[string][]string
+// @Router /trades/import/formats [get]
+func (h *TradeHandler) ListImportFormats(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"data": append([]string{service.ImportSourceCSV}, service.ImporterNames()...)})
 }